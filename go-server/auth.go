@@ -0,0 +1,367 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"htmlnojs/config"
+	"htmlnojs/routebuilder"
+	"htmlnojs/server"
+	"htmlnojs/store"
+)
+
+// authenticators builds the sign in methods set in auth, in the order
+// they are tried. A user signed in to their session and a signed URL
+// always count.
+func authenticators(auth config.AuthConfig, signer *routebuilder.URLSigner) ([]server.Authenticator, error) {
+	result := []server.Authenticator{server.SessionAuth{}, &server.SignedURLAuth{Signer: signer}}
+	if len(auth.Tokens) > 0 {
+		tokens, err := resolveSecrets("auth.tokens", auth.Tokens)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &server.TokenAuth{Tokens: tokens})
+	}
+	if auth.JWT.Enabled() {
+		jwt := &server.JWTAuth{
+			JWKSURL:  auth.JWT.JWKSURL,
+			Issuer:   auth.JWT.Issuer,
+			Audience: auth.JWT.Audience,
+			Claims:   auth.JWT.Claims,
+			Roles:    auth.JWT.RolesClaim,
+		}
+		jwt.Leeway, _ = time.ParseDuration(auth.JWT.Leeway) // validated when the config was loaded
+		if auth.JWT.Secret != "" {
+			secret, err := resolveSecrets("auth.jwt", map[string]string{"secret": auth.JWT.Secret})
+			if err != nil {
+				return nil, err
+			}
+			jwt.Secret = []byte(secret["secret"])
+		}
+		result = append(result, jwt)
+	}
+	if len(auth.Users) > 0 {
+		users, err := resolveSecrets("auth.users", auth.Users)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, &server.BasicAuth{Realm: auth.Realm, Users: users})
+	}
+	return result, nil
+}
+
+// apiKeys builds the API key authenticator set in cfg, or nil without
+// keys. "file:PATH" keys are read relative to the project directory.
+func apiKeys(cfg config.APIKeysConfig, directory string, kv store.Store) (*server.APIKeyAuth, error) {
+	if len(cfg.Keys) == 0 {
+		return nil, nil
+	}
+	values := make(map[string]string, len(cfg.Keys))
+	for name, key := range cfg.Keys {
+		values[name] = key.Key
+		if path, ok := strings.CutPrefix(key.Key, "file:"); ok {
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(directory, path)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("auth.api_keys.keys.%s: failed to read key file: %w", name, err)
+			}
+			values[name] = strings.TrimSpace(string(data))
+			if values[name] == "" {
+				return nil, fmt.Errorf("auth.api_keys.keys.%s: key file %s is empty", name, path)
+			}
+		}
+	}
+	resolved, err := resolveSecrets("auth.api_keys.keys", values)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]server.APIKey, 0, len(resolved))
+	for name, key := range resolved {
+		keys = append(keys, server.APIKey{Name: name, Key: key, RateLimit: cfg.Keys[name].RateLimit})
+	}
+	return server.NewAPIKeyAuth(kv, cfg.Header, cfg.Query, keys), nil
+}
+
+// ipAccess builds the client address checks set in cfg, or nil without
+// any
+func ipAccess(cfg config.IPAccessConfig) (*server.IPAccess, error) {
+	if len(cfg.Allow) == 0 && len(cfg.Deny) == 0 && len(cfg.Routes) == 0 {
+		return nil, nil
+	}
+	global, err := server.ParseIPRule(cfg.Allow, cfg.Deny)
+	if err != nil {
+		return nil, fmt.Errorf("ip_access: %w", err)
+	}
+	routes := make(map[string]server.IPRule, len(cfg.Routes))
+	for pattern, rule := range cfg.Routes {
+		if routes[pattern], err = server.ParseIPRule(rule.Allow, rule.Deny); err != nil {
+			return nil, fmt.Errorf("ip_access.routes.%s: %w", pattern, err)
+		}
+	}
+	return server.NewIPAccess(global, routes), nil
+}
+
+// trustedProxies builds the proxies whose forwarded client addresses are
+// believed, or nil without any
+func trustedProxies(addresses []string) (*server.TrustedProxies, error) {
+	if len(addresses) == 0 {
+		return nil, nil
+	}
+	proxies, err := server.ParseTrustedProxies(addresses)
+	if err != nil {
+		return nil, fmt.Errorf("trusted_proxies: %w", err)
+	}
+	return proxies, nil
+}
+
+// urlSigner builds the signer behind signed_url from cfg
+func urlSigner(cfg config.SignedURLsConfig) (*routebuilder.URLSigner, error) {
+	lifetime, _ := time.ParseDuration(cfg.Lifetime) // validated when the config was loaded
+	if cfg.Secret == "" {
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		return routebuilder.NewURLSigner(secret, lifetime), nil
+	}
+	secret, err := resolveSecrets("signed_urls", map[string]string{"secret": cfg.Secret})
+	if err != nil {
+		return nil, err
+	}
+	return routebuilder.NewURLSigner([]byte(secret["secret"]), lifetime), nil
+}
+
+// sessions builds the session subsystem set in cfg
+func sessions(cfg config.SessionsConfig, kv store.Store) (*server.Sessions, error) {
+	lifetime, _ := time.ParseDuration(cfg.Lifetime) // validated when the config was loaded
+	// Servers sharing a store share its sessions, where a key made at
+	// startup would only open cookies from the server that made them
+	if cfg.Store == "store" || (cfg.Store == "" && cfg.Secret == "" && store.Shared(kv)) {
+		return server.NewStoreSessions(kv, cfg.Cookie, lifetime), nil
+	}
+	if cfg.Secret == "" {
+		log.Printf("Sessions are encrypted with a key made at startup and end when the server restarts; set sessions.secret to keep them")
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		return server.NewCookieSessions(secret, cfg.Cookie, lifetime)
+	}
+	secret, err := resolveSecrets("sessions", map[string]string{"secret": cfg.Secret})
+	if err != nil {
+		return nil, err
+	}
+	return server.NewCookieSessions([]byte(secret["secret"]), cfg.Cookie, lifetime)
+}
+
+// resolveSecrets reads "env:NAME" values from the environment
+func resolveSecrets(key string, secrets map[string]string) (map[string]string, error) {
+	resolved := make(map[string]string, len(secrets))
+	for name, value := range secrets {
+		if env, ok := strings.CutPrefix(value, "env:"); ok {
+			value = os.Getenv(env)
+			if value == "" {
+				return nil, fmt.Errorf("%s.%s: environment variable %s is not set", key, name, env)
+			}
+		}
+		resolved[name] = value
+	}
+	return resolved, nil
+}
+
+// identitySecret resolves the key signing identity headers, or returns nil
+// without one
+func identitySecret(auth config.AuthConfig) ([]byte, error) {
+	if auth.IdentitySecret == "" {
+		return nil, nil
+	}
+	secret, err := resolveSecrets("auth", map[string]string{"identity_secret": auth.IdentitySecret})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(secret["identity_secret"]), nil
+}
+
+// adminTokenFile keeps the admin token made at startup, relative to the
+// project directory
+const adminTokenFile = ".htmlnojs/admin_token"
+
+// adminAccess builds who may use the /_admin endpoints from cfg. Without a
+// token one is made and written to adminTokenFile, readable only by the
+// user running the server.
+func adminAccess(cfg config.AdminConfig, projectDir string) (*server.Admin, error) {
+	admin := &server.Admin{Roles: cfg.Roles}
+	if cfg.Token != "" {
+		token, err := resolveSecrets("admin", map[string]string{"token": cfg.Token})
+		if err != nil {
+			return nil, err
+		}
+		admin.Token = token["token"]
+		return admin, nil
+	}
+
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	admin.Token = hex.EncodeToString(secret)
+	path := filepath.Join(projectDir, adminTokenFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to write admin token: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(admin.Token+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("failed to write admin token: %w", err)
+	}
+	log.Printf("Admin token written to %s; set admin.token to keep one across restarts", path)
+	return admin, nil
+}
+
+// adminToken returns the token htmlnojs commands send to a running
+// server's /_admin endpoints: $HTMLNOJS_ADMIN_TOKEN, or the one the server
+// made in the project directory
+func adminToken(projectDir string) string {
+	if token := os.Getenv("HTMLNOJS_ADMIN_TOKEN"); token != "" {
+		return token
+	}
+	data, err := os.ReadFile(filepath.Join(projectDir, adminTokenFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// login builds the login routes set in cfg, or nil without a handler; a
+// relative template is in the project directory
+func login(cfg config.LoginConfig, projectDir string) (*server.Login, error) {
+	if cfg.Handler == "" {
+		return nil, nil
+	}
+	l := &server.Login{
+		Path:       cfg.Path,
+		LogoutPath: cfg.LogoutPath,
+		Handler:    cfg.Handler,
+		Redirect:   cfg.Redirect,
+		RateLimit:  cfg.RateLimit,
+	}
+	if cfg.Template != "" {
+		path := cfg.Template
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(projectDir, path)
+		}
+		tmpl, err := template.ParseFiles(path)
+		if err != nil {
+			return nil, fmt.Errorf("auth.login.template: %w", err)
+		}
+		l.Template = tmpl
+	}
+	return l, nil
+}
+
+// userAgentRules builds the user agent rules set in cfg, in order
+func userAgentRules(cfg []config.UserAgentRuleConfig) server.UserAgentRules {
+	rules := make(server.UserAgentRules, 0, len(cfg))
+	for _, rule := range cfg {
+		name := rule.Name
+		if name == "" {
+			name = rule.Match[0] // validated when the config was loaded
+		}
+		if name == "" {
+			name = "no user agent"
+		}
+		rules = append(rules, &server.UserAgentRule{
+			Name:      name,
+			Match:     rule.Match,
+			Action:    rule.Action,
+			RateLimit: rule.RateLimit,
+		})
+	}
+	return rules
+}
+
+// cors builds the CORS rules set in cfg, or nil without any; route rules
+// take the global settings they leave unset
+func cors(cfg config.CORSConfig) (*server.CORS, error) {
+	if len(cfg.Origins) == 0 && len(cfg.Routes) == 0 {
+		return nil, nil
+	}
+	global, err := corsRule(cfg.Origins, cfg.Methods, cfg.Headers, cfg.Expose, cfg.Credentials, cfg.MaxAge)
+	if err != nil {
+		return nil, fmt.Errorf("cors: %w", err)
+	}
+	routes := make(map[string]server.CORSRule, len(cfg.Routes))
+	for pattern, rule := range cfg.Routes {
+		credentials := cfg.Credentials
+		if rule.Credentials != nil {
+			credentials = *rule.Credentials
+		}
+		maxAge := rule.MaxAge
+		if maxAge == "" {
+			maxAge = cfg.MaxAge
+		}
+		routes[pattern], err = corsRule(
+			orGlobal(rule.Origins, cfg.Origins),
+			orGlobal(rule.Methods, cfg.Methods),
+			orGlobal(rule.Headers, cfg.Headers),
+			orGlobal(rule.Expose, cfg.Expose),
+			credentials, maxAge,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("cors.routes.%s: %w", pattern, err)
+		}
+	}
+	return server.NewCORS(global, routes), nil
+}
+
+func corsRule(origins, methods, headers, expose []string, credentials bool, maxAge string) (server.CORSRule, error) {
+	rule := server.CORSRule{
+		Origins:     origins,
+		Methods:     methods,
+		Headers:     headers,
+		Expose:      expose,
+		Credentials: credentials,
+	}
+	if maxAge != "" {
+		d, err := time.ParseDuration(maxAge)
+		if err != nil {
+			return server.CORSRule{}, fmt.Errorf("invalid max_age: %w", err)
+		}
+		rule.MaxAge = d
+	}
+	return rule, nil
+}
+
+// orGlobal returns a route's list, or the global one when it sets none
+func orGlobal(route, global []string) []string {
+	if len(route) > 0 {
+		return route
+	}
+	return global
+}
+
+// auditLog opens the audit log set in cfg, or returns nil without one; a
+// relative file is in the project directory
+func auditLog(cfg config.AuditConfig, projectDir string) (*server.AuditLog, error) {
+	if cfg.File == "" && cfg.Webhook == "" {
+		return nil, nil
+	}
+	file := cfg.File
+	if file != "" && !filepath.IsAbs(file) {
+		file = filepath.Join(projectDir, file)
+	}
+	return server.NewAuditLog(file, cfg.Webhook, cfg.Methods)
+}
+
+// maintenance builds the maintenance mode switch, which is always available
+// through /_admin/maintenance; a relative sentinel file is in the project
+// directory
+func maintenance(cfg config.MaintenanceConfig, projectDir string) *server.Maintenance {
+	file := cfg.File
+	if file != "" && !filepath.IsAbs(file) {
+		file = filepath.Join(projectDir, file)
+	}
+	retryAfter, _ := time.ParseDuration(cfg.RetryAfter) // validated when the config was loaded
+	return server.NewMaintenance(file, cfg.Allow, retryAfter)
+}
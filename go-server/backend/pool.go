@@ -0,0 +1,123 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Pool is several uvicorn workers serving the same app, each on its own
+// port, so handlers that block don't hold up every request
+type Pool struct {
+	workers  []*Uvicorn
+	requests []atomic.Uint64 // Requests sent to each worker
+	next     atomic.Uint64
+}
+
+// FreePorts returns n distinct localhost ports nothing is listening on
+func FreePorts(n int) ([]int, error) {
+	ports := make([]int, 0, n)
+	for range n {
+		// Held open until all are picked, so no port comes up twice
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, fmt.Errorf("failed to find a free port: %w", err)
+		}
+		defer listener.Close()
+		ports = append(ports, listener.Addr().(*net.TCPAddr).Port)
+	}
+	return ports, nil
+}
+
+// NewPool prepares a uvicorn worker for each of ports; Start runs them
+func NewPool(python string, ports []int) (*Pool, error) {
+	pool := &Pool{requests: make([]atomic.Uint64, len(ports))}
+	for _, port := range ports {
+		worker, err := NewUvicorn(python, port)
+		if err != nil {
+			pool.Stop()
+			return nil, err
+		}
+		pool.workers = append(pool.workers, worker)
+	}
+	return pool, nil
+}
+
+// Start runs every worker on app, stopping them all if one fails
+func (p *Pool) Start(app []byte) error {
+	for _, worker := range p.workers {
+		if err := worker.Start(app); err != nil {
+			p.Stop()
+			return fmt.Errorf("worker on port %d: %w", worker.Port(), err)
+		}
+	}
+	return nil
+}
+
+// Size returns the number of workers
+func (p *Pool) Size() int {
+	return len(p.workers)
+}
+
+// Pick returns the host:port of the next running worker, taking turns so
+// each gets an even share of requests. With none running it picks one
+// anyway and the request fails as it would with a single backend.
+func (p *Pool) Pick() string {
+	n := uint64(len(p.workers))
+	start := p.next.Add(1) - 1
+	i := start % n
+	for offset := uint64(0); offset < n; offset++ {
+		if candidate := (start + offset) % n; p.workers[candidate].Status().State == "running" {
+			i = candidate
+			break
+		}
+	}
+	p.requests[i].Add(1)
+	return "127.0.0.1:" + strconv.Itoa(p.workers[i].Port())
+}
+
+// Restart replaces the app of one worker at a time, so the others keep
+// serving while each restarts
+func (p *Pool) Restart(app []byte) error {
+	for _, worker := range p.workers {
+		if err := worker.Restart(app); err != nil {
+			return fmt.Errorf("worker on port %d: %w", worker.Port(), err)
+		}
+	}
+	return nil
+}
+
+// Stop ends every worker
+func (p *Pool) Stop() {
+	for _, worker := range p.workers {
+		worker.Stop()
+	}
+}
+
+// Status reports the state of every worker and the requests it was sent
+func (p *Pool) Status() []Status {
+	statuses := make([]Status, len(p.workers))
+	for i, worker := range p.workers {
+		statuses[i] = worker.Status()
+		statuses[i].Port = worker.Port()
+		statuses[i].Requests = p.requests[i].Load()
+	}
+	return statuses
+}
+
+// Check is a health check failing once no worker answers; while one still
+// does, requests go to it
+func (p *Pool) Check(ctx context.Context) error {
+	var failures []string
+	for _, worker := range p.workers {
+		err := worker.Check(ctx)
+		if err == nil {
+			return nil
+		}
+		failures = append(failures, fmt.Sprintf("port %d: %v", worker.Port(), err))
+	}
+	return fmt.Errorf("no uvicorn worker is healthy (%s)", strings.Join(failures, "; "))
+}
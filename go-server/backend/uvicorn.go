@@ -0,0 +1,335 @@
+// Package backend runs the Python handler backend for the server, so a
+// project needs no second process started by hand
+package backend
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// startTimeout bounds how long uvicorn may take to answer /health
+const startTimeout = 15 * time.Second
+
+// Crash restarts wait minBackoff, doubling up to maxBackoff while uvicorn
+// keeps dying within stableAfter of starting
+const (
+	minBackoff  = time.Second
+	maxBackoff  = 30 * time.Second
+	stableAfter = 30 * time.Second
+)
+
+// Status is the state of a managed backend process, as shown on /health
+type Status struct {
+	State    string    `json:"state"` // "running", "restarting" or "stopped"
+	Port     int       `json:"port,omitempty"`
+	PID      int       `json:"pid,omitempty"`
+	Since    time.Time `json:"since"`
+	Restarts int       `json:"restarts"`
+	LastExit string    `json:"last_exit,omitempty"`
+	Requests uint64    `json:"requests"` // Sent to this process, counted by a Pool
+}
+
+// Uvicorn is a uvicorn process serving a generated FastAPI app on a local
+// port. It is restarted with backoff when it crashes. Handler modules are
+// imported once, so changes need a Restart.
+type Uvicorn struct {
+	python string
+	dir    string // Holds the generated main.py
+	port   int
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	stopping chan struct{} // Closed once the process is asked to stop
+	exited   chan struct{}
+	status   Status
+	failures int // Crashes in a row, each soon after starting
+}
+
+// Available reports why python can't run the generated app, nil if it can
+func Available(python string) error {
+	out, err := exec.Command(python, "-c", "import fastapi, uvicorn").CombinedOutput()
+	if err != nil {
+		if len(out) > 0 {
+			return fmt.Errorf("%s can't import fastapi and uvicorn: %s", python, lastLine(out))
+		}
+		return fmt.Errorf("%s can't import fastapi and uvicorn: %w", python, err)
+	}
+	return nil
+}
+
+// NewUvicorn prepares a uvicorn process for port; Start runs it
+func NewUvicorn(python string, port int) (*Uvicorn, error) {
+	dir, err := os.MkdirTemp("", "htmlnojs-backend-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create backend directory: %w", err)
+	}
+	return &Uvicorn{python: python, dir: dir, port: port}, nil
+}
+
+// Start writes app as main.py and serves it with python -m uvicorn,
+// returning once it answers /health
+func (u *Uvicorn) Start(app []byte) error {
+	if err := Available(u.python); err != nil {
+		return err
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.start(app)
+}
+
+// Port returns the port uvicorn listens on
+func (u *Uvicorn) Port() int {
+	return u.port
+}
+
+// Restart replaces the running app with app, e.g. after handlers changed
+func (u *Uvicorn) Restart(app []byte) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.stop()
+	return u.start(app)
+}
+
+// Stop ends uvicorn and removes the generated app
+func (u *Uvicorn) Stop() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.stop()
+	os.RemoveAll(u.dir)
+}
+
+// Status reports whether uvicorn is running and how often it crashed
+func (u *Uvicorn) Status() Status {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.status
+}
+
+// Check is a health check failing while uvicorn is down or not answering
+func (u *Uvicorn) Check(ctx context.Context) error {
+	status := u.Status()
+	if status.State != "running" {
+		if status.LastExit != "" {
+			return fmt.Errorf("uvicorn is %s after exiting with %s", status.State, status.LastExit)
+		}
+		return fmt.Errorf("uvicorn is %s", status.State)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.healthURL(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("uvicorn health check returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// start launches uvicorn on app and waits for it to become healthy
+func (u *Uvicorn) start(app []byte) error {
+	if err := os.WriteFile(filepath.Join(u.dir, "main.py"), app, 0644); err != nil {
+		return fmt.Errorf("failed to write backend app: %w", err)
+	}
+	u.failures = 0
+	if err := u.launch(); err != nil {
+		return err
+	}
+	if err := u.waitHealthy(); err != nil {
+		u.stop()
+		return err
+	}
+	return nil
+}
+
+// launch starts uvicorn on the app in dir and supervises it
+func (u *Uvicorn) launch() error {
+	cmd := exec.Command(u.python, "-m", "uvicorn", "main:app",
+		"--app-dir", u.dir, "--host", "127.0.0.1", "--port", strconv.Itoa(u.port))
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stdout := &logWriter{tag: fmt.Sprintf("uvicorn:%d stdout", u.port)}
+	stderr := &logWriter{tag: fmt.Sprintf("uvicorn:%d stderr", u.port)}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start uvicorn: %w", err)
+	}
+	log.Printf("Started uvicorn (pid %d) on port %d", cmd.Process.Pid, u.port)
+
+	stopping, exited := make(chan struct{}), make(chan struct{})
+	u.cmd, u.stopping, u.exited = cmd, stopping, exited
+	u.status = Status{State: "running", PID: cmd.Process.Pid, Since: time.Now(), Restarts: u.status.Restarts, LastExit: u.status.LastExit}
+	go u.supervise(cmd, stdout, stderr, stopping, exited)
+	return nil
+}
+
+// supervise waits for cmd to exit and, unless it was asked to stop,
+// launches it again after a backoff
+func (u *Uvicorn) supervise(cmd *exec.Cmd, stdout, stderr *logWriter, stopping, exited chan struct{}) {
+	err := cmd.Wait()
+	stdout.flush()
+	stderr.flush()
+	close(exited)
+
+	u.mu.Lock()
+	select {
+	case <-stopping:
+		u.mu.Unlock()
+		return
+	default:
+	}
+	if time.Since(u.status.Since) >= stableAfter {
+		u.failures = 0
+	}
+	delay := minBackoff << min(u.failures, 5)
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	u.failures++
+	u.status = Status{State: "restarting", Since: time.Now(), Restarts: u.status.Restarts, LastExit: exitReason(err)}
+	u.mu.Unlock()
+	log.Printf("WARNING: uvicorn exited unexpectedly (%s), restarting in %v", exitReason(err), delay)
+
+	for {
+		select {
+		case <-stopping:
+			return
+		case <-time.After(delay):
+		}
+		u.mu.Lock()
+		select {
+		case <-stopping:
+			// Stopped or restarted while we waited
+			u.mu.Unlock()
+			return
+		default:
+		}
+		u.status.Restarts++
+		err := u.launch()
+		u.mu.Unlock()
+		if err == nil {
+			return
+		}
+		log.Printf("ERROR: %v, retrying in %v", err, maxBackoff)
+		delay = maxBackoff
+	}
+}
+
+// healthURL is where uvicorn answers health checks
+func (u *Uvicorn) healthURL() string {
+	return fmt.Sprintf("http://127.0.0.1:%d/health", u.port)
+}
+
+// waitHealthy polls /health until it answers, uvicorn exits or startTimeout
+// passes
+func (u *Uvicorn) waitHealthy() error {
+	healthURL := u.healthURL()
+	deadline := time.Now().Add(startTimeout)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, healthURL, nil)
+		resp, err := http.DefaultClient.Do(req)
+		cancel()
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			err = fmt.Errorf("status %d", resp.StatusCode)
+		}
+
+		select {
+		case <-u.exited:
+			return fmt.Errorf("uvicorn exited before answering %s", healthURL)
+		case <-time.After(200 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("uvicorn did not become healthy at %s: %w", healthURL, err)
+		}
+	}
+}
+
+// stop ends the running process group and waits for it to exit
+func (u *Uvicorn) stop() {
+	if u.cmd == nil {
+		return
+	}
+	close(u.stopping)
+	select {
+	case <-u.exited:
+		// Crashed and waiting to restart, which closing stopping cancels
+	default:
+		syscall.Kill(-u.cmd.Process.Pid, syscall.SIGTERM)
+		select {
+		case <-u.exited:
+		case <-time.After(10 * time.Second):
+			syscall.Kill(-u.cmd.Process.Pid, syscall.SIGKILL)
+			<-u.exited
+		}
+	}
+	u.cmd = nil
+	u.status = Status{State: "stopped", Since: time.Now(), Restarts: u.status.Restarts, LastExit: u.status.LastExit}
+}
+
+// exitReason describes how a process ended, e.g. "exit status 1"
+func exitReason(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+	return err.Error()
+}
+
+// logWriter logs a process's output one line at a time, tagged with the
+// process and stream it came from
+type logWriter struct {
+	tag string
+	buf []byte
+}
+
+// Write logs every complete line in p and keeps the rest for later
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.logLine(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush logs a final line the process didn't end with a newline
+func (w *logWriter) flush() {
+	if len(w.buf) > 0 {
+		w.logLine(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *logWriter) logLine(line []byte) {
+	if text := strings.TrimRight(string(line), "\r"); text != "" {
+		log.Printf("[%s] %s", w.tag, text)
+	}
+}
+
+// lastLine returns the last non-empty line of a command's output, which
+// names the error of a Python traceback
+func lastLine(out []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
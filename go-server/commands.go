@@ -0,0 +1,725 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"htmlnojs/config"
+	"htmlnojs/doctor"
+	"htmlnojs/provenance"
+	"htmlnojs/routebuilder"
+	"htmlnojs/server"
+	"htmlnojs/setup"
+	"htmlnojs/smoke"
+	"htmlnojs/store"
+)
+
+// runCommand runs a subcommand such as "htmlnojs lint". It returns false
+// when name isn't a subcommand so the server starts as usual.
+func runCommand(name string, args []string) bool {
+	switch name {
+	case "lint":
+		os.Exit(runLint(args))
+	case "build":
+		os.Exit(runBuild(args))
+	case "generate":
+		os.Exit(runGenerate(args))
+	case "config":
+		os.Exit(runConfig(args))
+	case "smoke":
+		os.Exit(runSmoke(args))
+	case "doctor":
+		os.Exit(runDoctor(args))
+	case "replay":
+		os.Exit(runReplay(args))
+	default:
+		return false
+	}
+	return true
+}
+
+// loadProject resolves the project directories and its htmlnojs.json
+func loadProject(directory, configPath string) (*setup.Config, *config.ProjectConfig, error) {
+	cfg := &setup.Config{
+		ProjectDir:   directory,
+		PyHTMXDir:    filepath.Join(directory, "py_htmx"),
+		CSSDir:       filepath.Join(directory, "css"),
+		TemplatesDir: filepath.Join(directory, "templates"),
+		FontsDir:     filepath.Join(directory, "fonts"),
+	}
+
+	project, err := config.Load(configFilePath(directory, configPath))
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg.Exclude = project.Exclude
+	if cfg.HandlerDirs, err = handlerDirs(directory, project); err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, project, nil
+}
+
+// handlerDirs resolves the directory of every extra handler language
+// enabled in the project config, e.g. node_htmx for "node", plus those of
+// every in-process language
+func handlerDirs(directory string, project *config.ProjectConfig) (map[string]string, error) {
+	dirs := make(map[string]string, len(project.Handlers))
+	for language, handler := range project.Handlers {
+		adapter, err := routebuilder.GetHandlerAdapter(language)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config: handlers: %w", err)
+		}
+		if _, ok := adapter.(routebuilder.SidecarAdapter); handler.Spawn && !ok {
+			return nil, fmt.Errorf("invalid config: handlers.%s.spawn: %s has no backend to start", language, language)
+		}
+		dir := handler.Dir
+		if dir == "" {
+			dir = adapter.Dir()
+		}
+		dirs[language] = filepath.Join(directory, dir)
+	}
+	// In-process languages such as go_htmx/ need no backend to configure
+	for _, language := range routebuilder.HandlerAdapterNames() {
+		if _, ok := dirs[language]; ok || !routebuilder.IsInProcess(language) {
+			continue
+		}
+		adapter, _ := routebuilder.GetHandlerAdapter(language)
+		dirs[language] = filepath.Join(directory, adapter.Dir())
+	}
+	return dirs, nil
+}
+
+// configFilePath returns configPath, or htmlnojs.json in the project
+// directory when it is empty
+func configFilePath(directory, configPath string) string {
+	if configPath == "" {
+		return filepath.Join(directory, config.FileName)
+	}
+	return configPath
+}
+
+// registerTemplateFuncs registers the Python-backed template functions
+// declared in the project config
+func registerTemplateFuncs(project *config.ProjectConfig, fastAPIURL string) error {
+	for name, helper := range project.TemplateFuncs {
+		if err := routebuilder.RegisterPythonTemplateFunc(name, helper, fastAPIURL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runLint checks project files and exits non-zero when issues are found
+func runLint(args []string) int {
+	fs := flag.NewFlagSet("lint", flag.ExitOnError)
+	directory := fs.String("directory", ".", "Project directory to lint")
+	configPath := fs.String("config", "", "Project config file (default: <directory>/htmlnojs.json)")
+	fs.Parse(args)
+
+	cfg, project, err := loadProject(*directory, *configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := registerTemplateFuncs(project, "http://localhost"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	fileSet, err := cfg.GlobFiles()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	issues := routebuilder.LintTemplates(project, fileSet.TemplateFiles)
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+
+	overBudget, err := checkBudgets(cfg, project)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if len(issues)+overBudget > 0 {
+		fmt.Printf("%d issue(s) found\n", len(issues)+overBudget)
+		return 1
+	}
+	fmt.Println("No issues found")
+	return 0
+}
+
+// checkBudgets builds the routes, measures them against the project's
+// transfer budgets and returns how many went over
+func checkBudgets(cfg *setup.Config, project *config.ProjectConfig) (int, error) {
+	if len(project.Budgets) == 0 {
+		return 0, nil
+	}
+	routes, err := buildRoutes(cfg, project, 8081)
+	if err != nil {
+		return 0, fmt.Errorf("build failed: %w", err)
+	}
+	results, err := routebuilder.CheckBudgets(project.Budgets, routes)
+	if err != nil {
+		return 0, fmt.Errorf("invalid config: %w", err)
+	}
+
+	over := 0
+	for _, result := range results {
+		fmt.Println(result)
+		if !result.OK() {
+			over++
+		}
+	}
+	return over, nil
+}
+
+// runBuild builds every route once and writes a provenance report for audits
+func runBuild(args []string) int {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	directory := fs.String("directory", ".", "Project directory to build")
+	configPath := fs.String("config", "", "Project config file (default: <directory>/htmlnojs.json)")
+	out := fs.String("out", "", "Provenance report path, - for stdout (default: <directory>/htmlnojs-provenance.json)")
+	fs.Parse(args)
+
+	if *configPath == "" {
+		*configPath = filepath.Join(*directory, config.FileName)
+	}
+	if *out == "" {
+		*out = filepath.Join(*directory, "htmlnojs-provenance.json")
+	}
+
+	cfg, project, err := loadProject(*directory, *configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := registerTemplateFuncs(project, "http://localhost"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	fileSet, err := cfg.GlobFiles()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	routes, err := buildRoutes(cfg, project, 8081)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "build failed: %v\n", err)
+		return 1
+	}
+
+	report, err := provenance.Generate(provenance.Options{
+		ProjectDir: *directory,
+		ConfigPath: *configPath,
+		Project:    project,
+		Files:      fileSet,
+		Routes:     routes,
+		Embedded:   setup.EmbeddedFS(),
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	data = append(data, '\n')
+
+	if *out == "-" {
+		os.Stdout.Write(data)
+	} else if err := os.WriteFile(*out, data, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write provenance report: %v\n", err)
+		return 1
+	} else {
+		fmt.Fprintf(os.Stderr, "Wrote provenance report to %s\n", *out)
+	}
+
+	for _, warning := range report.Warnings {
+		fmt.Fprintf(os.Stderr, "WARNING: %s\n", warning)
+	}
+	return 0
+}
+
+// generatedFiles maps each generate target to its default output file
+var generatedFiles = map[string]string{
+	"fastapi": "main.py",
+	"models":  "models.py",
+	"client":  "client.py",
+}
+
+// runGenerate writes code derived from the discovered handlers: a FastAPI
+// main.py, pydantic request models or a typed client
+func runGenerate(args []string) int {
+	if len(args) == 0 || generatedFiles[args[0]] == "" {
+		fmt.Fprintln(os.Stderr, "usage: htmlnojs generate fastapi|models|client [-directory dir] [-out file]")
+		return 2
+	}
+	target := args[0]
+
+	fs := flag.NewFlagSet("generate "+args[0], flag.ExitOnError)
+	directory := fs.String("directory", ".", "Project directory")
+	configPath := fs.String("config", "", "Project config file (default: <directory>/htmlnojs.json)")
+	out := fs.String("out", "", "Output file, - for stdout (default: <directory>/"+generatedFiles[target]+")")
+	fastapiPort := fs.Int("fastapi-port", 8081, "Port the generated app listens on when run directly")
+	fs.Parse(args[1:])
+
+	if *out == "" {
+		*out = filepath.Join(*directory, generatedFiles[target])
+	}
+
+	cfg, project, err := loadProject(*directory, *configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	routes, err := buildRoutes(cfg, project, *fastapiPort)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "build failed: %v\n", err)
+		return 1
+	}
+
+	var source []byte
+	switch target {
+	case "models":
+		source, err = routebuilder.GenerateModels(routes.PythonRoutes)
+	case "client":
+		source, err = routebuilder.GenerateClient(routes.PythonRoutes)
+	default:
+		// py_htmx is located relative to the generated file
+		outDir := *directory
+		if *out != "-" {
+			outDir = filepath.Dir(*out)
+		}
+		pyHTMXDir, relErr := filepath.Rel(outDir, cfg.PyHTMXDir)
+		if relErr != nil {
+			pyHTMXDir = cfg.PyHTMXDir
+		}
+		source, err = routebuilder.GenerateFastAPIApp(routes.PythonRoutes, routebuilder.FastAPIAppOptions{
+			PyHTMXDir: filepath.ToSlash(pyHTMXDir),
+			Port:      *fastapiPort,
+		})
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *out == "-" {
+		os.Stdout.Write(source)
+		return 0
+	}
+	if err := os.WriteFile(*out, source, 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", *out, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s for %d handlers to %s\n", target, len(routes.PythonRoutes), *out)
+	return 0
+}
+
+// runConfig checks a proposed htmlnojs.json before it goes live, either
+// against the files on disk or against a running server with -server
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "usage: htmlnojs config check [-directory dir] [-server url] [-apply] proposed.json")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("config check", flag.ExitOnError)
+	directory := fs.String("directory", ".", "Project directory")
+	configPath := fs.String("config", "", "Current config file (default: <directory>/htmlnojs.json)")
+	serverURL := fs.String("server", "", "Check against a running server, e.g. http://localhost:8080")
+	apply := fs.Bool("apply", false, "Replace the current config when the proposal is valid")
+	asJSON := fs.Bool("json", false, "Print the result as JSON")
+	fs.Parse(args[1:])
+
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "usage: htmlnojs config check [-directory dir] [-server url] [-apply] proposed.json")
+		return 2
+	}
+	data, err := os.ReadFile(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var result *config.CheckResult
+	if *serverURL != "" {
+		result, err = checkConfigRemote(*serverURL, adminToken(*directory), data, *apply)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	} else {
+		cfg, project, err := loadProject(*directory, *configPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		_, _, _, result = checkProposal(cfg, project, data, 8081)
+		if result.Valid && *apply {
+			if err := config.WriteFile(configFilePath(*directory, *configPath), data); err != nil {
+				result.Errors = append(result.Errors, err.Error())
+			} else {
+				result.Applied = true
+			}
+		}
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(result)
+	} else {
+		printCheckResult(result)
+	}
+
+	if !result.Valid || (*apply && !result.Applied) {
+		return 1
+	}
+	return 0
+}
+
+// checkConfigRemote posts the proposal to a running server's
+// /_admin/config/validate endpoint with the admin token
+func checkConfigRemote(serverURL, token string, data []byte, apply bool) (*config.CheckResult, error) {
+	endpoint := strings.TrimRight(serverURL, "/") + "/_admin/config/validate"
+	if apply {
+		endpoint += "?" + url.Values{"apply": {"true"}}.Encode()
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s: %w", serverURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnprocessableEntity {
+		return nil, fmt.Errorf("%s returned status %d", endpoint, resp.StatusCode)
+	}
+	var result config.CheckResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode config check: %w", err)
+	}
+	return &result, nil
+}
+
+func printCheckResult(result *config.CheckResult) {
+	for _, e := range result.Errors {
+		fmt.Printf("ERROR: %s\n", e)
+	}
+	for _, w := range result.Warnings {
+		fmt.Printf("WARNING: %s\n", w)
+	}
+	if len(result.Changes) == 0 && result.Valid {
+		fmt.Println("No changes")
+	}
+	for _, change := range result.Changes {
+		fmt.Printf("  %s\n", change)
+	}
+
+	switch {
+	case !result.Valid:
+		fmt.Println("Config is invalid")
+	case result.Applied:
+		fmt.Printf("Config is valid and was applied (%d change(s))\n", len(result.Changes))
+	default:
+		fmt.Printf("Config is valid (%d change(s))\n", len(result.Changes))
+	}
+}
+
+// runSmoke starts the server against a mock (or real) backend, or targets a
+// deployed one with -url, and checks the configured routes. It exits 1 when
+// any check fails so it can gate a release.
+func runSmoke(args []string) int {
+	fs := flag.NewFlagSet("smoke", flag.ExitOnError)
+	directory := fs.String("directory", ".", "Project directory")
+	configPath := fs.String("config", "", "Project config file (default: <directory>/htmlnojs.json)")
+	targetURL := fs.String("url", "", "Check an already running server instead of starting one")
+	backend := fs.String("backend", "mock", "Handler backend when starting the server: mock or real")
+	backendCmd := fs.String("backend-cmd", "", "Command that starts the real backend, e.g. \"uvicorn main:app --port 8081\"")
+	fastapiPort := fs.Int("fastapi-port", 8081, "Port of the real backend")
+	timeout := fs.Duration("timeout", 10*time.Second, "Timeout per request")
+	fs.Parse(args)
+
+	if *backend != "mock" && *backend != "real" {
+		fmt.Fprintf(os.Stderr, "unknown backend %q (available: mock, real)\n", *backend)
+		return 2
+	}
+
+	cfg, project, err := loadProject(*directory, *configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx := context.Background()
+	client := &http.Client{Timeout: *timeout}
+	checks := project.Smoke.Routes
+
+	baseURL := *targetURL
+	if baseURL == "" {
+		backendPort := *fastapiPort
+		if *backend == "mock" {
+			mock, err := smoke.StartMockBackend()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 2
+			}
+			defer mock.Close()
+			backendPort = mock.Port()
+		} else if *backendCmd != "" {
+			stop, err := startBackend(ctx, *directory, *backendCmd, backendPort, *timeout)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 2
+			}
+			defer stop()
+		}
+
+		if err := registerTemplateFuncs(project, fmt.Sprintf("http://localhost:%d", backendPort)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		routes, err := buildRoutes(cfg, project, backendPort)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "build failed: %v\n", err)
+			return 1
+		}
+		if len(checks) == 0 {
+			checks = smoke.DefaultChecks(routes)
+		}
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to start server: %v\n", err)
+			return 2
+		}
+		srv := server.Development().WithStore(store.NewMemory()).WithRoutes(routes).Build()
+		httpServer := &http.Server{Handler: srv}
+		go httpServer.Serve(listener)
+		defer httpServer.Close()
+		baseURL = "http://" + listener.Addr().String()
+	} else if len(checks) == 0 {
+		checks = []config.SmokeCheck{{Path: "/health"}}
+	}
+
+	fmt.Printf("Smoke testing %s (%d check(s))\n", baseURL, len(checks))
+	failed := 0
+	for _, result := range smoke.Run(ctx, client, baseURL, checks) {
+		fmt.Println(result)
+		if !result.OK() {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d check(s) failed\n", failed, len(checks))
+		return 1
+	}
+	fmt.Printf("All %d check(s) passed\n", len(checks))
+	return 0
+}
+
+// runDoctor checks that the environment can run the project, e.g. before
+// a first start or on a new machine, and exits 1 when a check fails
+func runDoctor(args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	directory := fs.String("directory", ".", "Project directory")
+	configPath := fs.String("config", "", "Project config file (default: <directory>/htmlnojs.json)")
+	port := fs.Int("port", 8080, "Port the server will listen on")
+	fastapiPort := fs.Int("fastapi-port", 8081, "FastAPI server port")
+	fs.Parse(args)
+
+	cfg, project, err := loadProject(*directory, *configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := registerTemplateFuncs(project, fmt.Sprintf("http://localhost:%d", *fastapiPort)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	routes, buildErr := buildRoutes(cfg, project, *fastapiPort)
+
+	results := doctor.Run(context.Background(), doctor.Options{
+		Config:      cfg,
+		Project:     project,
+		Python:      workerPython(project),
+		Port:        *port,
+		FastAPIPort: *fastapiPort,
+		Routes:      routes,
+		BuildErr:    buildErr,
+	})
+	failed, warned := 0, 0
+	for _, result := range results {
+		fmt.Println(result)
+		if !result.OK() {
+			failed++
+		} else if result.Status == doctor.StatusWarn {
+			warned++
+		}
+	}
+
+	if failed > 0 {
+		fmt.Printf("%d of %d check(s) failed\n", failed, len(results))
+		return 1
+	}
+	fmt.Printf("No problems found, %d warning(s)\n", warned)
+	return 0
+}
+
+// runReplay lists the failed requests a running server kept or, given an
+// ID, sends that one to its backend again and prints the response. It exits
+// 1 when the replay fails too.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	serverURL := fs.String("server", "http://localhost:8080", "Running server")
+	directory := fs.String("directory", ".", "Project directory, where the server keeps its admin token")
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "usage: htmlnojs replay [-server url] [-directory dir] [id]")
+		return 2
+	}
+
+	endpoint := strings.TrimRight(*serverURL, "/") + "/_admin/replay"
+	token := adminToken(*directory)
+	if fs.NArg() == 0 {
+		var failed []routebuilder.FailedRequest
+		if err := replayRequest(http.MethodGet, endpoint, token, &failed); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		if len(failed) == 0 {
+			fmt.Println("No failed requests")
+		}
+		for _, request := range failed {
+			outcome := request.Error
+			if request.Status != 0 {
+				outcome = fmt.Sprintf("status %d", request.Status)
+			}
+			fmt.Printf("%4s  %s  %s %s  %s\n", request.ID, request.Time.Local().Format("15:04:05"), request.Method, request.URL, outcome)
+		}
+		return 0
+	}
+
+	var result routebuilder.ReplayResult
+	if err := replayRequest(http.MethodPost, endpoint+"/"+url.PathEscape(fs.Arg(0)), token, &result); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if result.Error != "" {
+		fmt.Printf("Replay failed after %.0fms: %s\n", result.DurationMs, result.Error)
+		return 1
+	}
+	fmt.Printf("Status %d in %.0fms\n", result.Status, result.DurationMs)
+	names := make([]string, 0, len(result.Headers))
+	for name := range result.Headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s: %s\n", name, strings.Join(result.Headers[name], ", "))
+	}
+	fmt.Printf("\n%s\n", result.Body)
+	if result.BodyTruncated {
+		fmt.Println("(body truncated)")
+	}
+	if result.Status >= 500 {
+		return 1
+	}
+	return 0
+}
+
+// replayRequest calls a /_admin/replay endpoint with the admin token and
+// decodes its answer into v
+func replayRequest(method, endpoint, token string, v any) error {
+	req, err := http.NewRequest(method, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %w", endpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s returned status %d: %s", endpoint, resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", endpoint, err)
+	}
+	return nil
+}
+
+// startBackend runs command in the project directory and waits until the
+// backend answers /health on port; the returned func stops it
+func startBackend(ctx context.Context, directory, command string, port int, timeout time.Duration) (func(), error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = directory
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	// Run it in its own process group so the shell's children stop with it
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start backend: %w", err)
+	}
+	stop := func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+		cmd.Wait()
+	}
+
+	if err := waitHealthy(ctx, port, timeout); err != nil {
+		stop()
+		return nil, err
+	}
+	return stop, nil
+}
+
+// waitHealthy polls the /health of the backend on port until it answers
+func waitHealthy(ctx context.Context, port int, timeout time.Duration) error {
+	healthURL := fmt.Sprintf("http://localhost:%d/health", port)
+	deadline := time.Now().Add(timeout)
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, time.Second)
+		err := checkHTTP(checkCtx, healthURL)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("backend did not become healthy at %s: %w", healthURL, err)
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// restartKeys are settings read once at startup; changing them takes effect
+// after a restart rather than on apply
+var restartKeys = map[string]bool{
+	"template_funcs":  true,
+	"cost_report":     true,
+	"sampling":        true,
+	"slow_requests":   true,
+	"idempotency":     true,
+	"nonces":          true,
+	"warmup":          true,
+	"demo_mode":       true,
+	"store":           true,
+	"status_page":     true,
+	"doctor":          true,
+	"worker":          true,
+	"shutdown":        true,
+	"replay":          true,
+	"auth":            true,
+	"sessions":        true,
+	"csrf":            true,
+	"signed_urls":     true,
+	"ip_access":       true,
+	"trusted_proxies": true,
+	"cors":            true,
+	"audit":           true,
+	"maintenance":     true,
+	"admin":           true,
+	"user_agents":     true,
+}
+
+// Change is one setting that differs between two configs
+type Change struct {
+	Key             string `json:"key"`
+	Old             any    `json:"old,omitempty"`
+	New             any    `json:"new,omitempty"`
+	RestartRequired bool   `json:"restart_required,omitempty"`
+}
+
+func (c Change) String() string {
+	s := fmt.Sprintf("%s: %s -> %s", c.Key, formatValue(c.Old), formatValue(c.New))
+	if c.RestartRequired {
+		s += " (restart required)"
+	}
+	return s
+}
+
+// CheckResult reports whether a proposed config is valid and what it changes
+type CheckResult struct {
+	Valid    bool     `json:"valid"`
+	Errors   []string `json:"errors,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+	Changes  []Change `json:"changes"`
+	Applied  bool     `json:"applied"`
+}
+
+// Check parses a proposed config and diffs it against the current one. The
+// parsed config is nil when the proposal is invalid.
+func Check(current *ProjectConfig, data []byte) (*ProjectConfig, *CheckResult) {
+	result := &CheckResult{Changes: []Change{}}
+
+	proposed, err := Parse(data)
+	if err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return nil, result
+	}
+
+	result.Valid = true
+	result.Changes = Diff(current, proposed)
+	return proposed, result
+}
+
+// Diff lists the settings that differ between two configs, keyed by their
+// dotted JSON path such as "sampling.rate"
+func Diff(current, proposed *ProjectConfig) []Change {
+	before := flatten(current)
+	after := flatten(proposed)
+
+	keys := make(map[string]bool, len(before)+len(after))
+	for key := range before {
+		keys[key] = true
+	}
+	for key := range after {
+		keys[key] = true
+	}
+
+	changes := []Change{}
+	for key := range keys {
+		if reflect.DeepEqual(before[key], after[key]) {
+			continue
+		}
+		top, _, _ := strings.Cut(key, ".")
+		changes = append(changes, Change{
+			Key:             key,
+			Old:             before[key],
+			New:             after[key],
+			RestartRequired: restartKeys[top],
+		})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Key < changes[j].Key })
+	return changes
+}
+
+// WriteFile replaces the config file atomically, so a running server or a
+// crash never observes a half-written file
+func WriteFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to write config %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write config %s: %w", path, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write config %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write config %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace config %s: %w", path, err)
+	}
+	return nil
+}
+
+// flatten turns a config into dotted keys; objects are expanded, lists and
+// scalars are compared as a whole
+func flatten(cfg *ProjectConfig) map[string]any {
+	out := map[string]any{}
+	if cfg == nil {
+		return out
+	}
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return out
+	}
+	var values map[string]any
+	if err := json.Unmarshal(data, &values); err != nil {
+		return out
+	}
+
+	var walk func(prefix string, value any)
+	walk = func(prefix string, value any) {
+		object, ok := value.(map[string]any)
+		if !ok {
+			out[prefix] = value
+			return
+		}
+		for key, child := range object {
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			walk(key, child)
+		}
+	}
+	walk("", values)
+	return out
+}
+
+func formatValue(v any) string {
+	if v == nil {
+		return "(unset)"
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
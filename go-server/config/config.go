@@ -0,0 +1,1469 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// FileName is the project configuration file looked up in the project directory
+const FileName = "htmlnojs.json"
+
+// DefaultExclude keeps test helpers and private modules from becoming routes
+var DefaultExclude = []string{"test_*.py", "_*.py", "conftest.py", "__pycache__"}
+
+// ProjectConfig holds the optional per-project settings read from htmlnojs.json
+type ProjectConfig struct {
+	// TemplateEngine selects how templates are rendered: "go" (html/template)
+	// or "jinja" (Jinja2/Django syntax)
+	TemplateEngine string `json:"template_engine,omitempty"`
+
+	// TemplateFuncs maps template function names to Python helpers,
+	// e.g. {"server_time": "utils.htmx_current_time"}
+	TemplateFuncs map[string]string `json:"template_funcs,omitempty"`
+
+	// Exclude lists glob patterns for files and directories skipped during
+	// discovery. Setting it replaces DefaultExclude.
+	Exclude []string `json:"exclude,omitempty"`
+
+	// Symlinks decides how templates, CSS, fonts and fragments behind a
+	// symbolic link are read: "inside" (the default) follows links that stay
+	// in the project directory, "deny" refuses every link and "follow"
+	// follows them anywhere
+	Symlinks string `json:"symlinks,omitempty"`
+
+	// FrontMatter restricts the values templates may declare in front-matter
+	FrontMatter FrontMatterConfig `json:"front_matter,omitempty"`
+
+	// Owners maps route patterns to the team charged for them, e.g.
+	// {"/api/billing/*": "billing"}. Front-matter "owner:" and @owner(team)
+	// take precedence.
+	Owners map[string]string `json:"owners,omitempty"`
+
+	// CostReport periodically writes per-owner usage for chargeback
+	CostReport CostReportConfig `json:"cost_report,omitempty"`
+
+	// Sampling captures full details of some requests for investigation
+	Sampling SamplingConfig `json:"sampling,omitempty"`
+
+	// SlowRequests logs page and handler requests that take longer than
+	// this, e.g. "500ms", with their route; empty disables the log
+	SlowRequests string `json:"slow_requests,omitempty"`
+
+	// Warmup lists paths requested once the server starts, e.g.
+	// "/api/products/list?page=1", so backends are warm for the first user;
+	// GET handlers marked @warmup are added to it
+	Warmup []string `json:"warmup,omitempty"`
+
+	// Idempotency replays the answer to a POST or PATCH handler request
+	// to repeats carrying the same Idempotency-Key header
+	Idempotency IdempotencyConfig `json:"idempotency,omitempty"`
+
+	// Nonces configures the single-use tokens pages embed for handlers
+	// marked @nonce
+	Nonces NonceConfig `json:"nonces,omitempty"`
+
+	// Auth decides who may reach routes marked @auth and templates named
+	// *_auth or *_admin; without it they answer every request with a 401
+	Auth AuthConfig `json:"auth,omitempty"`
+
+	// CSRF requires the session's CSRF token on POST, PUT, PATCH and DELETE
+	// requests to handlers; rendered pages send it from their forms and htmx
+	CSRF bool `json:"csrf,omitempty"`
+
+	// Sessions configures the session every client gets, which handlers
+	// see in the X-HTMLnoJS-Session and X-HTMLnoJS-User headers
+	Sessions SessionsConfig `json:"sessions,omitempty"`
+
+	// IPAccess limits which client addresses reach the server, globally
+	// and by route pattern
+	IPAccess IPAccessConfig `json:"ip_access,omitempty"`
+
+	// TrustedProxies are the reverse proxies in front of the server, as
+	// CIDR ranges or single addresses, or "unix" for connections on unix:
+	// listeners. Requests from them count as coming from the client address
+	// they forward in Forwarded or X-Forwarded-For.
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+
+	// UserAgents act on requests by their User-Agent before they reach any
+	// route, e.g. blocking known bad bots; the first matching rule applies
+	UserAgents []UserAgentRuleConfig `json:"user_agents,omitempty"`
+
+	// CORS lets pages on other origins call the server from the browser,
+	// answering their preflights; without it only the development server's
+	// blanket "*" applies
+	CORS CORSConfig `json:"cors,omitempty"`
+
+	// Audit records who made each state-changing request to a handler
+	// needing auth or roles, for compliance
+	Audit AuditConfig `json:"audit,omitempty"`
+
+	// Maintenance configures maintenance mode, switched at runtime through
+	// /_admin/maintenance or a sentinel file
+	Maintenance MaintenanceConfig `json:"maintenance,omitempty"`
+
+	// Admin says who may use the /_admin endpoints, which switch
+	// maintenance, apply configs and replay or capture traffic
+	Admin AdminConfig `json:"admin,omitempty"`
+
+	// SignedURLs configures the links made by the signed_url template
+	// function, which open protected routes without a session
+	SignedURLs SignedURLsConfig `json:"signed_urls,omitempty"`
+
+	// DuplicateRoutes decides what happens when two handlers resolve to the
+	// same URL: "error" (default) fails the build, "warn" keeps the first
+	DuplicateRoutes string `json:"duplicate_routes,omitempty"`
+
+	// DemoMode serves the project read-only: mutating requests get a
+	// "demo mode" notice instead of reaching handlers
+	DemoMode bool `json:"demo_mode,omitempty"`
+
+	// API configures versioned handlers under py_htmx/v1/, py_htmx/v2/, ...
+	API APIConfig `json:"api,omitempty"`
+
+	// Store is where sessions, caches, rate limits, jobs and flags persist:
+	// "memory://" (default), "sqlite://state.db" or "redis://host:6379/0"
+	Store string `json:"store,omitempty"`
+
+	// CSS configures how stylesheets are served
+	CSS CSSConfig `json:"css,omitempty"`
+
+	// Fonts configures how fonts/ files are served and preloaded
+	Fonts FontsConfig `json:"fonts,omitempty"`
+
+	// HTMX decides how routes answer htmx requests and the browser
+	// navigations that reach routes made for htmx
+	HTMX HTMXConfig `json:"htmx,omitempty"`
+
+	// Budgets cap the bytes of pages, stylesheets and fonts; "htmlnojs
+	// lint" fails when a rendered route goes over
+	Budgets []BudgetConfig `json:"budgets,omitempty"`
+
+	// Smoke lists the requests "htmlnojs smoke" makes after a release
+	Smoke SmokeConfig `json:"smoke,omitempty"`
+
+	// Handlers enables handler languages besides Python, keyed by adapter
+	// name, e.g. {"node": {"port": 8082}}
+	Handlers map[string]HandlerConfig `json:"handlers,omitempty"`
+
+	// WebSockets tunnels WebSocket connections on a path to another endpoint,
+	// e.g. {"/ws/chat": "ws://localhost:8081/chat"}. Handler routes pass
+	// upgrades through to their own backend without an entry here.
+	WebSockets map[string]string `json:"websockets,omitempty"`
+
+	// Gateway passes requests under a path through to a third-party API,
+	// keyed by the path, e.g. {"/ext/weather": {"url": "https://api.weather.example/v2"}},
+	// so pages can call it from the same origin
+	Gateway map[string]GatewayRoute `json:"gateway,omitempty"`
+
+	// Proxy tunes the connections to handler backends
+	Proxy ProxyConfig `json:"proxy,omitempty"`
+
+	// ProxyLog logs proxied requests and responses in full, for debugging
+	// what reaches a backend
+	ProxyLog ProxyLogConfig `json:"proxy_log,omitempty"`
+
+	// Replay keeps failed proxied requests so they can be sent again
+	Replay ReplayConfig `json:"replay,omitempty"`
+
+	// Worker runs py_htmx/ handlers in a Python process the server starts
+	// itself, instead of reaching a FastAPI backend over HTTP
+	Worker WorkerConfig `json:"worker,omitempty"`
+
+	// RequestEncoding decides what happens to request bodies a client sent
+	// gzip or deflate compressed: "decompress" (default) forwards them
+	// decompressed, so handlers only ever see plain bodies, "passthrough"
+	// forwards them as they came
+	RequestEncoding string `json:"request_encoding,omitempty"`
+
+	// ProxyHeaders rewrite the headers of proxied requests and responses,
+	// applied in order to the handler routes each rule matches
+	ProxyHeaders []HeaderRule `json:"proxy_headers,omitempty"`
+
+	// Transforms post-process the HTML fragments handler routes return,
+	// applied in order to the routes each one matches
+	Transforms []TransformConfig `json:"transforms,omitempty"`
+
+	// Sanitize strips scripts, inline event handlers and other JavaScript
+	// from every fragment handler routes return
+	Sanitize bool `json:"sanitize,omitempty"`
+
+	// DirectoryBackends maps py_htmx/ subdirectories to the backend URL
+	// serving their handlers, e.g. {"payments": "https://payments.internal"},
+	// in place of the FastAPI backend. The deepest matching directory wins.
+	DirectoryBackends map[string]string `json:"directory_backends,omitempty"`
+
+	// Canary sends part of the Python handler traffic to a second backend,
+	// e.g. one running a new version of py_htmx/
+	Canary CanaryConfig `json:"canary,omitempty"`
+
+	// Timeouts are named deadline profiles for handler routes, picked with
+	// @timeout(name); "default" applies to routes without one
+	Timeouts map[string]TimeoutProfile `json:"timeouts,omitempty"`
+
+	// Errors controls the fragments shown when a request fails, including
+	// those replacing backend 4xx and 5xx responses
+	Errors ErrorsConfig `json:"errors,omitempty"`
+
+	// StatusPage serves backend uptime, incidents and latency recorded from
+	// the health checks
+	StatusPage StatusPageConfig `json:"status_page,omitempty"`
+
+	// Doctor configures /_doctor, which runs the environment checks of
+	// "htmlnojs doctor" against the running server
+	Doctor DoctorConfig `json:"doctor,omitempty"`
+
+	// Shutdown controls how the server stops on SIGTERM or Ctrl+C
+	Shutdown ShutdownConfig `json:"shutdown,omitempty"`
+}
+
+// ProxyConfig tunes the HTTP transport used to reach handler backends.
+// Durations are strings such as "90s"; zero values keep the defaults.
+type ProxyConfig struct {
+	// MaxIdleConns caps idle connections kept across all backends (default 100)
+	MaxIdleConns int `json:"max_idle_conns,omitempty"`
+
+	// MaxIdleConnsPerHost caps idle connections kept per backend (default 100)
+	MaxIdleConnsPerHost int `json:"max_idle_conns_per_host,omitempty"`
+
+	// MaxConnsPerHost caps all connections per backend, 0 means unlimited
+	MaxConnsPerHost int `json:"max_conns_per_host,omitempty"`
+
+	// IdleConnTimeout closes idle connections after this long (default "90s")
+	IdleConnTimeout string `json:"idle_conn_timeout,omitempty"`
+
+	// DialTimeout bounds connecting to a backend (default "5s")
+	DialTimeout string `json:"dial_timeout,omitempty"`
+
+	// ResponseHeaderTimeout bounds the wait for response headers; by default
+	// only the route's @timeout applies
+	ResponseHeaderTimeout string `json:"response_header_timeout,omitempty"`
+
+	// Retries is how often a GET or HEAD is retried after a connection
+	// error or a 502/503 from the backend, 0 disables retries
+	Retries int `json:"retries,omitempty"`
+
+	// RetryBackoff is the base delay before the first retry, doubled for
+	// each further one and jittered (default "100ms")
+	RetryBackoff string `json:"retry_backoff,omitempty"`
+
+	// Breaker stops sending requests to a backend that keeps failing
+	Breaker BreakerConfig `json:"breaker,omitempty"`
+
+	// MaxInFlight caps the handler requests proxied at once, across all
+	// routes, 0 means unlimited. Requests over it get a 503 right away.
+	MaxInFlight int `json:"max_in_flight,omitempty"`
+
+	// OverloadRetryAfter is the Retry-After sent with those 503s
+	// (default "1s")
+	OverloadRetryAfter string `json:"overload_retry_after,omitempty"`
+
+	// BufferResponses is the largest response read into memory before it
+	// is passed on, so a backend failing halfway can be retried; larger
+	// and streamed responses pass through as they arrive. A size such as
+	// "256KB", or "off" (default "64KB").
+	BufferResponses string `json:"buffer_responses,omitempty"`
+
+	// HealthInterval is how often backends are polled on their /health
+	// endpoint; while one is down its routes answer without calling it
+	// (default "5s")
+	HealthInterval string `json:"health_interval,omitempty"`
+
+	// Host is the Host header sent to handler backends: empty for the
+	// backend's own address, "preserve" for the one the client sent, or a
+	// fixed name such as "app.example.com"
+	Host string `json:"host,omitempty"`
+
+	// BackendURL is where the Python backend runs when it isn't on
+	// localhost at the FastAPI port, e.g. "https://handlers.internal:8443"
+	BackendURL string `json:"backend_url,omitempty"`
+
+	// UnixSocket is the path of a Unix socket the Python backend listens on,
+	// e.g. with uvicorn --uds; it replaces the FastAPI port
+	UnixSocket string `json:"unix_socket,omitempty"`
+
+	// TLS configures https:// and wss:// backends
+	TLS ProxyTLSConfig `json:"tls,omitempty"`
+
+	// Signing adds an HMAC signature to every proxied request so backends
+	// can reject requests that didn't come through the server
+	Signing SigningConfig `json:"signing,omitempty"`
+}
+
+// ProxyLogConfig configures the debug log of proxied traffic. Credentials
+// are always masked: the Authorization, Cookie, Set-Cookie and X-Api-Key
+// headers, and password, secret and token fields of JSON and form bodies.
+type ProxyLogConfig struct {
+	// Enabled turns the log on; it is verbose, so leave it off in production
+	Enabled bool `json:"enabled,omitempty"`
+
+	// MaxBody is how many bytes of each body are logged (default 1024)
+	MaxBody int `json:"max_body,omitempty"`
+
+	// Redact lists further header names and body fields to mask,
+	// e.g. ["X-Session-Id", "card_number"]
+	Redact []string `json:"redact,omitempty"`
+}
+
+// ReplayConfig configures the failed requests kept for /_admin/replay
+type ReplayConfig struct {
+	// Capacity is how many failed requests are kept (default 50)
+	Capacity int `json:"capacity,omitempty"`
+}
+
+// SigningConfig configures the signature header on proxied requests. Its
+// value is "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<path>">".
+type SigningConfig struct {
+	// Secret is the shared key, or "env:NAME" to read it from the
+	// environment variable NAME; empty disables signing
+	Secret string `json:"secret,omitempty"`
+
+	// Header carries the signature (default "X-HTMLnoJS-Signature")
+	Header string `json:"header,omitempty"`
+}
+
+// BreakerConfig configures the per-backend circuit breaker. While open,
+// GET requests get the backend's last good response for the same URL and
+// everything else gets Fallback, without waiting on the backend.
+type BreakerConfig struct {
+	// Failures is how many consecutive connection errors or 502/503/504
+	// responses open the circuit, 0 disables the breaker
+	Failures int `json:"failures,omitempty"`
+
+	// Cooldown is how long the circuit stays open before one trial request
+	// is let through (default "10s")
+	Cooldown string `json:"cooldown,omitempty"`
+
+	// Fallback is the HTML fragment served while open and nothing is cached
+	Fallback string `json:"fallback,omitempty"`
+}
+
+// ProxyTLSConfig configures TLS connections to backends
+type ProxyTLSConfig struct {
+	// CAFile is a PEM bundle trusted in addition to the system roots
+	CAFile string `json:"ca_file,omitempty"`
+
+	// ServerName overrides the name verified in the backend's certificate
+	ServerName string `json:"server_name,omitempty"`
+
+	// MinVersion is the oldest TLS version accepted: "1.2" (default) or "1.3"
+	MinVersion string `json:"min_version,omitempty"`
+
+	// InsecureSkipVerify accepts any certificate; for local testing only
+	InsecureSkipVerify bool `json:"insecure_skip_verify,omitempty"`
+
+	// CertFile and KeyFile are the PEM client certificate and key presented
+	// to backends that require mutual TLS
+	CertFile string `json:"cert_file,omitempty"`
+	KeyFile  string `json:"key_file,omitempty"`
+}
+
+// HeaderRule rewrites headers on the handler routes matching Match
+type HeaderRule struct {
+	// Match is a route, a glob or a prefix such as "/api/*"; empty matches
+	// every handler route
+	Match string `json:"match,omitempty"`
+
+	// Request is applied before the request goes to the backend
+	Request HeaderRewrite `json:"request,omitempty"`
+
+	// Response is applied before the backend's response goes to the client
+	Response HeaderRewrite `json:"response,omitempty"`
+}
+
+// HeaderRewrite renames, then removes, then sets headers
+type HeaderRewrite struct {
+	// Rename maps old header names to new ones, e.g. {"X-User": "X-Remote-User"}
+	Rename map[string]string `json:"rename,omitempty"`
+
+	// Remove drops headers, e.g. ["Server"]
+	Remove []string `json:"remove,omitempty"`
+
+	// Set adds headers, replacing existing values, e.g. {"X-App-Env": "prod"}
+	Set map[string]string `json:"set,omitempty"`
+}
+
+// TransformConfig enables one response transform: a built-in
+// ("rewrite_urls", "csrf", "wrap", "sanitize") or one registered by Go code
+type TransformConfig struct {
+	// Name of the transform
+	Name string `json:"name"`
+
+	// Match is a route, a glob or a prefix such as "/api/*"; empty matches
+	// every handler route
+	Match string `json:"match,omitempty"`
+
+	// Options configure the transform, e.g. {"class": "fragment"} for wrap
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// WorkerConfig configures the Python worker for py_htmx/ handlers
+type WorkerConfig struct {
+	// Mode "stdio" starts a worker and sends it requests as JSON-RPC over its
+	// stdin and stdout; "uvicorn" serves the generated FastAPI app with
+	// uvicorn on a free port; "http" proxies to a FastAPI backend run
+	// separately. Empty starts uvicorn when nothing answers on the FastAPI
+	// port and uvicorn is installed, and proxies otherwise.
+	Mode string `json:"mode,omitempty"`
+
+	// Python is the interpreter running the worker or uvicorn (default "python3")
+	Python string `json:"python,omitempty"`
+
+	// Count is how many uvicorn processes share the requests when the
+	// server runs uvicorn, each on its own port (default 1)
+	Count int `json:"count,omitempty"`
+}
+
+// CanaryConfig routes a share of Python handler requests to another backend.
+// A Cookie or Header value of "1" always picks the canary and "0" always
+// picks the regular backend; other requests are split by Percent.
+type CanaryConfig struct {
+	// URL of the canary backend, e.g. "http://localhost:8091"; empty disables it
+	URL string `json:"url,omitempty"`
+
+	// Percent of requests sent to the canary, 0 to 100
+	Percent int `json:"percent,omitempty"`
+
+	// Cookie keeps a browser on the backend it was first sent to
+	Cookie string `json:"cookie,omitempty"`
+
+	// Header lets a request pick its backend, e.g. for testing the canary
+	Header string `json:"header,omitempty"`
+}
+
+// GatewayRoute is a third-party API served under a path of the server. The
+// rest of the request path and the query are appended to URL.
+type GatewayRoute struct {
+	// URL is the API's base URL, e.g. "https://api.weather.example/v2"
+	URL string `json:"url"`
+
+	// Headers are set on every request, e.g. an API key; a value of
+	// "env:NAME" is read from the environment variable NAME
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// Methods the route accepts (default GET and HEAD)
+	Methods []string `json:"methods,omitempty"`
+}
+
+// TimeoutProfile bounds the phases of a proxied request. Durations are
+// strings such as "10s"; empty ones fall back to the "default" profile.
+type TimeoutProfile struct {
+	// Connect bounds connecting to the backend (default proxy.dial_timeout)
+	Connect string `json:"connect,omitempty"`
+
+	// ResponseHeader bounds the wait for the backend to start responding
+	// (default proxy.response_header_timeout)
+	ResponseHeader string `json:"response_header,omitempty"`
+
+	// Total bounds the whole request, body included (default "30s")
+	Total string `json:"total,omitempty"`
+}
+
+// ErrorsConfig configures error fragments
+type ErrorsConfig struct {
+	// Detail "full" shows backend error messages and tracebacks, for
+	// development; "generic" shows only a generic message, for production
+	// (default "full")
+	Detail string `json:"detail,omitempty"`
+
+	// Template is an html/template file, relative to the project directory,
+	// rendering error fragments from .Status, .Title, .Message and .Detail
+	Template string `json:"template,omitempty"`
+
+	// Forbidden is an html/template file, relative to the project
+	// directory, rendering the 403 page for clients without a role the
+	// route requires, from .Path, .User and .Roles
+	Forbidden string `json:"forbidden,omitempty"`
+
+	// Maintenance is an html/template file, relative to the project
+	// directory, rendering the 503 page served during maintenance, from
+	// .Path, .Message, .Since and .RetryAfter
+	Maintenance string `json:"maintenance,omitempty"`
+}
+
+// IdempotencyConfig configures Idempotency-Key handling
+type IdempotencyConfig struct {
+	// Window is how long answers are kept for repeats, e.g. "10m"; empty
+	// disables Idempotency-Key handling
+	Window string `json:"window,omitempty"`
+}
+
+// NonceConfig configures action nonces
+type NonceConfig struct {
+	// Lifetime is how long a page's unused nonces stay valid, e.g. "30m"
+	// (default "1h")
+	Lifetime string `json:"lifetime,omitempty"`
+}
+
+// AuthConfig configures the ways clients sign in to routes that require
+// auth. They are tried in order: signed-in session, bearer token, JWT,
+// Basic auth. Passwords and tokens may be "env:NAME" to read them from the
+// environment variable NAME.
+type AuthConfig struct {
+	// Users are the Basic auth passwords by user name
+	Users map[string]string `json:"users,omitempty"`
+
+	// Realm names the site in the browser's sign in prompt (default "htmlnojs")
+	Realm string `json:"realm,omitempty"`
+
+	// Tokens are the bearer tokens accepted by the name they identify,
+	// e.g. {"ci": "env:CI_TOKEN"}
+	Tokens map[string]string `json:"tokens,omitempty"`
+
+	// JWT accepts bearer JWTs signed by an identity provider
+	JWT JWTConfig `json:"jwt,omitempty"`
+
+	// APIKeys lets programmatic clients call handlers with a key
+	APIKeys APIKeysConfig `json:"api_keys,omitempty"`
+
+	// Roles are the roles of each user, token, API key or JWT subject by
+	// name, e.g. {"ann": ["admin"]}; @roles and *_admin templates check them
+	Roles map[string][]string `json:"roles,omitempty"`
+
+	// LoginURL is where browsers that aren't signed in are sent, with the
+	// page they asked for in ?next=; without it they get a 401, or the
+	// built-in login page when Login is set
+	LoginURL string `json:"login_url,omitempty"`
+
+	// IdentitySecret signs the X-HTMLnoJS-User, -Roles and -Auth headers
+	// sent to handlers, in X-HTMLnoJS-Identity-Signature, or "env:NAME" to read it
+	// from the environment; without it they are sent unsigned
+	IdentitySecret string `json:"identity_secret,omitempty"`
+
+	// Login serves a login form and signs sessions in with the user a
+	// handler checks the posted credentials for
+	Login LoginConfig `json:"login,omitempty"`
+}
+
+// LoginConfig configures the built-in /login and /logout routes, served
+// when Handler is set
+type LoginConfig struct {
+	// Handler is the route of the handler checking credentials, e.g.
+	// "/api/accounts/verify"; it gets the posted form and answers with
+	// {"user": "ann", "roles": ["admin"]}, or no user when they're wrong
+	Handler string `json:"handler,omitempty"`
+
+	// Path serves the form (default "/login")
+	Path string `json:"path,omitempty"`
+
+	// LogoutPath signs out on POST (default "/logout")
+	LogoutPath string `json:"logout_path,omitempty"`
+
+	// Redirect is where users go once signed in, unless the form carries
+	// the page they came from in next (default "/")
+	Redirect string `json:"redirect,omitempty"`
+
+	// Template is an html/template file, relative to the project
+	// directory, rendering the form from .Action, .Next, .Username,
+	// .Error and .CSRFToken
+	Template string `json:"template,omitempty"`
+
+	// RateLimit caps sign in attempts a minute per address (default 10)
+	RateLimit int `json:"rate_limit,omitempty"`
+}
+
+// JWTConfig configures bearer JWT verification. A valid token's claims are
+// passed to handlers in the headers named by Claims, on every handler
+// route, not just those that require auth.
+type JWTConfig struct {
+	// JWKSURL publishes the provider's RS/PS/ES signing keys, e.g.
+	// "https://auth.example.com/.well-known/jwks.json"
+	JWKSURL string `json:"jwks_url,omitempty"`
+
+	// Secret verifies HS256/384/512 tokens, or "env:NAME" to read it from
+	// the environment variable NAME
+	Secret string `json:"secret,omitempty"`
+
+	// Issuer is the "iss" tokens must have, empty accepts any
+	Issuer string `json:"issuer,omitempty"`
+
+	// Audience must be in the tokens' "aud", empty accepts any
+	Audience string `json:"audience,omitempty"`
+
+	// Leeway is the clock skew allowed on "exp" and "nbf" (default "1m")
+	Leeway string `json:"leeway,omitempty"`
+
+	// Claims maps claim names to the request headers that carry them to
+	// handlers, e.g. {"sub": "X-User-Id", "email": "X-User-Email"}
+	Claims map[string]string `json:"claims,omitempty"`
+
+	// RolesClaim is the claim listing the token's roles (default "roles")
+	RolesClaim string `json:"roles_claim,omitempty"`
+}
+
+// Enabled reports whether JWTs are accepted
+func (c JWTConfig) Enabled() bool {
+	return c.JWKSURL != "" || c.Secret != ""
+}
+
+// APIKeysConfig configures the keys programmatic clients send to handler
+// routes. A valid key signs its client in, and handlers see the key's name
+// in the X-HTMLnoJS-API-Key header.
+type APIKeysConfig struct {
+	// Header carries the key (default "X-API-Key")
+	Header string `json:"header,omitempty"`
+
+	// Query is a query parameter that may carry the key instead, e.g.
+	// "api_key"; empty accepts the header only
+	Query string `json:"query,omitempty"`
+
+	// Keys are the accepted keys by the client they belong to
+	Keys map[string]APIKeyConfig `json:"keys,omitempty"`
+}
+
+// APIKeyConfig is one client's API key
+type APIKeyConfig struct {
+	// Key is the key itself, "env:NAME" to read it from the environment
+	// variable NAME, or "file:PATH" to read it from a file in the project
+	Key string `json:"key"`
+
+	// RateLimit caps the client's requests per minute, 0 means unlimited
+	RateLimit int `json:"rate_limit,omitempty"`
+}
+
+// SessionsConfig configures where sessions are kept
+type SessionsConfig struct {
+	// Store keeps sessions encrypted in the cookie itself ("cookie"), or in
+	// the configured store with only their ID in the cookie ("store"). The
+	// default is "cookie", or "store" with a Redis store and no Secret.
+	Store string `json:"store,omitempty"`
+
+	// Secret encrypts cookie sessions, or "env:NAME" to read it from the
+	// environment variable NAME; without it a key is made at startup, so
+	// sessions end when the server restarts
+	Secret string `json:"secret,omitempty"`
+
+	// Cookie names the session cookie (default "htmlnojs_session")
+	Cookie string `json:"cookie,omitempty"`
+
+	// Lifetime is how long a session lasts after it last changed
+	// (default "24h")
+	Lifetime string `json:"lifetime,omitempty"`
+}
+
+// IPAccessConfig lists the CIDR ranges, e.g. "10.0.0.0/8", or single
+// addresses let in or turned away. Deny wins; with an Allow list, only
+// addresses in it get in.
+type IPAccessConfig struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+
+	// Routes add rules by route pattern, checked after the global ones,
+	// e.g. {"/admin/*": {"allow": ["203.0.113.0/24"]}}; the longest
+	// matching pattern applies
+	Routes map[string]IPRuleConfig `json:"routes,omitempty"`
+}
+
+// IPRuleConfig is the allow and deny lists of one route pattern
+type IPRuleConfig struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// UserAgentRuleConfig is one user agent rule
+type UserAgentRuleConfig struct {
+	// Name is shown in logs and metrics (default the first match)
+	Name string `json:"name,omitempty"`
+
+	// Match lists parts of the User-Agent, compared without case, e.g.
+	// "AhrefsBot"; "" matches requests without a User-Agent
+	Match []string `json:"match"`
+
+	// Action is "block" (403), "rate_limit" (RateLimit requests a minute
+	// per address) or "static" (pages, stylesheets and fonts only)
+	Action string `json:"action"`
+
+	RateLimit int `json:"rate_limit,omitempty"`
+}
+
+// CORSConfig lists the origins, e.g. "https://app.example.com",
+// "https://*.example.com" or "*", allowed to call the server from a
+// browser, and what they may send. Empty lists default to what htmx needs.
+type CORSConfig struct {
+	Origins []string `json:"origins,omitempty"`
+	Methods []string `json:"methods,omitempty"`
+	Headers []string `json:"headers,omitempty"`
+
+	// Expose lists response headers scripts on the origin may read
+	Expose []string `json:"expose,omitempty"`
+
+	// Credentials lets requests carry cookies and Authorization headers;
+	// it can't be combined with the origin "*"
+	Credentials bool `json:"credentials,omitempty"`
+
+	// MaxAge is how long browsers may cache a preflight, e.g. "10m"
+	MaxAge string `json:"max_age,omitempty"`
+
+	// Routes replace the global rule on the paths their pattern matches,
+	// e.g. {"/api/public/*": {"origins": ["*"]}}; the longest matching
+	// pattern applies and unset settings come from the global rule
+	Routes map[string]CORSRuleConfig `json:"routes,omitempty"`
+}
+
+// CORSRuleConfig is the CORS rule of one route pattern; Credentials is a
+// pointer so a route can turn it off
+type CORSRuleConfig struct {
+	Origins     []string `json:"origins,omitempty"`
+	Methods     []string `json:"methods,omitempty"`
+	Headers     []string `json:"headers,omitempty"`
+	Expose      []string `json:"expose,omitempty"`
+	Credentials *bool    `json:"credentials,omitempty"`
+	MaxAge      string   `json:"max_age,omitempty"`
+}
+
+// AuditConfig says where audit entries go; either or both may be set
+type AuditConfig struct {
+	// File receives one JSON line per request, relative to the project
+	// directory; it is only ever appended to
+	File string `json:"file,omitempty"`
+
+	// Webhook receives each entry as a JSON POST
+	Webhook string `json:"webhook,omitempty"`
+
+	// Methods are the HTTP methods recorded (default POST, PUT, PATCH and
+	// DELETE)
+	Methods []string `json:"methods,omitempty"`
+}
+
+// MaintenanceConfig configures maintenance mode; the page itself is
+// errors.maintenance
+type MaintenanceConfig struct {
+	// File switches maintenance on while it exists, relative to the
+	// project directory; its contents are shown as the message
+	File string `json:"file,omitempty"`
+
+	// Allow lists route patterns still served during maintenance, e.g.
+	// "/admin/*"; health checks, /_admin, stylesheets and fonts always are
+	Allow []string `json:"allow,omitempty"`
+
+	// RetryAfter is sent to clients in the Retry-After header, e.g. "15m"
+	RetryAfter string `json:"retry_after,omitempty"`
+}
+
+// AdminConfig configures access to the /_admin endpoints
+type AdminConfig struct {
+	// Token is sent by admin requests as "Authorization: Bearer <token>",
+	// or "env:NAME" to read it from the environment variable NAME; without
+	// it a token is made at startup and written to .htmlnojs/admin_token
+	// in the project directory, where htmlnojs commands find it
+	Token string `json:"token,omitempty"`
+
+	// Roles let clients signed in with any of them use the endpoints too,
+	// e.g. ["admin"]
+	Roles []string `json:"roles,omitempty"`
+}
+
+// SignedURLsConfig configures signed URLs
+type SignedURLsConfig struct {
+	// Secret signs the URLs, or "env:NAME" to read it from the environment
+	// variable NAME; without it a key is made at startup, so links stop
+	// working when the server restarts
+	Secret string `json:"secret,omitempty"`
+
+	// Lifetime is how long a link works unless signed_url is given one
+	// (default "24h")
+	Lifetime string `json:"lifetime,omitempty"`
+}
+
+// ShutdownConfig configures graceful shutdown
+type ShutdownConfig struct {
+	// DrainTimeout is how long requests in flight may take to finish before
+	// the server and the backends it runs stop anyway (default "10s")
+	DrainTimeout string `json:"drain_timeout,omitempty"`
+}
+
+// StatusPageConfig configures the uptime page
+type StatusPageConfig struct {
+	// Path serves the page, "/status" by default; "off" disables it
+	Path string `json:"path,omitempty"`
+
+	// Public lets anyone see the page; by default only localhost can
+	Public bool `json:"public,omitempty"`
+
+	// Title heads the page (default "Service Status")
+	Title string `json:"title,omitempty"`
+
+	// Days of health history kept and shown (default 30)
+	Days int `json:"days,omitempty"`
+}
+
+// DoctorConfig configures /_doctor
+type DoctorConfig struct {
+	// Public lets anyone run the checks; by default only localhost can
+	Public bool `json:"public,omitempty"`
+}
+
+// CSSConfig configures stylesheet handling
+type CSSConfig struct {
+	// ScopeComponents rewrites css/components/*.css so their rules only
+	// apply inside templates that include them
+	ScopeComponents bool `json:"scope_components,omitempty"`
+
+	// Critical inlines the rules styling the top of each page into <head>
+	// and loads the full stylesheets after the content
+	Critical bool `json:"critical,omitempty"`
+
+	// CriticalElements is how many elements at the start of <body> count as
+	// above the fold (default 40)
+	CriticalElements int `json:"critical_elements,omitempty"`
+}
+
+// FontsConfig configures the fonts/ pipeline
+type FontsConfig struct {
+	// Display is the font-display added to @font-face rules that don't set
+	// one: "swap" (default), "optional", "fallback" or "block". "auto"
+	// leaves the rules alone.
+	Display string `json:"display,omitempty"`
+
+	// Preload links the fonts a page's stylesheets use from its <head>
+	// (default true)
+	Preload bool `json:"preload"`
+
+	// Subset serves TrueType fonts cut down to the characters used in
+	// templates
+	Subset bool `json:"subset,omitempty"`
+}
+
+// HTMXConfig configures how responses follow htmx's request headers
+type HTMXConfig struct {
+	// FullPages serves pages whole to htmx requests too. By default htmx
+	// gets only the content of <body>, unless a boosted link or a history
+	// restore replaces the whole document.
+	FullPages bool `json:"full_pages,omitempty"`
+
+	// BareFragments serves handler fragments as they are to browsers
+	// opening a handler route directly. By default they are shown inside a
+	// page with htmx and the global stylesheets.
+	BareFragments bool `json:"bare_fragments,omitempty"`
+}
+
+// BudgetConfig caps the size of the routes matching Route
+type BudgetConfig struct {
+	Route string `json:"route"`          // URL path or pattern, e.g. "/docs/*"
+	Max   string `json:"max"`            // Size such as "200KB"
+	Page  bool   `json:"page,omitempty"` // Count a page's stylesheets and preloaded fonts too
+}
+
+// HandlerConfig configures one handler language
+type HandlerConfig struct {
+	// Dir overrides the adapter's handler directory, relative to the project
+	Dir string `json:"dir,omitempty"`
+	// Port is where the language's backend listens
+	Port int `json:"port,omitempty"`
+	// Spawn starts the backend with the server, for languages that ship
+	// one such as node
+	Spawn bool `json:"spawn,omitempty"`
+}
+
+// CostReportConfig configures the periodic per-owner usage report
+type CostReportConfig struct {
+	// Interval between reports, e.g. "1h"; empty disables the report
+	Interval string `json:"interval,omitempty"`
+	// File receives one JSON line per owner and period; empty only logs
+	File string `json:"file,omitempty"`
+}
+
+// SamplingConfig selects the requests captured at /_samples
+type SamplingConfig struct {
+	// Rate is the fraction of requests captured, e.g. 0.001 for 0.1%
+	Rate float64 `json:"rate,omitempty"`
+	// Errors captures every 5xx response regardless of Rate
+	Errors bool `json:"errors"`
+	// Capacity is how many samples are kept in memory (default 200)
+	Capacity int `json:"capacity,omitempty"`
+}
+
+// SmokeConfig configures the post-release smoke test
+type SmokeConfig struct {
+	// Routes are checked in order; empty checks every page returns 200
+	Routes []SmokeCheck `json:"routes,omitempty"`
+}
+
+// SmokeCheck is one request of the smoke test and what it must return
+type SmokeCheck struct {
+	Path      string            `json:"path"`
+	Method    string            `json:"method,omitempty"`    // Default GET
+	HTMX      bool              `json:"htmx,omitempty"`      // Send HX-Request like htmx does
+	Form      map[string]string `json:"form,omitempty"`      // Form values, sent as the body or query
+	Status    int               `json:"status,omitempty"`    // Expected status, default 200
+	Selectors []string          `json:"selectors,omitempty"` // CSS selectors that must match
+}
+
+// APIConfig holds API versioning settings
+type APIConfig struct {
+	// DefaultVersion, e.g. "v2", is also served without the version segment
+	// so /api/orders/list reaches /api/v2/orders/list
+	DefaultVersion string `json:"default_version,omitempty"`
+}
+
+// FrontMatterConfig lists the allowed values for front-matter keys.
+// An empty list allows any value.
+type FrontMatterConfig struct {
+	Layouts []string `json:"layouts,omitempty"`
+	Roles   []string `json:"roles,omitempty"`
+}
+
+// Default returns the configuration used when no project file exists
+func Default() *ProjectConfig {
+	return &ProjectConfig{
+		TemplateEngine: "go",
+		TemplateFuncs:  map[string]string{},
+		Exclude:        append([]string(nil), DefaultExclude...),
+		Sampling:       SamplingConfig{Errors: true},
+		Fonts:          FontsConfig{Display: "swap", Preload: true},
+		StatusPage:     StatusPageConfig{Path: "/status", Title: "Service Status", Days: 30},
+	}
+}
+
+// Load reads a project configuration file, falling back to defaults when it
+// does not exist
+func Load(path string) (*ProjectConfig, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Default(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config %s: %w", path, err)
+	}
+
+	cfg, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Parse decodes a project configuration, rejecting unknown keys so typos
+// are reported instead of silently ignored
+func Parse(data []byte) (*ProjectConfig, error) {
+	cfg := Default()
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// validate checks settings that only accept a fixed set of values
+func (c *ProjectConfig) validate() error {
+	if c.CostReport.Interval != "" {
+		if d, err := time.ParseDuration(c.CostReport.Interval); err != nil || d <= 0 {
+			return fmt.Errorf("cost_report.interval must be a positive duration such as \"1h\", got %q", c.CostReport.Interval)
+		}
+	}
+
+	if c.Sampling.Rate < 0 || c.Sampling.Rate > 1 {
+		return fmt.Errorf("sampling.rate must be between 0 and 1, got %v", c.Sampling.Rate)
+	}
+
+	for name, handler := range c.Handlers {
+		if handler.Port < 0 || handler.Port > 65535 {
+			return fmt.Errorf("handlers.%s.port must be a valid port, got %d", name, handler.Port)
+		}
+		if handler.Spawn && handler.Port == 0 {
+			return fmt.Errorf("handlers.%s.port is required to spawn its backend", name)
+		}
+	}
+
+	for path, endpoint := range c.WebSockets {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("websockets path must start with /, got %q", path)
+		}
+		target, err := url.Parse(endpoint)
+		if err != nil || target.Host == "" {
+			return fmt.Errorf("websockets.%s must be a ws:// or wss:// URL, got %q", path, endpoint)
+		}
+		switch target.Scheme {
+		case "ws", "wss", "http", "https":
+		default:
+			return fmt.Errorf("websockets.%s must be a ws:// or wss:// URL, got %q", path, endpoint)
+		}
+	}
+
+	switch c.RequestEncoding {
+	case "", "decompress", "passthrough":
+	default:
+		return fmt.Errorf("request_encoding must be \"decompress\" or \"passthrough\", got %q", c.RequestEncoding)
+	}
+
+	if c.ProxyLog.MaxBody < 0 {
+		return fmt.Errorf("proxy_log.max_body must not be negative, got %d", c.ProxyLog.MaxBody)
+	}
+	for _, field := range c.ProxyLog.Redact {
+		if field == "" || strings.ContainsAny(field, " :\t\r\n") {
+			return fmt.Errorf("proxy_log.redact entries must be header or field names, got %q", field)
+		}
+	}
+
+	if c.Replay.Capacity < 0 {
+		return fmt.Errorf("replay.capacity must not be negative, got %d", c.Replay.Capacity)
+	}
+
+	for prefix, route := range c.Gateway {
+		if !strings.HasPrefix(prefix, "/") || strings.Trim(prefix, "/") == "" {
+			return fmt.Errorf("gateway path must start with / and name a path below it, got %q", prefix)
+		}
+		target, err := url.Parse(route.URL)
+		if err != nil || target.Host == "" || (target.Scheme != "http" && target.Scheme != "https") {
+			return fmt.Errorf("gateway.%s.url must be an http:// or https:// URL, got %q", prefix, route.URL)
+		}
+		for name, value := range route.Headers {
+			if name == "" || strings.ContainsAny(name, " :\t\r\n") {
+				return fmt.Errorf("gateway.%s.headers has an invalid header name %q", prefix, name)
+			}
+			if value == "env:" {
+				return fmt.Errorf("gateway.%s.headers.%s must name an environment variable after env:", prefix, name)
+			}
+		}
+		for _, method := range route.Methods {
+			if method == "" || strings.ToUpper(method) != method || strings.ContainsAny(method, " \t/") {
+				return fmt.Errorf("gateway.%s.methods must be upper-case HTTP methods, got %q", prefix, method)
+			}
+		}
+	}
+
+	for i, rule := range c.ProxyHeaders {
+		for _, rewrite := range []HeaderRewrite{rule.Request, rule.Response} {
+			names := append([]string(nil), rewrite.Remove...)
+			for from, to := range rewrite.Rename {
+				names = append(names, from, to)
+			}
+			for name := range rewrite.Set {
+				names = append(names, name)
+			}
+			for _, name := range names {
+				if strings.TrimSpace(name) == "" {
+					return fmt.Errorf("proxy_headers[%d] has an empty header name", i)
+				}
+			}
+		}
+	}
+
+	for i, transform := range c.Transforms {
+		if transform.Name == "" {
+			return fmt.Errorf("transforms[%d] needs a name", i)
+		}
+	}
+
+	switch c.Errors.Detail {
+	case "", "full", "generic":
+	default:
+		return fmt.Errorf("errors.detail must be \"full\" or \"generic\", got %q", c.Errors.Detail)
+	}
+
+	if c.SlowRequests != "" {
+		if d, err := time.ParseDuration(c.SlowRequests); err != nil || d <= 0 {
+			return fmt.Errorf("slow_requests must be a positive duration such as \"500ms\", got %q", c.SlowRequests)
+		}
+	}
+	for _, path := range c.Warmup {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("warmup paths must start with /, got %q", path)
+		}
+	}
+	if c.Idempotency.Window != "" {
+		if d, err := time.ParseDuration(c.Idempotency.Window); err != nil || d <= 0 {
+			return fmt.Errorf("idempotency.window must be a positive duration such as \"10m\", got %q", c.Idempotency.Window)
+		}
+	}
+	if c.Nonces.Lifetime != "" {
+		if d, err := time.ParseDuration(c.Nonces.Lifetime); err != nil || d <= 0 {
+			return fmt.Errorf("nonces.lifetime must be a positive duration such as \"30m\", got %q", c.Nonces.Lifetime)
+		}
+	}
+	for name, password := range c.Auth.Users {
+		if name == "" || strings.Contains(name, ":") {
+			return fmt.Errorf("auth.users has an invalid user name %q", name)
+		}
+		if password == "" || password == "env:" {
+			return fmt.Errorf("auth.users.%s needs a password or env:NAME", name)
+		}
+	}
+	for name, token := range c.Auth.Tokens {
+		if token == "" || token == "env:" {
+			return fmt.Errorf("auth.tokens.%s needs a token or env:NAME", name)
+		}
+	}
+	switch c.Sessions.Store {
+	case "", "cookie", "store":
+	default:
+		return fmt.Errorf("sessions.store must be \"cookie\" or \"store\", got %q", c.Sessions.Store)
+	}
+	if c.Sessions.Secret == "env:" {
+		return fmt.Errorf("sessions.secret must name an environment variable after env:")
+	}
+	if strings.ContainsAny(c.Sessions.Cookie, " ;,=\t\r\n\"") {
+		return fmt.Errorf("sessions.cookie must be a cookie name, got %q", c.Sessions.Cookie)
+	}
+	if c.Sessions.Lifetime != "" {
+		if d, err := time.ParseDuration(c.Sessions.Lifetime); err != nil || d <= 0 {
+			return fmt.Errorf("sessions.lifetime must be a positive duration such as \"12h\", got %q", c.Sessions.Lifetime)
+		}
+	}
+	if err := validateIPRanges("ip_access", c.IPAccess.Allow, c.IPAccess.Deny); err != nil {
+		return err
+	}
+	for pattern, rule := range c.IPAccess.Routes {
+		if !strings.HasPrefix(pattern, "/") {
+			return fmt.Errorf("ip_access.routes must be keyed by route patterns starting with /, got %q", pattern)
+		}
+		if err := validateIPRanges("ip_access.routes."+pattern, rule.Allow, rule.Deny); err != nil {
+			return err
+		}
+	}
+	for _, proxy := range c.TrustedProxies {
+		if proxy != "unix" {
+			if err := validateIPRanges("trusted_proxies", []string{proxy}, nil); err != nil {
+				return err
+			}
+		}
+	}
+	if c.Admin.Token == "env:" {
+		return fmt.Errorf("admin.token must name an environment variable after env:")
+	}
+	if c.SignedURLs.Secret == "env:" {
+		return fmt.Errorf("signed_urls.secret must name an environment variable after env:")
+	}
+	if c.SignedURLs.Lifetime != "" {
+		if d, err := time.ParseDuration(c.SignedURLs.Lifetime); err != nil || d <= 0 {
+			return fmt.Errorf("signed_urls.lifetime must be a positive duration such as \"24h\", got %q", c.SignedURLs.Lifetime)
+		}
+	}
+	if c.Auth.JWT.JWKSURL != "" {
+		if u, err := url.Parse(c.Auth.JWT.JWKSURL); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("auth.jwt.jwks_url must be an http:// or https:// URL, got %q", c.Auth.JWT.JWKSURL)
+		}
+	}
+	if c.Auth.JWT.Secret == "env:" {
+		return fmt.Errorf("auth.jwt.secret must name an environment variable after env:")
+	}
+	if c.Auth.JWT.Leeway != "" {
+		if d, err := time.ParseDuration(c.Auth.JWT.Leeway); err != nil || d < 0 {
+			return fmt.Errorf("auth.jwt.leeway must be a duration such as \"30s\", got %q", c.Auth.JWT.Leeway)
+		}
+	}
+	if !c.Auth.JWT.Enabled() && (c.Auth.JWT.Issuer != "" || c.Auth.JWT.Audience != "" || len(c.Auth.JWT.Claims) > 0 || c.Auth.JWT.RolesClaim != "") {
+		return fmt.Errorf("auth.jwt needs a jwks_url or a secret")
+	}
+	for claim, header := range c.Auth.JWT.Claims {
+		if claim == "" || header == "" || strings.ContainsAny(header, " :\t\r\n") {
+			return fmt.Errorf("auth.jwt.claims.%s must name a request header, got %q", claim, header)
+		}
+	}
+	for name, roles := range c.Auth.Roles {
+		for _, role := range roles {
+			if role == "" || strings.ContainsAny(role, ", \t\r\n") {
+				return fmt.Errorf("auth.roles.%s has an invalid role %q", name, role)
+			}
+		}
+	}
+	if strings.ContainsAny(c.Auth.APIKeys.Header, " :\t\r\n") {
+		return fmt.Errorf("auth.api_keys.header must be a header name, got %q", c.Auth.APIKeys.Header)
+	}
+	if len(c.Auth.APIKeys.Keys) == 0 && (c.Auth.APIKeys.Header != "" || c.Auth.APIKeys.Query != "") {
+		return fmt.Errorf("auth.api_keys needs keys")
+	}
+	for name, key := range c.Auth.APIKeys.Keys {
+		if key.Key == "" || key.Key == "env:" || key.Key == "file:" {
+			return fmt.Errorf("auth.api_keys.keys.%s needs a key, env:NAME or file:PATH", name)
+		}
+		if key.RateLimit < 0 {
+			return fmt.Errorf("auth.api_keys.keys.%s.rate_limit must not be negative, got %d", name, key.RateLimit)
+		}
+	}
+	if c.Auth.LoginURL != "" && !strings.HasPrefix(c.Auth.LoginURL, "/") {
+		return fmt.Errorf("auth.login_url must be a path starting with /, got %q", c.Auth.LoginURL)
+	}
+	if c.Shutdown.DrainTimeout != "" {
+		if d, err := time.ParseDuration(c.Shutdown.DrainTimeout); err != nil || d <= 0 {
+			return fmt.Errorf("shutdown.drain_timeout must be a positive duration such as \"30s\", got %q", c.Shutdown.DrainTimeout)
+		}
+	}
+
+	switch c.Worker.Mode {
+	case "", "http", "stdio", "uvicorn":
+	default:
+		return fmt.Errorf("worker.mode must be \"http\", \"stdio\" or \"uvicorn\", got %q", c.Worker.Mode)
+	}
+
+	if c.Worker.Mode == "uvicorn" && (c.Proxy.BackendURL != "" || c.Proxy.UnixSocket != "") {
+		return fmt.Errorf("worker.mode \"uvicorn\" runs its own backend, so proxy.backend_url and proxy.unix_socket must be empty")
+	}
+	if c.Worker.Count < 0 {
+		return fmt.Errorf("worker.count must not be negative, got %d", c.Worker.Count)
+	}
+	if c.Worker.Count > 1 && (c.Worker.Mode == "http" || c.Worker.Mode == "stdio") {
+		return fmt.Errorf("worker.count only applies when the server runs uvicorn, not with worker.mode %q", c.Worker.Mode)
+	}
+
+	if c.Canary.URL != "" {
+		target, err := url.Parse(c.Canary.URL)
+		if err != nil || target.Host == "" || (target.Scheme != "http" && target.Scheme != "https") {
+			return fmt.Errorf("canary.url must be an http:// or https:// URL, got %q", c.Canary.URL)
+		}
+	}
+	if c.Canary.Percent < 0 || c.Canary.Percent > 100 {
+		return fmt.Errorf("canary.percent must be between 0 and 100, got %d", c.Canary.Percent)
+	}
+
+	for dir, backendURL := range c.DirectoryBackends {
+		clean := strings.Trim(dir, "/")
+		if clean == "" || clean == "." || strings.HasPrefix(path.Clean(clean), "..") {
+			return fmt.Errorf("directory_backends keys must be directories inside py_htmx, got %q", dir)
+		}
+		target, err := url.Parse(backendURL)
+		if err != nil || target.Host == "" || (target.Scheme != "http" && target.Scheme != "https") {
+			return fmt.Errorf("directory_backends[%q] must be an http:// or https:// URL, got %q", dir, backendURL)
+		}
+	}
+
+	for name, profile := range c.Timeouts {
+		// Numbers are taken by @timeout(seconds)
+		if name == "" || !unicode.IsLetter(rune(name[0])) || strings.ContainsAny(name, "() \t") {
+			return fmt.Errorf("timeouts profile names must start with a letter, got %q", name)
+		}
+		for _, timeout := range []struct{ key, value string }{
+			{"connect", profile.Connect},
+			{"response_header", profile.ResponseHeader},
+			{"total", profile.Total},
+		} {
+			if timeout.value == "" {
+				continue
+			}
+			if d, err := time.ParseDuration(timeout.value); err != nil || d <= 0 {
+				return fmt.Errorf("timeouts.%s.%s must be a positive duration such as \"30s\", got %q", name, timeout.key, timeout.value)
+			}
+		}
+	}
+
+	if c.Proxy.MaxIdleConns < 0 || c.Proxy.MaxIdleConnsPerHost < 0 || c.Proxy.MaxConnsPerHost < 0 {
+		return fmt.Errorf("proxy connection limits must not be negative")
+	}
+	if c.Proxy.MaxInFlight < 0 {
+		return fmt.Errorf("proxy.max_in_flight must not be negative, got %d", c.Proxy.MaxInFlight)
+	}
+	for _, timeout := range []struct{ key, value string }{
+		{"idle_conn_timeout", c.Proxy.IdleConnTimeout},
+		{"dial_timeout", c.Proxy.DialTimeout},
+		{"response_header_timeout", c.Proxy.ResponseHeaderTimeout},
+		{"retry_backoff", c.Proxy.RetryBackoff},
+		{"breaker.cooldown", c.Proxy.Breaker.Cooldown},
+		{"health_interval", c.Proxy.HealthInterval},
+		{"overload_retry_after", c.Proxy.OverloadRetryAfter},
+	} {
+		if timeout.value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(timeout.value); err != nil || d <= 0 {
+			return fmt.Errorf("proxy.%s must be a positive duration such as \"30s\", got %q", timeout.key, timeout.value)
+		}
+	}
+	if c.Proxy.Retries < 0 || c.Proxy.Retries > 10 {
+		return fmt.Errorf("proxy.retries must be between 0 and 10, got %d", c.Proxy.Retries)
+	}
+	if c.Proxy.Breaker.Failures < 0 {
+		return fmt.Errorf("proxy.breaker.failures must not be negative, got %d", c.Proxy.Breaker.Failures)
+	}
+	if c.Proxy.BackendURL != "" {
+		target, err := url.Parse(c.Proxy.BackendURL)
+		if err != nil || target.Host == "" || (target.Scheme != "http" && target.Scheme != "https") {
+			return fmt.Errorf("proxy.backend_url must be an http:// or https:// URL, got %q", c.Proxy.BackendURL)
+		}
+		if c.Proxy.UnixSocket != "" {
+			return fmt.Errorf("proxy.backend_url and proxy.unix_socket can't both be set")
+		}
+	}
+	if strings.ContainsAny(c.Proxy.Host, " /\t\r\n") {
+		return fmt.Errorf("proxy.host must be a host name, got %q", c.Proxy.Host)
+	}
+	if c.Proxy.Signing.Secret == "env:" {
+		return fmt.Errorf("proxy.signing.secret must name an environment variable after env:")
+	}
+	switch strings.ToLower(c.Proxy.Signing.Header) {
+	case "x-htmlnojs-user", "x-htmlnojs-roles", "x-htmlnojs-auth", "x-htmlnojs-identity-signature":
+		return fmt.Errorf("proxy.signing.header %q would replace an identity header sent to handlers", c.Proxy.Signing.Header)
+	}
+	if (c.Proxy.TLS.CertFile == "") != (c.Proxy.TLS.KeyFile == "") {
+		return fmt.Errorf("proxy.tls.cert_file and proxy.tls.key_file must be set together")
+	}
+	switch c.Proxy.TLS.MinVersion {
+	case "", "1.2", "1.3":
+	default:
+		return fmt.Errorf("proxy.tls.min_version must be \"1.2\" or \"1.3\", got %q", c.Proxy.TLS.MinVersion)
+	}
+
+	if path := c.StatusPage.Path; path != "" && path != "off" && !strings.HasPrefix(path, "/") {
+		return fmt.Errorf("status_page.path must start with / or be \"off\", got %q", path)
+	}
+	if c.StatusPage.Days < 1 || c.StatusPage.Days > 365 {
+		return fmt.Errorf("status_page.days must be between 1 and 365, got %d", c.StatusPage.Days)
+	}
+
+	if c.CSS.CriticalElements < 0 {
+		return fmt.Errorf("css.critical_elements must not be negative, got %d", c.CSS.CriticalElements)
+	}
+
+	for i, budget := range c.Budgets {
+		if !strings.HasPrefix(budget.Route, "/") {
+			return fmt.Errorf("budgets[%d].route must start with /, got %q", i, budget.Route)
+		}
+		if budget.Max == "" {
+			return fmt.Errorf("budgets[%d].max is required", i)
+		}
+	}
+
+	switch c.Fonts.Display {
+	case "", "auto", "swap", "optional", "fallback", "block":
+	default:
+		return fmt.Errorf("fonts.display must be auto, swap, optional, fallback or block, got %q", c.Fonts.Display)
+	}
+
+	if c.Store != "" {
+		scheme, _, _ := strings.Cut(c.Store, "://")
+		switch scheme {
+		case "memory", "sqlite", "redis", "rediss":
+		default:
+			return fmt.Errorf("store must be a memory://, sqlite:// or redis:// URL, got %q", c.Store)
+		}
+	}
+
+	for i, check := range c.Smoke.Routes {
+		if !strings.HasPrefix(check.Path, "/") {
+			return fmt.Errorf("smoke.routes[%d].path must start with /, got %q", i, check.Path)
+		}
+	}
+
+	switch c.DuplicateRoutes {
+	case "", "error", "warn":
+	default:
+		return fmt.Errorf("duplicate_routes must be \"error\" or \"warn\", got %q", c.DuplicateRoutes)
+	}
+
+	if login := c.Auth.Login; login.Handler != "" {
+		for key, path := range map[string]string{"handler": login.Handler, "path": login.Path, "logout_path": login.LogoutPath, "redirect": login.Redirect} {
+			if path != "" && !strings.HasPrefix(path, "/") {
+				return fmt.Errorf("auth.login.%s must start with /, got %q", key, path)
+			}
+		}
+		if login.RateLimit < 0 {
+			return fmt.Errorf("auth.login.rate_limit must be a positive number of attempts a minute, got %d", login.RateLimit)
+		}
+	}
+
+	for i, rule := range c.UserAgents {
+		if len(rule.Match) == 0 {
+			return fmt.Errorf("user_agents[%d].match must list at least one user agent", i)
+		}
+		switch rule.Action {
+		case "block", "static":
+		case "rate_limit":
+			if rule.RateLimit <= 0 {
+				return fmt.Errorf("user_agents[%d].rate_limit must be a positive number of requests a minute", i)
+			}
+		default:
+			return fmt.Errorf("user_agents[%d].action must be \"block\", \"rate_limit\" or \"static\", got %q", i, rule.Action)
+		}
+	}
+
+	if err := validateCORS("cors", c.CORS.Origins, c.CORS.Credentials, c.CORS.MaxAge); err != nil {
+		return err
+	}
+	for pattern, rule := range c.CORS.Routes {
+		if !strings.HasPrefix(pattern, "/") {
+			return fmt.Errorf("cors.routes pattern must start with /, got %q", pattern)
+		}
+		origins, credentials := rule.Origins, c.CORS.Credentials
+		if len(origins) == 0 {
+			origins = c.CORS.Origins
+		}
+		if rule.Credentials != nil {
+			credentials = *rule.Credentials
+		}
+		if err := validateCORS("cors.routes."+pattern, origins, credentials, rule.MaxAge); err != nil {
+			return err
+		}
+	}
+
+	if c.Audit.Webhook != "" {
+		target, err := url.Parse(c.Audit.Webhook)
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+			return fmt.Errorf("audit.webhook must be an http:// or https:// URL, got %q", c.Audit.Webhook)
+		}
+	}
+	for _, method := range c.Audit.Methods {
+		if method == "" || method != strings.ToUpper(method) {
+			return fmt.Errorf("audit.methods must hold HTTP methods such as \"POST\", got %q", method)
+		}
+	}
+
+	if c.Maintenance.RetryAfter != "" {
+		if d, err := time.ParseDuration(c.Maintenance.RetryAfter); err != nil || d <= 0 {
+			return fmt.Errorf("maintenance.retry_after must be a positive duration such as \"15m\", got %q", c.Maintenance.RetryAfter)
+		}
+	}
+	for _, pattern := range c.Maintenance.Allow {
+		if !strings.HasPrefix(pattern, "/") {
+			return fmt.Errorf("maintenance.allow patterns must start with /, got %q", pattern)
+		}
+	}
+
+	switch c.Symlinks {
+	case "", "inside", "deny", "follow":
+	default:
+		return fmt.Errorf("symlinks must be \"inside\", \"deny\" or \"follow\", got %q", c.Symlinks)
+	}
+	return nil
+}
+
+// validateCORS checks the origins and preflight lifetime of a CORS rule
+func validateCORS(key string, origins []string, credentials bool, maxAge string) error {
+	for _, origin := range origins {
+		if origin == "*" {
+			if credentials {
+				return fmt.Errorf("%s.credentials can't be used with the origin \"*\", list the origins instead", key)
+			}
+			continue
+		}
+		target, err := url.Parse(strings.Replace(origin, "://*.", "://", 1))
+		if err != nil || target.Scheme == "" || target.Host == "" || (target.Path != "" && target.Path != "/") {
+			return fmt.Errorf("%s.origins must hold origins such as \"https://app.example.com\", got %q", key, origin)
+		}
+		if strings.HasSuffix(origin, "/") {
+			return fmt.Errorf("%s.origins must not end with /, got %q", key, origin)
+		}
+	}
+	if maxAge != "" {
+		if d, err := time.ParseDuration(maxAge); err != nil || d < 0 {
+			return fmt.Errorf("%s.max_age must be a duration such as \"10m\", got %q", key, maxAge)
+		}
+	}
+	return nil
+}
+
+// validateIPRanges checks that allow and deny hold CIDR ranges or addresses
+func validateIPRanges(key string, allow, deny []string) error {
+	for _, value := range append(slices.Clone(allow), deny...) {
+		value = strings.TrimSpace(value)
+		if _, err := netip.ParsePrefix(value); err == nil {
+			continue
+		}
+		if _, err := netip.ParseAddr(value); err != nil {
+			return fmt.Errorf("%s has an invalid CIDR range %q", key, value)
+		}
+	}
+	return nil
+}
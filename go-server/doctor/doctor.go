@@ -0,0 +1,322 @@
+// Package doctor checks that the environment can run a project, e.g. that
+// Python and uvicorn are installed and the ports are free, and says how to
+// fix what isn't right
+package doctor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"htmlnojs/backend"
+	"htmlnojs/config"
+	"htmlnojs/routebuilder"
+	"htmlnojs/setup"
+)
+
+// minPython is the oldest Python FastAPI supports
+var minPython = [2]int{3, 8}
+
+// Result statuses, from fine to broken
+const (
+	StatusOK   = "ok"
+	StatusWarn = "warn"
+	StatusFail = "fail"
+)
+
+// Result is the outcome of one check
+type Result struct {
+	Check   string `json:"check"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	Fix     string `json:"fix,omitempty"` // What to do about a warning or failure
+}
+
+// OK reports whether the check didn't fail; warnings are OK
+func (r Result) OK() bool {
+	return r.Status != StatusFail
+}
+
+func (r Result) String() string {
+	mark := map[string]string{StatusOK: "PASS", StatusWarn: "WARN", StatusFail: "FAIL"}[r.Status]
+	line := fmt.Sprintf("%s %s: %s", mark, r.Check, r.Message)
+	if r.Fix != "" {
+		line += "\n    fix: " + r.Fix
+	}
+	return line
+}
+
+// Options describe the project and, for a running server, its state
+type Options struct {
+	Config      *setup.Config
+	Project     *config.ProjectConfig
+	Python      string // Interpreter running handlers
+	Port        int    // Port the server will listen on, 0 once it does
+	FastAPIPort int
+	Managed     bool // The server runs uvicorn on FastAPIPort itself
+
+	// Routes as built from the project, nil when BuildErr says why not
+	Routes   *routebuilder.RouteCollection
+	BuildErr error
+}
+
+// Run runs every check in order
+func Run(ctx context.Context, opts Options) []Result {
+	results := []Result{checkPython(ctx, opts), checkUvicorn(opts)}
+	results = append(results, checkPorts(ctx, opts)...)
+	results = append(results, checkDirectories(opts.Config)...)
+	return append(results, checkRoutes(opts)...)
+}
+
+// checkPython checks the interpreter runs and is new enough for FastAPI
+func checkPython(ctx context.Context, opts Options) Result {
+	result := Result{Check: "python"}
+	out, err := exec.CommandContext(ctx, opts.Python, "-c", "import sys; print('%d.%d.%d' % sys.version_info[:3])").Output()
+	if err != nil {
+		result.Status = StatusFail
+		result.Message = fmt.Sprintf("%s doesn't run: %v", opts.Python, err)
+		result.Fix = fmt.Sprintf("install Python %d.%d or newer, or point worker.python in htmlnojs.json at it", minPython[0], minPython[1])
+		return result
+	}
+
+	version := strings.TrimSpace(string(out))
+	parts := strings.SplitN(version, ".", 3)
+	major, _ := strconv.Atoi(parts[0])
+	minor := 0
+	if len(parts) > 1 {
+		minor, _ = strconv.Atoi(parts[1])
+	}
+	if major < minPython[0] || (major == minPython[0] && minor < minPython[1]) {
+		result.Status = StatusFail
+		result.Message = fmt.Sprintf("%s is Python %s, FastAPI needs %d.%d or newer", opts.Python, version, minPython[0], minPython[1])
+		result.Fix = "install a newer Python and set worker.python to it, e.g. \".venv/bin/python\""
+		return result
+	}
+	result.Status = StatusOK
+	result.Message = fmt.Sprintf("%s is Python %s", opts.Python, version)
+	return result
+}
+
+// checkUvicorn checks fastapi and uvicorn import, which only matters
+// when handlers run under FastAPI
+func checkUvicorn(opts Options) Result {
+	result := Result{Check: "uvicorn"}
+	if opts.Project.Worker.Mode == "stdio" {
+		result.Status = StatusOK
+		result.Message = "not needed, handlers run in a stdio worker"
+		return result
+	}
+	if err := backend.Available(opts.Python); err != nil {
+		result.Status = StatusWarn
+		if opts.Project.Worker.Mode == "uvicorn" {
+			// The server refuses to start without it
+			result.Status = StatusFail
+		}
+		result.Message = err.Error()
+		result.Fix = fmt.Sprintf("run %s -m pip install fastapi uvicorn", opts.Python)
+		return result
+	}
+	result.Status = StatusOK
+	result.Message = "fastapi and uvicorn are installed"
+	return result
+}
+
+// checkPorts checks the server's port is free and that the FastAPI port
+// holds a backend, or can take the one htmlnojs starts
+func checkPorts(ctx context.Context, opts Options) []Result {
+	var results []Result
+	if opts.Port != 0 {
+		result := Result{Check: "port", Status: StatusOK, Message: fmt.Sprintf("port %d is free", opts.Port)}
+		if err := portFree(opts.Port); err != nil {
+			result.Status = StatusFail
+			result.Message = fmt.Sprintf("port %d is in use: %v", opts.Port, err)
+			result.Fix = "stop the process using it or start htmlnojs with another -port"
+		}
+		results = append(results, result)
+	}
+
+	proxy := opts.Project.Proxy
+	if opts.Project.Worker.Mode == "stdio" || proxy.BackendURL != "" || proxy.UnixSocket != "" {
+		return results
+	}
+	result := Result{Check: "fastapi-port", Status: StatusOK}
+	switch {
+	case opts.Managed:
+		result.Message = fmt.Sprintf("htmlnojs runs uvicorn on port %d", opts.FastAPIPort)
+	case opts.Project.Worker.Mode == "uvicorn":
+		result.Message = "htmlnojs starts uvicorn on a free port"
+	case portFree(opts.FastAPIPort) == nil:
+		if opts.Project.Worker.Mode == "" && backend.Available(opts.Python) == nil {
+			result.Message = fmt.Sprintf("nothing listens on port %d, so htmlnojs starts uvicorn itself", opts.FastAPIPort)
+			break
+		}
+		result.Status = StatusWarn
+		result.Message = fmt.Sprintf("no FastAPI backend listens on port %d, Python routes fail until one does", opts.FastAPIPort)
+		result.Fix = fmt.Sprintf("run htmlnojs generate fastapi -out main.py and uvicorn main:app --port %d", opts.FastAPIPort)
+	default:
+		healthURL := fmt.Sprintf("http://localhost:%d/health", opts.FastAPIPort)
+		if err := checkHealth(ctx, healthURL); err != nil {
+			result.Status = StatusFail
+			result.Message = fmt.Sprintf("port %d is taken by something that isn't a FastAPI backend: %v", opts.FastAPIPort, err)
+			result.Fix = "stop the process using it or start htmlnojs with another -fastapi-port"
+			break
+		}
+		result.Message = fmt.Sprintf("a FastAPI backend answers on port %d", opts.FastAPIPort)
+	}
+	return append(results, result)
+}
+
+// portFree reports why nothing can listen on port, nil if something can
+func portFree(port int) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		if errors.Is(err, syscall.EADDRINUSE) {
+			return errors.New("address already in use")
+		}
+		return err
+	}
+	listener.Close()
+	return nil
+}
+
+// checkHealth succeeds when url answers with a 2xx status
+func checkHealth(ctx context.Context, url string) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+	return nil
+}
+
+// checkDirectories checks the project directories can be read, and the
+// project directory written, which file stores and build reports need
+func checkDirectories(cfg *setup.Config) []Result {
+	var results []Result
+	dirs := []struct{ name, path string }{
+		{"templates", cfg.TemplatesDir},
+		{"css", cfg.CSSDir},
+		{"py_htmx", cfg.PyHTMXDir},
+	}
+	for _, dir := range dirs {
+		result := Result{Check: dir.name + "-dir", Status: StatusOK, Message: dir.path + " is readable"}
+		info, err := os.Stat(dir.path)
+		switch {
+		case os.IsNotExist(err):
+			result.Status = StatusWarn
+			result.Message = dir.path + " doesn't exist"
+			result.Fix = "create it, or run htmlnojs once to set up the project"
+		case err != nil:
+			result.Status = StatusFail
+			result.Message = err.Error()
+			result.Fix = "check the permissions of its parent directories"
+		case !info.IsDir():
+			result.Status = StatusFail
+			result.Message = dir.path + " is a file, not a directory"
+			result.Fix = "move the file out of the way"
+		default:
+			if _, err := os.ReadDir(dir.path); err != nil {
+				result.Status = StatusFail
+				result.Message = fmt.Sprintf("can't list %s: %v", dir.path, err)
+				result.Fix = "chmod u+rx " + dir.path
+			}
+		}
+		results = append(results, result)
+	}
+
+	result := Result{Check: "project-dir", Status: StatusOK, Message: cfg.ProjectDir + " is writable"}
+	probe, err := os.CreateTemp(cfg.ProjectDir, ".htmlnojs-doctor-*")
+	if err != nil {
+		result.Status = StatusWarn
+		result.Message = fmt.Sprintf("%s isn't writable: %v", cfg.ProjectDir, err)
+		result.Fix = "chmod u+w " + cfg.ProjectDir + ", a file store and htmlnojs build write there"
+	} else {
+		probe.Close()
+		os.Remove(probe.Name())
+	}
+	return append(results, result)
+}
+
+// checkRoutes checks the routes build and make sense: there are pages, no
+// two routes claim the same path and every handler file defines handlers
+func checkRoutes(opts Options) []Result {
+	if opts.BuildErr != nil {
+		return []Result{{
+			Check:   "routes",
+			Status:  StatusFail,
+			Message: fmt.Sprintf("routes don't build: %v", opts.BuildErr),
+			Fix:     "fix the file named in the error; htmlnojs lint checks templates",
+		}}
+	}
+	routes := opts.Routes
+	results := []Result{{
+		Check:   "routes",
+		Status:  StatusOK,
+		Message: fmt.Sprintf("%d routes, %d of them handlers", routes.Metadata.TotalRoutes, len(routes.PythonRoutes)),
+	}}
+
+	if len(routes.HTMLRoutes) == 0 {
+		results = append(results, Result{
+			Check:   "pages",
+			Status:  StatusWarn,
+			Message: "no pages were found in " + opts.Config.TemplatesDir,
+			Fix:     "add an .html template, e.g. index.html for /",
+		})
+	}
+
+	owners := make(map[string]string)
+	claim := func(method, route, owner string) {
+		key := method + " " + route
+		if other, ok := owners[key]; ok && other != owner {
+			results = append(results, Result{
+				Check:   "routes",
+				Status:  StatusFail,
+				Message: fmt.Sprintf("%s is served by both %s and %s", key, other, owner),
+				Fix:     "rename one of them",
+			})
+			return
+		}
+		owners[key] = owner
+	}
+	for _, route := range routes.HTMLRoutes {
+		claim(route.Method, route.Route, route.FilePath)
+	}
+	handlerFiles := make(map[string]bool)
+	for _, route := range routes.PythonRoutes {
+		claim(route.Method, route.Route, route.FilePath+":"+route.Function)
+		handlerFiles[filepath.Clean(route.FilePath)] = true
+	}
+
+	if fileSet, err := opts.Config.GlobFiles(); err == nil {
+		for _, file := range fileSet.PyHTMXFiles {
+			if filepath.Ext(file) != ".py" || handlerFiles[filepath.Clean(file)] {
+				continue
+			}
+			results = append(results, Result{
+				Check:   "handlers",
+				Status:  StatusWarn,
+				Message: file + " defines no htmx_ handlers",
+				Fix:     "name handler functions htmx_<name>, or start helper module names with _ so discovery skips them",
+			})
+		}
+	}
+	return results
+}
@@ -0,0 +1,285 @@
+// Package health tracks optional subsystems so a missing integration
+// degrades the server instead of failing startup or the first request
+package health
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status is the state of a subsystem
+type Status string
+
+const (
+	StatusOK       Status = "ok"
+	StatusDegraded Status = "degraded" // Optional subsystem unavailable, features fall back
+	StatusDown     Status = "down"     // Required subsystem unavailable, not ready
+)
+
+// Check probes a subsystem and returns why it is unavailable
+type Check func(ctx context.Context) error
+
+// checkTimeout bounds a single subsystem probe
+const checkTimeout = 5 * time.Second
+
+// Subsystem is the reported state of a registered integration
+type Subsystem struct {
+	Name        string    `json:"name"`
+	Optional    bool      `json:"optional"`
+	Status      Status    `json:"status"`
+	Reason      string    `json:"reason,omitempty"`
+	Since       time.Time `json:"since"`
+	LastChecked time.Time `json:"last_checked"`
+	LatencyMS   float64   `json:"latency_ms,omitempty"` // Duration of the last probe
+}
+
+type entry struct {
+	Subsystem
+	check Check
+}
+
+// Registry holds the state of every registered subsystem
+type Registry struct {
+	mu         sync.RWMutex
+	subsystems map[string]*entry
+	history    *History
+}
+
+// Default is the registry used by the server's /readyz endpoint
+var Default = NewRegistry()
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{subsystems: make(map[string]*entry)}
+}
+
+// SetHistory records every probe and incident into h. Call it before
+// registering subsystems so their first probe is kept too.
+func (r *Registry) SetHistory(h *History) {
+	r.mu.Lock()
+	r.history = h
+	r.mu.Unlock()
+}
+
+// History returns where probes are recorded, nil when they aren't
+func (r *Registry) History() *History {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.history
+}
+
+// Register adds a subsystem and probes it right away. A failing optional
+// subsystem is marked degraded; a failing required one is marked down.
+// check may be nil for subsystems that report their state via Mark*.
+func (r *Registry) Register(name string, optional bool, check Check) {
+	r.mu.Lock()
+	r.subsystems[name] = &entry{
+		Subsystem: Subsystem{Name: name, Optional: optional, Status: StatusOK, Since: time.Now()},
+		check:     check,
+	}
+	r.mu.Unlock()
+
+	if check != nil {
+		r.probe(context.Background(), name)
+	}
+
+	// Close what a previous run left open if the subsystem is back
+	if h := r.History(); h != nil && r.Available(name) {
+		h.recordTransition(context.Background(), name, StatusOK, "", time.Now())
+	}
+}
+
+// MarkDegraded records that a subsystem is unavailable, e.g. after it failed
+// to connect at startup and a fallback was put in its place
+func (r *Registry) MarkDegraded(name, reason string) {
+	r.mark(name, reason)
+}
+
+// MarkOK records that a subsystem is available again
+func (r *Registry) MarkOK(name string) {
+	r.mark(name, "")
+}
+
+func (r *Registry) mark(name, reason string) {
+	status, changed := r.set(name, reason, 0)
+	if h := r.History(); h != nil && changed {
+		h.recordTransition(context.Background(), name, status, reason, time.Now())
+	}
+}
+
+// Available reports whether a subsystem is registered and currently ok
+func (r *Registry) Available(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	e, ok := r.subsystems[name]
+	return ok && e.Status == StatusOK
+}
+
+// Get returns the state of a subsystem and whether it is registered
+func (r *Registry) Get(name string) (Subsystem, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	e, ok := r.subsystems[name]
+	if !ok {
+		return Subsystem{}, false
+	}
+	return e.Subsystem, true
+}
+
+// Ready reports whether every required subsystem is available
+func (r *Registry) Ready() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, e := range r.subsystems {
+		if e.Status == StatusDown {
+			return false
+		}
+	}
+	return true
+}
+
+// Snapshot returns every subsystem ordered by name
+func (r *Registry) Snapshot() []Subsystem {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	out := make([]Subsystem, 0, len(r.subsystems))
+	for _, e := range r.subsystems {
+		out = append(out, e.Subsystem)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// Refresh probes every subsystem that has a check
+func (r *Registry) Refresh(ctx context.Context) {
+	r.mu.RLock()
+	var names []string
+	for name, e := range r.subsystems {
+		if e.check != nil {
+			names = append(names, name)
+		}
+	}
+	r.mu.RUnlock()
+
+	for _, name := range names {
+		r.probe(ctx, name)
+	}
+}
+
+// Start re-probes subsystems every interval so recovered integrations come
+// back without a restart. Call the returned func to stop.
+func (r *Registry) Start(interval time.Duration) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.Refresh(ctx)
+			}
+		}
+	}()
+
+	return cancel
+}
+
+// Watch re-probes only the named subsystems every interval, for those that
+// need to be noticed sooner than Start's interval. Call the returned func
+// to stop.
+func (r *Registry) Watch(interval time.Duration, names ...string) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				for _, name := range names {
+					r.probe(ctx, name)
+				}
+			}
+		}
+	}()
+
+	return cancel
+}
+
+func (r *Registry) probe(ctx context.Context, name string) {
+	r.mu.RLock()
+	e, ok := r.subsystems[name]
+	r.mu.RUnlock()
+	if !ok || e.check == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	start := time.Now()
+	reason := ""
+	if err := e.check(ctx); err != nil {
+		reason = err.Error()
+	}
+	status, changed := r.set(name, reason, time.Since(start))
+
+	if h := r.History(); h != nil {
+		h.recordCheck(ctx, name, status == StatusOK, start)
+		if changed {
+			h.recordTransition(ctx, name, status, reason, start)
+		}
+	}
+}
+
+// set updates a subsystem's state, logging transitions, and reports the
+// new status and whether it changed
+func (r *Registry) set(name, reason string, latency time.Duration) (Status, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	e, ok := r.subsystems[name]
+	if !ok {
+		// Subsystems reporting their own state are optional by definition
+		e = &entry{Subsystem: Subsystem{Name: name, Optional: true, Status: StatusOK, Since: time.Now()}}
+		r.subsystems[name] = e
+	}
+
+	status := StatusOK
+	if reason != "" {
+		status = StatusDegraded
+		if !e.Optional {
+			status = StatusDown
+		}
+	}
+
+	now := time.Now()
+	e.LastChecked = now
+	if latency > 0 {
+		e.LatencyMS = float64(latency.Microseconds()) / 1000
+	}
+	changed := status != e.Status
+	if changed {
+		e.Since = now
+		if status == StatusOK {
+			log.Printf("Subsystem %s recovered", name)
+		} else {
+			log.Printf("WARNING: Subsystem %s is %s: %s", name, status, reason)
+		}
+	}
+	e.Status = status
+	e.Reason = reason
+	return status, changed
+}
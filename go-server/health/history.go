@@ -0,0 +1,218 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"htmlnojs/store"
+)
+
+// dayFormat names the daily uptime buckets
+const dayFormat = "2006-01-02"
+
+// History persists probe results in the shared store, so uptime and past
+// incidents survive restarts and are shared by servers using one store.
+// Checks are counted per subsystem and UTC day; every stretch a subsystem
+// spends degraded or down is kept as an Incident.
+type History struct {
+	kv   store.Store
+	days int
+}
+
+// Incident is a period during which a subsystem was unavailable
+type Incident struct {
+	Subsystem string    `json:"subsystem"`
+	Status    Status    `json:"status"`
+	Reason    string    `json:"reason,omitempty"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end,omitzero"` // Zero while ongoing
+}
+
+// Ongoing reports whether the subsystem is still unavailable
+func (i Incident) Ongoing() bool {
+	return i.End.IsZero()
+}
+
+// Duration is how long the incident lasted, or has lasted so far
+func (i Incident) Duration() time.Duration {
+	if i.Ongoing() {
+		return time.Since(i.Start)
+	}
+	return i.End.Sub(i.Start)
+}
+
+// DayUptime is the share of successful checks on one UTC day
+type DayUptime struct {
+	Date   string  `json:"date"`
+	Checks int64   `json:"checks"`
+	Uptime float64 `json:"uptime"` // Percent, -1 without checks
+}
+
+// Report is a subsystem's current state and its recorded history
+type Report struct {
+	Subsystem
+	Uptime    float64     `json:"uptime"` // Percent over Days, -1 without checks
+	Days      []DayUptime `json:"days"`   // Oldest first
+	Incidents []Incident  `json:"incidents"`
+}
+
+// NewHistory records into kv and keeps the given number of days
+func NewHistory(kv store.Store, days int) *History {
+	if days <= 0 {
+		days = 30
+	}
+	return &History{kv: store.WithPrefix(kv, "health:"), days: days}
+}
+
+// Days is how many days of history are kept
+func (h *History) Days() int {
+	return h.days
+}
+
+func (h *History) retention() time.Duration {
+	return time.Duration(h.days+1) * 24 * time.Hour
+}
+
+// recordCheck counts one probe of name in today's bucket
+func (h *History) recordCheck(ctx context.Context, name string, ok bool, at time.Time) {
+	day := at.UTC().Format(dayFormat)
+	if _, err := h.kv.Incr(ctx, name+":checks:"+day, 1, h.retention()); err != nil {
+		log.Printf("WARNING: Failed to record %s health check: %v", name, err)
+		return
+	}
+	if ok {
+		if _, err := h.kv.Incr(ctx, name+":ok:"+day, 1, h.retention()); err != nil {
+			log.Printf("WARNING: Failed to record %s health check: %v", name, err)
+		}
+	}
+}
+
+// recordTransition opens an incident when name becomes unavailable and
+// closes it when it recovers
+func (h *History) recordTransition(ctx context.Context, name string, status Status, reason string, at time.Time) {
+	if err := h.transition(ctx, name, status, reason, at); err != nil {
+		log.Printf("WARNING: Failed to record %s incident: %v", name, err)
+	}
+}
+
+func (h *History) transition(ctx context.Context, name string, status Status, reason string, at time.Time) error {
+	open, err := h.incidents(ctx, name, true)
+	if err != nil {
+		return err
+	}
+
+	if status == StatusOK {
+		for key, incident := range open {
+			incident.End = at
+			if err := h.save(ctx, key, incident); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// A server restarted mid-incident picks up the incident it left open
+	for key, incident := range open {
+		incident.Status = status
+		incident.Reason = reason
+		return h.save(ctx, key, incident)
+	}
+	key := fmt.Sprintf("%s:incident:%d", name, at.UnixNano())
+	return h.save(ctx, key, Incident{Subsystem: name, Status: status, Reason: reason, Start: at})
+}
+
+func (h *History) save(ctx context.Context, key string, incident Incident) error {
+	data, err := json.Marshal(incident)
+	if err != nil {
+		return err
+	}
+	return h.kv.Set(ctx, key, data, h.retention())
+}
+
+// incidents loads name's recorded incidents by key, only the ongoing ones
+// when openOnly is set
+func (h *History) incidents(ctx context.Context, name string, openOnly bool) (map[string]Incident, error) {
+	keys, err := h.kv.Scan(ctx, name+":incident:")
+	if err != nil {
+		return nil, err
+	}
+	incidents := make(map[string]Incident, len(keys))
+	for _, key := range keys {
+		data, err := h.kv.Get(ctx, key)
+		if errors.Is(err, store.ErrNotFound) {
+			continue // Expired since the scan
+		}
+		if err != nil {
+			return nil, err
+		}
+		var incident Incident
+		if err := json.Unmarshal(data, &incident); err != nil {
+			continue
+		}
+		if !openOnly || incident.Ongoing() {
+			incidents[key] = incident
+		}
+	}
+	return incidents, nil
+}
+
+// Report combines a subsystem's current state with its daily uptime and
+// the incidents of the kept days, newest first
+func (h *History) Report(ctx context.Context, subsystem Subsystem) (Report, error) {
+	report := Report{Subsystem: subsystem, Uptime: -1}
+
+	var checks, ok int64
+	today := time.Now().UTC()
+	for i := h.days - 1; i >= 0; i-- {
+		day := today.AddDate(0, 0, -i).Format(dayFormat)
+		dayChecks, err := h.counter(ctx, subsystem.Name+":checks:"+day)
+		if err != nil {
+			return report, err
+		}
+		dayOK, err := h.counter(ctx, subsystem.Name+":ok:"+day)
+		if err != nil {
+			return report, err
+		}
+		report.Days = append(report.Days, DayUptime{Date: day, Checks: dayChecks, Uptime: percent(dayOK, dayChecks)})
+		checks += dayChecks
+		ok += dayOK
+	}
+	report.Uptime = percent(ok, checks)
+
+	incidents, err := h.incidents(ctx, subsystem.Name, false)
+	if err != nil {
+		return report, err
+	}
+	for _, incident := range incidents {
+		report.Incidents = append(report.Incidents, incident)
+	}
+	sort.Slice(report.Incidents, func(i, j int) bool {
+		return report.Incidents[i].Start.After(report.Incidents[j].Start)
+	})
+	return report, nil
+}
+
+func (h *History) counter(ctx context.Context, key string) (int64, error) {
+	data, err := h.kv.Get(ctx, key)
+	if errors.Is(err, store.ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+func percent(part, total int64) float64 {
+	if total == 0 {
+		return -1
+	}
+	return float64(part) * 100 / float64(total)
+}
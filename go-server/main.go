@@ -1,70 +1,569 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
-	"path/filepath"
+	"net"
+	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"htmlnojs/backend"
+	"htmlnojs/config"
+	"htmlnojs/doctor"
+	"htmlnojs/health"
 	"htmlnojs/routebuilder"
 	"htmlnojs/server"
 	"htmlnojs/setup"
+	"htmlnojs/store"
 )
 
 func main() {
+	if len(os.Args) > 1 && runCommand(os.Args[1], os.Args[2:]) {
+		return
+	}
+
 	directory := flag.String("directory", ".", "Project directory to serve")
+	host := flag.String("host", "localhost", "Interface to listen on, e.g. 0.0.0.0 for every interface or a LAN address")
+	flag.StringVar(host, "bind", "localhost", "Same as -host")
 	port := flag.Int("port", 8080, "Server port")
 	fastapiPort := flag.Int("fastapi-port", 8081, "FastAPI server port")
+	watch := flag.Bool("watch", true, "Rebuild routes when py_htmx handlers change")
+	configPath := flag.String("config", "", "Project config file (default: <directory>/htmlnojs.json)")
+	demo := flag.Bool("demo", false, "Read-only demo mode: refuse POST/PUT/PATCH/DELETE requests")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate file; serves HTTPS together with -tls-key")
+	tlsKey := flag.String("tls-key", "", "PEM private key file for -tls-cert")
+	var listen []string
+	flag.Func("listen", "Address to serve instead of -port, \"host:port\" or \"unix:/path/to.sock\"; repeat or separate with commas for several", func(value string) error {
+		for _, addr := range strings.Split(value, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				listen = append(listen, addr)
+			}
+		}
+		return nil
+	})
+	httpRedirectPort := flag.Int("http-redirect-port", 0, "Also listen for plain HTTP on this port, redirecting to HTTPS (needs -tls-cert)")
+	flag.IntVar(&proxyFlags.MaxIdleConns, "proxy-max-idle-conns", 0, "Idle backend connections kept in total (default 100)")
+	flag.IntVar(&proxyFlags.MaxIdleConnsPerHost, "proxy-max-idle-conns-per-host", 0, "Idle connections kept per backend (default 100)")
+	flag.IntVar(&proxyFlags.MaxConnsPerHost, "proxy-max-conns-per-host", 0, "Connections allowed per backend (default unlimited)")
+	flag.StringVar(&proxyFlags.IdleConnTimeout, "proxy-idle-timeout", "", "Close idle backend connections after this long (default 90s)")
+	flag.StringVar(&proxyFlags.DialTimeout, "proxy-dial-timeout", "", "Give up connecting to a backend after this long (default 5s)")
 	flag.Parse()
+	for name, value := range map[string]string{"proxy-idle-timeout": proxyFlags.IdleConnTimeout, "proxy-dial-timeout": proxyFlags.DialTimeout} {
+		if d, err := time.ParseDuration(value); value != "" && (err != nil || d <= 0) {
+			log.Fatalf("-%s must be a positive duration such as 30s, got %q", name, value)
+		}
+	}
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatal("-tls-cert and -tls-key must be given together")
+	}
+	if *httpRedirectPort != 0 && *tlsCert == "" {
+		log.Fatal("-http-redirect-port needs -tls-cert and -tls-key")
+	}
 
 	log.SetOutput(os.Stdout)
 	log.Printf("Starting HTMLnoJS server for: %s", *directory)
 
-	config := &setup.Config{
-		ProjectDir:   *directory,
-		PyHTMXDir:    filepath.Join(*directory, "py_htmx"),
-		CSSDir:       filepath.Join(*directory, "css"),
-		TemplatesDir: filepath.Join(*directory, "templates"),
+	cfg, project, err := loadProject(*directory, *configPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	proxy := withProxyFlags(project).Proxy
+	transport, err := routebuilder.ProxyTransport(proxy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// A stdio worker takes the place of the FastAPI backend
+	if project.Worker.Mode == "stdio" {
+		if pythonWorker, err = routebuilder.StartStdioWorker(workerPython(project), cfg.PyHTMXDir); err != nil {
+			log.Fatal(err)
+		}
+		transport = pythonWorker
+	}
+	// Without a backend to proxy to, the server runs uvicorn itself
+	managed := wantsManagedBackend(project, proxy, *fastapiPort)
+	var workerPorts []int
+	if managed {
+		if workerPorts, err = backend.FreePorts(max(project.Worker.Count, 1)); err != nil {
+			log.Fatal(err)
+		}
+		*fastapiPort = workerPorts[0]
+		if pythonBackend, err = backend.NewPool(workerPython(project), workerPorts); err != nil {
+			log.Fatal(err)
+		}
+	}
+	routebuilder.SetTemplateHelperTransport(transport)
+	fastAPIURL := routebuilder.BackendURL(proxy, *fastapiPort)
+	if err := registerTemplateFuncs(project, fastAPIURL); err != nil {
+		log.Fatal(err)
+	}
+
+	failedRequests = routebuilder.NewFailedRequests(project.Replay.Capacity)
+	routes, err := buildRoutes(cfg, project, *fastapiPort)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if pythonBackend != nil {
+		app, err := managedApp(cfg, routes, *fastapiPort)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := pythonBackend.Start(app); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	kv, err := store.Open(project.Store, *directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer kv.Close()
+
+	// Keep health checks so /status can show uptime and past incidents
+	health.Default.SetHistory(health.NewHistory(kv, project.StatusPage.Days))
+	statusPath := project.StatusPage.Path
+	if statusPath == "off" {
+		statusPath = ""
 	}
 
-	fileSet, err := config.GlobFiles()
+	state := &projectState{
+		cfg:         cfg,
+		project:     project,
+		configPath:  configFilePath(*directory, *configPath),
+		fastapiPort: *fastapiPort,
+	}
+
+	drainTimeout, _ := time.ParseDuration(project.Shutdown.DrainTimeout) // validated when the config was loaded
+	slowRequests, _ := time.ParseDuration(project.SlowRequests)
+	idempotencyWindow, _ := time.ParseDuration(project.Idempotency.Window)
+	nonceLifetime, _ := time.ParseDuration(project.Nonces.Lifetime)
+	signer, err := urlSigner(project.SignedURLs)
+	if err != nil {
+		log.Fatal(err)
+	}
+	routebuilder.SetURLSigner(signer)
+	auth, err := authenticators(project.Auth, signer)
+	if err != nil {
+		log.Fatal(err)
+	}
+	keys, err := apiKeys(project.Auth.APIKeys, *directory, kv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	access, err := ipAccess(project.IPAccess)
+	if err != nil {
+		log.Fatal(err)
+	}
+	proxies, err := trustedProxies(project.TrustedProxies)
+	if err != nil {
+		log.Fatal(err)
+	}
+	crossOrigin, err := cors(project.CORS)
+	if err != nil {
+		log.Fatal(err)
+	}
+	identityKey, err := identitySecret(project.Auth)
+	if err != nil {
+		log.Fatal(err)
+	}
+	loginRoutes, err := login(project.Auth.Login, *directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+	audit, err := auditLog(project.Audit, *directory)
 	if err != nil {
 		log.Fatal(err)
 	}
+	sessionStore, err := sessions(project.Sessions, kv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	admin, err := adminAccess(project.Admin, *directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var srv *server.Server
+	srv = server.Development().
+		Host(*host).
+		Port(*port).
+		Listen(listen...).
+		WithTLS(*tlsCert, *tlsKey).
+		WithHTTPSRedirect(*httpRedirectPort).
+		EnableDemoMode(*demo || project.DemoMode).
+		EnableCSRF(project.CSRF).
+		WithSampling(server.SamplingConfig{
+			Rate:     project.Sampling.Rate,
+			Errors:   project.Sampling.Errors,
+			Capacity: project.Sampling.Capacity,
+		}).
+		WithSlowRequestLog(slowRequests).
+		WithIdempotency(idempotencyWindow).
+		WithNonceLifetime(nonceLifetime).
+		WithStore(kv).
+		WithSessions(sessionStore).
+		WithAuthenticators(auth...).
+		WithAPIKeys(keys).
+		WithRoles(project.Auth.Roles).
+		WithIPAccess(access).
+		WithTrustedProxies(proxies).
+		WithUserAgentRules(userAgentRules(project.UserAgents)).
+		WithCORS(crossOrigin).
+		WithAuditLog(audit).
+		WithMaintenance(maintenance(project.Maintenance, *directory)).
+		WithLoginURL(project.Auth.LoginURL).
+		WithLogin(loginRoutes).
+		WithIdentitySecret(identityKey).
+		WithAdmin(admin).
+		WithBackendStatus(backendStatus).
+		WithBackendProcess(backendProcess).
+		WithStatusPage(server.StatusPageConfig{
+			Path:   statusPath,
+			Title:  project.StatusPage.Title,
+			Public: project.StatusPage.Public,
+		}).
+		WithHARRecorder(harRecorder).
+		WithFailedRequests(failedRequests).
+		WithDoctor(func(ctx context.Context) []doctor.Result {
+			return state.diagnose(ctx, srv.GetRoutes())
+		}).
+		EnablePublicDoctor(project.Doctor.Public).
+		WithConfigValidator(func(data []byte, apply bool) *config.CheckResult {
+			return state.validate(data, apply, srv.RegisterRoutes)
+		}).
+		WithRoutes(routes).
+		WithShutdownTimeout(drainTimeout).
+		Build()
+
+	// Backends stop once requests have drained, so none is cut off mid-request
+	if pythonWorker != nil {
+		srv.OnShutdown(pythonWorker.Stop)
+	}
+	if pythonBackend != nil {
+		srv.OnShutdown(pythonBackend.Stop)
+	}
+	if audit != nil {
+		srv.OnShutdown(audit.Close)
+	}
+
+	// Backends the server starts itself, e.g. the Node sidecar for js_htmx/
+	for language, handler := range project.Handlers {
+		if !handler.Spawn {
+			continue
+		}
+		adapter, _ := routebuilder.GetHandlerAdapter(language) // checked by loadProject
+		stop, err := adapter.(routebuilder.SidecarAdapter).StartSidecar(cfg.HandlerDirs[language], handler.Port)
+		if err != nil {
+			log.Fatal(err)
+		}
+		srv.OnShutdown(stop)
+		if err := waitHealthy(context.Background(), handler.Port, 10*time.Second); err != nil {
+			log.Printf("WARNING: %s backend: %v", language, err)
+		}
+	}
+
+	// The Python backend may start after us; requests degrade until it's up
+	fastAPI := routebuilder.NewPythonRouteBuilder(cfg.PyHTMXDir)
+	fastAPI.SetFastAPIURL(fastAPIURL)
+	fastAPI.SetTransport(transport)
+	check := fastAPI.CheckFastAPIHealth
+	if pythonWorker != nil {
+		check = pythonWorker.Check
+	} else if pythonBackend != nil {
+		check = pythonBackend.Check
+	}
+	health.Default.Register(backendSubsystem(routebuilder.DefaultHandlerLanguage), true, check)
+	// Sessions and rate limits can't work without their store
+	health.Default.Register("store", false, func(ctx context.Context) error {
+		return store.Ping(ctx, kv)
+	})
+	backends := []string{backendSubsystem(routebuilder.DefaultHandlerLanguage)}
+	for language, handler := range project.Handlers {
+		if routebuilder.IsInProcess(language) {
+			continue
+		}
+		backendURL := fmt.Sprintf("http://localhost:%d/health", handler.Port)
+		health.Default.Register(backendSubsystem(language), true, func(ctx context.Context) error {
+			return checkHTTP(ctx, backendURL)
+		})
+		backends = append(backends, backendSubsystem(language))
+	}
+	stopHealth := health.Default.Start(30 * time.Second)
+	defer stopHealth()
+	// Backends are watched closely since their routes fail fast while down
+	healthInterval, _ := time.ParseDuration(project.Proxy.HealthInterval) // validated when the config was loaded
+	if healthInterval <= 0 {
+		healthInterval = 5 * time.Second
+	}
+	stopWatch := health.Default.Watch(healthInterval, backends...)
+	defer stopWatch()
+
+	if interval := project.CostReport.Interval; interval != "" {
+		d, _ := time.ParseDuration(interval) // validated when the config was loaded
+		stop := srv.StartCostReports(d, project.CostReport.File)
+		defer stop()
+	}
+
+	if *watch {
+		watcher := routebuilder.NewWatcher(cfg.PyHTMXDir, ".py", time.Second, func() {
+			routes, err := state.rebuild(srv.RegisterRoutes)
+			if routes == nil {
+				log.Printf("ERROR: Route rebuild failed, keeping previous routes: %v", err)
+				return
+			}
+			if err != nil {
+				log.Printf("ERROR: Route swap failed, keeping previous routes: %v", err)
+			}
+			// uvicorn imported the old handlers and mounted the old routes
+			if pythonBackend != nil {
+				app, err := managedApp(cfg, routes, *fastapiPort)
+				if err == nil {
+					err = pythonBackend.Restart(app)
+				}
+				if err != nil {
+					log.Printf("ERROR: Restarting uvicorn failed: %v", err)
+				}
+			}
+		})
+		watcher.Start()
+		defer watcher.Stop()
+	}
+
+	scheme := "http"
+	if *tlsCert != "" {
+		scheme = "https"
+	}
+	baseURL := serverURL(scheme, srv.GetAddr())
+	log.Printf("HTMLnoJS server starting at %s", baseURL)
+	if pythonWorker != nil {
+		log.Printf("Python handlers run in a stdio worker")
+	} else if pythonBackend != nil && pythonBackend.Size() > 1 {
+		log.Printf("Python handlers run in %d uvicorn workers", pythonBackend.Size())
+	} else if pythonBackend != nil {
+		log.Printf("Python handlers run in uvicorn at %s", fastAPIURL)
+	} else if proxy.UnixSocket != "" {
+		log.Printf("FastAPI backend expected on unix socket %s", proxy.UnixSocket)
+	} else {
+		log.Printf("FastAPI backend expected at %s", fastAPIURL)
+	}
+	log.Printf("Route map: %s/_routes", baseURL)
+    log.Printf("Routes.json: %s/_routes.json", baseURL)
+	log.Printf("Introspection: %s/_introspect", baseURL)
+	log.Printf("Render stats: %s/_stats", baseURL)
+	log.Printf("Health check: %s/health", baseURL)
+	log.Printf("Readiness: %s/readyz", baseURL)
+	if statusPath != "" {
+		log.Printf("Status page: %s%s", baseURL, statusPath)
+	}
+	log.Printf("Config check: POST %s/_admin/config/validate", baseURL)
+	log.Printf("Press Ctrl+C to stop")
+
+	// Prime the backends so the first user doesn't wait for them to warm up
+	go warmup(srv, warmupPaths(project, routes), check)
+
+	// Returning, rather than exiting, lets the deferred cleanup run
+	if err := srv.StartWithGracefulShutdown(); err != nil {
+		log.Printf("ERROR: %v", err)
+	}
+}
+
+// serverURL is where the log points people to the server listening on
+// addr; sockets are reached with curl --unix-socket at http://localhost
+func serverURL(scheme, addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || strings.HasPrefix(addr, "unix:") {
+		return scheme + "://localhost"
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return scheme + "://" + net.JoinHostPort(host, port)
+}
+
+// proxyFlags override the proxy settings of htmlnojs.json, including
+// configs applied while the server runs
+var proxyFlags config.ProxyConfig
+
+// pythonWorker runs py_htmx/ handlers when worker.mode is "stdio"
+var pythonWorker *routebuilder.StdioWorker
+
+// pythonBackend is the uvicorn workers the server manages, if any
+var pythonBackend *backend.Pool
+
+// harRecorder captures proxied traffic on demand, see /_admin/har
+var harRecorder = routebuilder.NewHARRecorder()
+
+// failedRequests keeps failed proxied requests for /_admin/replay; it is
+// sized from the config at startup
+var failedRequests *routebuilder.FailedRequests
+
+// workerPython returns the interpreter running Python handlers
+func workerPython(project *config.ProjectConfig) string {
+	if project.Worker.Python != "" {
+		return project.Worker.Python
+	}
+	return "python3"
+}
+
+// wantsManagedBackend reports whether the server should serve py_htmx/ with
+// uvicorn itself: always with worker.mode "uvicorn", and without a mode when
+// no backend answers on port and uvicorn is installed
+func wantsManagedBackend(project *config.ProjectConfig, proxy config.ProxyConfig, port int) bool {
+	switch project.Worker.Mode {
+	case "uvicorn":
+		return true
+	case "":
+		if proxy.BackendURL != "" || proxy.UnixSocket != "" {
+			return false
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if checkHTTP(ctx, fmt.Sprintf("http://localhost:%d/health", port)) == nil {
+			return false
+		}
+		if err := backend.Available(workerPython(project)); err != nil {
+			log.Printf("No FastAPI backend on port %d and %v; Python routes fail until one starts", port, err)
+			return false
+		}
+		log.Printf("No FastAPI backend on port %d, starting uvicorn (set worker.mode to \"http\" to run your own)", port)
+		return true
+	}
+	return false
+}
+
+// managedApp generates the FastAPI app uvicorn serves for routes
+func managedApp(cfg *setup.Config, routes *routebuilder.RouteCollection, port int) ([]byte, error) {
+	pyHTMXDir, err := filepath.Abs(cfg.PyHTMXDir)
+	if err != nil {
+		return nil, err
+	}
+	return routebuilder.GenerateFastAPIApp(routes.PythonRoutes, routebuilder.FastAPIAppOptions{
+		PyHTMXDir: filepath.ToSlash(pyHTMXDir),
+		Port:      port,
+	})
+}
+
+// withProxyFlags returns project with the -proxy-* flags applied
+func withProxyFlags(project *config.ProjectConfig) *config.ProjectConfig {
+	if proxyFlags == (config.ProxyConfig{}) {
+		return project
+	}
+	overridden := *project
+	proxy := &overridden.Proxy
+	if proxyFlags.MaxIdleConns > 0 {
+		proxy.MaxIdleConns = proxyFlags.MaxIdleConns
+	}
+	if proxyFlags.MaxIdleConnsPerHost > 0 {
+		proxy.MaxIdleConnsPerHost = proxyFlags.MaxIdleConnsPerHost
+	}
+	if proxyFlags.MaxConnsPerHost > 0 {
+		proxy.MaxConnsPerHost = proxyFlags.MaxConnsPerHost
+	}
+	if proxyFlags.IdleConnTimeout != "" {
+		proxy.IdleConnTimeout = proxyFlags.IdleConnTimeout
+	}
+	if proxyFlags.DialTimeout != "" {
+		proxy.DialTimeout = proxyFlags.DialTimeout
+	}
+	return &overridden
+}
+
+// buildRoutes discovers project files and builds a fresh route collection
+func buildRoutes(cfg *setup.Config, project *config.ProjectConfig, fastapiPort int) (*routebuilder.RouteCollection, error) {
+	fileSet, err := cfg.GlobFiles()
+	if err != nil {
+		return nil, err
+	}
 
 	log.Printf("Discovered %d HTML, %d CSS, %d Python files",
 		len(fileSet.TemplateFiles), len(fileSet.CSSFiles), len(fileSet.PyHTMXFiles),
 	)
 
+	if err := routebuilder.SetProjectRoot(cfg.ProjectDir, project.Symlinks); err != nil {
+		return nil, err
+	}
+	if err := routebuilder.SetErrorPages(project.Errors, cfg.ProjectDir); err != nil {
+		return nil, err
+	}
+
 	routeBuilder := routebuilder.NewAllRoutesBuilder(
-		config.TemplatesDir,
-		config.CSSDir,
-		config.PyHTMXDir,
-		*fastapiPort,
+		cfg.TemplatesDir,
+		cfg.CSSDir,
+		cfg.PyHTMXDir,
+		fastapiPort,
 	)
+	routeBuilder.SetProjectConfig(withProxyFlags(project))
+	routeBuilder.SetBackendStatus(backendStatus)
+	routeBuilder.SetHARRecorder(harRecorder)
+	if failedRequests != nil {
+		routeBuilder.SetFailedRequests(failedRequests)
+	}
+	if pythonWorker != nil {
+		routeBuilder.SetPythonWorker(pythonWorker)
+	}
+	if pythonBackend != nil {
+		routeBuilder.SetBackendPicker(pythonBackend)
+	}
+	routeBuilder.AddFonts(cfg.FontsDir, fileSet.FontFiles)
+	for language, dir := range cfg.HandlerDirs {
+		if err := routeBuilder.AddHandlers(language, dir, project.Handlers[language].Port, fileSet.HandlerFiles[language]); err != nil {
+			return nil, err
+		}
+	}
 
-	routes, err := routeBuilder.BuildAllRoutes(
+	return routeBuilder.BuildAllRoutes(
 		fileSet.TemplateFiles,
 		fileSet.CSSFiles,
 		fileSet.PyHTMXFiles,
 	)
-	if err != nil {
-		log.Fatal(err)
+}
+
+// backendSubsystem is the health subsystem checking language's backend
+func backendSubsystem(language string) string {
+	if language == routebuilder.DefaultHandlerLanguage {
+		return "fastapi"
 	}
+	return language
+}
 
-	srv := server.Development().
-		Port(*port).
-		WithRoutes(routes).
-		Build()
+// backendStatus reports a handler backend down once its health check
+// fails. Backends without a check, e.g. before the server registered them,
+// are assumed up.
+func backendStatus(language string) (bool, string) {
+	subsystem, ok := health.Default.Get(backendSubsystem(language))
+	if !ok || subsystem.Status == health.StatusOK {
+		return true, ""
+	}
+	return false, subsystem.Reason
+}
 
-	log.Printf("HTMLnoJS server starting at http://localhost:%d", *port)
-	log.Printf("FastAPI backend expected at http://localhost:%d", *fastapiPort)
-	log.Printf("Route map: http://localhost:%d/_routes", *port)
-    log.Printf("Routes.json: http://localhost:%d/_routes.json", *port)
-	log.Printf("Health check: http://localhost:%d/health", *port)
-	log.Printf("Press Ctrl+C to stop")
+// backendProcess reports the uvicorn workers the server manages for Python
+// handlers
+func backendProcess(language string) []backend.Status {
+	if language != routebuilder.DefaultHandlerLanguage || pythonBackend == nil {
+		return nil
+	}
+	return pythonBackend.Status()
+}
 
-	if err := srv.StartWithGracefulShutdown(); err != nil {
-		log.Fatal(err)
+// checkHTTP succeeds when url answers with a non-5xx status
+func checkHTTP(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("%s returned status %d", url, resp.StatusCode)
 	}
+	return nil
 }
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+
+	"htmlnojs/config"
+	"htmlnojs/doctor"
+	"htmlnojs/routebuilder"
+	"htmlnojs/setup"
+)
+
+// projectState is the running project configuration. Applying a new config
+// through /_admin/config/validate replaces it while the server keeps running.
+type projectState struct {
+	mu          sync.Mutex
+	cfg         *setup.Config
+	project     *config.ProjectConfig
+	configPath  string
+	fastapiPort int
+}
+
+// rebuild builds routes from the current configuration and hands them to
+// swap. The lock is held throughout, so a config applied meanwhile can't be
+// replaced by routes built from the one before it.
+func (p *projectState) rebuild(swap func(*routebuilder.RouteCollection) error) (*routebuilder.RouteCollection, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	routes, err := buildRoutes(p.cfg, p.project, p.fastapiPort)
+	if err != nil {
+		return nil, err
+	}
+	return routes, swap(routes)
+}
+
+// diagnose runs the doctor's checks against the running server
+func (p *projectState) diagnose(ctx context.Context, routes *routebuilder.RouteCollection) []doctor.Result {
+	p.mu.Lock()
+	cfg, project := p.cfg, p.project
+	p.mu.Unlock()
+	return doctor.Run(ctx, doctor.Options{
+		Config:      cfg,
+		Project:     project,
+		Python:      workerPython(project),
+		FastAPIPort: p.fastapiPort,
+		Managed:     pythonBackend != nil,
+		Routes:      routes,
+	})
+}
+
+// validate checks a proposed config and, when apply is set and it builds,
+// writes it to disk and hands the new routes to swap. The file goes back to
+// what it was when the swap fails, so it always holds the running config.
+func (p *projectState) validate(data []byte, apply bool, swap func(*routebuilder.RouteCollection) error) *config.CheckResult {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cfg, proposed, routes, result := checkProposal(p.cfg, p.project, data, p.fastapiPort)
+	if !apply || !result.Valid {
+		return result
+	}
+
+	previous, err := os.ReadFile(p.configPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		result.Errors = append(result.Errors, "failed to read current config: "+err.Error())
+		return result
+	}
+	if err := config.WriteFile(p.configPath, data); err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		return result
+	}
+	if err := swap(routes); err != nil {
+		result.Errors = append(result.Errors, err.Error())
+		if previous == nil {
+			err = os.Remove(p.configPath)
+		} else {
+			err = config.WriteFile(p.configPath, previous)
+		}
+		if err != nil {
+			result.Errors = append(result.Errors, "failed to restore previous config: "+err.Error())
+		}
+		return result
+	}
+	p.cfg, p.project = cfg, proposed
+	result.Applied = true
+	return result
+}
+
+// checkProposal parses a proposed config, diffs it against the current one
+// and builds the routes it would produce, without touching the running server
+func checkProposal(cfg *setup.Config, current *config.ProjectConfig, data []byte, fastapiPort int) (*setup.Config, *config.ProjectConfig, *routebuilder.RouteCollection, *config.CheckResult) {
+	proposed, result := config.Check(current, data)
+	if !result.Valid {
+		return nil, nil, nil, result
+	}
+
+	next := *cfg
+	next.Exclude = proposed.Exclude
+	dirs, err := handlerDirs(cfg.ProjectDir, proposed)
+	if err != nil {
+		result.Valid = false
+		result.Errors = append(result.Errors, err.Error())
+		return nil, nil, nil, result
+	}
+	next.HandlerDirs = dirs
+
+	routes, err := buildRoutes(&next, proposed, fastapiPort)
+	if err != nil {
+		result.Valid = false
+		result.Errors = append(result.Errors, "route build failed: "+err.Error())
+		return nil, nil, nil, result
+	}
+
+	if fileSet, err := next.GlobFiles(); err == nil {
+		for _, issue := range routebuilder.LintTemplates(proposed, fileSet.TemplateFiles) {
+			result.Warnings = append(result.Warnings, issue.String())
+		}
+	}
+	return &next, proposed, routes, result
+}
@@ -0,0 +1,162 @@
+// Package projectfs is the one way files of a served project are read.
+// Every path is resolved against the project directory through os.Root,
+// so neither "..", an absolute path nor a symlink can reach a file
+// outside of it.
+package projectfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Symlink policies
+const (
+	// SymlinksInside follows symlinks that stay in the project (default)
+	SymlinksInside = "inside"
+	// SymlinksDeny refuses every path through a symlink
+	SymlinksDeny = "deny"
+	// SymlinksFollow follows symlinks wherever they point; paths are still
+	// kept from climbing out with ".."
+	SymlinksFollow = "follow"
+)
+
+var (
+	// ErrOutside is returned for paths outside the project directory
+	ErrOutside = errors.New("path is outside the project directory")
+	// ErrSymlink is returned for paths through a symlink under SymlinksDeny
+	ErrSymlink = errors.New("path goes through a symlink")
+)
+
+// Root is a project directory opened for reading. It implements fs.FS,
+// fs.ReadFileFS and fs.StatFS with slash-separated names relative to it.
+type Root struct {
+	dir    string // Absolute
+	root   *os.Root
+	policy string
+}
+
+// Open opens dir with a symlink policy, "" meaning SymlinksInside
+func Open(dir, policy string) (*Root, error) {
+	switch policy {
+	case "":
+		policy = SymlinksInside
+	case SymlinksInside, SymlinksDeny, SymlinksFollow:
+	default:
+		return nil, fmt.Errorf("unknown symlink policy %q (available: inside, deny, follow)", policy)
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve project directory: %w", err)
+	}
+	root, err := os.OpenRoot(abs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open project directory: %w", err)
+	}
+	return &Root{dir: abs, root: root, policy: policy}, nil
+}
+
+// Dir returns the absolute project directory
+func (r *Root) Dir() string {
+	return r.dir
+}
+
+// Policy returns the symlink policy
+func (r *Root) Policy() string {
+	return r.policy
+}
+
+// Close releases the directory
+func (r *Root) Close() error {
+	return r.root.Close()
+}
+
+// Name turns an OS path, absolute or relative to the working directory,
+// into the name of the same file in the root, or returns ErrOutside
+func (r *Root) Name(osPath string) (string, error) {
+	abs, err := filepath.Abs(osPath)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(r.dir, abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return "", &fs.PathError{Op: "open", Path: osPath, Err: ErrOutside}
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// Open opens the named file for reading
+func (r *Root) Open(name string) (fs.File, error) {
+	if err := r.check("open", name); err != nil {
+		return nil, err
+	}
+	if r.policy == SymlinksFollow {
+		return os.Open(filepath.Join(r.dir, filepath.FromSlash(name)))
+	}
+	return r.root.Open(filepath.FromSlash(name))
+}
+
+// ReadFile reads the named file
+func (r *Root) ReadFile(name string) ([]byte, error) {
+	f, err := r.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// Stat describes the named file
+func (r *Root) Stat(name string) (fs.FileInfo, error) {
+	if err := r.check("stat", name); err != nil {
+		return nil, err
+	}
+	if r.policy == SymlinksFollow {
+		return os.Stat(filepath.Join(r.dir, filepath.FromSlash(name)))
+	}
+	return r.root.Stat(filepath.FromSlash(name))
+}
+
+// ReadPath reads a file by its OS path, e.g. one found by discovery
+func (r *Root) ReadPath(osPath string) ([]byte, error) {
+	name, err := r.Name(osPath)
+	if err != nil {
+		return nil, err
+	}
+	return r.ReadFile(name)
+}
+
+// OpenPath opens a file by its OS path
+func (r *Root) OpenPath(osPath string) (fs.File, error) {
+	name, err := r.Name(osPath)
+	if err != nil {
+		return nil, err
+	}
+	return r.Open(name)
+}
+
+// check refuses names that aren't clean and relative, and under
+// SymlinksDeny, names with a symlink anywhere along them
+func (r *Root) check(op, name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: op, Path: name, Err: ErrOutside}
+	}
+	if r.policy != SymlinksDeny || name == "." {
+		return nil
+	}
+	for prefix := name; prefix != "."; prefix = path.Dir(prefix) {
+		info, err := r.root.Lstat(filepath.FromSlash(prefix))
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return &fs.PathError{Op: op, Path: name, Err: ErrSymlink}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+// Package provenance produces the audit report written by "htmlnojs build"
+package provenance
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"htmlnojs/config"
+	"htmlnojs/routebuilder"
+	"htmlnojs/setup"
+)
+
+// Report describes exactly what went into a build
+type Report struct {
+	GeneratedAt    string            `json:"generated_at"`
+	ProjectDir     string            `json:"project_dir"`
+	Go             GoInfo            `json:"go"`
+	Modules        []Module          `json:"modules"`
+	VCS            map[string]string `json:"vcs,omitempty"`
+	EmbeddedAssets []Asset           `json:"embedded_assets"`
+	ProjectFiles   []Asset           `json:"project_files"`
+	HTMX           []HTMXReference   `json:"htmx"`
+	Config         ConfigSnapshot    `json:"config"`
+	Routes         RouteSummary      `json:"routes"`
+	Warnings       []string          `json:"warnings,omitempty"`
+}
+
+// GoInfo identifies the toolchain and main module of the binary
+type GoInfo struct {
+	Version string `json:"version"`
+	OS      string `json:"os"`
+	Arch    string `json:"arch"`
+	Module  string `json:"module,omitempty"`
+}
+
+// Module is a Go module compiled into the binary
+type Module struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	Sum     string `json:"sum,omitempty"`
+	Replace string `json:"replace,omitempty"`
+}
+
+// Asset is a file and its SHA-256 digest
+type Asset struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// HTMXReference is an htmx version loaded by one or more templates
+type HTMXReference struct {
+	Version string   `json:"version"`
+	Files   []string `json:"files"`
+}
+
+// ConfigSnapshot is the effective project configuration at build time
+type ConfigSnapshot struct {
+	Path   string          `json:"path"`
+	SHA256 string          `json:"sha256,omitempty"`
+	Values json.RawMessage `json:"values"`
+}
+
+// RouteSummary lists the routes the build produced
+type RouteSummary struct {
+	HTML   []string `json:"html"`
+	CSS    []string `json:"css"`
+	Python []string `json:"python"`
+	Total  int      `json:"total"`
+}
+
+// Options are the inputs of a provenance report
+type Options struct {
+	ProjectDir string
+	ConfigPath string
+	Project    *config.ProjectConfig
+	Files      *setup.FileSet
+	Routes     *routebuilder.RouteCollection
+	Embedded   fs.FS
+}
+
+// unpinnedHTMX marks templates that load htmx without a version
+const unpinnedHTMX = "unpinned"
+
+var htmxScriptRegex = regexp.MustCompile(`htmx\.org(?:@([0-9A-Za-z.\-]+))?`)
+
+// Generate builds a provenance report for a project build
+func Generate(opts Options) (*Report, error) {
+	report := &Report{
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		ProjectDir:  opts.ProjectDir,
+		Go: GoInfo{
+			Version: runtime.Version(),
+			OS:      runtime.GOOS,
+			Arch:    runtime.GOARCH,
+		},
+	}
+
+	if info, ok := debug.ReadBuildInfo(); ok {
+		report.Go.Module = info.Main.Path
+		report.Modules, report.VCS = buildModules(info)
+	}
+
+	embedded, err := hashFS(opts.Embedded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash embedded assets: %w", err)
+	}
+	report.EmbeddedAssets = embedded
+
+	var files []string
+	if opts.Files != nil {
+		files = append(files, opts.Files.TemplateFiles...)
+		files = append(files, opts.Files.CSSFiles...)
+		files = append(files, opts.Files.FontFiles...)
+		files = append(files, opts.Files.PyHTMXFiles...)
+	}
+	projectFiles, err := hashFiles(opts.ProjectDir, files)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash project files: %w", err)
+	}
+	report.ProjectFiles = projectFiles
+
+	if opts.Files != nil {
+		report.HTMX, err = findHTMXVersions(opts.ProjectDir, opts.Files.TemplateFiles)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan templates for htmx: %w", err)
+		}
+	}
+	for _, ref := range report.HTMX {
+		if ref.Version == unpinnedHTMX {
+			report.Warnings = append(report.Warnings,
+				fmt.Sprintf("htmx is loaded without a pinned version in %s", strings.Join(ref.Files, ", ")))
+		}
+	}
+	if len(report.HTMX) > 1 {
+		report.Warnings = append(report.Warnings, "templates load more than one htmx version")
+	}
+
+	report.Config, err = snapshotConfig(opts.ConfigPath, opts.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Routes != nil {
+		report.Routes = summarizeRoutes(opts.Routes)
+	}
+
+	return report, nil
+}
+
+func buildModules(info *debug.BuildInfo) ([]Module, map[string]string) {
+	modules := []Module{{Path: info.Main.Path, Version: info.Main.Version, Sum: info.Main.Sum}}
+	for _, dep := range info.Deps {
+		module := Module{Path: dep.Path, Version: dep.Version, Sum: dep.Sum}
+		if dep.Replace != nil {
+			module.Replace = dep.Replace.Path + "@" + dep.Replace.Version
+		}
+		modules = append(modules, module)
+	}
+
+	vcs := map[string]string{}
+	for _, setting := range info.Settings {
+		if strings.HasPrefix(setting.Key, "vcs") {
+			vcs[setting.Key] = setting.Value
+		}
+	}
+	if len(vcs) == 0 {
+		vcs = nil
+	}
+	return modules, vcs
+}
+
+func hashFS(fsys fs.FS) ([]Asset, error) {
+	assets := []Asset{}
+	if fsys == nil {
+		return assets, nil
+	}
+
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return err
+		}
+		assets = append(assets, newAsset(path, data))
+		return nil
+	})
+	return assets, err
+}
+
+func hashFiles(baseDir string, files []string) ([]Asset, error) {
+	assets := []Asset{}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, newAsset(relPath(baseDir, file), data))
+	}
+
+	sort.Slice(assets, func(i, j int) bool { return assets[i].Path < assets[j].Path })
+	return assets, nil
+}
+
+func newAsset(path string, data []byte) Asset {
+	sum := sha256.Sum256(data)
+	return Asset{Path: path, Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])}
+}
+
+// findHTMXVersions groups templates by the htmx version their script tags load
+func findHTMXVersions(baseDir string, templateFiles []string) ([]HTMXReference, error) {
+	byVersion := map[string][]string{}
+	for _, file := range templateFiles {
+		if !strings.HasSuffix(strings.ToLower(file), ".html") {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, match := range htmxScriptRegex.FindAllStringSubmatch(string(data), -1) {
+			version := match[1]
+			if version == "" {
+				version = unpinnedHTMX
+			}
+			byVersion[version] = appendUnique(byVersion[version], relPath(baseDir, file))
+		}
+	}
+
+	refs := []HTMXReference{}
+	for version, files := range byVersion {
+		sort.Strings(files)
+		refs = append(refs, HTMXReference{Version: version, Files: files})
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Version < refs[j].Version })
+	return refs, nil
+}
+
+func snapshotConfig(path string, project *config.ProjectConfig) (ConfigSnapshot, error) {
+	snapshot := ConfigSnapshot{Path: path}
+	if project == nil {
+		project = config.Default()
+	}
+
+	values, err := json.Marshal(project)
+	if err != nil {
+		return snapshot, fmt.Errorf("failed to encode config snapshot: %w", err)
+	}
+	snapshot.Values = values
+
+	if data, err := os.ReadFile(path); err == nil {
+		sum := sha256.Sum256(data)
+		snapshot.SHA256 = hex.EncodeToString(sum[:])
+	}
+	return snapshot, nil
+}
+
+func summarizeRoutes(routes *routebuilder.RouteCollection) RouteSummary {
+	summary := RouteSummary{HTML: []string{}, CSS: []string{}, Python: []string{}}
+	for _, route := range routes.HTMLRoutes {
+		summary.HTML = append(summary.HTML, route.Method+" "+route.Route)
+	}
+	for _, route := range routes.CSSRoutes {
+		summary.CSS = append(summary.CSS, route.Method+" "+route.Route)
+	}
+	for _, route := range routes.PythonRoutes {
+		summary.Python = append(summary.Python, route.Method+" "+route.Route)
+	}
+	summary.Total = len(summary.HTML) + len(summary.CSS) + len(summary.Python)
+	return summary
+}
+
+func relPath(baseDir, path string) string {
+	if rel, err := filepath.Rel(baseDir, path); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return filepath.ToSlash(path)
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, item := range list {
+		if item == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
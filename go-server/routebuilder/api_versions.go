@@ -0,0 +1,84 @@
+package routebuilder
+
+import (
+	"log"
+	"regexp"
+	"strings"
+)
+
+var (
+	apiVersionSegmentRegex    = regexp.MustCompile(`^v\d+$`)
+	apiVersionAnnotationRegex = regexp.MustCompile(`@api_version\(\s*["']?(v?\d+)["']?\s*\)`)
+)
+
+// NormalizeAPIVersion turns "2" or "v2" into "v2", returning "" for
+// anything that isn't a version
+func NormalizeAPIVersion(version string) string {
+	version = strings.ToLower(strings.TrimSpace(version))
+	if version != "" && !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	if !apiVersionSegmentRegex.MatchString(version) {
+		return ""
+	}
+	return version
+}
+
+// apiVersionOf returns the leading vN segment of a handler URL path, as
+// produced by py_htmx/v1/... and py_htmx/v2/... directories
+func apiVersionOf(urlPath string) string {
+	first := strings.SplitN(strings.Trim(urlPath, "/"), "/", 2)[0]
+	if apiVersionSegmentRegex.MatchString(first) {
+		return first
+	}
+	return ""
+}
+
+// extractAPIVersion reads an @api_version(2) annotation from a docstring
+func (p *PythonRouteBuilder) extractAPIVersion(doc string) string {
+	if matches := apiVersionAnnotationRegex.FindStringSubmatch(doc); matches != nil {
+		return NormalizeAPIVersion(matches[1])
+	}
+	return ""
+}
+
+// defaultVersionAliases exposes the routes of the default API version
+// without their version segment, e.g. /api/v2/orders/list is also served
+// at /api/orders/list. Unversioned handlers already at that path win.
+func defaultVersionAliases(routes []PythonRoute, defaultVersion string) []PythonRoute {
+	version := NormalizeAPIVersion(defaultVersion)
+	if version == "" {
+		return nil
+	}
+
+	taken := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		taken[route.Method+" "+route.Route] = true
+	}
+
+	versionPrefix := "/api/" + version + "/"
+	var aliases []PythonRoute
+	for _, route := range routes {
+		if route.APIVersion != version || !strings.HasPrefix(route.Route, versionPrefix) {
+			continue
+		}
+
+		aliasPath := "/api/" + strings.TrimPrefix(route.Route, versionPrefix)
+		if taken[route.Method+" "+aliasPath] {
+			log.Printf("WARNING: Not aliasing %s to %s, an unversioned handler already serves it", route.Route, aliasPath)
+			continue
+		}
+		taken[route.Method+" "+aliasPath] = true
+
+		alias := route
+		alias.Route = aliasPath
+		alias.AliasOf = route.Route
+		alias.Metadata = make(map[string]interface{}, len(route.Metadata)+1)
+		for k, v := range route.Metadata {
+			alias.Metadata[k] = v
+		}
+		alias.Metadata["alias_of"] = route.Route
+		aliases = append(aliases, alias)
+	}
+	return aliases
+}
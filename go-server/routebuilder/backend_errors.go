@@ -0,0 +1,137 @@
+package routebuilder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// maxErrorDetail bounds how much of a backend error body is read for the
+// fragment replacing it
+const maxErrorDetail = 16 << 10
+
+// mapBackendError replaces a 4xx or 5xx response from functionName with an
+// error fragment. HTML a handler returns for a client error, e.g. a form
+// with validation messages, passes through, as does anything sent to
+// clients asking for JSON.
+func mapBackendError(resp *http.Response, functionName string) {
+	if resp.StatusCode < 400 {
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if resp.StatusCode < 500 && mediaType == "text/html" {
+		return
+	}
+	if wantsJSON(resp.Request) && (resp.StatusCode < 500 || !errorsAreGeneric()) {
+		return
+	}
+
+	var body []byte
+	if resp.Header.Get("Content-Encoding") == "" {
+		body, _ = io.ReadAll(io.LimitReader(resp.Body, maxErrorDetail))
+	}
+	resp.Body.Close()
+	message, detail := describeBackendError(mediaType, body)
+	if resp.StatusCode >= 500 {
+		// Server errors may leak internals, so their text is only detail
+		log.Printf("ERROR: %s returned %d: %s", functionName, resp.StatusCode, errorSummary(detail))
+	}
+
+	if wantsJSON(resp.Request) {
+		// API clients get the status without the traceback
+		replaceBody(resp, "application/json", fmt.Sprintf(`{"detail": %q}`, http.StatusText(resp.StatusCode)))
+		return
+	}
+
+	page := ErrorPage{Status: resp.StatusCode, Title: http.StatusText(resp.StatusCode), Detail: detail}
+	if resp.StatusCode >= 500 {
+		page.Message = fmt.Sprintf("%s failed, please try again", functionName)
+	} else {
+		// Handlers raise client errors with a message meant for the user
+		page.Message = message
+		if page.Message == "" {
+			page.Message = "The request could not be completed"
+		}
+	}
+
+	replaceBody(resp, "text/html; charset=utf-8", RenderErrorPage(page))
+}
+
+// replaceBody swaps a response's body for content of contentType
+func replaceBody(resp *http.Response, contentType, content string) {
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("ETag")
+	resp.Header.Set("Content-Type", contentType)
+	resp.Header.Set("Content-Length", strconv.Itoa(len(content)))
+	resp.Body = io.NopCloser(strings.NewReader(content))
+	resp.ContentLength = int64(len(content))
+}
+
+// describeBackendError pulls a short message and the full detail out of an
+// error body: FastAPI's {"detail": ...}, plain text such as a traceback, or
+// the text of an HTML error page
+func describeBackendError(mediaType string, body []byte) (message, detail string) {
+	body = bytes.TrimSpace(body)
+	switch {
+	case len(body) == 0:
+		return "", ""
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		var payload struct {
+			Detail json.RawMessage `json:"detail"`
+		}
+		if json.Unmarshal(body, &payload) == nil && json.Unmarshal(payload.Detail, &message) == nil {
+			return message, ""
+		}
+		var indented bytes.Buffer
+		if json.Indent(&indented, body, "", "  ") == nil {
+			return "", indented.String()
+		}
+		return "", string(body)
+	case mediaType == "text/html":
+		return "", htmlText(body)
+	}
+	return "", string(body)
+}
+
+// htmlText returns the visible text of an HTML document, one line per block
+func htmlText(body []byte) string {
+	var lines []string
+	skip := 0
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return strings.Join(lines, "\n")
+		case html.StartTagToken:
+			if name, _ := z.TagName(); string(name) == "script" || string(name) == "style" {
+				skip++
+			}
+		case html.EndTagToken:
+			if name, _ := z.TagName(); (string(name) == "script" || string(name) == "style") && skip > 0 {
+				skip--
+			}
+		case html.TextToken:
+			if text := strings.TrimSpace(string(z.Text())); text != "" && skip == 0 {
+				lines = append(lines, text)
+			}
+		}
+	}
+}
+
+// errorSummary returns the last line of a traceback, which names the
+// exception, or the first line of any other text
+func errorSummary(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	if strings.HasPrefix(lines[0], "Traceback") {
+		return strings.TrimSpace(lines[len(lines)-1])
+	}
+	return lines[0]
+}
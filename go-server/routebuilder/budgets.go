@@ -0,0 +1,141 @@
+package routebuilder
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+
+	"htmlnojs/config"
+)
+
+// BudgetResult is one route measured against a transfer budget
+type BudgetResult struct {
+	Budget config.BudgetConfig
+	Route  string // Empty when the budget matched no route
+	Limit  int64
+	Bytes  int64
+	Err    error // Set when the route couldn't be rendered
+}
+
+// OK reports whether the route rendered within its budget
+func (b BudgetResult) OK() bool {
+	return b.Err == nil && b.Bytes <= b.Limit
+}
+
+func (b BudgetResult) String() string {
+	what := "response"
+	if b.Budget.Page {
+		what = "page"
+	}
+	switch {
+	case b.Route == "":
+		return fmt.Sprintf("SKIP %s: no page, stylesheet or font matches this budget", b.Budget.Route)
+	case b.Err != nil:
+		return fmt.Sprintf("FAIL %s: %v", b.Route, b.Err)
+	case !b.OK():
+		return fmt.Sprintf("OVER %s: %s %s, budget %s", b.Route, formatKB(b.Bytes), what, b.Budget.Max)
+	default:
+		return fmt.Sprintf("OK   %s: %s %s, budget %s", b.Route, formatKB(b.Bytes), what, b.Budget.Max)
+	}
+}
+
+func formatKB(n int64) string {
+	return fmt.Sprintf("%.1fKB (%d bytes)", float64(n)/1024, n)
+}
+
+// CheckBudgets renders the pages, stylesheets and fonts matched by the
+// project's budgets and measures them. Handler routes need their backend
+// running, so budgets only cover what the Go server produces itself.
+func CheckBudgets(budgets []config.BudgetConfig, routes *RouteCollection) ([]BudgetResult, error) {
+	cssByFile := make(map[string]CSSRoute, len(routes.CSSRoutes))
+	for _, route := range routes.CSSRoutes {
+		cssByFile[route.FilePath] = route
+	}
+	fontByRoute := make(map[string]FontRoute, len(routes.FontRoutes))
+	for _, route := range routes.FontRoutes {
+		fontByRoute[route.Route] = route
+	}
+
+	var results []BudgetResult
+	for i, budget := range budgets {
+		limit, err := parseByteSize(budget.Max)
+		if err != nil {
+			return nil, fmt.Errorf("budgets[%d].max: %w", i, err)
+		}
+		matches := func(route string) bool {
+			ok, _ := path.Match(budget.Route, route)
+			return ok || budget.Route == route
+		}
+
+		matched := false
+		measure := func(route string, bytes int64, err error) {
+			matched = true
+			results = append(results, BudgetResult{Budget: budget, Route: route, Limit: limit, Bytes: bytes, Err: err})
+		}
+
+		for _, route := range routes.HTMLRoutes {
+			if !matches(route.Route) {
+				continue
+			}
+			bytes, err := renderedSize(route.Route, route.Handler)
+			if err == nil && budget.Page {
+				// Stylesheets and the fonts they load come with the page
+				seenFonts := map[string]bool{}
+				for _, cssFile := range route.CSSFiles {
+					css, ok := cssByFile[cssFile]
+					if !ok {
+						continue
+					}
+					n, cssErr := renderedSize(css.Route, css.Handler)
+					bytes += n
+					err = firstError(err, cssErr)
+					for _, fontRoute := range css.Fonts {
+						if font, ok := fontByRoute[fontRoute]; ok && !seenFonts[fontRoute] {
+							seenFonts[fontRoute] = true
+							n, fontErr := renderedSize(font.Route, font.Handler)
+							bytes += n
+							err = firstError(err, fontErr)
+						}
+					}
+				}
+			}
+			measure(route.Route, bytes, err)
+		}
+		for _, route := range routes.CSSRoutes {
+			if matches(route.Route) {
+				bytes, err := renderedSize(route.Route, route.Handler)
+				measure(route.Route, bytes, err)
+			}
+		}
+		for _, route := range routes.FontRoutes {
+			if matches(route.Route) {
+				bytes, err := renderedSize(route.Route, route.Handler)
+				measure(route.Route, bytes, err)
+			}
+		}
+
+		if !matched {
+			results = append(results, BudgetResult{Budget: budget, Limit: limit})
+		}
+	}
+	return results, nil
+}
+
+// renderedSize runs handler for a plain GET of route and returns the
+// size of the body it sends
+func renderedSize(route string, handler http.HandlerFunc) (int64, error) {
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, route, nil))
+	if recorder.Code >= 400 {
+		return 0, fmt.Errorf("%s responded %d", route, recorder.Code)
+	}
+	return int64(recorder.Body.Len()), nil
+}
+
+func firstError(err, next error) error {
+	if err != nil {
+		return err
+	}
+	return next
+}
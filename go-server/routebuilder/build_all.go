@@ -3,21 +3,28 @@ package routebuilder
 import (
 	"fmt"
 	"log"
+	"net/http"
 	"sort"
 	"strings"
+
+	"htmlnojs/config"
 )
 
 type RouteCollection struct {
-	HTMLRoutes   []HTMLRoute
-	CSSRoutes    []CSSRoute
-	PythonRoutes []PythonRoute
-	Metadata     RouteMetadata
+	HTMLRoutes      []HTMLRoute
+	CSSRoutes       []CSSRoute
+	FontRoutes      []FontRoute
+	PythonRoutes    []PythonRoute
+	WebSocketRoutes []WebSocketRoute
+	GatewayRoutes   []GatewayRoute
+	Metadata        RouteMetadata
 }
 
 type RouteMetadata struct {
 	TotalRoutes    int
 	HTMLCount      int
 	CSSCount       int
+	FontCount      int
 	PythonCount    int
 	AuthRequired   int
 	CacheEnabled   int
@@ -31,6 +38,15 @@ type AllRoutesBuilder struct {
 	cssDir       string
 	pyHTMXDir    string
 	fastAPIPort  int
+	project      *config.ProjectConfig
+	status       BackendStatus
+	worker       http.RoundTripper
+	picker       BackendPicker
+	har          *HARRecorder
+	failures     *FailedRequests
+	handlers     []handlerSource
+	fontsDir     string
+	fontFiles    []string
 	Collection   RouteCollection
 }
 
@@ -41,6 +57,7 @@ func NewAllRoutesBuilder(templatesDir, cssDir, pyHTMXDir string, fastAPIPort int
         cssDir:       cssDir,
         pyHTMXDir:    pyHTMXDir,
         fastAPIPort:  fastAPIPort,
+        project:      config.Default(),
         Collection: RouteCollection {
             HTMLRoutes:   []HTMLRoute{},
             CSSRoutes:    []CSSRoute{},
@@ -52,18 +69,98 @@ func NewAllRoutesBuilder(templatesDir, cssDir, pyHTMXDir string, fastAPIPort int
     }
 }
 
+// SetProjectConfig applies project-level settings from htmlnojs.json
+func (a *AllRoutesBuilder) SetProjectConfig(project *config.ProjectConfig) {
+	a.project = project
+}
+
+// BackendStatus reports whether the backend serving a handler language is
+// up and, if not, why. It runs on every proxied request, so it should
+// return known state rather than probe the backend.
+type BackendStatus func(language string) (ok bool, reason string)
+
+// SetBackendStatus makes proxied routes answer right away while status
+// reports their backend down, instead of waiting for the dial to fail
+func (a *AllRoutesBuilder) SetBackendStatus(status BackendStatus) {
+	a.status = status
+}
+
+// SetPythonWorker sends py_htmx/ requests to worker, e.g. a StdioWorker,
+// instead of the FastAPI backend
+func (a *AllRoutesBuilder) SetPythonWorker(worker http.RoundTripper) {
+	a.worker = worker
+}
+
+// SetHARRecorder lets har capture the traffic of proxied routes
+func (a *AllRoutesBuilder) SetHARRecorder(har *HARRecorder) {
+	a.har = har
+}
+
+// SetFailedRequests keeps proxied requests that fail in failures, so they
+// can be replayed
+func (a *AllRoutesBuilder) SetFailedRequests(failures *FailedRequests) {
+	a.failures = failures
+}
+
+// observed wraps a proxy transport in failure tracking, the proxy log and
+// HAR capture, as far as they're set up. Headers named in secretHeaders
+// are masked in the log and capture.
+func (a *AllRoutesBuilder) observed(transport http.RoundTripper, secretHeaders ...string) http.RoundTripper {
+	if a.failures != nil && transport != nil {
+		transport = &failureTransport{base: transport, failures: a.failures}
+	}
+	if a.har != nil && transport != nil {
+		transport = &harTransport{base: transport, recorder: a.har, secrets: secretHeaders}
+	}
+	return newLoggingTransport(transport, a.project.ProxyLog, secretHeaders...)
+}
+
+// AddHandlers serves the handlers of another language, e.g. node_htmx/
+// files through a Node backend on port
+func (a *AllRoutesBuilder) AddHandlers(language, dir string, port int, files []string) error {
+	adapter, err := GetHandlerAdapter(language)
+	if err != nil {
+		return err
+	}
+	if language == DefaultHandlerLanguage {
+		return fmt.Errorf("%s handlers are always served from %s", language, a.pyHTMXDir)
+	}
+	a.handlers = append(a.handlers, handlerSource{adapter: adapter, dir: dir, port: port, files: files})
+	return nil
+}
+
+// AddFonts serves the font files of dir at /fonts/
+func (a *AllRoutesBuilder) AddFonts(dir string, files []string) {
+	a.fontsDir = dir
+	a.fontFiles = files
+}
+
 // BuildAllRoutes orchestrates building all route types
 func (a *AllRoutesBuilder) BuildAllRoutes(htmlFiles, cssFiles, pythonFiles []string) (*RouteCollection, error) {
 	log.Printf("=== Building All Routes ===")
 
-	// Step 1: Build CSS routes first (needed for HTML dependencies)
+	// Step 1: Build font routes (stylesheets reference them) and CSS routes
+	// (needed for HTML dependencies)
+	if err := a.buildFontRoutes(htmlFiles); err != nil {
+		return nil, fmt.Errorf("failed to build font routes: %w", err)
+	}
 	if err := a.buildCSSRoutes(cssFiles); err != nil {
 		return nil, fmt.Errorf("failed to build CSS routes: %w", err)
 	}
 
-	// Step 2: Build Python routes (needed for API endpoint mapping)
+	// Step 2: Build handler routes of every language (needed for API endpoint mapping)
 	if err := a.buildPythonRoutes(pythonFiles); err != nil {
-		return nil, fmt.Errorf("failed to build Python routes: %w", err)
+		return nil, fmt.Errorf("failed to build handler routes: %w", err)
+	}
+
+	// Configured WebSocket endpoints tunnel to their own backends
+	if err := a.buildWebSocketRoutes(); err != nil {
+		return nil, fmt.Errorf("failed to build WebSocket routes: %w", err)
+	}
+
+	// Configured third-party APIs pass through under their own paths
+	if err := a.buildGatewayRoutes(); err != nil {
+		return nil, fmt.Errorf("failed to build gateway routes: %w", err)
 	}
 
 	// Step 3: Build HTML routes (can reference CSS and Python routes)
@@ -77,6 +174,7 @@ func (a *AllRoutesBuilder) BuildAllRoutes(htmlFiles, cssFiles, pythonFiles []str
 	}
 
 	// Step 5: Generate metadata
+	a.assignOwners()
 	a.generateMetadata()
 
 	// Step 6: Log summary
@@ -89,6 +187,8 @@ func (a *AllRoutesBuilder) buildCSSRoutes(cssFiles []string) error {
 	log.Printf("Building CSS routes from %d files...", len(cssFiles))
 
 	cssBuilder := NewCSSRouteBuilder(a.cssDir)
+	cssBuilder.SetScopeComponents(a.project.CSS.ScopeComponents)
+	cssBuilder.SetFonts(a.Collection.FontRoutes, a.project.Fonts.Display)
 	routes, err := cssBuilder.BuildRoutes(cssFiles)
 	if err != nil {
 		return err
@@ -99,18 +199,158 @@ func (a *AllRoutesBuilder) buildCSSRoutes(cssFiles []string) error {
 	return nil
 }
 
+func (a *AllRoutesBuilder) buildFontRoutes(htmlFiles []string) error {
+	if len(a.fontFiles) == 0 {
+		return nil
+	}
+	log.Printf("Building font routes from %d files...", len(a.fontFiles))
+
+	fontBuilder := NewFontRouteBuilder(a.fontsDir)
+	if a.project.Fonts.Subset {
+		fontBuilder.SetSubsetTemplates(htmlFiles)
+	}
+	routes, err := fontBuilder.BuildRoutes(a.fontFiles)
+	if err != nil {
+		return err
+	}
+
+	a.Collection.FontRoutes = routes
+	log.Printf("Built %d font routes", len(routes))
+	return nil
+}
+
 func (a *AllRoutesBuilder) buildPythonRoutes(pythonFiles []string) error {
-	log.Printf("Building Python routes from %d files...", len(pythonFiles))
+	python, err := GetHandlerAdapter(DefaultHandlerLanguage)
+	if err != nil {
+		return err
+	}
+	sources := append([]handlerSource{{adapter: python, dir: a.pyHTMXDir, port: a.fastAPIPort, files: pythonFiles}}, a.handlers...)
 
-	pythonBuilder := NewPythonRouteBuilder(a.pyHTMXDir)
-	pythonBuilder.SetFastAPIServer("localhost", a.fastAPIPort)
-	routes, err := pythonBuilder.BuildRoutes(pythonFiles)
+	transport, err := ProxyTransport(a.project.Proxy)
 	if err != nil {
 		return err
 	}
 
+	warn := a.project.DuplicateRoutes == "warn"
+	var routes []PythonRoute
+	for _, source := range sources {
+		var files []string
+		for _, file := range source.files {
+			if source.adapter.Match(file) {
+				files = append(files, file)
+			}
+		}
+		log.Printf("Building %s routes from %d files...", source.adapter.Name(), len(files))
+
+		var healthy func() (bool, string)
+		if a.status != nil {
+			language := source.adapter.Name()
+			healthy = func() (bool, string) { return a.status(language) }
+		}
+		opts := HandlerAdapterOptions{
+			Dir:              source.dir,
+			BackendHost:      "localhost",
+			BackendPort:      source.port,
+			WarnOnDuplicates: warn,
+			Transport:        transport,
+			Healthy:          healthy,
+			HostHeader:       a.project.Proxy.Host,
+			TimeoutProfiles:  a.project.Timeouts,
+			RequestEncoding:  a.project.RequestEncoding,
+		}
+		if source.adapter.Name() == DefaultHandlerLanguage {
+			opts.BackendURL = BackendURL(a.project.Proxy, source.port)
+			if a.worker != nil {
+				opts.Transport = a.worker
+			} else if a.picker != nil {
+				opts.Transport = &pickerTransport{base: transport, picker: a.picker}
+			}
+			opts.DirBackends = a.project.DirectoryBackends
+			opts.DirTransport = a.observed(transport)
+		}
+		opts.Transport = a.observed(opts.Transport)
+		// The canary runs another version of py_htmx/
+		if source.adapter.Name() == DefaultHandlerLanguage && a.project.Canary.URL != "" {
+			opts.Canary = a.project.Canary
+			log.Printf("Sending %d%% of %s traffic to canary %s", a.project.Canary.Percent, source.adapter.Name(), a.project.Canary.URL)
+		}
+		built, err := source.adapter.BuildRoutes(opts, files)
+		if err != nil {
+			return fmt.Errorf("%s handlers: %w", source.adapter.Name(), err)
+		}
+		for i := range built {
+			built[i].Language = source.adapter.Name()
+			if built[i].Metadata == nil {
+				built[i].Metadata = map[string]interface{}{}
+			}
+			built[i].Metadata["language"] = source.adapter.Name()
+		}
+		routes = append(routes, built...)
+	}
+
+	// Two languages may still claim the same URL
+	routes, err = dedupeRoutes(routes, warn)
+	if err != nil {
+		return err
+	}
+
+	// Serve the default API version without its /vN segment
+	if aliases := defaultVersionAliases(routes, a.project.API.DefaultVersion); len(aliases) > 0 {
+		log.Printf("Aliased %d %s routes as the default API version", len(aliases), NormalizeAPIVersion(a.project.API.DefaultVersion))
+		routes = append(routes, aliases...)
+	}
+
+	a.applyHeaderRules(routes)
+	if err := a.applyJSONFragments(routes); err != nil {
+		return err
+	}
+	if err := a.applyTransforms(routes); err != nil {
+		return err
+	}
+	a.applyFragmentPages(routes)
+	a.applyInFlightLimit(routes)
 	a.Collection.PythonRoutes = routes
-	log.Printf("Built %d Python routes", len(routes))
+	log.Printf("Built %d handler routes", len(routes))
+	return nil
+}
+
+func (a *AllRoutesBuilder) buildWebSocketRoutes() error {
+	if len(a.project.WebSockets) == 0 {
+		return nil
+	}
+	transport, err := ProxyTransport(a.project.Proxy)
+	if err != nil {
+		return err
+	}
+	routes, err := BuildWebSocketRoutes(a.project.WebSockets, transport)
+	if err != nil {
+		return err
+	}
+
+	a.Collection.WebSocketRoutes = routes
+	log.Printf("Built %d WebSocket routes", len(routes))
+	return nil
+}
+
+func (a *AllRoutesBuilder) buildGatewayRoutes() error {
+	if len(a.project.Gateway) == 0 {
+		return nil
+	}
+	// The headers a gateway adds hold its API keys
+	var secrets []string
+	for _, api := range a.project.Gateway {
+		for name := range api.Headers {
+			secrets = append(secrets, name)
+		}
+	}
+	transport := a.observed(gatewayTransport, secrets...)
+	routes, err := BuildGatewayRoutes(a.project.Gateway, transport)
+	if err != nil {
+		return err
+	}
+
+	a.Collection.GatewayRoutes = routes
+	log.Printf("Built %d gateway routes", len(routes))
 	return nil
 }
 
@@ -123,7 +363,31 @@ func (a *AllRoutesBuilder) buildHTMLRoutes(htmlFiles []string) error {
 		cssFilePaths[i] = route.FilePath
 	}
 
+	engine, err := NewTemplateEngine(a.project.TemplateEngine, a.templatesDir)
+	if err != nil {
+		return err
+	}
+	log.Printf("Using %s template engine", engine.Name())
+
+	// Catch wrong-engine syntax and front-matter typos before they silently misbehave
+	logLintIssues(LintTemplates(a.project, htmlFiles))
+
 	htmlBuilder := NewHTMLRouteBuilder(a.templatesDir, cssFilePaths)
+	htmlBuilder.SetTemplateEngine(engine)
+	htmlBuilder.SetCSSRoutes(a.Collection.CSSRoutes)
+	if a.project.HTMX.FullPages {
+		htmlBuilder.SetFullPages()
+	}
+	if a.project.Fonts.Preload {
+		htmlBuilder.SetFontPreloads(a.Collection.FontRoutes)
+	}
+	if a.project.CSS.Critical {
+		elements := a.project.CSS.CriticalElements
+		if elements == 0 {
+			elements = DefaultCriticalElements
+		}
+		htmlBuilder.SetCriticalCSS(elements)
+	}
 	routes, err := htmlBuilder.BuildRoutes(htmlFiles)
 	if err != nil {
 		return err
@@ -215,8 +479,9 @@ func (a *AllRoutesBuilder) generateMetadata() {
 	// Count totals
 	meta.HTMLCount = len(a.Collection.HTMLRoutes)
 	meta.CSSCount = len(a.Collection.CSSRoutes)
+	meta.FontCount = len(a.Collection.FontRoutes)
 	meta.PythonCount = len(a.Collection.PythonRoutes)
-	meta.TotalRoutes = meta.HTMLCount + meta.CSSCount + meta.PythonCount
+	meta.TotalRoutes = meta.HTMLCount + meta.CSSCount + meta.FontCount + meta.PythonCount
 
 	// Count auth-required routes
 	for _, route := range a.Collection.HTMLRoutes {
@@ -260,6 +525,7 @@ func (a *AllRoutesBuilder) logBuildSummary() {
 	log.Printf("Total Routes: %d", meta.TotalRoutes)
 	log.Printf("  - HTML Routes: %d", meta.HTMLCount)
 	log.Printf("  - CSS Routes: %d", meta.CSSCount)
+	log.Printf("  - Font Routes: %d", meta.FontCount)
 	log.Printf("  - Python Routes: %d", meta.PythonCount)
 	log.Printf("Authentication Required: %d routes", meta.AuthRequired)
 	log.Printf("Cache Enabled: %d routes", meta.CacheEnabled)
@@ -388,6 +654,21 @@ func (a *AllRoutesBuilder) GenerateRouteMap() string {
 		if route.CacheTimeout > 0 {
 			cache = fmt.Sprintf(" [CACHE:%ds]", route.CacheTimeout)
 		}
+		if route.Timeout > 0 {
+			cache += fmt.Sprintf(" [TIMEOUT:%ds]", route.Timeout)
+		}
+		if route.MaxBody > 0 {
+			cache += fmt.Sprintf(" [MAX_BODY:%s]", formatByteSize(route.MaxBody))
+		}
+		if route.MaxFile > 0 {
+			cache += fmt.Sprintf(" [MAX_FILE:%s]", formatByteSize(route.MaxFile))
+		}
+		if route.Deprecation != nil {
+			cache += fmt.Sprintf(" [%s]", route.Deprecation)
+		}
+		if route.AliasOf != "" {
+			cache += fmt.Sprintf(" [ALIAS:%s]", route.AliasOf)
+		}
 		builder.WriteString(fmt.Sprintf("  %s %s -> %s%s%s\n",
 			route.Method, route.Route, route.Function, auth, cache))
 	}
@@ -0,0 +1,91 @@
+package routebuilder
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+
+	"htmlnojs/config"
+)
+
+// canaryCookieAge is how long a browser stays on the backend it was sent to
+const canaryCookieAge = 24 * 60 * 60
+
+// canaryRequestKey marks requests sent to the canary backend
+type canaryRequestKey struct{}
+
+// canaryRouter decides which requests go to the canary backend
+type canaryRouter struct {
+	url     string
+	percent int
+	cookie  string
+	header  string
+}
+
+// newCanaryRouter returns nil when cfg has no canary backend
+func newCanaryRouter(cfg config.CanaryConfig) *canaryRouter {
+	if cfg.URL == "" {
+		return nil
+	}
+	return &canaryRouter{
+		url:     strings.TrimSuffix(cfg.URL, "/"),
+		percent: cfg.Percent,
+		cookie:  cfg.Cookie,
+		header:  cfg.Header,
+	}
+}
+
+// route picks the backend for r, pinning the browser to it with the cookie
+// when the choice was random, and returns r marked if it goes to the canary
+func (c *canaryRouter) route(w http.ResponseWriter, r *http.Request) *http.Request {
+	canary, forced := c.forced(r)
+	if !forced {
+		canary = rand.N(100) < c.percent
+		if c.cookie != "" {
+			value := "0"
+			if canary {
+				value = "1"
+			}
+			http.SetCookie(w, &http.Cookie{
+				Name:     c.cookie,
+				Value:    value,
+				Path:     "/",
+				MaxAge:   canaryCookieAge,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+		}
+	}
+	if !canary {
+		return r
+	}
+	w.Header().Set("X-Backend-Canary", "1")
+	return r.WithContext(context.WithValue(r.Context(), canaryRequestKey{}, true))
+}
+
+// forced reports the backend r asks for with the header or cookie, if any
+func (c *canaryRouter) forced(r *http.Request) (canary, ok bool) {
+	value := ""
+	if c.header != "" {
+		value = r.Header.Get(c.header)
+	}
+	if value == "" && c.cookie != "" {
+		if cookie, err := r.Cookie(c.cookie); err == nil {
+			value = cookie.Value
+		}
+	}
+	switch value {
+	case "1":
+		return true, true
+	case "0":
+		return false, true
+	}
+	return false, false
+}
+
+// isCanary reports whether the request was routed to the canary backend
+func isCanary(ctx context.Context) bool {
+	canary, _ := ctx.Value(canaryRequestKey{}).(bool)
+	return canary
+}
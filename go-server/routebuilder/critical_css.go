@@ -0,0 +1,123 @@
+package routebuilder
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// DefaultCriticalElements is how many elements at the start of <body> are
+// treated as above the fold when a project doesn't choose a number
+const DefaultCriticalElements = 40
+
+// criticalCSS collects the rules of cssFiles that style the first elements
+// of a page, so they can be inlined while the full stylesheets load after
+// the content. Pages are analysed from their template source, so nothing
+// is rendered and no template function is called at build time.
+func criticalCSS(templateSource []byte, cssFiles []string, cssRoutes map[string]CSSRoute, elements int) string {
+	nodes := aboveTheFold(templateSource, elements)
+	if len(nodes) == 0 {
+		return ""
+	}
+
+	var critical strings.Builder
+	for _, cssFile := range cssFiles {
+		content, err := readProjectFile(cssFile)
+		if err != nil {
+			continue
+		}
+		rules := extractRules(string(content), func(selector string) bool {
+			return matchesAny(selector, nodes)
+		})
+		if rules == "" {
+			continue
+		}
+		if scope := cssRoutes[cssFile].Scope; scope != "" {
+			rules = string(ScopeCSS([]byte(rules), scope))
+		}
+		critical.WriteString(rules)
+	}
+	return critical.String()
+}
+
+// aboveTheFold returns <html>, <body> and the first elements inside body
+func aboveTheFold(source []byte, elements int) []*html.Node {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(string(source)))
+	if err != nil {
+		return nil
+	}
+
+	nodes := append(doc.Find("html").Nodes, doc.Find("body").Nodes...)
+	doc.Find("body *").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		if i >= elements {
+			return false
+		}
+		nodes = append(nodes, s.Nodes...)
+		return true
+	})
+	return nodes
+}
+
+// statePseudoRegex matches pseudo-classes and pseudo-elements that don't
+// change which elements a rule can style on first render
+var statePseudoRegex = regexp.MustCompile(`(?i)::?(before|after|first-line|first-letter|placeholder|marker|selection|hover|focus|focus-within|focus-visible|active|visited|link|target)\b`)
+
+// matchesAny reports whether any selector of a list styles one of nodes
+func matchesAny(selectorList string, nodes []*html.Node) bool {
+	for _, selector := range splitTopLevel(selectorList, ',') {
+		selector = strings.TrimSpace(statePseudoRegex.ReplaceAllString(selector, ""))
+		if selector == "" || selector == ":root" || selector == "*" {
+			return true
+		}
+		compiled, err := cascadia.Compile(selector)
+		if err != nil {
+			continue
+		}
+		for _, node := range nodes {
+			if compiled.Match(node) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// extractRules returns the style rules of css whose selector list keep
+// accepts, with @media, @supports and @layer blocks kept around them.
+// @font-face and @import are left to the full stylesheet.
+func extractRules(css string, keep func(selector string) bool) string {
+	var out strings.Builder
+	for len(css) > 0 {
+		css = css[skipSpaceAndComments(css):]
+		if css == "" {
+			break
+		}
+
+		end := scanUntil(css, "{;}")
+		if end == len(css) || css[end] != '{' {
+			css = css[min(end+1, len(css)):]
+			continue
+		}
+
+		prelude := strings.TrimSpace(css[:end])
+		closing := matchingBrace(css, end)
+		body := css[end+1 : closing]
+		css = css[min(closing+1, len(css)):]
+
+		switch {
+		case strings.HasPrefix(prelude, "@"):
+			if !scopesNested(prelude) {
+				continue
+			}
+			if nested := extractRules(body, keep); nested != "" {
+				out.WriteString(prelude + "{" + nested + "}")
+			}
+		case keep(prelude):
+			out.WriteString(prelude + "{" + strings.TrimSpace(body) + "}")
+		}
+	}
+	return out.String()
+}
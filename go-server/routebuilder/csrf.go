@@ -0,0 +1,66 @@
+package routebuilder
+
+import (
+	"context"
+	"html"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// CSRF token names: htmx sends the token in CSRFHeader, plain forms in the
+// CSRFField form field
+const (
+	CSRFHeader = "X-CSRF-Token"
+	CSRFField  = "csrf_token"
+)
+
+type csrfTokenKey struct{}
+
+// WithCSRFToken gives the pages rendered for a request the token that
+// state-changing requests from them must carry
+func WithCSRFToken(ctx context.Context, token string) context.Context {
+	return context.WithValue(ctx, csrfTokenKey{}, token)
+}
+
+// csrfToken returns the request's CSRF token, or "" when CSRF protection
+// is off
+func csrfToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfTokenKey{}).(string)
+	return token
+}
+
+var (
+	formOpenRegex = regexp.MustCompile(`(?i)<form\b[^>]*>`)
+	formGetRegex  = regexp.MustCompile(`(?i)\bmethod\s*=\s*["']?get\b`)
+)
+
+// injectCSRFToken adds token to a rendered page: a hidden field in each
+// form that doesn't submit with GET, and, on full pages, a csrf-token meta
+// tag and hx-headers on <body> so every htmx request sends it
+func injectCSRFToken(page, token string) string {
+	if token == "" {
+		return page
+	}
+	escaped := html.EscapeString(token)
+
+	page = formOpenRegex.ReplaceAllStringFunc(page, func(form string) string {
+		if formGetRegex.MatchString(form) {
+			return form
+		}
+		return form + `<input type="hidden" name="` + CSRFField + `" value="` + escaped + `">`
+	})
+
+	if strings.Contains(page, "<head>") {
+		page = strings.Replace(page, "<head>", `<head>`+"\n    "+`<meta name="csrf-token" content="`+escaped+`">`, 1)
+	}
+	if loc := bodyOpenRegex.FindStringIndex(page); loc != nil {
+		tag := page[loc[0]:loc[1]]
+		if !strings.Contains(strings.ToLower(tag), "hx-headers") {
+			headers := `{"` + CSRFHeader + `": "` + escaped + `"}`
+			tag = strings.TrimSuffix(tag, ">") + ` hx-headers='` + headers + `'>`
+			page = page[:loc[0]] + tag + page[loc[1]:]
+		}
+	}
+	return page
+}
@@ -20,12 +20,17 @@ type CSSRoute struct {
 	Minified     bool
 	Dependencies []string
 	MediaQuery   string
+	Scope        string   // Component name the rules are scoped to, see ScopeCSS
+	Fonts        []string // Routes of the fonts/ files the stylesheet loads
 	Metadata     map[string]interface{}
 }
 
 type CSSRouteBuilder struct {
-	cssDir string
-	routes []CSSRoute
+	cssDir          string
+	routes          []CSSRoute
+	scopeComponents bool
+	fonts           map[string]FontRoute // By route
+	fontDisplay     string
 }
 
 // NewCSSRouteBuilder creates a new CSS route builder
@@ -36,6 +41,22 @@ func NewCSSRouteBuilder(cssDir string) *CSSRouteBuilder {
 	}
 }
 
+// SetScopeComponents scopes the rules of css/components/ files to the
+// templates that include them
+func (c *CSSRouteBuilder) SetScopeComponents(scope bool) {
+	c.scopeComponents = scope
+}
+
+// SetFonts records which fonts stylesheets load and the font-display
+// added to their @font-face rules
+func (c *CSSRouteBuilder) SetFonts(fonts []FontRoute, display string) {
+	c.fonts = make(map[string]FontRoute, len(fonts))
+	for _, font := range fonts {
+		c.fonts[font.Route] = font
+	}
+	c.fontDisplay = display
+}
+
 // BuildRoutes discovers and builds CSS file routes
 func (c *CSSRouteBuilder) BuildRoutes(cssFiles []string) ([]CSSRoute, error) {
 	for _, filePath := range cssFiles {
@@ -66,6 +87,16 @@ func (c *CSSRouteBuilder) buildCSSRoute(filePath string) (CSSRoute, error) {
 
 	// Determine category and load order
 	category := c.categorizeCSS(name)
+
+	// css/components/ files are components whatever their name
+	scope := ""
+	if c.isComponentFile(filePath) {
+		routePath = "/css/" + ComponentsCSSDir + "/" + filename
+		category = "component"
+		if c.scopeComponents {
+			scope = name
+		}
+	}
 	loadOrder := c.determineLoadOrder(name, category)
 
 	// Check if minified
@@ -81,24 +112,42 @@ func (c *CSSRouteBuilder) buildCSSRoute(filePath string) (CSSRoute, error) {
 		"file_size": c.getFileSize(filePath),
 		"category":  category,
 	}
+	if scope != "" {
+		metadata["scope"] = scope
+	}
+
+	var fonts []string
+	if content, err := readProjectFile(filePath); err == nil {
+		fonts = referencedFonts(content, c.fonts)
+	}
+	if len(fonts) > 0 {
+		metadata["fonts"] = fonts
+	}
 
 	route := CSSRoute{
 		Name:         name,
 		FilePath:     filePath,
 		Route:        routePath,
 		Method:       "GET",
-		Handler:      c.createCSSHandler(filePath),
+		Handler:      c.createCSSHandler(filePath, scope),
 		Category:     category,
 		LoadOrder:    loadOrder,
 		Minified:     isMinified,
 		Dependencies: dependencies,
 		MediaQuery:   mediaQuery,
+		Scope:        scope,
+		Fonts:        fonts,
 		Metadata:     metadata,
 	}
 
 	return route, nil
 }
 
+// isComponentFile reports whether filePath is directly inside css/components/
+func (c *CSSRouteBuilder) isComponentFile(filePath string) bool {
+	return filepath.Clean(filepath.Dir(filePath)) == filepath.Join(c.cssDir, ComponentsCSSDir)
+}
+
 func (c *CSSRouteBuilder) categorizeCSS(name string) string {
 	name = strings.ToLower(name)
 
@@ -196,24 +245,29 @@ func (c *CSSRouteBuilder) getFileSize(filePath string) int64 {
 	return 0
 }
 
-func (c *CSSRouteBuilder) createCSSHandler(cssPath string) http.HandlerFunc {
+func (c *CSSRouteBuilder) createCSSHandler(cssPath, scope string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Set CSS headers
 		w.Header().Set("Content-Type", "text/css")
 		w.Header().Set("Cache-Control", "public, max-age=31536000") // 1 year cache
 
 		// Read and serve CSS file
-		content, err := os.ReadFile(cssPath)
+		content, err := readProjectFile(cssPath)
 		if err != nil {
 			http.Error(w, "CSS file not found", http.StatusNotFound)
 			return
 		}
 
 		// Set Last-Modified header
-		if info, err := os.Stat(cssPath); err == nil {
+		if info, err := statProjectFile(cssPath); err == nil {
 			w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
 		}
 
+		if scope != "" {
+			content = ScopeCSS(content, scope)
+		}
+		content = InjectFontDisplay(content, c.fontDisplay)
+
 		w.Write(content)
 	}
 }
@@ -0,0 +1,258 @@
+package routebuilder
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// CSSScopeAttribute marks the root element of a template that includes
+// scoped component CSS. Its value lists the component names, so one root
+// can carry several scopes: data-css-scope="button card".
+const CSSScopeAttribute = "data-css-scope"
+
+// ComponentsCSSDir is the css/ subdirectory whose files can be scoped
+const ComponentsCSSDir = "components"
+
+// ScopeCSS rewrites every style rule in css to apply only at or below an
+// element carrying the scope, e.g.
+//
+//	.title:hover { ... }
+//	.title:hover:where([data-css-scope~="card"], [data-css-scope~="card"] *) { ... }
+//
+// :where() keeps the rules' original specificity. Rules inside @media,
+// @supports, @container and @layer blocks are scoped too; @keyframes,
+// @font-face and other at-rules are copied unchanged.
+func ScopeCSS(css []byte, scope string) []byte {
+	suffix := fmt.Sprintf(`:where([%s~="%s"], [%s~="%s"] *)`, CSSScopeAttribute, scope, CSSScopeAttribute, scope)
+	var out strings.Builder
+	scopeRules(&out, string(css), suffix)
+	return []byte(out.String())
+}
+
+// scopeRules copies a list of rules from src to out, scoping selectors
+func scopeRules(out *strings.Builder, src, suffix string) {
+	for len(src) > 0 {
+		// Copy whitespace and comments between rules as they are
+		i := skipSpaceAndComments(src)
+		out.WriteString(src[:i])
+		src = src[i:]
+		if src == "" {
+			return
+		}
+
+		end := scanUntil(src, "{;}")
+		if end == len(src) || src[end] != '{' {
+			// A statement such as @import or @charset, or a stray brace
+			if end < len(src) {
+				end++
+			}
+			out.WriteString(src[:end])
+			src = src[end:]
+			continue
+		}
+
+		prelude := src[:end]
+		closing := matchingBrace(src, end)
+		body := src[end+1 : closing]
+		src = src[min(closing+1, len(src)):]
+
+		if strings.HasPrefix(prelude, "@") {
+			out.WriteString(prelude)
+			out.WriteByte('{')
+			if scopesNested(prelude) {
+				scopeRules(out, body, suffix)
+			} else {
+				out.WriteString(body)
+			}
+			out.WriteByte('}')
+			continue
+		}
+
+		out.WriteString(scopeSelectorList(prelude, suffix))
+		out.WriteByte('{')
+		out.WriteString(body)
+		out.WriteByte('}')
+	}
+}
+
+// scopesNested reports whether an at-rule's block holds style rules
+func scopesNested(prelude string) bool {
+	match := atRuleNameRegex.FindStringSubmatch(prelude)
+	if match == nil {
+		return false
+	}
+	switch strings.ToLower(match[1]) {
+	case "media", "supports", "container", "layer", "document":
+		return true
+	}
+	return false
+}
+
+// scopeSelectorList scopes each selector of a comma-separated list,
+// keeping surrounding whitespace so the output stays readable
+func scopeSelectorList(prelude, suffix string) string {
+	trailing := prelude[len(strings.TrimRight(prelude, " \t\r\n")):]
+	selectors := splitTopLevel(strings.TrimRight(prelude, " \t\r\n"), ',')
+	for i, selector := range selectors {
+		leading := selector[:len(selector)-len(strings.TrimLeft(selector, " \t\r\n"))]
+		selectors[i] = leading + scopeSelector(strings.TrimSpace(selector), suffix)
+	}
+	return strings.Join(selectors, ",") + trailing
+}
+
+var (
+	atRuleNameRegex    = regexp.MustCompile(`^@([a-zA-Z-]+)`)
+	pseudoElementRegex = regexp.MustCompile(`(?i)^(::|:(before|after|first-line|first-letter)\b)`)
+)
+
+// scopeSelector appends suffix to the selector's subject, ahead of any
+// pseudo-element since nothing may follow one
+func scopeSelector(selector, suffix string) string {
+	if selector == "" {
+		return selector
+	}
+	depth := 0
+	for i := 0; i < len(selector); i++ {
+		switch selector[i] {
+		case '(', '[':
+			depth++
+		case ')', ']':
+			depth--
+		case ':':
+			if depth == 0 && pseudoElementRegex.MatchString(selector[i:]) {
+				return selector[:i] + suffix + selector[i:]
+			}
+		}
+	}
+	return selector + suffix
+}
+
+// splitTopLevel splits s at sep outside parentheses, brackets and strings
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\'':
+			i = skipString(s, i) - 1
+		case c == '(' || c == '[':
+			depth++
+		case c == ')' || c == ']':
+			depth--
+		case c == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// scanUntil returns the index of the first byte of stop outside strings
+// and comments, or len(s)
+func scanUntil(s, stop string) int {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"' || s[i] == '\'':
+			i = skipString(s, i) - 1
+		case strings.HasPrefix(s[i:], "/*"):
+			i = skipComment(s, i) - 1
+		case strings.IndexByte(stop, s[i]) >= 0:
+			return i
+		}
+	}
+	return len(s)
+}
+
+// matchingBrace returns the index of the brace closing the one at open
+func matchingBrace(s string, open int) int {
+	depth := 0
+	for i := open; i < len(s); i++ {
+		switch {
+		case s[i] == '"' || s[i] == '\'':
+			i = skipString(s, i) - 1
+		case strings.HasPrefix(s[i:], "/*"):
+			i = skipComment(s, i) - 1
+		case s[i] == '{':
+			depth++
+		case s[i] == '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(s)
+}
+
+// skipString returns the index just past the string literal starting at i
+func skipString(s string, i int) int {
+	quote := s[i]
+	for i++; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// skipComment returns the index just past the comment starting at i
+func skipComment(s string, i int) int {
+	if end := strings.Index(s[i+2:], "*/"); end >= 0 {
+		return i + 2 + end + 2
+	}
+	return len(s)
+}
+
+// skipSpaceAndComments returns the length of the leading whitespace and
+// comments in s
+func skipSpaceAndComments(s string) int {
+	i := 0
+	for i < len(s) {
+		switch {
+		case s[i] == ' ' || s[i] == '\t' || s[i] == '\n' || s[i] == '\r':
+			i++
+		case strings.HasPrefix(s[i:], "/*"):
+			i = skipComment(s, i)
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+var (
+	bodyTagRegex         = regexp.MustCompile(`(?i)<body\b`)
+	htmlTagRegex         = regexp.MustCompile(`(?i)<html\b`)
+	firstElementTagRegex = regexp.MustCompile(`<([a-zA-Z][a-zA-Z0-9-]*)\b`)
+)
+
+// addScopeAttribute marks the root element of rendered HTML with scopes:
+// <body> for full documents, otherwise the first element of a fragment.
+// A root that already declares the attribute is left to the author.
+func addScopeAttribute(html string, scopes []string) string {
+	if len(scopes) == 0 {
+		return html
+	}
+
+	loc := bodyTagRegex.FindStringIndex(html)
+	if loc == nil {
+		loc = htmlTagRegex.FindStringIndex(html)
+	}
+	if loc == nil {
+		loc = firstElementTagRegex.FindStringIndex(html)
+	}
+	if loc == nil {
+		return html
+	}
+
+	tagEnd := strings.IndexByte(html[loc[1]:], '>')
+	if tagEnd >= 0 && strings.Contains(html[loc[1]:loc[1]+tagEnd], CSSScopeAttribute+"=") {
+		return html
+	}
+	attribute := fmt.Sprintf(` %s="%s"`, CSSScopeAttribute, strings.Join(scopes, " "))
+	return html[:loc[1]] + attribute + html[loc[1]:]
+}
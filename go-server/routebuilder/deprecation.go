@@ -0,0 +1,70 @@
+package routebuilder
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Deprecation describes a handler marked with @deprecated
+type Deprecation struct {
+	Message   string    // Migration hint, e.g. "use /api/v2/orders"
+	Sunset    time.Time // When the route goes away, zero if not announced
+	Successor string    // Replacement path found in the message
+}
+
+// String renders the deprecation for route listings
+func (d *Deprecation) String() string {
+	parts := []string{"DEPRECATED"}
+	if !d.Sunset.IsZero() {
+		parts = append(parts, "sunset "+d.Sunset.Format("2006-01-02"))
+	}
+	if d.Message != "" {
+		parts = append(parts, d.Message)
+	}
+	return strings.Join(parts, ": ")
+}
+
+var (
+	deprecatedRegex = regexp.MustCompile(`@deprecated(?:\(\s*(?:"([^"]*)"|'([^']*)')?\s*(?:,?\s*sunset\s*=\s*["']([^"']+)["'])?\s*\))?`)
+	successorRegex  = regexp.MustCompile(`(/[\w\-./{}]+)`)
+)
+
+// extractDeprecation parses @deprecated, @deprecated("use /api/v2/x") or
+// @deprecated("use /api/v2/x", sunset="2025-12-31") from a docstring
+func (p *PythonRouteBuilder) extractDeprecation(doc string) (*Deprecation, error) {
+	matches := deprecatedRegex.FindStringSubmatch(doc)
+	if matches == nil {
+		return nil, nil
+	}
+
+	dep := &Deprecation{Message: matches[1] + matches[2]}
+	if match := successorRegex.FindString(dep.Message); match != "" {
+		dep.Successor = strings.TrimRight(match, ".")
+	}
+	if matches[3] != "" {
+		sunset, err := time.Parse("2006-01-02", matches[3])
+		if err != nil {
+			return dep, fmt.Errorf("invalid sunset date %q, expected YYYY-MM-DD", matches[3])
+		}
+		dep.Sunset = sunset
+	}
+	return dep, nil
+}
+
+// deprecationHandler adds Deprecation, Sunset and successor Link headers
+// so clients learn about the migration from every response
+func deprecationHandler(dep *Deprecation, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		if !dep.Sunset.IsZero() {
+			w.Header().Set("Sunset", dep.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if dep.Successor != "" {
+			w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, dep.Successor))
+		}
+		next(w, r)
+	}
+}
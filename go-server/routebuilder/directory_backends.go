@@ -0,0 +1,44 @@
+package routebuilder
+
+import (
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+)
+
+// directoryBackend is a py_htmx/ subdirectory served by its own backend
+type directoryBackend struct {
+	dir string
+	url string
+}
+
+// SetDirectoryBackends sends requests for handlers below each directory,
+// relative to py_htmx/, to its backend URL over transport instead of to
+// the FastAPI backend
+func (p *PythonRouteBuilder) SetDirectoryBackends(backends map[string]string, transport http.RoundTripper) {
+	p.dirBackends = nil
+	for dir, url := range backends {
+		p.dirBackends = append(p.dirBackends, directoryBackend{
+			dir: path.Clean(strings.Trim(dir, "/")),
+			url: strings.TrimSuffix(url, "/"),
+		})
+	}
+	// The deepest directory wins, e.g. payments/refunds over payments
+	sort.Slice(p.dirBackends, func(i, j int) bool {
+		return len(p.dirBackends[i].dir) > len(p.dirBackends[j].dir)
+	})
+	p.dirTransport = transport
+}
+
+// directoryBackendURL returns the backend of the directory holding the
+// module at basePath, "" when the FastAPI backend serves it
+func (p *PythonRouteBuilder) directoryBackendURL(basePath string) string {
+	basePath = path.Clean(strings.ReplaceAll(basePath, "\\", "/"))
+	for _, backend := range p.dirBackends {
+		if basePath == backend.dir || strings.HasPrefix(basePath, backend.dir+"/") {
+			return backend.url
+		}
+	}
+	return ""
+}
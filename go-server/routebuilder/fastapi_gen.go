@@ -0,0 +1,164 @@
+package routebuilder
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"text/template"
+)
+
+// FastAPIAppOptions controls the generated FastAPI application
+type FastAPIAppOptions struct {
+	// PyHTMXDir is the handler directory relative to the generated file
+	PyHTMXDir string
+	// Port is the port used when the file is run directly
+	Port int
+}
+
+// fastAPIMount is one add_api_route call in the generated app
+type fastAPIMount struct {
+	Path     string
+	Method   string
+	Module   string
+	Function string
+	Route    string
+}
+
+var fastAPIAppTemplate = template.Must(template.New("main.py").Funcs(template.FuncMap{
+	"py": strconv.Quote,
+}).Parse(`# Generated by "htmlnojs generate fastapi" - do not edit by hand.
+# Re-run the generator after adding, renaming or removing htmx_ handlers.
+import importlib.util
+import inspect
+import pathlib
+import traceback
+from urllib.parse import parse_qs
+
+from fastapi import FastAPI, Request
+from fastapi.responses import HTMLResponse, JSONResponse, PlainTextResponse, Response
+
+PY_HTMX_DIR = pathlib.Path(__file__).resolve().parent / {{ py .PyHTMXDir }}
+
+app = FastAPI(title="HTMLnoJS handlers")
+
+_modules = {}
+
+
+def _load(module):
+    """Import py_htmx/<module>.py once, by file path."""
+    if module not in _modules:
+        file_path = PY_HTMX_DIR / f"{module}.py"
+        spec = importlib.util.spec_from_file_location(module.replace("/", "."), file_path)
+        mod = importlib.util.module_from_spec(spec)
+        spec.loader.exec_module(mod)
+        _modules[module] = mod
+    return _modules[module]
+
+
+async def _request_data(request: Request) -> dict:
+    """Collect JSON, form or query values the same way the dev server does."""
+    if request.method in ("GET", "HEAD", "OPTIONS"):
+        return dict(request.query_params)
+
+    content_type = request.headers.get("content-type", "")
+    if content_type.startswith("application/json"):
+        return await request.json()
+    try:
+        data = dict(await request.form())
+    except Exception:
+        data = {}
+    if not data:
+        body = (await request.body()).decode("utf-8", errors="replace")
+        data = {k: v[0] if v else "" for k, v in parse_qs(body).items()}
+    return data
+
+
+def _call(handler_func, data):
+    """Pass request data first, plus any later parameter named in the request."""
+    params = list(inspect.signature(handler_func).parameters.values())[1:]
+    kwargs = {p.name: data[p.name] for p in params
+              if p.name in data and p.kind in (p.POSITIONAL_OR_KEYWORD, p.KEYWORD_ONLY)}
+    return handler_func(data, **kwargs)
+
+
+def _mount(path, method, module, function):
+    handler_func = getattr(_load(module), function)
+
+    async def endpoint(request: Request):
+        try:
+            result = _call(handler_func, await _request_data(request))
+            if inspect.isawaitable(result):
+                result = await result
+            if isinstance(result, Response):
+                return result
+            if isinstance(result, (dict, list)):
+                # Rendered by templates/_fragments/<route>.html when it exists
+                return JSONResponse(result)
+            return HTMLResponse(content=result)
+        except Exception:
+            # The Go server turns this into an error fragment, showing the
+            # traceback only while errors.detail is "full"
+            return PlainTextResponse(traceback.format_exc(), status_code=500)
+
+    endpoint.__name__ = function
+    app.add_api_route(path, endpoint, methods=[method], name=f"{module}.{function}")
+
+
+@app.get("/health")
+def health():
+    return JSONResponse({"status": "ok", "routes": {{ len .Mounts }}})
+
+{{ range .Mounts }}
+_mount({{ py .Path }}, {{ py .Method }}, {{ py .Module }}, {{ py .Function }})  # {{ .Route }}
+{{- end }}
+
+
+if __name__ == "__main__":
+    import uvicorn
+
+    uvicorn.run(app, host="127.0.0.1", port={{ .Port }})
+`))
+
+// GenerateFastAPIApp renders a main.py that wires every discovered htmx_
+// handler into a FastAPI app at the path the Go proxy calls
+func GenerateFastAPIApp(routes []PythonRoute, opts FastAPIAppOptions) ([]byte, error) {
+	if opts.PyHTMXDir == "" {
+		opts.PyHTMXDir = "py_htmx"
+	}
+	if opts.Port == 0 {
+		opts.Port = 8081
+	}
+
+	var mounts []fastAPIMount
+	for _, route := range routes {
+		// Aliases reuse the versioned backend path, other languages have
+		// their own backends
+		if route.AliasOf != "" || !servedByPython(route) {
+			continue
+		}
+		module, _ := route.Metadata["base_path"].(string)
+		path, _ := route.Metadata["fastapi_path"].(string)
+		if module == "" || path == "" {
+			return nil, fmt.Errorf("route %s is missing its module or FastAPI path", route.Route)
+		}
+		mounts = append(mounts, fastAPIMount{
+			Path:     path,
+			Method:   route.Method,
+			Module:   module,
+			Function: route.Function,
+			Route:    route.Route,
+		})
+	}
+	sort.Slice(mounts, func(i, j int) bool { return mounts[i].Path < mounts[j].Path })
+
+	var buf bytes.Buffer
+	err := fastAPIAppTemplate.Execute(&buf, struct {
+		FastAPIAppOptions
+		Mounts []fastAPIMount
+	}{opts, mounts})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render FastAPI app: %w", err)
+	}
+	return buf.Bytes(), nil
+}
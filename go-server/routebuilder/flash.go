@@ -0,0 +1,64 @@
+package routebuilder
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// Flash is a one-time message shown on the next page, e.g. "Order placed"
+// after a form redirects
+type Flash struct {
+	Level   string `json:"level"` // "info", "success", "warning" or "error"
+	Message string `json:"message"`
+}
+
+// flashColors are the text, background and border colors of each level
+var flashColors = map[string][3]string{
+	"info":    {"#1e3a8a", "#eff6ff", "#93c5fd"},
+	"success": {"#14532d", "#f0fdf4", "#86efac"},
+	"warning": {"#78350f", "#fffbeb", "#fcd34d"},
+	"error":   {"#7f1d1d", "#fef2f2", "#fca5a5"},
+}
+
+// IsFlashLevel reports whether level is one of the flash levels
+func IsFlashLevel(level string) bool {
+	_, ok := flashColors[level]
+	return ok
+}
+
+type flashesKey struct{}
+
+// WithFlashes gives the page rendered for a request the flash messages
+// waiting for it
+func WithFlashes(ctx context.Context, flashes []Flash) context.Context {
+	return context.WithValue(ctx, flashesKey{}, flashes)
+}
+
+// requestFlashes returns the flash messages for the page being rendered
+func requestFlashes(r *http.Request) []Flash {
+	flashes, _ := r.Context().Value(flashesKey{}).([]Flash)
+	return flashes
+}
+
+// FlashBanners renders the page's flash messages, for {{.FlashBanners}}
+func (d TemplateData) FlashBanners() template.HTML {
+	return template.HTML(FlashFragment(d.Flashes))
+}
+
+// FlashFragment renders flash messages as banners, one per message
+func FlashFragment(flashes []Flash) string {
+	var b strings.Builder
+	for _, flash := range flashes {
+		colors, ok := flashColors[flash.Level]
+		if !ok {
+			colors = flashColors["info"]
+		}
+		fmt.Fprintf(&b, `<div class="htmx-flash htmx-flash-%s" role="status" style="color: %s; background: %s; padding: 10px; border: 1px solid %s; border-radius: 4px; margin-bottom: 8px;">%s</div>
+`, html.EscapeString(flash.Level), colors[0], colors[1], colors[2], html.EscapeString(flash.Message))
+	}
+	return b.String()
+}
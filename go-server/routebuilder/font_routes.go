@@ -0,0 +1,215 @@
+package routebuilder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+type FontRoute struct {
+	Name     string
+	FilePath string
+	Route    string
+	Method   string
+	Handler  http.HandlerFunc
+	Format   string // As in @font-face src format(), e.g. "woff2"
+	MimeType string
+	Subset   bool // Served cut down to the characters used in templates
+	Metadata map[string]interface{}
+}
+
+// fontFormats maps font file extensions to their format and MIME type
+var fontFormats = map[string][2]string{
+	".woff2": {"woff2", "font/woff2"},
+	".woff":  {"woff", "font/woff"},
+	".ttf":   {"truetype", "font/ttf"},
+	".otf":   {"opentype", "font/otf"},
+}
+
+type FontRouteBuilder struct {
+	fontsDir string
+	routes   []FontRoute
+	runes    []rune // Characters kept when subsetting, nil serves fonts whole
+}
+
+// NewFontRouteBuilder creates a new font route builder
+func NewFontRouteBuilder(fontsDir string) *FontRouteBuilder {
+	return &FontRouteBuilder{
+		fontsDir: fontsDir,
+		routes:   make([]FontRoute, 0),
+	}
+}
+
+// SetSubsetTemplates subsets fonts to the characters used in templateFiles
+func (f *FontRouteBuilder) SetSubsetTemplates(templateFiles []string) {
+	f.runes = usedRunes(templateFiles)
+}
+
+// BuildRoutes builds a route for every font file
+func (f *FontRouteBuilder) BuildRoutes(fontFiles []string) ([]FontRoute, error) {
+	for _, filePath := range fontFiles {
+		format, ok := fontFormats[strings.ToLower(filepath.Ext(filePath))]
+		if !ok {
+			continue
+		}
+
+		route, err := f.buildFontRoute(filePath, format[0], format[1])
+		if err != nil {
+			return nil, fmt.Errorf("failed to build font route for %s: %w", filePath, err)
+		}
+		f.routes = append(f.routes, route)
+	}
+	return f.routes, nil
+}
+
+func (f *FontRouteBuilder) buildFontRoute(filePath, format, mimeType string) (FontRoute, error) {
+	filename := filepath.Base(filePath)
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return FontRoute{}, err
+	}
+
+	metadata := map[string]interface{}{
+		"file_size": info.Size(),
+		"format":    format,
+	}
+
+	// Subsets are cut once at build time, whole fonts are read per request
+	var subset []byte
+	if f.runes != nil {
+		data, err := readProjectFile(filePath)
+		if err != nil {
+			return FontRoute{}, err
+		}
+		subset, err = subsetTrueType(data, f.runes)
+		if err != nil {
+			log.Printf("Serving font %s whole: %v", filename, err)
+			subset = nil
+		} else {
+			metadata["subset_size"] = len(subset)
+			log.Printf("Subset font %s from %d to %d bytes", filename, len(data), len(subset))
+		}
+	}
+
+	return FontRoute{
+		Name:     strings.TrimSuffix(filename, filepath.Ext(filename)),
+		FilePath: filePath,
+		Route:    "/fonts/" + filename,
+		Method:   "GET",
+		Handler:  f.createFontHandler(filePath, mimeType, subset, info.ModTime()),
+		Format:   format,
+		MimeType: mimeType,
+		Subset:   subset != nil,
+		Metadata: metadata,
+	}, nil
+}
+
+func (f *FontRouteBuilder) createFontHandler(fontPath, mimeType string, subset []byte, modTime time.Time) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", mimeType)
+		w.Header().Set("Cache-Control", "public, max-age=31536000") // 1 year cache
+
+		if subset != nil {
+			http.ServeContent(w, r, "", modTime, bytes.NewReader(subset))
+			return
+		}
+
+		file, err := openProjectFile(fontPath)
+		if err != nil {
+			http.Error(w, "Font file not found", http.StatusNotFound)
+			return
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		content, ok := file.(io.ReadSeeker)
+		if err != nil || !ok || info.IsDir() {
+			http.Error(w, "Font file not found", http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, "", info.ModTime(), content)
+	}
+}
+
+// GetRoutes returns all built font routes
+func (f *FontRouteBuilder) GetRoutes() []FontRoute {
+	return f.routes
+}
+
+// usedRunes returns printable ASCII plus every other character appearing
+// in templateFiles, sorted
+func usedRunes(templateFiles []string) []rune {
+	seen := map[rune]bool{}
+	for r := rune(0x20); r < 0x7f; r++ {
+		seen[r] = true
+	}
+	for _, file := range templateFiles {
+		content, err := readProjectFile(file)
+		if err != nil {
+			continue
+		}
+		for _, r := range string(content) {
+			if r >= 0x20 && r != 0x7f && r != 0xfffd {
+				seen[r] = true
+			}
+		}
+	}
+
+	runes := make([]rune, 0, len(seen))
+	for r := range seen {
+		runes = append(runes, r)
+	}
+	slices.Sort(runes)
+	return runes
+}
+
+var (
+	fontFaceRegex = regexp.MustCompile(`(?i)@font-face\s*\{`)
+	cssURLRegex   = regexp.MustCompile(`url\(\s*['"]?([^'")]+?)['"]?\s*\)`)
+)
+
+// InjectFontDisplay adds font-display: display to the @font-face rules of
+// css that don't choose one, so text shows in a fallback font instead of
+// staying invisible while the font downloads
+func InjectFontDisplay(css []byte, display string) []byte {
+	if display == "" || display == "auto" {
+		return css
+	}
+
+	src := string(css)
+	matches := fontFaceRegex.FindAllStringIndex(src, -1)
+	for i := len(matches) - 1; i >= 0; i-- {
+		open := matches[i][1] - 1
+		body := src[open+1 : matchingBrace(src, open)]
+		if strings.Contains(strings.ToLower(body), "font-display") {
+			continue
+		}
+		src = src[:open+1] + " font-display: " + display + ";" + src[open+1:]
+	}
+	return []byte(src)
+}
+
+// referencedFonts returns the routes of the fonts css loads from fonts/
+func referencedFonts(css []byte, fonts map[string]FontRoute) []string {
+	var routes []string
+	for _, match := range cssURLRegex.FindAllStringSubmatch(string(css), -1) {
+		url, _, _ := strings.Cut(match[1], "?")
+		url, _, _ = strings.Cut(url, "#")
+		if !strings.Contains(url, "fonts/") {
+			continue
+		}
+		route, ok := fonts["/fonts/"+path.Base(url)]
+		if ok && !slices.Contains(routes, route.Route) {
+			routes = append(routes, route.Route)
+		}
+	}
+	return routes
+}
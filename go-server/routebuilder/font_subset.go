@@ -0,0 +1,354 @@
+package routebuilder
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"slices"
+
+	"golang.org/x/image/font/sfnt"
+)
+
+// sfntChecksumMagic is what head.checkSumAdjustment makes the font sum to
+const sfntChecksumMagic = 0xB1B0AFBA
+
+// droppedSubsetTables may map kept characters to emptied glyphs, e.g. an
+// "fi" ligature, or sign the original bytes
+var droppedSubsetTables = []string{"GSUB", "morx", "mort", "DSIG"}
+
+// subsetTrueType cuts a TrueType font down to the glyphs of runes. Glyph
+// IDs don't change, so hmtx, kern and GPOS stay valid: unused outlines are
+// emptied and cmap only maps the kept characters, so any other character
+// falls back to the next font of the font-family. Ligatures and alternates
+// are dropped with GSUB.
+func subsetTrueType(data []byte, runes []rune) ([]byte, error) {
+	tables, err := readSFNTTables(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range []string{"head", "maxp", "loca", "glyf"} {
+		if tables[tag] == nil {
+			return nil, fmt.Errorf("no %s table, only TrueType outlines can be subset", tag)
+		}
+	}
+	if len(tables["head"]) < 54 || len(tables["maxp"]) < 6 {
+		return nil, errors.New("truncated head or maxp table")
+	}
+
+	font, err := sfnt.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	glyf := tables["glyf"]
+	numGlyphs := int(binary.BigEndian.Uint16(tables["maxp"][4:]))
+	longLoca := binary.BigEndian.Uint16(tables["head"][50:]) == 1
+	offsets, err := glyphOffsets(tables["loca"], numGlyphs, longLoca, len(glyf))
+	if err != nil {
+		return nil, err
+	}
+
+	// .notdef, glyph 0, is always kept
+	keep := map[uint16]bool{0: true}
+	var mapping []cmapEntry
+	var buf sfnt.Buffer
+	for _, r := range runes {
+		glyph, err := font.GlyphIndex(&buf, r)
+		if err != nil || glyph == 0 {
+			continue
+		}
+		keep[uint16(glyph)] = true
+		mapping = append(mapping, cmapEntry{r, uint16(glyph)})
+	}
+
+	// Composite glyphs are drawn from other glyphs, which must stay too
+	queue := make([]uint16, 0, len(keep))
+	for glyph := range keep {
+		queue = append(queue, glyph)
+	}
+	for len(queue) > 0 {
+		glyph := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+		for _, component := range glyphComponents(glyf[offsets[glyph]:offsets[glyph+1]]) {
+			if int(component) < numGlyphs && !keep[component] {
+				keep[component] = true
+				queue = append(queue, component)
+			}
+		}
+	}
+
+	// Rebuild glyf with empty outlines for the rest, indexed by a long loca
+	newGlyf := make([]byte, 0, len(glyf))
+	newLoca := make([]byte, 4*(numGlyphs+1))
+	for glyph := 0; glyph < numGlyphs; glyph++ {
+		binary.BigEndian.PutUint32(newLoca[4*glyph:], uint32(len(newGlyf)))
+		if keep[uint16(glyph)] {
+			newGlyf = append(newGlyf, glyf[offsets[glyph]:offsets[glyph+1]]...)
+			for len(newGlyf)%4 != 0 {
+				newGlyf = append(newGlyf, 0)
+			}
+		}
+	}
+	binary.BigEndian.PutUint32(newLoca[4*numGlyphs:], uint32(len(newGlyf)))
+
+	cmap, err := buildCmap(mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	head := slices.Clone(tables["head"])
+	binary.BigEndian.PutUint16(head[50:], 1)
+	tables["head"], tables["loca"], tables["glyf"], tables["cmap"] = head, newLoca, newGlyf, cmap
+	for _, tag := range droppedSubsetTables {
+		delete(tables, tag)
+	}
+
+	return writeSFNT(binary.BigEndian.Uint32(data), tables), nil
+}
+
+// readSFNTTables returns the tables of an uncompressed font by tag
+func readSFNTTables(data []byte) (map[string][]byte, error) {
+	if len(data) < 12 {
+		return nil, errors.New("not a font file")
+	}
+	switch string(data[:4]) {
+	case "\x00\x01\x00\x00", "true":
+	case "OTTO":
+		return nil, errors.New("CFF outlines can't be subset, provide a TrueType font")
+	case "wOFF", "wOF2":
+		return nil, errors.New("compressed WOFF fonts can't be subset, provide the .ttf")
+	case "ttcf":
+		return nil, errors.New("font collections can't be subset")
+	default:
+		return nil, errors.New("not a font file")
+	}
+
+	numTables := int(binary.BigEndian.Uint16(data[4:]))
+	if len(data) < 12+16*numTables {
+		return nil, errors.New("truncated table directory")
+	}
+	tables := make(map[string][]byte, numTables)
+	for i := 0; i < numTables; i++ {
+		record := data[12+16*i:]
+		offset := int64(binary.BigEndian.Uint32(record[8:]))
+		length := int64(binary.BigEndian.Uint32(record[12:]))
+		if offset+length > int64(len(data)) {
+			return nil, fmt.Errorf("table %q is out of bounds", record[:4])
+		}
+		tables[string(record[:4])] = data[offset : offset+length]
+	}
+	return tables, nil
+}
+
+// glyphOffsets decodes loca into numGlyphs+1 offsets into glyf
+func glyphOffsets(loca []byte, numGlyphs int, long bool, glyfLength int) ([]int, error) {
+	size := 2
+	if long {
+		size = 4
+	}
+	if len(loca) < size*(numGlyphs+1) {
+		return nil, errors.New("truncated loca table")
+	}
+
+	offsets := make([]int, numGlyphs+1)
+	for i := range offsets {
+		if long {
+			offsets[i] = int(binary.BigEndian.Uint32(loca[4*i:]))
+		} else {
+			offsets[i] = 2 * int(binary.BigEndian.Uint16(loca[2*i:]))
+		}
+		if offsets[i] > glyfLength || (i > 0 && offsets[i] < offsets[i-1]) {
+			return nil, fmt.Errorf("bad loca offset for glyph %d", i)
+		}
+	}
+	return offsets, nil
+}
+
+// Composite glyph flags, see the OpenType glyf table
+const (
+	argsAreWords    = 0x0001
+	haveScale       = 0x0008
+	moreComponents  = 0x0020
+	haveXYScale     = 0x0040
+	haveTwoByTwo    = 0x0080
+	glyphHeaderSize = 10
+)
+
+// glyphComponents lists the glyphs a composite glyph is made of
+func glyphComponents(glyph []byte) []uint16 {
+	if len(glyph) < glyphHeaderSize || int16(binary.BigEndian.Uint16(glyph)) >= 0 {
+		return nil
+	}
+
+	var components []uint16
+	for p := glyphHeaderSize; p+4 <= len(glyph); {
+		flags := binary.BigEndian.Uint16(glyph[p:])
+		components = append(components, binary.BigEndian.Uint16(glyph[p+2:]))
+		p += 4
+		if flags&argsAreWords != 0 {
+			p += 4
+		} else {
+			p += 2
+		}
+		switch {
+		case flags&haveScale != 0:
+			p += 2
+		case flags&haveXYScale != 0:
+			p += 4
+		case flags&haveTwoByTwo != 0:
+			p += 8
+		}
+		if flags&moreComponents == 0 {
+			break
+		}
+	}
+	return components
+}
+
+type cmapEntry struct {
+	char  rune
+	glyph uint16
+}
+
+// cmapRange maps chars start..end to consecutive glyphs from glyph
+type cmapRange struct {
+	start, end rune
+	glyph      uint16
+}
+
+func cmapRanges(entries []cmapEntry) []cmapRange {
+	var ranges []cmapRange
+	for _, entry := range entries {
+		if n := len(ranges); n > 0 {
+			last := &ranges[n-1]
+			if entry.char == last.end+1 && int(entry.glyph) == int(last.glyph)+int(entry.char-last.start) {
+				last.end = entry.char
+				continue
+			}
+		}
+		ranges = append(ranges, cmapRange{entry.char, entry.char, entry.glyph})
+	}
+	return ranges
+}
+
+// buildCmap writes a cmap with a format 4 subtable for the Basic
+// Multilingual Plane and a format 12 one for every character
+func buildCmap(entries []cmapEntry) ([]byte, error) {
+	slices.SortFunc(entries, func(a, b cmapEntry) int { return int(a.char - b.char) })
+	ranges := cmapRanges(entries)
+
+	// Format 4 ends with the mandatory 0xFFFF segment
+	var bmp []cmapRange
+	for _, r := range ranges {
+		if r.end <= 0xFFFF && r.start < 0xFFFF {
+			bmp = append(bmp, r)
+		}
+	}
+	bmp = append(bmp, cmapRange{0xFFFF, 0xFFFF, 0})
+	segCount := len(bmp)
+	length4 := 16 + 8*segCount
+	if length4 > 0xFFFF {
+		return nil, errors.New("too many characters for a format 4 cmap")
+	}
+	searchRange := 2 << (bits.Len(uint(segCount)) - 1)
+
+	format4 := make([]byte, length4)
+	be := binary.BigEndian
+	be.PutUint16(format4[0:], 4)
+	be.PutUint16(format4[2:], uint16(length4))
+	be.PutUint16(format4[6:], uint16(2*segCount))
+	be.PutUint16(format4[8:], uint16(searchRange))
+	be.PutUint16(format4[10:], uint16(bits.Len(uint(segCount))-1))
+	be.PutUint16(format4[12:], uint16(2*segCount-searchRange))
+	for i, r := range bmp {
+		be.PutUint16(format4[14+2*i:], uint16(r.end))
+		be.PutUint16(format4[16+2*segCount+2*i:], uint16(r.start))
+		delta := uint16(1)
+		if r.start != 0xFFFF {
+			delta = r.glyph - uint16(r.start)
+		}
+		be.PutUint16(format4[16+4*segCount+2*i:], delta)
+		// idRangeOffset stays zero
+	}
+
+	format12 := make([]byte, 16+12*len(ranges))
+	be.PutUint16(format12[0:], 12)
+	be.PutUint32(format12[4:], uint32(len(format12)))
+	be.PutUint32(format12[12:], uint32(len(ranges)))
+	for i, r := range ranges {
+		be.PutUint32(format12[16+12*i:], uint32(r.start))
+		be.PutUint32(format12[20+12*i:], uint32(r.end))
+		be.PutUint32(format12[24+12*i:], uint32(r.glyph))
+	}
+
+	// Windows Unicode BMP (3, 1) and full repertoire (3, 10) encodings
+	header := make([]byte, 4+8*2)
+	be.PutUint16(header[2:], 2)
+	be.PutUint16(header[4:], 3)
+	be.PutUint16(header[6:], 1)
+	be.PutUint32(header[8:], uint32(len(header)))
+	be.PutUint16(header[12:], 3)
+	be.PutUint16(header[14:], 10)
+	be.PutUint32(header[16:], uint32(len(header)+len(format4)))
+
+	return slices.Concat(header, format4, format12), nil
+}
+
+// writeSFNT assembles tables into a font with correct checksums
+func writeSFNT(version uint32, tables map[string][]byte) []byte {
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	slices.Sort(tags)
+
+	be := binary.BigEndian
+	numTables := len(tags)
+	entrySelector := bits.Len(uint(numTables)) - 1
+	searchRange := 16 << entrySelector
+
+	font := make([]byte, 12+16*numTables)
+	be.PutUint32(font[0:], version)
+	be.PutUint16(font[4:], uint16(numTables))
+	be.PutUint16(font[6:], uint16(searchRange))
+	be.PutUint16(font[8:], uint16(entrySelector))
+	be.PutUint16(font[10:], uint16(16*numTables-searchRange))
+
+	headOffset := -1
+	for i, tag := range tags {
+		table := tables[tag]
+		if tag == "head" {
+			// The adjustment is computed over the font with it zeroed
+			table = slices.Clone(table)
+			be.PutUint32(table[8:], 0)
+			headOffset = len(font)
+		}
+		record := font[12+16*i:]
+		copy(record, tag)
+		be.PutUint32(record[4:], sfntChecksum(table))
+		be.PutUint32(record[8:], uint32(len(font)))
+		be.PutUint32(record[12:], uint32(len(table)))
+
+		font = append(font, table...)
+		for len(font)%4 != 0 {
+			font = append(font, 0)
+		}
+	}
+
+	if headOffset >= 0 {
+		be.PutUint32(font[headOffset+8:], sfntChecksumMagic-sfntChecksum(font))
+	}
+	return font
+}
+
+// sfntChecksum sums data as big-endian uint32s, zero padded
+func sfntChecksum(data []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(data); i += 4 {
+		var word [4]byte
+		copy(word[:], data[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return sum
+}
@@ -0,0 +1,91 @@
+package routebuilder
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// routeCacheControlKey carries the Cache-Control a route middleware (such
+// as @cache) already set, so a fragment's ETag doesn't override it
+type routeCacheControlKey struct{}
+
+// withRouteCacheControl records the Cache-Control w carries before the
+// backend response is copied over it
+func withRouteCacheControl(ctx context.Context, w http.ResponseWriter) context.Context {
+	return context.WithValue(ctx, routeCacheControlKey{}, w.Header().Get("Cache-Control"))
+}
+
+// applyFragmentFreshness lets polled fragments skip unchanged data. A
+// backend ETag makes the browser revalidate on every poll (sending
+// If-None-Match), and a response that hasn't changed - a 304, or a 200
+// whose ETag the client already has - becomes a bodyless 204 for HTMX,
+// which leaves the target untouched, or a 304 for everything else.
+func applyFragmentFreshness(resp *http.Response) {
+	req := resp.Request
+	etag := resp.Header.Get("ETag")
+	if etag == "" || (req.Method != http.MethodGet && req.Method != http.MethodHead) {
+		return
+	}
+
+	if resp.Header.Get("Cache-Control") == "" {
+		routeCacheControl, _ := req.Context().Value(routeCacheControlKey{}).(string)
+		if routeCacheControl == "" {
+			// Stored but always revalidated, so polls carry If-None-Match
+			resp.Header.Set("Cache-Control", "no-cache")
+		}
+	}
+	addVary(resp.Header, "HX-Request")
+
+	unchanged := resp.StatusCode == http.StatusNotModified ||
+		(resp.StatusCode == http.StatusOK && ETagMatches(req.Header.Get("If-None-Match"), etag))
+	if !unchanged {
+		return
+	}
+
+	status := http.StatusNotModified
+	if req.Header.Get("HX-Request") == "true" {
+		// HTMX doesn't swap 204s, and the browser hands them to it as is
+		// where it would turn a 304 back into the cached 200
+		status = http.StatusNoContent
+	}
+	if resp.Body != nil {
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+	}
+	resp.StatusCode = status
+	resp.Status = http.StatusText(status)
+	resp.Body = http.NoBody
+	resp.ContentLength = 0
+	resp.Header.Del("Content-Length")
+	resp.Header.Del("Content-Type")
+}
+
+// ETagMatches reports whether an If-None-Match header lists etag, using
+// the weak comparison conditional GETs call for
+func ETagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// addVary appends field to the Vary header unless it's already listed
+func addVary(header http.Header, field string) {
+	for _, value := range header.Values("Vary") {
+		for _, existing := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(existing), field) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", field)
+}
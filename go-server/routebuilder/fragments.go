@@ -0,0 +1,208 @@
+package routebuilder
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"htmlnojs/config"
+)
+
+// ErrorPage is what an error fragment shows
+type ErrorPage struct {
+	Status  int // HTTP status, 0 when the fragment isn't tied to one
+	Title   string
+	Message string
+	Detail  string // Error text or traceback, left out when errors are generic
+}
+
+// defaultErrorTemplate renders error fragments unless errors.template
+// replaces it; the classes let projects restyle it from their CSS
+var defaultErrorTemplate = template.Must(template.New("error").Parse(`
+                <div class="htmx-error"{{if .Status}} data-status="{{.Status}}"{{end}} role="alert" style="color: #991b1b; background: #fef2f2; padding: 10px; border: 1px solid #fca5a5; border-radius: 4px;">
+                    <strong class="htmx-error-title">{{.Title}}</strong><br>
+                    <span class="htmx-error-message">{{.Message}}</span>
+                    {{- if .Detail}}
+                    <pre class="htmx-error-detail" style="margin: 8px 0 0; white-space: pre-wrap; font-size: 0.85em;">{{.Detail}}</pre>
+                    {{- end}}
+                </div>
+            `))
+
+// ForbiddenPage is what the 403 page shows a client without a role the
+// route requires
+type ForbiddenPage struct {
+	Path  string
+	User  string   // Who the client signed in as
+	Roles []string // Roles that would have let it in
+}
+
+// MaintenancePage is what the 503 page shows while the site is down for
+// maintenance
+type MaintenancePage struct {
+	Path       string
+	Message    string    // Set when maintenance was switched on, may be empty
+	Since      time.Time // When it was switched on
+	RetryAfter int       // Seconds clients are asked to wait, 0 when unknown
+}
+
+var (
+	errorPagesMu        sync.RWMutex
+	errorTemplate       = defaultErrorTemplate
+	forbiddenTemplate   *template.Template // nil renders an error fragment
+	maintenanceTemplate *template.Template // nil renders an error fragment
+	genericErrors       bool
+)
+
+// SetErrorPages applies the project's errors settings to every error
+// fragment; a template path is relative to projectDir
+func SetErrorPages(cfg config.ErrorsConfig, projectDir string) error {
+	tmpl := defaultErrorTemplate
+	if cfg.Template != "" {
+		var err error
+		if tmpl, err = loadErrorTemplate(cfg.Template, projectDir); err != nil {
+			return err
+		}
+	}
+	var forbidden *template.Template
+	if cfg.Forbidden != "" {
+		var err error
+		if forbidden, err = loadErrorTemplate(cfg.Forbidden, projectDir); err != nil {
+			return err
+		}
+	}
+
+	var maintenance *template.Template
+	if cfg.Maintenance != "" {
+		var err error
+		if maintenance, err = loadErrorTemplate(cfg.Maintenance, projectDir); err != nil {
+			return err
+		}
+	}
+
+	errorPagesMu.Lock()
+	defer errorPagesMu.Unlock()
+	errorTemplate = tmpl
+	forbiddenTemplate = forbidden
+	maintenanceTemplate = maintenance
+	genericErrors = cfg.Detail == "generic"
+	return nil
+}
+
+func loadErrorTemplate(path, projectDir string) (*template.Template, error) {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(projectDir, path)
+	}
+	source, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read error template: %w", err)
+	}
+	tmpl, err := template.New(filepath.Base(path)).Parse(string(source))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse error template %s: %w", path, err)
+	}
+	return tmpl, nil
+}
+
+// errorsAreGeneric reports whether error details are hidden from clients
+func errorsAreGeneric() bool {
+	errorPagesMu.RLock()
+	defer errorPagesMu.RUnlock()
+	return genericErrors
+}
+
+// RenderErrorPage renders page with the project's error template
+func RenderErrorPage(page ErrorPage) string {
+	errorPagesMu.RLock()
+	tmpl, generic := errorTemplate, genericErrors
+	errorPagesMu.RUnlock()
+	if generic {
+		page.Detail = ""
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, page); err != nil {
+		log.Printf("WARNING: Error template failed, using the built-in one: %v", err)
+		buf.Reset()
+		defaultErrorTemplate.Execute(&buf, page)
+	}
+	return buf.String()
+}
+
+// RenderForbiddenPage renders page with the project's 403 template, or as
+// an error fragment without one
+func RenderForbiddenPage(page ForbiddenPage) string {
+	errorPagesMu.RLock()
+	tmpl := forbiddenTemplate
+	errorPagesMu.RUnlock()
+
+	if tmpl != nil {
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, page)
+		if err == nil {
+			return buf.String()
+		}
+		log.Printf("WARNING: Forbidden template failed, using the built-in one: %v", err)
+	}
+	message := "You don't have access to this page"
+	if len(page.Roles) == 1 {
+		message = fmt.Sprintf("This page needs the %s role", page.Roles[0])
+	} else if len(page.Roles) > 1 {
+		message = fmt.Sprintf("This page needs one of the roles %s", strings.Join(page.Roles, ", "))
+	}
+	return RenderErrorPage(ErrorPage{Status: http.StatusForbidden, Title: "Access Denied", Message: message})
+}
+
+// RenderMaintenancePage renders page with the project's maintenance
+// template, or as an error fragment without one
+func RenderMaintenancePage(page MaintenancePage) string {
+	errorPagesMu.RLock()
+	tmpl := maintenanceTemplate
+	errorPagesMu.RUnlock()
+
+	if tmpl != nil {
+		var buf bytes.Buffer
+		err := tmpl.Execute(&buf, page)
+		if err == nil {
+			return buf.String()
+		}
+		log.Printf("WARNING: Maintenance template failed, using the built-in one: %v", err)
+	}
+	message := page.Message
+	if message == "" {
+		message = "The site is down for maintenance and will be back shortly"
+	}
+	return RenderErrorPage(ErrorPage{Status: http.StatusServiceUnavailable, Title: "Down for Maintenance", Message: message})
+}
+
+// ErrorFragment renders the styled error block swapped into the page when a
+// request can't be completed
+func ErrorFragment(title, message, detail string) string {
+	return RenderErrorPage(ErrorPage{Title: title, Message: message, Detail: detail})
+}
+
+// NoticeFragment renders a neutral informational block, used when a request
+// is refused on purpose rather than failing
+func NoticeFragment(title, message string) string {
+	return fmt.Sprintf(`
+                <div class="htmx-notice" style="color: #1e3a8a; background: #eff6ff; padding: 10px; border: 1px solid #93c5fd; border-radius: 4px;">
+                    <strong>%s</strong><br>
+                    %s
+                </div>
+            `, html.EscapeString(title), html.EscapeString(message))
+}
+
+// WriteFragment writes an HTML fragment response with the given status
+func WriteFragment(w http.ResponseWriter, status int, fragment string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	fmt.Fprint(w, fragment)
+}
@@ -0,0 +1,271 @@
+package routebuilder
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"htmlnojs/config"
+)
+
+// FrontMatter holds the values declared in a template's front-matter block
+type FrontMatter map[string]interface{}
+
+// frontMatterDelimiter opens and closes a front-matter block
+const frontMatterDelimiter = "---"
+
+// frontMatterBlock is a parsed front-matter block along with the template
+// body that follows it
+type frontMatterBlock struct {
+	Values FrontMatter
+	Lines  map[string]int // Line number each key was declared on
+	Body   []byte
+	Errors []LintIssue
+}
+
+// parseFrontMatter splits a leading front-matter block from template source.
+// Only a small YAML subset is understood: "key: value" pairs with strings,
+// booleans, integers, [inline, lists] and "- item" lists.
+func parseFrontMatter(templatePath string, source []byte) frontMatterBlock {
+	block := frontMatterBlock{Values: FrontMatter{}, Lines: map[string]int{}, Body: source}
+
+	lines := strings.Split(string(source), "\n")
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != frontMatterDelimiter {
+		return block
+	}
+
+	end := -1
+	for i := 1; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == frontMatterDelimiter {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		block.Errors = append(block.Errors, LintIssue{
+			File:    templatePath,
+			Line:    1,
+			Rule:    "front-matter",
+			Message: "front-matter block is never closed with ---",
+		})
+		return block
+	}
+
+	var listKey string
+	for i := 1; i < end; i++ {
+		lineNo := i + 1
+		line := strings.TrimRight(lines[i], " \t\r")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "- ") && listKey != "" {
+			list, _ := block.Values[listKey].([]interface{})
+			block.Values[listKey] = append(list, parseFrontMatterScalar(trimmed[2:]))
+			continue
+		}
+		listKey = ""
+
+		key, value, ok := strings.Cut(trimmed, ":")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			block.Errors = append(block.Errors, LintIssue{
+				File:    templatePath,
+				Line:    lineNo,
+				Rule:    "front-matter",
+				Message: fmt.Sprintf("expected \"key: value\", got %q", trimmed),
+			})
+			continue
+		}
+
+		block.Lines[key] = lineNo
+		value = strings.TrimSpace(value)
+		if value == "" {
+			listKey = key
+			block.Values[key] = []interface{}{}
+			continue
+		}
+		block.Values[key] = parseFrontMatterValue(value)
+	}
+
+	block.Body = []byte(strings.Join(lines[end+1:], "\n"))
+	return block
+}
+
+func parseFrontMatterValue(value string) interface{} {
+	if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+		inner := strings.TrimSpace(value[1 : len(value)-1])
+		list := []interface{}{}
+		if inner == "" {
+			return list
+		}
+		for _, item := range strings.Split(inner, ",") {
+			list = append(list, parseFrontMatterScalar(item))
+		}
+		return list
+	}
+	return parseFrontMatterScalar(value)
+}
+
+func parseFrontMatterScalar(value string) interface{} {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	switch value {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if n, err := strconv.Atoi(value); err == nil {
+		return n
+	}
+	return value
+}
+
+// frontMatterField describes one known front-matter key
+type frontMatterField struct {
+	Type    string // "string", "bool", "int" or "list"
+	Allowed func(project *config.ProjectConfig) []string
+}
+
+// frontMatterSchema lists the keys templates may declare
+var frontMatterSchema = map[string]frontMatterField{
+	"title":       {Type: "string"},
+	"description": {Type: "string"},
+	"layout": {Type: "string", Allowed: func(project *config.ProjectConfig) []string {
+		return project.FrontMatter.Layouts
+	}},
+	"roles": {Type: "list", Allowed: func(project *config.ProjectConfig) []string {
+		return project.FrontMatter.Roles
+	}},
+	"auth":  {Type: "bool"},
+	"owner": {Type: "string"},
+	"tags":  {Type: "list"},
+}
+
+// LintFrontMatter validates a template's front-matter against the schema,
+// reporting unknown keys, wrong types and values outside the allowed sets
+func LintFrontMatter(project *config.ProjectConfig, templatePath string, source []byte) []LintIssue {
+	block := parseFrontMatter(templatePath, source)
+	issues := append([]LintIssue(nil), block.Errors...)
+
+	keys := make([]string, 0, len(block.Values))
+	for key := range block.Values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := block.Values[key]
+		issue := LintIssue{File: templatePath, Line: block.Lines[key], Rule: "front-matter"}
+
+		field, ok := frontMatterSchema[key]
+		if !ok {
+			issue.Message = fmt.Sprintf("unknown key %q", key)
+			if suggestion := closestFrontMatterKey(key); suggestion != "" {
+				issue.Message += fmt.Sprintf(" (did you mean %q?)", suggestion)
+			}
+			issues = append(issues, issue)
+			continue
+		}
+
+		if !frontMatterTypeMatches(field.Type, value) {
+			issue.Message = fmt.Sprintf("%q must be a %s", key, field.Type)
+			issues = append(issues, issue)
+			continue
+		}
+
+		if field.Allowed == nil {
+			continue
+		}
+		allowed := field.Allowed(project)
+		if len(allowed) == 0 {
+			continue
+		}
+		for _, v := range frontMatterStrings(value) {
+			if !containsString(allowed, v) {
+				issue.Message = fmt.Sprintf("%q is not allowed for %s (allowed: %s)", v, key, strings.Join(allowed, ", "))
+				issues = append(issues, issue)
+			}
+		}
+	}
+
+	return issues
+}
+
+func frontMatterTypeMatches(fieldType string, value interface{}) bool {
+	switch fieldType {
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "bool":
+		_, ok := value.(bool)
+		return ok
+	case "int":
+		_, ok := value.(int)
+		return ok
+	case "list":
+		_, ok := value.([]interface{})
+		return ok
+	}
+	return false
+}
+
+func frontMatterStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			out = append(out, fmt.Sprint(item))
+		}
+		return out
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// closestFrontMatterKey suggests a known key within two edits of a typo
+func closestFrontMatterKey(key string) string {
+	best, bestDistance := "", 3
+	for known := range frontMatterSchema {
+		if d := editDistance(strings.ToLower(key), known); d < bestDistance || (d == bestDistance && known < best) {
+			best, bestDistance = known, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
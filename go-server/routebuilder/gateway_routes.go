@@ -0,0 +1,108 @@
+package routebuilder
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"htmlnojs/config"
+)
+
+// GatewayRoute passes requests under Route through to a third-party API at
+// Target, so pages can call it without a cross-origin request
+type GatewayRoute struct {
+	Route   string
+	Target  string
+	Methods []string
+	Handler http.HandlerFunc
+}
+
+// gatewayTransport reaches third-party APIs. Handler backend settings such
+// as client certificates and request signing are meant for the project's
+// own backends, so they don't apply.
+var gatewayTransport http.RoundTripper = http.DefaultTransport
+
+// BuildGatewayRoutes creates a passthrough route for each configured API,
+// keyed by the path it's served under, connecting through transport
+func BuildGatewayRoutes(apis map[string]config.GatewayRoute, transport http.RoundTripper) ([]GatewayRoute, error) {
+	prefixes := make([]string, 0, len(apis))
+	for prefix := range apis {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	routes := make([]GatewayRoute, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		api := apis[prefix]
+		target, err := url.Parse(api.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gateway URL for %s: %w", prefix, err)
+		}
+		headers := make(http.Header, len(api.Headers))
+		for name, value := range api.Headers {
+			if env, ok := strings.CutPrefix(value, "env:"); ok {
+				value = os.Getenv(env)
+				if value == "" {
+					return nil, fmt.Errorf("gateway.%s.headers.%s: environment variable %s is not set", prefix, name, env)
+				}
+			}
+			headers.Set(name, value)
+		}
+		methods := api.Methods
+		if len(methods) == 0 {
+			methods = []string{http.MethodGet, http.MethodHead}
+		}
+
+		route := strings.TrimSuffix(prefix, "/") + "/"
+		routes = append(routes, GatewayRoute{
+			Route:   route,
+			Target:  target.String(),
+			Methods: methods,
+			Handler: newGatewayHandler(route, target, headers, methods, transport),
+		})
+	}
+	return routes, nil
+}
+
+// newGatewayHandler proxies requests under route to target with headers
+// set. The browser's cookies and credentials are for this origin, so they
+// aren't passed on, and the API can't set cookies on it either.
+func newGatewayHandler(route string, target *url.URL, headers http.Header, methods []string, transport http.RoundTripper) http.HandlerFunc {
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL.Path = "/" + strings.TrimPrefix(pr.In.URL.Path, route)
+			pr.Out.URL.RawPath = ""
+			pr.SetURL(target)
+			pr.Out.Header.Del("Cookie")
+			pr.Out.Header.Del("Authorization")
+			for name, values := range headers {
+				pr.Out.Header[name] = values
+			}
+		},
+		Transport: transport,
+		ModifyResponse: func(resp *http.Response) error {
+			resp.Header.Del("Set-Cookie")
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("ERROR: Gateway request to %s failed: %v", target, err)
+			http.Error(w, "External API unavailable", http.StatusBadGateway)
+		},
+	}
+	allow := strings.Join(methods, ", ")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !slices.Contains(methods, r.Method) {
+			w.Header().Set("Allow", allow)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	}
+}
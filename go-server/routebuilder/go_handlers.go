@@ -0,0 +1,193 @@
+package routebuilder
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"plugin"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// GoHandlerLanguage is the adapter serving Go handlers in-process, from
+// go_htmx/ plugins and RegisterGoHandler, without a backend round trip
+const GoHandlerLanguage = "go"
+
+// GoHandler is an htmx_ function implemented in Go
+type GoHandler struct {
+	Module   string // URL path below /api/, like a py_htmx module path
+	Function string // htmx_ name, e.g. htmx_post_save
+	Doc      string // Documentation, may carry @auth, @cache(n), @rate_limit(n), @owner(team)
+	Handler  http.HandlerFunc
+
+	file string // Plugin the handler was loaded from, empty when registered in code
+}
+
+var (
+	goHandlersMu sync.RWMutex
+	goHandlers   []GoHandler
+)
+
+// RegisterGoHandler serves handler at the route a py_htmx function of the
+// same module and name would get, e.g. Module "search" and Function
+// "htmx_query" at /api/search/query
+func RegisterGoHandler(handler GoHandler) error {
+	if !strings.HasPrefix(handler.Function, "htmx_") {
+		return fmt.Errorf("go handler %q must start with htmx_", handler.Function)
+	}
+	if handler.Handler == nil {
+		return fmt.Errorf("go handler %s has no handler func", handler.Function)
+	}
+	handler.Module = strings.Trim(handler.Module, "/")
+
+	goHandlersMu.Lock()
+	defer goHandlersMu.Unlock()
+	goHandlers = append(goHandlers, handler)
+	return nil
+}
+
+// registeredGoHandlers returns a copy of the handlers registered in code
+func registeredGoHandlers() []GoHandler {
+	goHandlersMu.RLock()
+	defer goHandlersMu.RUnlock()
+	return append([]GoHandler(nil), goHandlers...)
+}
+
+var (
+	goPluginsMu sync.Mutex
+	goPlugins   = map[string][]GoHandler{}
+)
+
+// loadGoPlugin opens a go_htmx plugin and reads its handlers. A plugin
+// exports them as
+//
+//	var Handlers = map[string]func(http.ResponseWriter, *http.Request){"htmx_query": query}
+//
+// and may document them with a `var Docs = map[string]string{...}`. Go can't
+// unload plugins, so each file is opened once and a rebuilt plugin needs a
+// server restart.
+func loadGoPlugin(path, module string) ([]GoHandler, error) {
+	goPluginsMu.Lock()
+	defer goPluginsMu.Unlock()
+	if handlers, ok := goPlugins[path]; ok {
+		return handlers, nil
+	}
+
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open plugin %s: %w", path, err)
+	}
+	symbol, err := p.Lookup("Handlers")
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s does not export Handlers: %w", path, err)
+	}
+
+	funcs := map[string]http.HandlerFunc{}
+	switch exported := symbol.(type) {
+	case *map[string]func(http.ResponseWriter, *http.Request):
+		for name, fn := range *exported {
+			funcs[name] = fn
+		}
+	case *map[string]http.HandlerFunc:
+		funcs = *exported
+	default:
+		return nil, fmt.Errorf("plugin %s: Handlers must be a map[string]func(http.ResponseWriter, *http.Request), got %T", path, symbol)
+	}
+
+	docs := map[string]string{}
+	if symbol, err := p.Lookup("Docs"); err == nil {
+		if exported, ok := symbol.(*map[string]string); ok {
+			docs = *exported
+		}
+	}
+
+	names := make([]string, 0, len(funcs))
+	for name := range funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var handlers []GoHandler
+	for _, name := range names {
+		if !strings.HasPrefix(name, "htmx_") {
+			log.Printf("WARNING: Ignoring %s in %s, handler names must start with htmx_", name, path)
+			continue
+		}
+		handlers = append(handlers, GoHandler{Module: module, Function: name, Doc: docs[name], Handler: funcs[name], file: path})
+	}
+	goPlugins[path] = handlers
+	return handlers, nil
+}
+
+// goAdapter serves go_htmx/*.so plugins and registered Go handlers
+type goAdapter struct{}
+
+func (goAdapter) Name() string { return GoHandlerLanguage }
+
+func (goAdapter) Dir() string { return "go_htmx" }
+
+func (goAdapter) InProcess() bool { return true }
+
+func (goAdapter) Match(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".so")
+}
+
+func (goAdapter) BuildRoutes(opts HandlerAdapterOptions, files []string) ([]PythonRoute, error) {
+	handlers := registeredGoHandlers()
+	for _, file := range files {
+		relPath, _ := filepath.Rel(opts.Dir, file)
+		module := filepath.ToSlash(strings.TrimSuffix(relPath, filepath.Ext(relPath)))
+		loaded, err := loadGoPlugin(file, module)
+		if err != nil {
+			return nil, err
+		}
+		handlers = append(handlers, loaded...)
+	}
+
+	routes := make([]PythonRoute, 0, len(handlers))
+	for _, handler := range handlers {
+		routes = append(routes, inProcessRoute(opts, handler))
+	}
+
+	return dedupeRoutes(routes, opts.WarnOnDuplicates)
+}
+
+// inProcessRoute builds the route of a handler running inside the Go
+// server. The Python builder's naming and annotation rules apply unchanged.
+func inProcessRoute(opts HandlerAdapterOptions, handler GoHandler) PythonRoute {
+	p := NewPythonRouteBuilder(opts.Dir)
+	routeName := p.extractRouteName(handler.Function)
+	route := "/api/" + routeName
+	if handler.Module != "" && handler.Module != "." {
+		route = "/api/" + handler.Module + "/" + routeName
+	}
+	method := p.determineHTTPMethod(handler.Function)
+	roles := p.extractRoles(handler.Doc)
+
+	log.Printf("DEBUG: Registered in-process route: %s %s -> %s", method, route, handler.Function)
+	return PythonRoute{
+		Name:          routeName,
+		FilePath:      handler.file,
+		Route:         route,
+		Method:        method,
+		Handler:       handler.Handler,
+		Function:      handler.Function,
+		RequiresAuth:  p.checkRequiresAuth(handler.Doc) || len(roles) > 0,
+		Roles:         roles,
+		RateLimit:     p.extractRateLimit(handler.Doc),
+		CacheTimeout:  p.extractCacheTimeout(handler.Doc),
+		CacheStale:    p.extractCacheStale(handler.Doc),
+		Timeout:       p.extractTimeout(handler.Doc),
+		DemoSafe:      strings.Contains(handler.Doc, "@demo_safe"),
+		CSRFExempt:    strings.Contains(handler.Doc, "@csrf_exempt"),
+		Nonce:         strings.Contains(handler.Doc, "@nonce"),
+		Owner:         p.extractOwner(handler.Doc),
+		Documentation: handler.Doc,
+		Metadata: map[string]interface{}{
+			"base_path":  handler.Module,
+			"in_process": true,
+		},
+	}
+}
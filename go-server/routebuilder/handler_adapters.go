@@ -0,0 +1,185 @@
+package routebuilder
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"htmlnojs/config"
+)
+
+// HandlerAdapter discovers htmx_ handlers written in one language and
+// builds the routes that proxy to that language's backend. Each adapter
+// owns a handler directory such as py_htmx/, node_htmx/ or rb_htmx/.
+type HandlerAdapter interface {
+	// Name returns the language name used in project configuration
+	Name() string
+	// Dir returns the default handler directory inside the project
+	Dir() string
+	// Match reports whether a discovered file can contain handlers
+	Match(path string) bool
+	// BuildRoutes discovers the handlers in files and returns their routes
+	BuildRoutes(opts HandlerAdapterOptions, files []string) ([]PythonRoute, error)
+}
+
+// InProcessAdapter is implemented by adapters that run their handlers
+// inside the Go server. They need no backend, so their directories are
+// picked up without any configuration.
+type InProcessAdapter interface {
+	HandlerAdapter
+	InProcess() bool
+}
+
+// IsInProcess reports whether language's handlers run inside the Go server
+func IsInProcess(language string) bool {
+	adapter, err := GetHandlerAdapter(language)
+	if err != nil {
+		return false
+	}
+	inProcess, ok := adapter.(InProcessAdapter)
+	return ok && inProcess.InProcess()
+}
+
+// SidecarAdapter is implemented by adapters that can start their own
+// backend, so a project doesn't have to run it separately
+type SidecarAdapter interface {
+	HandlerAdapter
+	// StartSidecar serves the handlers of dir on port until stop is called
+	StartSidecar(dir string, port int) (stop func(), err error)
+}
+
+// HandlerAdapterOptions tells an adapter where its handlers and backend live
+type HandlerAdapterOptions struct {
+	Dir              string // Handler directory, API paths are relative to it
+	BackendHost      string
+	BackendPort      int
+	BackendURL       string                           // Replaces host and port when set
+	WarnOnDuplicates bool                             // Keep the first of two handlers with the same route
+	Transport        http.RoundTripper                // Reaches the backend, nil keeps the builder's default
+	Healthy          func() (ok bool, reason string)  // Backend state, nil always proxies
+	Canary           config.CanaryConfig              // Second backend taking part of the traffic
+	HostHeader       string                           // See config.ProxyConfig.Host
+	TimeoutProfiles  map[string]config.TimeoutProfile // Deadlines picked with @timeout(name)
+	DirBackends      map[string]string                // Backend URLs of subdirectories, see config.ProjectConfig.DirectoryBackends
+	DirTransport     http.RoundTripper                // Reaches DirBackends
+	RequestEncoding  string                           // See config.ProjectConfig.RequestEncoding
+}
+
+// DefaultHandlerLanguage is the adapter behind py_htmx/
+const DefaultHandlerLanguage = "python"
+
+var (
+	handlerAdaptersMu sync.RWMutex
+	handlerAdapters   = map[string]HandlerAdapter{
+		DefaultHandlerLanguage:  pythonAdapter{},
+		GoHandlerLanguage:       goAdapter{},
+		StarlarkHandlerLanguage: starlarkAdapter{},
+		LuaHandlerLanguage:      luaAdapter{},
+		NodeHandlerLanguage:     nodeAdapter{},
+	}
+)
+
+// RegisterHandlerAdapter makes a handler language available to projects
+func RegisterHandlerAdapter(adapter HandlerAdapter) {
+	handlerAdaptersMu.Lock()
+	defer handlerAdaptersMu.Unlock()
+	handlerAdapters[adapter.Name()] = adapter
+}
+
+// HandlerAdapterNames returns the names of all registered adapters
+func HandlerAdapterNames() []string {
+	handlerAdaptersMu.RLock()
+	defer handlerAdaptersMu.RUnlock()
+
+	names := make([]string, 0, len(handlerAdapters))
+	for name := range handlerAdapters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetHandlerAdapter returns the adapter registered for a language
+func GetHandlerAdapter(name string) (HandlerAdapter, error) {
+	handlerAdaptersMu.RLock()
+	adapter, ok := handlerAdapters[name]
+	handlerAdaptersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown handler language %q (available: %v)", name, HandlerAdapterNames())
+	}
+	return adapter, nil
+}
+
+// pythonAdapter serves py_htmx/ handlers through the FastAPI backend
+type pythonAdapter struct{}
+
+func (pythonAdapter) Name() string { return DefaultHandlerLanguage }
+
+func (pythonAdapter) Dir() string { return "py_htmx" }
+
+func (pythonAdapter) Match(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".py")
+}
+
+func (pythonAdapter) BuildRoutes(opts HandlerAdapterOptions, files []string) ([]PythonRoute, error) {
+	builder := NewPythonRouteBuilder(opts.Dir)
+	builder.SetFastAPIServer(opts.BackendHost, opts.BackendPort)
+	builder.SetFastAPIURL(opts.BackendURL)
+	builder.SetWarnOnDuplicates(opts.WarnOnDuplicates)
+	builder.SetTransport(opts.Transport)
+	builder.SetHealthy(opts.Healthy)
+	builder.SetCanary(opts.Canary)
+	builder.SetHostHeader(opts.HostHeader)
+	builder.SetTimeoutProfiles(opts.TimeoutProfiles)
+	builder.SetDirectoryBackends(opts.DirBackends, opts.DirTransport)
+	builder.SetRequestEncoding(opts.RequestEncoding)
+	return builder.BuildRoutes(files)
+}
+
+// servedByPython reports whether the FastAPI backend serves route
+func servedByPython(route PythonRoute) bool {
+	return route.Language == "" || route.Language == DefaultHandlerLanguage
+}
+
+// handlerSource is one handler directory and the backend serving it
+type handlerSource struct {
+	adapter HandlerAdapter
+	dir     string
+	port    int
+	files   []string
+}
+
+// dedupeRoutes reports handlers that resolve to the same URL, e.g.
+// htmx_search in two modules sharing a route prefix, since only one of them
+// could ever be reached. With warn set the first handler keeps the route.
+func dedupeRoutes(routes []PythonRoute, warn bool) ([]PythonRoute, error) {
+	seen := make(map[string]PythonRoute, len(routes))
+	unique := make([]PythonRoute, 0, len(routes))
+	var duplicates []string
+
+	for _, route := range routes {
+		first, ok := seen[route.Route]
+		if !ok {
+			seen[route.Route] = route
+			unique = append(unique, route)
+			continue
+		}
+		duplicates = append(duplicates, fmt.Sprintf("%s is defined by %s (%s) and %s (%s)",
+			route.Route, first.Function, first.FilePath, route.Function, route.FilePath))
+	}
+
+	if len(duplicates) == 0 {
+		return routes, nil
+	}
+	if !warn {
+		return nil, fmt.Errorf("duplicate handler routes:\n  %s", strings.Join(duplicates, "\n  "))
+	}
+
+	for _, duplicate := range duplicates {
+		log.Printf("WARNING: Duplicate route, keeping the first handler: %s", duplicate)
+	}
+	return unique, nil
+}
@@ -0,0 +1,103 @@
+package routebuilder
+
+import (
+	"strings"
+)
+
+// HandlerParam is a parameter of an htmx_ handler with its type hint.
+// Parameters after the first (the request data) are filled from request
+// values of the same name.
+type HandlerParam struct {
+	Name     string `json:"name"`
+	Type     string `json:"type,omitempty"`
+	Default  string `json:"default,omitempty"`
+	Required bool   `json:"required"`
+}
+
+// splitParams splits a Python parameter list on top-level commas, keeping
+// commas inside brackets and strings such as Dict[str, int] intact
+func splitParams(paramStr string) []string {
+	var parts []string
+	depth := 0
+	var quote rune
+	start := 0
+
+	for i, r := range paramStr {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '[' || r == '(' || r == '{':
+			depth++
+		case r == ']' || r == ')' || r == '}':
+			depth--
+		case r == ',' && depth == 0:
+			parts = append(parts, paramStr[start:i])
+			start = i + 1
+		}
+	}
+	parts = append(parts, paramStr[start:])
+	return parts
+}
+
+// parseHandlerParams parses "request, q: str, page: int = 1" into typed
+// parameters, skipping self, *args and **kwargs
+func parseHandlerParams(paramStr string) []HandlerParam {
+	var params []HandlerParam
+	for _, part := range splitParams(paramStr) {
+		part = strings.TrimSpace(part)
+		if part == "" || part == "self" || part == "/" || part == "*" || strings.HasPrefix(part, "*") {
+			continue
+		}
+
+		param := HandlerParam{Required: true}
+		if name, def, ok := cutTopLevel(part, '='); ok {
+			part = strings.TrimSpace(name)
+			param.Default = strings.TrimSpace(def)
+			param.Required = false
+		}
+		if name, typ, ok := cutTopLevel(part, ':'); ok {
+			param.Name = strings.TrimSpace(name)
+			param.Type = strings.TrimSpace(typ)
+		} else {
+			param.Name = part
+		}
+		params = append(params, param)
+	}
+	return params
+}
+
+// cutTopLevel splits s around the first sep outside brackets and strings
+func cutTopLevel(s string, sep rune) (string, string, bool) {
+	depth := 0
+	var quote rune
+	for i, r := range s {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '[' || r == '(' || r == '{':
+			depth++
+		case r == ']' || r == ')' || r == '}':
+			depth--
+		case r == sep && depth == 0:
+			return s[:i], s[i+1:], true
+		}
+	}
+	return s, "", false
+}
+
+// requestFields returns the parameters filled from request values, i.e.
+// every parameter after the request data itself
+func requestFields(params []HandlerParam) []HandlerParam {
+	if len(params) <= 1 {
+		return nil
+	}
+	return params[1:]
+}
@@ -0,0 +1,315 @@
+package routebuilder
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// HAR captures keep at most maxHAREntries exchanges and maxHARBody bytes
+// of each body, so a forgotten capture can't use up memory
+const (
+	maxHAREntries = 1000
+	maxHARBody    = 64 << 10
+)
+
+// HARRecorder records proxied requests and their responses while a capture
+// runs, for export as a HAR file that browsers' dev tools and HTTP clients
+// can open and replay. Credentials are masked like in the proxy log.
+type HARRecorder struct {
+	mu        sync.Mutex
+	recording bool
+	started   time.Time
+	stopped   time.Time
+	entries   []harEntry
+	dropped   int // Exchanges not kept once maxHAREntries was reached
+}
+
+// NewHARRecorder creates a recorder that isn't capturing yet
+func NewHARRecorder() *HARRecorder {
+	return &HARRecorder{}
+}
+
+// Start discards the previous capture and records from now on
+func (h *HARRecorder) Start() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.recording = true
+	h.started = time.Now()
+	h.stopped = time.Time{}
+	h.entries = nil
+	h.dropped = 0
+}
+
+// Stop ends the capture, keeping it for HAR
+func (h *HARRecorder) Stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.recording {
+		h.recording = false
+		h.stopped = time.Now()
+	}
+}
+
+// HARStatus describes the current or last capture
+type HARStatus struct {
+	Recording bool       `json:"recording"`
+	Started   *time.Time `json:"started,omitempty"`
+	Stopped   *time.Time `json:"stopped,omitempty"`
+	Entries   int        `json:"entries"`
+	Dropped   int        `json:"dropped,omitempty"`
+}
+
+// Status reports whether a capture runs and how much it holds
+func (h *HARRecorder) Status() HARStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status := HARStatus{Recording: h.recording, Entries: len(h.entries), Dropped: h.dropped}
+	if !h.started.IsZero() {
+		started := h.started
+		status.Started = &started
+	}
+	if !h.stopped.IsZero() {
+		stopped := h.stopped
+		status.Stopped = &stopped
+	}
+	return status
+}
+
+// HAR returns the current or last capture as a HAR 1.2 document
+func (h *HARRecorder) HAR() ([]byte, error) {
+	h.mu.Lock()
+	entries := append([]harEntry{}, h.entries...)
+	h.mu.Unlock()
+
+	return json.MarshalIndent(map[string]any{
+		"log": map[string]any{
+			"version": "1.2",
+			"creator": map[string]string{"name": "htmlnojs", "version": "1.0"},
+			"entries": entries,
+		},
+	}, "", "  ")
+}
+
+func (h *HARRecorder) recordingNow() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.recording
+}
+
+func (h *HARRecorder) add(entry harEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	// Exchanges started before a restart belong to the discarded capture;
+	// those still running at Stop are kept
+	if entry.started.Before(h.started) {
+		return
+	}
+	if len(h.entries) >= maxHAREntries {
+		h.dropped++
+		return
+	}
+	h.entries = append(h.entries, entry)
+}
+
+// The parts of the HAR 1.2 format htmlnojs fills in
+type (
+	harEntry struct {
+		started         time.Time
+		StartedDateTime string         `json:"startedDateTime"`
+		Time            float64        `json:"time"`
+		Request         harRequest     `json:"request"`
+		Response        harResponse    `json:"response"`
+		Cache           struct{}       `json:"cache"`
+		Timings         map[string]any `json:"timings"`
+	}
+	harRequest struct {
+		Method      string         `json:"method"`
+		URL         string         `json:"url"`
+		HTTPVersion string         `json:"httpVersion"`
+		Headers     []harNameValue `json:"headers"`
+		QueryString []harNameValue `json:"queryString"`
+		Cookies     []harNameValue `json:"cookies"`
+		HeadersSize int            `json:"headersSize"`
+		BodySize    int            `json:"bodySize"`
+		PostData    *harPostData   `json:"postData,omitempty"`
+	}
+	harResponse struct {
+		Status      int            `json:"status"`
+		StatusText  string         `json:"statusText"`
+		HTTPVersion string         `json:"httpVersion"`
+		Headers     []harNameValue `json:"headers"`
+		Cookies     []harNameValue `json:"cookies"`
+		Content     harContent     `json:"content"`
+		RedirectURL string         `json:"redirectURL"`
+		HeadersSize int            `json:"headersSize"`
+		BodySize    int            `json:"bodySize"`
+	}
+	harNameValue struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	harPostData struct {
+		MimeType string `json:"mimeType"`
+		Text     string `json:"text"`
+		Comment  string `json:"comment,omitempty"`
+	}
+	harContent struct {
+		Size     int    `json:"size"`
+		MimeType string `json:"mimeType"`
+		Text     string `json:"text,omitempty"`
+		Encoding string `json:"encoding,omitempty"`
+		Comment  string `json:"comment,omitempty"`
+	}
+)
+
+// harTransport hands each exchange to recorder while it records. Bodies
+// are captured as the proxy streams them, and the exchange is added once
+// the response body is closed.
+type harTransport struct {
+	base     http.RoundTripper
+	recorder *HARRecorder
+	secrets  []string // Headers masked besides the usual credentials
+}
+
+func (t *harTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.recorder.recordingNow() {
+		return t.base.RoundTrip(req)
+	}
+
+	start := time.Now()
+	var reqBody *capturingBody
+	if req.Body != nil && req.Body != http.NoBody {
+		// RoundTrippers must not modify the caller's request
+		req = req.Clone(req.Context())
+		reqBody = &capturingBody{ReadCloser: req.Body, limit: maxHARBody}
+		req.Body = reqBody
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode == http.StatusSwitchingProtocols {
+		// Failed and upgraded exchanges have no response body to record
+		return resp, err
+	}
+	wait := time.Since(start)
+
+	respBody := &capturingBody{ReadCloser: resp.Body, limit: maxHARBody}
+	respBody.onClose = func() {
+		entry := harEntry{
+			started:         start,
+			StartedDateTime: start.UTC().Format(time.RFC3339Nano),
+			Time:            durationMs(time.Since(start)),
+			Request:         t.harRequest(req, reqBody),
+			Response:        t.harResponse(resp, respBody),
+			Timings: map[string]any{
+				"send":    0,
+				"wait":    durationMs(wait),
+				"receive": durationMs(time.Since(start) - wait),
+			},
+		}
+		t.recorder.add(entry)
+	}
+	resp.Body = respBody
+	return resp, nil
+}
+
+func (t *harTransport) harRequest(req *http.Request, body *capturingBody) harRequest {
+	out := harRequest{
+		Method:      req.Method,
+		URL:         redactURL(req.URL.String()),
+		HTTPVersion: req.Proto,
+		Headers:     t.headers(req.Header),
+		QueryString: []harNameValue{},
+		Cookies:     []harNameValue{},
+		HeadersSize: -1,
+	}
+	if out.HTTPVersion == "" {
+		out.HTTPVersion = "HTTP/1.1"
+	}
+	for _, pair := range strings.Split(req.URL.RawQuery, "&") {
+		name, value, _ := strings.Cut(pair, "=")
+		if name == "" {
+			continue
+		}
+		name, _ = url.QueryUnescape(name)
+		value, _ = url.QueryUnescape(value)
+		if redactedField(name) {
+			value = "[redacted]"
+		}
+		out.QueryString = append(out.QueryString, harNameValue{Name: name, Value: value})
+	}
+	if body != nil {
+		head, total := body.snapshot()
+		out.BodySize = total
+		out.PostData = &harPostData{MimeType: req.Header.Get("Content-Type"), Text: string(head)}
+		if !utf8.Valid(head) {
+			// HAR has no encoding for request bodies
+			out.PostData.Text = base64.StdEncoding.EncodeToString(head)
+			out.PostData.Comment = "base64"
+		}
+		if len(head) < total {
+			out.PostData.Comment = strings.TrimPrefix(out.PostData.Comment+", truncated", ", ")
+		}
+	}
+	return out
+}
+
+func (t *harTransport) harResponse(resp *http.Response, body *capturingBody) harResponse {
+	head, total := body.snapshot()
+	out := harResponse{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: resp.Proto,
+		Headers:     t.headers(resp.Header),
+		Cookies:     []harNameValue{},
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    total,
+		Content:     harContent{Size: total, MimeType: resp.Header.Get("Content-Type")},
+	}
+	if mediaType, _, err := mime.ParseMediaType(out.Content.MimeType); err == nil && utf8.Valid(head) && !strings.HasPrefix(mediaType, "image/") {
+		out.Content.Text = string(head)
+	} else {
+		out.Content.Text = base64.StdEncoding.EncodeToString(head)
+		out.Content.Encoding = "base64"
+	}
+	if len(head) < total {
+		out.Content.Comment = "truncated"
+	}
+	return out
+}
+
+// headers lists header in name order, masking credentials
+func (t *harTransport) headers(header http.Header) []harNameValue {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := []harNameValue{}
+	for _, name := range names {
+		masked := slices.ContainsFunc(redactedHeaders, func(secret string) bool { return strings.EqualFold(name, secret) }) ||
+			slices.ContainsFunc(t.secrets, func(secret string) bool { return strings.EqualFold(name, secret) })
+		for _, value := range header[name] {
+			if masked {
+				value = "[redacted]"
+			}
+			out = append(out, harNameValue{Name: name, Value: value})
+		}
+	}
+	return out
+}
+
+// durationMs converts d to the fractional milliseconds HAR uses
+func durationMs(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
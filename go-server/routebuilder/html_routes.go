@@ -2,10 +2,13 @@ package routebuilder
 
 import (
 	"fmt"
+	"log"
 	"net/http"
-	"os"
+	"net/url"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 )
 
 type HTMLRoute struct {
@@ -17,13 +20,42 @@ type HTMLRoute struct {
 	Template     string
 	CSSFiles     []string
 	RequiresAuth bool
+	Roles        []string // Any one of them lets a client in, from *_admin or front-matter
+	Owner        string   // Team charged for this route, from front-matter or config
 	Metadata     map[string]interface{}
 }
 
+// TemplateData is the data available to templates while rendering
+type TemplateData struct {
+	Route string
+	Path  string
+	Query url.Values
+	HTMX  bool
+	Meta  FrontMatter
+	Data  interface{} // Decoded JSON response, for fragment templates
+
+	// From htmx's request headers, empty for other clients
+	Boosted     bool   // Sent by an hx-boost link or form
+	Target      string // id of the element the response is swapped into
+	Trigger     string // id of the element that sent the request
+	TriggerName string // name of the element that sent the request
+	CurrentURL  string // URL of the page the request came from
+
+	CSRFToken string  // Sent back by state-changing requests, empty when CSRF protection is off
+	Flashes   []Flash // One-time messages left for this page, e.g. by a form that redirected here
+
+	nonces NonceIssuer // Backs Nonce and NonceField
+}
+
 type HTMLRouteBuilder struct {
 	templatesDir string
 	cssFiles     []string
 	routes       []HTMLRoute
+	engine       TemplateEngine
+	cssRoutes    map[string]CSSRoute // By file path, for URLs and scopes
+	criticalCSS  int                 // Elements treated as above the fold, 0 disables inlining
+	fontPreload  map[string]FontRoute // By route, nil disables preloading
+	fullPages    bool                 // Serve htmx requests whole documents too
 }
 
 // NewHTMLRouteBuilder creates a new HTML route builder
@@ -32,6 +64,41 @@ func NewHTMLRouteBuilder(templatesDir string, cssFiles []string) *HTMLRouteBuild
 		templatesDir: templatesDir,
 		cssFiles:     cssFiles,
 		routes:       make([]HTMLRoute, 0),
+		engine:       &goTemplateEngine{},
+	}
+}
+
+// SetTemplateEngine sets the engine used to render templates
+func (h *HTMLRouteBuilder) SetTemplateEngine(engine TemplateEngine) {
+	h.engine = engine
+}
+
+// SetCSSRoutes tells the builder where each CSS file is served and which
+// ones are scoped to the templates including them
+func (h *HTMLRouteBuilder) SetCSSRoutes(routes []CSSRoute) {
+	h.cssRoutes = make(map[string]CSSRoute, len(routes))
+	for _, route := range routes {
+		h.cssRoutes[route.FilePath] = route
+	}
+}
+
+// SetCriticalCSS inlines the CSS rules styling the first elements of each
+// page and moves the full stylesheets to the end of <body>
+func (h *HTMLRouteBuilder) SetCriticalCSS(elements int) {
+	h.criticalCSS = elements
+}
+
+// SetFullPages serves htmx requests for pages the whole document rather
+// than the content of its <body>
+func (h *HTMLRouteBuilder) SetFullPages() {
+	h.fullPages = true
+}
+
+// SetFontPreloads preloads the fonts each page's stylesheets load
+func (h *HTMLRouteBuilder) SetFontPreloads(fonts []FontRoute) {
+	h.fontPreload = make(map[string]FontRoute, len(fonts))
+	for _, font := range fonts {
+		h.fontPreload[font.Route] = font
 	}
 }
 
@@ -66,6 +133,7 @@ func (h *HTMLRouteBuilder) buildHTMLRoute(filePath string) (HTMLRoute, error) {
 	// Check for special route patterns
 	method := "GET"
 	requiresAuth := false
+	var roles []string
 	metadata := make(map[string]interface{})
 
 	// Parse special naming conventions
@@ -76,6 +144,7 @@ func (h *HTMLRouteBuilder) buildHTMLRoute(filePath string) (HTMLRoute, error) {
 
 	if strings.Contains(name, "_admin") {
 		requiresAuth = true
+		roles = []string{AdminRole}
 		metadata["admin_required"] = true
 	}
 
@@ -84,18 +153,50 @@ func (h *HTMLRouteBuilder) buildHTMLRoute(filePath string) (HTMLRoute, error) {
 		metadata["is_api"] = true
 	}
 
+	// Front-matter can require auth and carries page metadata
+	owner := ""
+	var source []byte
+	if content, err := readProjectFile(filePath); err == nil {
+		parsed := parseFrontMatter(filePath, content)
+		source = parsed.Body
+		frontMatter := parsed.Values
+		if len(frontMatter) > 0 {
+			metadata["front_matter"] = frontMatter
+		}
+		owner, _ = frontMatter["owner"].(string)
+		if auth, ok := frontMatter["auth"].(bool); ok && auth {
+			requiresAuth = true
+			metadata["auth_required"] = true
+		}
+		if fmRoles := frontMatterRoles(frontMatter); len(fmRoles) > 0 {
+			requiresAuth = true
+			roles = fmRoles
+		}
+	}
+
 	// Determine CSS dependencies based on template name
 	cssFiles := h.determineCSSFiles(name)
 
+	// Critical CSS is computed once here, not per request
+	critical := ""
+	if h.criticalCSS > 0 && source != nil {
+		critical = criticalCSS(source, cssFiles, h.cssRoutes, h.criticalCSS)
+		if critical != "" {
+			metadata["critical_css_bytes"] = len(critical)
+		}
+	}
+
 	route := HTMLRoute{
 		Name:         name,
 		FilePath:     filePath,
 		Route:        routePath,
 		Method:       method,
-		Handler:      h.createTemplateHandler(filePath, cssFiles),
+		Handler:      h.createTemplateHandler(routePath, filePath, cssFiles, critical),
 		Template:     filePath,
 		CSSFiles:     cssFiles,
 		RequiresAuth: requiresAuth,
+		Roles:        roles,
+		Owner:        owner,
 		Metadata:     metadata,
 	}
 
@@ -110,10 +211,7 @@ func (h *HTMLRouteBuilder) determineCSSFiles(templateName string) []string {
 		cssName := strings.ToLower(filepath.Base(cssFile))
 
 		// Include global CSS files
-		if strings.Contains(cssName, "global") ||
-		   strings.Contains(cssName, "main") ||
-		   strings.Contains(cssName, "reset") ||
-		   strings.Contains(cssName, "variables") {
+		if isGlobalCSS(cssFile) {
 			relevantCSS = append(relevantCSS, cssFile)
 		}
 
@@ -137,21 +235,59 @@ func (h *HTMLRouteBuilder) determineCSSFiles(templateName string) []string {
 	return relevantCSS
 }
 
-func (h *HTMLRouteBuilder) createTemplateHandler(templatePath string, cssFiles []string) http.HandlerFunc {
+func (h *HTMLRouteBuilder) createTemplateHandler(routePath, templatePath string, cssFiles []string, critical string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
 		// Read the HTML template file
-		content, err := os.ReadFile(templatePath)
+		content, err := readProjectFile(templatePath)
 		if err != nil {
 			http.Error(w, "Template not found", http.StatusNotFound)
 			return
 		}
 
+		// Strip front-matter so it is not rendered as page text
+		frontMatter := parseFrontMatter(templatePath, content)
+
+		// Execute the template with the project's template engine
+		data := newTemplateData(routePath, r)
+		data.Meta = frontMatter.Values
+		rendered, err := h.engine.Render(templatePath, frontMatter.Body, data)
+		if err != nil {
+			log.Printf("ERROR: Failed to render template %s: %v", templatePath, err)
+			http.Error(w, "Template render failed", http.StatusInternalServerError)
+			return
+		}
+
 		// Convert to string for processing
-		html := string(content)
+		html := injectCSRFToken(string(rendered), data.CSRFToken)
+
+		// htmx swaps the page into part of the current one, which already
+		// has the <head>; only the content of <body> belongs there
+		if !h.fullPages {
+			addVary(w.Header(), "HX-Request")
+			if wantsPageContent(r) {
+				html = addScopeAttribute(pageContent(html), h.cssScopes(cssFiles))
+				addServerTiming(w.Header(), "render", time.Since(start))
+				w.Header().Set("Content-Type", "text/html; charset=utf-8")
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte(html))
+				return
+			}
+		}
+
+		// Scoped component CSS only applies inside this template's root
+		html = addScopeAttribute(html, h.cssScopes(cssFiles))
 
 		// Inject CSS files into the head section
 		cssLinks := h.generateCSSLinks(cssFiles)
-		if cssLinks != "" {
+		if critical != "" && strings.Contains(html, "<head>") && strings.Contains(html, "</body>") {
+			// Inline what the first screen needs; the full stylesheets at the
+			// end of <body> no longer block rendering the content above them
+			html = strings.Replace(html, "<head>", "<head>\n    <style data-critical-css>"+critical+"</style>", 1)
+			i := strings.LastIndex(html, "</body>")
+			html = html[:i] + cssLinks + "\n" + html[i:]
+		} else if cssLinks != "" {
 			// Try to inject after <head> tag
 			if strings.Contains(html, "<head>") {
 				html = strings.Replace(html, "<head>", "<head>\n    "+cssLinks, 1)
@@ -164,7 +300,14 @@ func (h *HTMLRouteBuilder) createTemplateHandler(templatePath string, cssFiles [
 			}
 		}
 
+		// Fonts only show once their stylesheet is parsed; preloading starts
+		// the download right away. Fragments land in a page that has them.
+		if preloads := h.generateFontPreloads(cssFiles); preloads != "" && strings.Contains(html, "<head>") {
+			html = strings.Replace(html, "<head>", "<head>\n    "+preloads, 1)
+		}
+
 		// Set content type and serve
+		addServerTiming(w.Header(), "render", time.Since(start))
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(html))
@@ -181,12 +324,42 @@ func (h *HTMLRouteBuilder) generateCSSLinks(cssFiles []string) string {
 		// Convert file path to URL path
 		cssName := filepath.Base(cssFile)
 		cssURL := "/css/" + cssName
+		if route, ok := h.cssRoutes[cssFile]; ok {
+			cssURL = route.Route
+		}
 		links = append(links, fmt.Sprintf(`<link rel="stylesheet" href="%s">`, cssURL))
 	}
 
 	return strings.Join(links, "\n    ")
 }
 
+// generateFontPreloads links the fonts loaded by cssFiles for preloading
+func (h *HTMLRouteBuilder) generateFontPreloads(cssFiles []string) string {
+	var links, seen []string
+	for _, cssFile := range cssFiles {
+		for _, fontRoute := range h.cssRoutes[cssFile].Fonts {
+			font, ok := h.fontPreload[fontRoute]
+			if !ok || slices.Contains(seen, fontRoute) {
+				continue
+			}
+			seen = append(seen, fontRoute)
+			links = append(links, fmt.Sprintf(`<link rel="preload" href="%s" as="font" type="%s" crossorigin>`, font.Route, font.MimeType))
+		}
+	}
+	return strings.Join(links, "\n    ")
+}
+
+// cssScopes returns the scopes of the scoped CSS files among cssFiles
+func (h *HTMLRouteBuilder) cssScopes(cssFiles []string) []string {
+	var scopes []string
+	for _, cssFile := range cssFiles {
+		if scope := h.cssRoutes[cssFile].Scope; scope != "" && !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
 // GetRoutes returns all built routes
 func (h *HTMLRouteBuilder) GetRoutes() []HTMLRoute {
 	return h.routes
@@ -0,0 +1,172 @@
+package routebuilder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// fragmentPageScript loads htmx in the page fragments are opened in, so
+// their hx- attributes keep working
+const fragmentPageScript = "https://unpkg.com/htmx.org@1.9.10"
+
+var (
+	bodyOpenRegex  = regexp.MustCompile(`(?i)<body\b[^>]*>`)
+	bodyCloseRegex = regexp.MustCompile(`(?i)</body\s*>`)
+	documentRegex  = regexp.MustCompile(`(?is)^\s*(<!--.*?-->\s*)*(<!doctype\b|<html\b)`)
+)
+
+// newTemplateData returns the data about r that templates of route see,
+// including what htmx tells about the element that sent it
+func newTemplateData(route string, r *http.Request) TemplateData {
+	return TemplateData{
+		Route:       route,
+		Path:        r.URL.Path,
+		Query:       r.URL.Query(),
+		HTMX:        r.Header.Get("HX-Request") == "true",
+		Boosted:     r.Header.Get("HX-Boosted") == "true",
+		Target:      r.Header.Get("HX-Target"),
+		Trigger:     r.Header.Get("HX-Trigger"),
+		TriggerName: r.Header.Get("HX-Trigger-Name"),
+		CurrentURL:  r.Header.Get("HX-Current-URL"),
+		CSRFToken:   csrfToken(r),
+		Flashes:     requestFlashes(r),
+		nonces:      requestNonceIssuer(r),
+	}
+}
+
+// wantsPageContent reports whether htmx asked for a page to swap into part
+// of the current one. Boosted links and history restores replace the whole
+// document, so they still get all of it.
+func wantsPageContent(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "true" &&
+		r.Header.Get("HX-Boosted") != "true" &&
+		r.Header.Get("HX-History-Restore-Request") != "true"
+}
+
+// pageContent returns what is inside the <body> of a rendered page, or the
+// page itself when it has no body
+func pageContent(page string) string {
+	open := bodyOpenRegex.FindStringIndex(page)
+	if open == nil {
+		return page
+	}
+	content := page[open[1]:]
+	if closes := bodyCloseRegex.FindAllStringIndex(content, -1); len(closes) > 0 {
+		content = content[:closes[len(closes)-1][0]]
+	}
+	return strings.TrimSpace(content)
+}
+
+// isGlobalCSS reports whether a stylesheet applies to every page, going by
+// its file name
+func isGlobalCSS(cssFile string) bool {
+	name := strings.ToLower(filepath.Base(cssFile))
+	return strings.Contains(name, "global") ||
+		strings.Contains(name, "main") ||
+		strings.Contains(name, "reset") ||
+		strings.Contains(name, "variables")
+}
+
+// fragmentPage is the page a handler route's fragments are shown in when
+// a browser opens the route itself
+type fragmentPage struct {
+	title       string
+	stylesheets string
+}
+
+// fragmentPageKey carries the fragment page of the route being served
+type fragmentPageKey struct{}
+
+// applyFragmentPages makes handler routes answer browsers that navigate
+// to them with their fragment inside a page, styled by the global CSS,
+// rather than a bare fragment
+func (a *AllRoutesBuilder) applyFragmentPages(routes []PythonRoute) {
+	if a.project.HTMX.BareFragments {
+		return
+	}
+	var links []string
+	for _, cssRoute := range a.Collection.CSSRoutes {
+		if isGlobalCSS(cssRoute.FilePath) {
+			links = append(links, fmt.Sprintf(`<link rel="stylesheet" href="%s">`, cssRoute.Route))
+		}
+	}
+	stylesheets := strings.Join(links, "\n    ")
+
+	for i, route := range routes {
+		page := &fragmentPage{title: route.Route, stylesheets: stylesheets}
+		handler := route.Handler
+		routes[i].Handler = func(w http.ResponseWriter, r *http.Request) {
+			handler(w, r.WithContext(context.WithValue(r.Context(), fragmentPageKey{}, page)))
+		}
+	}
+}
+
+// wantsFragmentPage reports whether r is a browser opening a URL, rather
+// than htmx or an API client fetching it
+func wantsFragmentPage(r *http.Request) bool {
+	return r.Method == http.MethodGet &&
+		r.Header.Get("HX-Request") != "true" &&
+		strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// wrapFragmentPage puts a successful HTML fragment inside its route's
+// fragment page when a browser navigated to it. Responses that already are
+// whole documents, encoded or too large pass through unchanged.
+func wrapFragmentPage(resp *http.Response) error {
+	page, _ := resp.Request.Context().Value(fragmentPageKey{}).(*fragmentPage)
+	if page == nil || resp.Request.Method != http.MethodGet {
+		return nil
+	}
+	addVary(resp.Header, "HX-Request")
+	if resp.StatusCode != http.StatusOK || !wantsFragmentPage(resp.Request) {
+		return nil
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/html" {
+		return nil
+	}
+	if resp.Header.Get("Content-Encoding") != "" || resp.ContentLength > maxTransformBody {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTransformBody+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxTransformBody || documentRegex.Match(body) {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return nil
+	}
+	resp.Body.Close()
+
+	wrapped := fmt.Sprintf(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>%s</title>
+    <script src="%s"></script>
+    %s
+</head>
+<body>
+%s
+</body>
+</html>
+`, html.EscapeString(page.title), fragmentPageScript, page.stylesheets, body)
+
+	resp.Body = io.NopCloser(strings.NewReader(wrapped))
+	resp.ContentLength = int64(len(wrapped))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(wrapped)))
+	return nil
+}
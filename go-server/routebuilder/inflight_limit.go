@@ -0,0 +1,54 @@
+package routebuilder
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultOverloadRetryAfter is how soon clients refused for overload are
+// told to try again
+const defaultOverloadRetryAfter = time.Second
+
+// applyInFlightLimit caps the requests handler routes proxy to backend
+// processes at once, across all routes. Requests over the cap are refused
+// with a 503 at once, so a single-process backend isn't buried under a
+// queue it can't work off. Go and script handlers run in the server and
+// aren't counted.
+func (a *AllRoutesBuilder) applyInFlightLimit(routes []PythonRoute) {
+	limit := a.project.Proxy.MaxInFlight
+	if limit <= 0 {
+		return
+	}
+	retryAfter := defaultOverloadRetryAfter
+	if a.project.Proxy.OverloadRetryAfter != "" {
+		retryAfter, _ = time.ParseDuration(a.project.Proxy.OverloadRetryAfter) // validated when the config was loaded
+	}
+	retrySeconds := strconv.Itoa(max(int(retryAfter.Seconds()+0.999), 1))
+
+	slots := make(chan struct{}, limit)
+	for i, route := range routes {
+		if route.Language != DefaultHandlerLanguage && route.Language != NodeHandlerLanguage {
+			continue
+		}
+		handler, function := route.Handler, route.Function
+		routes[i].Handler = func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case slots <- struct{}{}:
+			default:
+				log.Printf("WARNING: Refused %s %s, %d handler requests already in flight", r.Method, r.URL.Path, limit)
+				w.Header().Set("Retry-After", retrySeconds)
+				WriteFragment(w, http.StatusServiceUnavailable, ErrorFragment(
+					"Busy Right Now",
+					function+" has more requests than it can take at the moment; try again in a few seconds",
+					"",
+				))
+				return
+			}
+			defer func() { <-slots }()
+			handler(w, r)
+		}
+	}
+	log.Printf("Limiting handler backends to %d requests in flight", limit)
+}
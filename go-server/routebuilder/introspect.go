@@ -0,0 +1,208 @@
+package routebuilder
+
+// Introspection is the full metadata of every discovered route, served at
+// /_introspect for editors, code generators and dashboards
+type Introspection struct {
+	Summary      IntrospectionSummary `json:"summary"`
+	HTML         []HTMLRouteInfo      `json:"html_routes"`
+	CSS          []CSSRouteInfo       `json:"css_routes"`
+	Fonts        []FontRouteInfo      `json:"font_routes"`
+	Python       []PythonRouteInfo    `json:"python_routes"`
+	Dependencies map[string][]string  `json:"dependencies"`
+	CSSLoadOrder []string             `json:"css_load_order"`
+}
+
+// IntrospectionSummary counts the routes in the collection
+type IntrospectionSummary struct {
+	Total        int `json:"total"`
+	HTML         int `json:"html"`
+	CSS          int `json:"css"`
+	Fonts        int `json:"fonts"`
+	Python       int `json:"python"`
+	AuthRequired int `json:"auth_required"`
+	CacheEnabled int `json:"cache_enabled"`
+}
+
+// HTMLRouteInfo describes a template route
+type HTMLRouteInfo struct {
+	Name            string                 `json:"name"`
+	Route           string                 `json:"route"`
+	Method          string                 `json:"method"`
+	File            string                 `json:"file"`
+	CSSFiles        []string               `json:"css_files"`
+	RequiresAuth    bool                   `json:"requires_auth"`
+	Roles           []string               `json:"roles,omitempty"`
+	Owner           string                 `json:"owner,omitempty"`
+	FrontMatter     FrontMatter            `json:"front_matter,omitempty"`
+	APIDependencies []string               `json:"api_dependencies,omitempty"`
+	Metadata        map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// CSSRouteInfo describes a stylesheet route
+type CSSRouteInfo struct {
+	Name         string                 `json:"name"`
+	Route        string                 `json:"route"`
+	File         string                 `json:"file"`
+	Category     string                 `json:"category"`
+	LoadOrder    int                    `json:"load_order"`
+	Minified     bool                   `json:"minified"`
+	Dependencies []string               `json:"dependencies,omitempty"`
+	MediaQuery   string                 `json:"media_query,omitempty"`
+	Scope        string                 `json:"scope,omitempty"`
+	Metadata     map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// FontRouteInfo describes a fonts/ file route
+type FontRouteInfo struct {
+	Name     string                 `json:"name"`
+	Route    string                 `json:"route"`
+	File     string                 `json:"file"`
+	Format   string                 `json:"format"`
+	Subset   bool                   `json:"subset"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// PythonRouteInfo describes an htmx_ handler route
+type PythonRouteInfo struct {
+	Name           string                 `json:"name"`
+	Language       string                 `json:"language"`
+	Route          string                 `json:"route"`
+	Method         string                 `json:"method"`
+	File           string                 `json:"file"`
+	Module         string                 `json:"module,omitempty"`
+	Function       string                 `json:"function"`
+	FastAPIPath    string                 `json:"fastapi_path,omitempty"`
+	Parameters     []HandlerParam         `json:"parameters"`
+	ReturnType     string                 `json:"return_type,omitempty"`
+	Docstring      string                 `json:"docstring,omitempty"`
+	RequiresAuth   bool                   `json:"requires_auth"`
+	Roles          []string               `json:"roles,omitempty"`
+	RateLimit      int                    `json:"rate_limit,omitempty"`
+	CacheTimeout   int                    `json:"cache_timeout,omitempty"`
+	CacheStale     int                    `json:"cache_stale,omitempty"`
+	TimeoutSeconds int                    `json:"timeout_seconds,omitempty"`
+	MaxBody        int64                  `json:"max_body,omitempty"`
+	MaxFile        int64                  `json:"max_file,omitempty"`
+	Deprecation    *DeprecationInfo       `json:"deprecation,omitempty"`
+	APIVersion     string                 `json:"api_version,omitempty"`
+	AliasOf        string                 `json:"alias_of,omitempty"`
+	DemoSafe       bool                   `json:"demo_safe,omitempty"`
+	CSRFExempt     bool                   `json:"csrf_exempt,omitempty"`
+	Nonce          bool                   `json:"nonce,omitempty"`
+	Owner          string                 `json:"owner,omitempty"`
+	Metadata       map[string]interface{} `json:"metadata,omitempty"`
+}
+
+// DeprecationInfo is the JSON form of a Deprecation
+type DeprecationInfo struct {
+	Message   string `json:"message,omitempty"`
+	Sunset    string `json:"sunset,omitempty"`
+	Successor string `json:"successor,omitempty"`
+}
+
+// Introspect collects the metadata of every route in the collection
+func Introspect(routes *RouteCollection) *Introspection {
+	out := &Introspection{
+		Summary: IntrospectionSummary{
+			Total:        routes.Metadata.TotalRoutes,
+			HTML:         routes.Metadata.HTMLCount,
+			CSS:          routes.Metadata.CSSCount,
+			Fonts:        routes.Metadata.FontCount,
+			Python:       routes.Metadata.PythonCount,
+			AuthRequired: routes.Metadata.AuthRequired,
+			CacheEnabled: routes.Metadata.CacheEnabled,
+		},
+		HTML:         make([]HTMLRouteInfo, 0, len(routes.HTMLRoutes)),
+		CSS:          make([]CSSRouteInfo, 0, len(routes.CSSRoutes)),
+		Fonts:        make([]FontRouteInfo, 0, len(routes.FontRoutes)),
+		Python:       make([]PythonRouteInfo, 0, len(routes.PythonRoutes)),
+		Dependencies: routes.Metadata.Dependencies,
+		CSSLoadOrder: routes.Metadata.LoadOrder,
+	}
+
+	for _, route := range routes.HTMLRoutes {
+		info := HTMLRouteInfo{
+			Name:         route.Name,
+			Route:        route.Route,
+			Method:       route.Method,
+			File:         route.FilePath,
+			CSSFiles:     route.CSSFiles,
+			RequiresAuth: route.RequiresAuth,
+			Roles:        route.Roles,
+			Owner:        route.Owner,
+			Metadata:     route.Metadata,
+		}
+		info.FrontMatter, _ = route.Metadata["front_matter"].(FrontMatter)
+		info.APIDependencies, _ = route.Metadata["api_dependencies"].([]string)
+		out.HTML = append(out.HTML, info)
+	}
+
+	for _, route := range routes.CSSRoutes {
+		out.CSS = append(out.CSS, CSSRouteInfo{
+			Name:         route.Name,
+			Route:        route.Route,
+			File:         route.FilePath,
+			Category:     route.Category,
+			LoadOrder:    route.LoadOrder,
+			Minified:     route.Minified,
+			Dependencies: route.Dependencies,
+			MediaQuery:   route.MediaQuery,
+			Scope:        route.Scope,
+			Metadata:     route.Metadata,
+		})
+	}
+
+	for _, route := range routes.FontRoutes {
+		out.Fonts = append(out.Fonts, FontRouteInfo{
+			Name:     route.Name,
+			Route:    route.Route,
+			File:     route.FilePath,
+			Format:   route.Format,
+			Subset:   route.Subset,
+			Metadata: route.Metadata,
+		})
+	}
+
+	for _, route := range routes.PythonRoutes {
+		info := PythonRouteInfo{
+			Name:           route.Name,
+			Language:       route.Language,
+			Route:          route.Route,
+			Method:         route.Method,
+			File:           route.FilePath,
+			Function:       route.Function,
+			Parameters:     route.Params,
+			ReturnType:     route.ReturnType,
+			Docstring:      route.Documentation,
+			RequiresAuth:   route.RequiresAuth,
+			Roles:          route.Roles,
+			RateLimit:      route.RateLimit,
+			CacheTimeout:   route.CacheTimeout,
+			CacheStale:     route.CacheStale,
+			TimeoutSeconds: route.Timeout,
+			MaxBody:        route.MaxBody,
+			MaxFile:        route.MaxFile,
+			APIVersion:     route.APIVersion,
+			AliasOf:        route.AliasOf,
+			DemoSafe:       route.DemoSafe,
+			CSRFExempt:     route.CSRFExempt,
+			Nonce:          route.Nonce,
+			Owner:          route.Owner,
+			Metadata:       route.Metadata,
+		}
+		if info.Parameters == nil {
+			info.Parameters = []HandlerParam{}
+		}
+		info.Module, _ = route.Metadata["base_path"].(string)
+		info.FastAPIPath, _ = route.Metadata["fastapi_path"].(string)
+		if d := route.Deprecation; d != nil {
+			info.Deprecation = &DeprecationInfo{Message: d.Message, Successor: d.Successor}
+			if !d.Sunset.IsZero() {
+				info.Deprecation.Sunset = d.Sunset.Format("2006-01-02")
+			}
+		}
+		out.Python = append(out.Python, info)
+	}
+
+	return out
+}
@@ -0,0 +1,184 @@
+package routebuilder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fragmentTemplatesDir holds the templates that render JSON handler
+// responses as HTML, inside the templates directory
+const fragmentTemplatesDir = "_fragments"
+
+// jsonFragment is the template rendering a route's JSON responses
+type jsonFragment struct {
+	route  string
+	path   string
+	engine TemplateEngine
+}
+
+// jsonFragmentKey carries the fragment template of the route being served
+type jsonFragmentKey struct{}
+
+// fragmentTemplatePath returns the template rendering the JSON responses of
+// route, e.g. templates/_fragments/orders/list.html for /api/orders/list
+func fragmentTemplatePath(templatesDir, route string) string {
+	name := strings.TrimPrefix(route, "/api/")
+	return filepath.Join(templatesDir, fragmentTemplatesDir, filepath.FromSlash(name)+".html")
+}
+
+// applyJSONFragments makes handler routes that have a fragment template
+// render their JSON responses through it with the project's template engine
+func (a *AllRoutesBuilder) applyJSONFragments(routes []PythonRoute) error {
+	var engine TemplateEngine
+	count := 0
+	for i, route := range routes {
+		// Default-version aliases share their versioned route's template
+		name := route.Route
+		if route.AliasOf != "" {
+			name = route.AliasOf
+		}
+		path := fragmentTemplatePath(a.templatesDir, name)
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if engine == nil {
+			var err error
+			if engine, err = NewTemplateEngine(a.project.TemplateEngine, a.templatesDir); err != nil {
+				return err
+			}
+		}
+
+		fragment := &jsonFragment{route: route.Route, path: path, engine: engine}
+		if routes[i].Metadata == nil {
+			routes[i].Metadata = map[string]interface{}{}
+		}
+		routes[i].Metadata["fragment_template"] = path
+		handler := route.Handler
+		routes[i].Handler = func(w http.ResponseWriter, r *http.Request) {
+			handler(w, r.WithContext(context.WithValue(r.Context(), jsonFragmentKey{}, fragment)))
+		}
+		count++
+	}
+	if count > 0 {
+		log.Printf("Rendering JSON responses of %d handler routes with %s templates", count, fragmentTemplatesDir)
+	}
+	return nil
+}
+
+// hasJSONFragment reports whether the route serving ctx renders JSON
+func hasJSONFragment(ctx context.Context) bool {
+	_, ok := ctx.Value(jsonFragmentKey{}).(*jsonFragment)
+	return ok
+}
+
+// wantsJSON reports whether a client asked for the JSON itself, e.g. an
+// API client sending Accept: application/json rather than htmx or a browser
+func wantsJSON(r *http.Request) bool {
+	if r.Header.Get("HX-Request") == "true" {
+		return false
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+// renderJSONFragment replaces a successful JSON response with its route's
+// fragment template rendered over the decoded body. Other responses, and
+// requests asking for JSON, pass through unchanged.
+func renderJSONFragment(resp *http.Response) error {
+	fragment, _ := resp.Request.Context().Value(jsonFragmentKey{}).(*jsonFragment)
+	if fragment == nil {
+		return nil
+	}
+	addVary(resp.Header, "Accept")
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 || resp.StatusCode == http.StatusNoContent || wantsJSON(resp.Request) {
+		return nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return nil
+	}
+	if resp.Header.Get("Content-Encoding") != "" || resp.ContentLength > maxTransformBody {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTransformBody+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxTransformBody {
+		// Too large to render, pass it on as it came
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return nil
+	}
+	resp.Body.Close()
+
+	start := time.Now()
+	rendered, err := fragment.render(resp.Request, body)
+	addServerTiming(resp.Header, "render", time.Since(start))
+	if err != nil {
+		log.Printf("ERROR: Failed to render fragment template %s: %v", fragment.path, err)
+		resp.StatusCode = http.StatusInternalServerError
+		resp.Status = "500 Internal Server Error"
+		rendered = []byte(ErrorFragment("Template Error",
+			"The response could not be rendered", filepath.Base(fragment.path)))
+	}
+
+	// The backend's ETag describes the JSON, not the markup
+	resp.Header.Del("ETag")
+	resp.Header.Set("Content-Type", "text/html; charset=utf-8")
+	resp.Body = io.NopCloser(bytes.NewReader(rendered))
+	resp.ContentLength = int64(len(rendered))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(rendered)))
+	return nil
+}
+
+// render executes the fragment template with the decoded JSON as .Data
+func (f *jsonFragment) render(r *http.Request, body []byte) ([]byte, error) {
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+	data = wholeNumbersAsInts(data)
+	// Read on every request, like page templates, so edits show up at once
+	source, err := readProjectFile(f.path)
+	if err != nil {
+		return nil, err
+	}
+	templateData := newTemplateData(f.route, r)
+	templateData.Data = data
+	return f.engine.Render(f.path, source, templateData)
+}
+
+// wholeNumbersAsInts turns JSON numbers without a fraction into ints, so
+// templates print ids and counts as 3 rather than 3.000000
+func wholeNumbersAsInts(value interface{}) interface{} {
+	switch v := value.(type) {
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			return int(v)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = wholeNumbersAsInts(v[i])
+		}
+	case map[string]interface{}:
+		for key := range v {
+			v[key] = wholeNumbersAsInts(v[key])
+		}
+	}
+	return value
+}
@@ -0,0 +1,66 @@
+package routebuilder
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"htmlnojs/config"
+)
+
+// LintIssue describes a problem found in a project file
+type LintIssue struct {
+	File    string
+	Line    int
+	Rule    string
+	Message string
+}
+
+func (i LintIssue) String() string {
+	if i.Line > 0 {
+		return fmt.Sprintf("%s:%d: [%s] %s", i.File, i.Line, i.Rule, i.Message)
+	}
+	return fmt.Sprintf("%s: [%s] %s", i.File, i.Rule, i.Message)
+}
+
+// LintTemplates runs the template lint rules against every HTML file
+func LintTemplates(project *config.ProjectConfig, htmlFiles []string) []LintIssue {
+	var issues []LintIssue
+
+	for _, filePath := range htmlFiles {
+		if !strings.HasSuffix(strings.ToLower(filePath), ".html") {
+			continue
+		}
+
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			issues = append(issues, LintIssue{File: filePath, Rule: "read", Message: err.Error()})
+			continue
+		}
+
+		issues = append(issues, LintTemplateSyntax(project.TemplateEngine, filePath, content)...)
+		issues = append(issues, LintFrontMatter(project, filePath, content)...)
+	}
+
+	SortLintIssues(issues)
+	return issues
+}
+
+// SortLintIssues orders issues by file and line
+func SortLintIssues(issues []LintIssue) {
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].File != issues[j].File {
+			return issues[i].File < issues[j].File
+		}
+		return issues[i].Line < issues[j].Line
+	})
+}
+
+// logLintIssues reports issues found during a route build
+func logLintIssues(issues []LintIssue) {
+	for _, issue := range issues {
+		log.Printf("WARNING: %s", issue)
+	}
+}
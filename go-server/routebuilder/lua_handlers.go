@@ -0,0 +1,188 @@
+package routebuilder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// LuaHandlerLanguage runs lua_htmx/*.lua handlers in-process
+const LuaHandlerLanguage = "lua"
+
+// luaAdapter serves lua_htmx/*.lua files. Each global htmx_ function takes
+// the request table and returns HTML, optionally followed by a status.
+// Comment lines right above a function are its documentation:
+//
+//	-- Items matching the filter @cache(30)
+//	function htmx_filter(request)
+//	  return "<ul>...</ul>"
+//	end
+type luaAdapter struct{}
+
+func (luaAdapter) Name() string { return LuaHandlerLanguage }
+
+func (luaAdapter) Dir() string { return "lua_htmx" }
+
+func (luaAdapter) InProcess() bool { return true }
+
+func (luaAdapter) Match(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".lua")
+}
+
+func (luaAdapter) BuildRoutes(opts HandlerAdapterOptions, files []string) ([]PythonRoute, error) {
+	return buildScriptRoutes(opts, files, loadLua)
+}
+
+var luaFunctionRegex = regexp.MustCompile(`(?m)^function\s+(htmx_\w+)\s*\(`)
+
+// luaDocs returns the comment block above each htmx_ function in source
+func luaDocs(source string) map[string]string {
+	docs := map[string]string{}
+	lines := strings.Split(source, "\n")
+	for i, line := range lines {
+		match := luaFunctionRegex.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		var comment []string
+		for j := i - 1; j >= 0; j-- {
+			trimmed := strings.TrimSpace(lines[j])
+			if !strings.HasPrefix(trimmed, "--") {
+				break
+			}
+			comment = append([]string{strings.TrimSpace(strings.TrimPrefix(trimmed, "--"))}, comment...)
+		}
+		docs[match[1]] = strings.Join(comment, " ")
+	}
+	return docs
+}
+
+// loadLua compiles a .lua file once and lists its htmx_ functions. Lua
+// states aren't safe for concurrent use, so each request runs the compiled
+// chunk in a fresh state.
+func loadLua(file, module string, source []byte) ([]GoHandler, error) {
+	chunk, err := parse.Parse(bytes.NewReader(source), file)
+	if err != nil {
+		return nil, err
+	}
+	proto, err := lua.Compile(chunk, file)
+	if err != nil {
+		return nil, err
+	}
+
+	L, err := newLuaState(context.Background(), proto)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	L.G.Global.ForEach(func(key, value lua.LValue) {
+		if name := key.String(); strings.HasPrefix(name, "htmx_") && value.Type() == lua.LTFunction {
+			names = append(names, name)
+		}
+	})
+	L.Close()
+	sort.Strings(names)
+
+	docs := luaDocs(string(source))
+	p := NewPythonRouteBuilder("")
+	var handlers []GoHandler
+	for _, name := range names {
+		handlers = append(handlers, GoHandler{
+			Module:   module,
+			Function: name,
+			Doc:      docs[name],
+			Handler:  scriptHandler(name, p.extractTimeout(docs[name]), luaFunc(proto, name)),
+		})
+	}
+	return handlers, nil
+}
+
+// newLuaState runs the compiled chunk in a sandbox without file or
+// process access: base, string, table and math, plus os.date/time/clock
+func newLuaState(ctx context.Context, proto *lua.FunctionProto) (*lua.LState, error) {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+		{lua.OsLibName, lua.OpenOs},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+
+	osLib := L.GetGlobal(lua.OsLibName).(*lua.LTable)
+	safeOS := L.NewTable()
+	for _, name := range []string{"date", "time", "clock"} {
+		safeOS.RawSetString(name, osLib.RawGetString(name))
+	}
+	L.SetGlobal(lua.OsLibName, safeOS)
+	for _, name := range []string{"dofile", "loadfile"} {
+		L.SetGlobal(name, lua.LNil)
+	}
+	L.SetGlobal("escape", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LString(html.EscapeString(L.CheckString(1))))
+		return 1
+	}))
+
+	L.SetContext(ctx)
+	L.Push(L.NewFunctionFromProto(proto))
+	if err := L.PCall(0, lua.MultRet, nil); err != nil {
+		L.Close()
+		return nil, err
+	}
+	return L, nil
+}
+
+// luaFunc calls the global function name with the request as a table
+func luaFunc(proto *lua.FunctionProto, name string) scriptFunc {
+	return func(ctx context.Context, req scriptRequest) (string, int, error) {
+		L, err := newLuaState(ctx, proto)
+		if err != nil {
+			return "", 0, err
+		}
+		defer L.Close()
+
+		request := L.NewTable()
+		request.RawSetString("method", lua.LString(req.Method))
+		request.RawSetString("path", lua.LString(req.Path))
+		request.RawSetString("htmx", lua.LBool(req.HTMX))
+		request.RawSetString("query", luaTable(L, req.Query))
+		request.RawSetString("form", luaTable(L, req.Form))
+		request.RawSetString("headers", luaTable(L, req.Headers))
+
+		if err := L.CallByParam(lua.P{Fn: L.GetGlobal(name), NRet: 2, Protect: true}, request); err != nil {
+			return "", 0, err
+		}
+		body, status := L.Get(-2), L.Get(-1)
+
+		if body.Type() != lua.LTString && body.Type() != lua.LTNumber {
+			return "", 0, fmt.Errorf("%s must return a string, got %s", name, body.Type())
+		}
+		code := 0
+		if n, ok := status.(lua.LNumber); ok {
+			code = int(n)
+		}
+		return body.String(), code, nil
+	}
+}
+
+func luaTable(L *lua.LState, values map[string]string) *lua.LTable {
+	table := L.NewTable()
+	for key, value := range values {
+		table.RawSetString(key, lua.LString(value))
+	}
+	return table
+}
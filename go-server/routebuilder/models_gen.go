@@ -0,0 +1,179 @@
+package routebuilder
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+// handlerModel describes the request model and client method of a handler
+type handlerModel struct {
+	Class    string
+	Client   string
+	Route    string
+	Verb     string
+	Function string
+	Doc      string
+	Fields   []HandlerParam
+}
+
+var modelsTemplate = template.Must(template.New("models.py").Funcs(template.FuncMap{
+	"py": strconv.Quote,
+}).Parse(`# Generated by "htmlnojs generate models" - do not edit by hand.
+# One request model per htmx_ handler, built from its parameters and type hints.
+from typing import Any, Dict, List, Optional
+
+from pydantic import BaseModel
+{{ range .Models }}
+
+class {{ .Class }}(BaseModel):
+    """{{ .Verb }} {{ .Route }} ({{ .Function }})"""
+{{- if .Fields }}
+{{- range .Fields }}
+    {{ .Name }}: {{ if .Type }}{{ .Type }}{{ else }}Any{{ end }}{{ if not .Required }} = {{ .Default }}{{ end }}
+{{- end }}
+{{- else }}
+    pass
+{{- end }}
+{{ end }}
+
+__all__ = [{{ range $i, $m := .Models }}{{ if $i }}, {{ end }}{{ py $m.Class }}{{ end }}]
+`))
+
+var clientTemplate = template.Must(template.New("client.py").Funcs(template.FuncMap{
+	"py": strconv.Quote,
+}).Parse(`# Generated by "htmlnojs generate client" - do not edit by hand.
+# Typed client for the public /api routes of this project.
+import json
+import urllib.parse
+import urllib.request
+from typing import Any, Optional
+
+from models import *  # noqa: F401,F403
+
+
+class HTMLnoJSClient:
+    def __init__(self, base_url: str = "http://localhost:8080", timeout: float = 30):
+        self.base_url = base_url.rstrip("/")
+        self.timeout = timeout
+
+    def _send(self, method: str, path: str, body: Any, fields: dict) -> str:
+        values = {}
+        if body is not None:
+            values.update(body.model_dump() if hasattr(body, "model_dump") else body.dict())
+        values.update(fields)
+        values = {k: v if isinstance(v, str) else json.dumps(v) for k, v in values.items() if v is not None}
+
+        url = self.base_url + path
+        data = None
+        if method in ("GET", "HEAD", "DELETE"):
+            if values:
+                url += "?" + urllib.parse.urlencode(values)
+        else:
+            data = urllib.parse.urlencode(values).encode()
+
+        request = urllib.request.Request(url, data=data, method=method)
+        request.add_header("HX-Request", "true")
+        if data is not None:
+            request.add_header("Content-Type", "application/x-www-form-urlencoded")
+        with urllib.request.urlopen(request, timeout=self.timeout) as response:
+            return response.read().decode("utf-8")
+{{ range .Models }}
+    def {{ .Client }}(self, body: Optional[{{ .Class }}] = None, **fields: Any) -> str:
+        """{{ .Verb }} {{ .Route }}{{ if .Doc }} - {{ .Doc }}{{ end }}"""
+        return self._send({{ py .Verb }}, {{ py .Route }}, body, fields)
+{{ end -}}
+`))
+
+// handlerModels derives the model and client names for every Python
+// handler, skipping default-version aliases
+func handlerModels(routes []PythonRoute) []handlerModel {
+	var models []handlerModel
+	usedClients := map[string]int{}
+
+	for _, route := range routes {
+		if route.AliasOf != "" || !servedByPython(route) {
+			continue
+		}
+		segments := strings.Split(strings.Trim(strings.TrimPrefix(route.Route, "/api/"), "/"), "/")
+		client := pythonIdentifier(strings.ToLower(strings.Join(segments, "_")))
+		if n := usedClients[client]; n > 0 {
+			client = fmt.Sprintf("%s_%d", client, n+1)
+		}
+		usedClients[client]++
+
+		doc := strings.TrimSpace(annotationRegex.ReplaceAllString(route.Documentation, ""))
+		models = append(models, handlerModel{
+			Class:    pascalCase(segments) + "Request",
+			Client:   client,
+			Route:    route.Route,
+			Verb:     route.Method,
+			Function: route.Function,
+			Doc:      strings.ReplaceAll(doc, `"""`, `'''`),
+			Fields:   requestFields(route.Params),
+		})
+	}
+
+	sort.Slice(models, func(i, j int) bool { return models[i].Route < models[j].Route })
+	return models
+}
+
+// annotationRegex strips @annotations from docstrings copied into clients
+var annotationRegex = regexp.MustCompile(`@\w+(\([^)]*\))?`)
+
+// GenerateModels renders pydantic request models for every handler
+func GenerateModels(routes []PythonRoute) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := modelsTemplate.Execute(&buf, struct{ Models []handlerModel }{handlerModels(routes)}); err != nil {
+		return nil, fmt.Errorf("failed to render models: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// GenerateClient renders a typed Python client for the public /api routes
+func GenerateClient(routes []PythonRoute) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := clientTemplate.Execute(&buf, struct{ Models []handlerModel }{handlerModels(routes)}); err != nil {
+		return nil, fmt.Errorf("failed to render client: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func pascalCase(segments []string) string {
+	var b strings.Builder
+	for _, segment := range segments {
+		for _, word := range strings.FieldsFunc(segment, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			runes := []rune(word)
+			runes[0] = unicode.ToUpper(runes[0])
+			b.WriteString(string(runes))
+		}
+	}
+	name := b.String()
+	if name == "" || unicode.IsDigit([]rune(name)[0]) {
+		name = "Handler" + name
+	}
+	return name
+}
+
+func pythonIdentifier(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	id := b.String()
+	if id == "" || unicode.IsDigit([]rune(id)[0]) {
+		id = "call_" + id
+	}
+	return id
+}
@@ -0,0 +1,99 @@
+package routebuilder
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+)
+
+// FileTooLargeError stops an upload whose file part exceeds @max_file
+type FileTooLargeError struct {
+	Field    string
+	Filename string
+	Limit    int64
+}
+
+func (e *FileTooLargeError) Error() string {
+	return fmt.Sprintf("file %q in field %q exceeds %s", e.Filename, e.Field, formatByteSize(e.Limit))
+}
+
+// limitUploadFiles makes r's body a stream that enforces maxFile on every
+// file part of a multipart/form-data upload. The original bytes, boundary
+// included, are passed on unchanged in the small chunks the multipart
+// reader consumes, so the browser's upload progress follows what the
+// backend has actually received. A file over the limit aborts the stream
+// with a *FileTooLargeError.
+func limitUploadFiles(r *http.Request, maxFile int64) {
+	if maxFile <= 0 || r.Body == nil || r.Body == http.NoBody {
+		return
+	}
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" || params["boundary"] == "" {
+		return
+	}
+
+	body := r.Body
+	pr, pw := io.Pipe()
+	go func() {
+		defer body.Close()
+		pw.CloseWithError(scanUpload(io.TeeReader(body, pw), body, pw, params["boundary"], maxFile))
+	}()
+	r.Body = pr
+}
+
+// scanUpload reads the multipart stream, which tee forwards as it goes,
+// measuring each file part. A stream the multipart reader can't follow is
+// forwarded as is for the backend to reject.
+func scanUpload(tee io.Reader, body io.Reader, pw io.Writer, boundary string, maxFile int64) error {
+	reader := multipart.NewReader(tee, boundary)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			// Forward the epilogue, if any
+			_, err = io.Copy(io.Discard, tee)
+			return err
+		}
+		if err != nil {
+			if isPipeError(err) {
+				return err
+			}
+			_, err = io.Copy(pw, body)
+			return err
+		}
+
+		if part.FileName() == "" {
+			if _, err := io.Copy(io.Discard, part); err != nil {
+				return err
+			}
+			continue
+		}
+		n, err := io.Copy(io.Discard, io.LimitReader(part, maxFile+1))
+		if err != nil {
+			return err
+		}
+		if n > maxFile {
+			err := &FileTooLargeError{Field: part.FormName(), Filename: part.FileName(), Limit: maxFile}
+			log.Printf("WARNING: Rejected upload: %v", err)
+			return err
+		}
+	}
+}
+
+// isPipeError reports whether err came from the backend side closing the
+// pipe rather than from a malformed upload
+func isPipeError(err error) bool {
+	return errors.Is(err, io.ErrClosedPipe)
+}
+
+// writeFileTooLarge rejects an upload whose file exceeds @max_file
+func writeFileTooLarge(w http.ResponseWriter, functionName string, err *FileTooLargeError) {
+	WriteFragment(w, http.StatusRequestEntityTooLarge, ErrorFragment(
+		"File Too Large",
+		fmt.Sprintf("%s is larger than %s, the most %s accepts per file", err.Filename, formatByteSize(err.Limit), functionName),
+		"",
+	))
+}
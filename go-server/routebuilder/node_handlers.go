@@ -0,0 +1,156 @@
+package routebuilder
+
+import (
+	_ "embed"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// NodeHandlerLanguage serves js_htmx/*.mjs handlers through a Node sidecar
+const NodeHandlerLanguage = "node"
+
+// nodeSidecarScript is the Node server run by StartSidecar
+//
+//go:embed node_sidecar.mjs
+var nodeSidecarScript []byte
+
+// nodeAdapter serves js_htmx/*.mjs modules. Each exported htmx_ function
+// takes the request and returns HTML, or {html, status, headers}; a JSDoc
+// or line comment right above it is its documentation:
+//
+//	/** Save an item @auth */
+//	export async function htmx_post_save(request) {
+//	  return `<p>Saved ${request.form.name}</p>`;
+//	}
+type nodeAdapter struct{}
+
+func (nodeAdapter) Name() string { return NodeHandlerLanguage }
+
+func (nodeAdapter) Dir() string { return "js_htmx" }
+
+func (nodeAdapter) Match(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".mjs")
+}
+
+func (nodeAdapter) BuildRoutes(opts HandlerAdapterOptions, files []string) ([]PythonRoute, error) {
+	p := NewPythonRouteBuilder(opts.Dir)
+	p.SetFastAPIServer(opts.BackendHost, opts.BackendPort)
+	p.SetFastAPIURL(opts.BackendURL)
+	p.SetTransport(opts.Transport)
+	p.SetHealthy(opts.Healthy)
+	p.SetHostHeader(opts.HostHeader)
+	p.SetTimeoutProfiles(opts.TimeoutProfiles)
+	p.SetRequestEncoding(opts.RequestEncoding)
+
+	var routes []PythonRoute
+	for _, file := range files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		// The sidecar serves each export at /<module>/<name>, the same
+		// backend path a py_htmx function gets
+		module := scriptModule(opts.Dir, file)
+		routePrefix := p.findRoutePrefix(file, string(source))
+		for _, function := range nodeFunctions(p, string(source)) {
+			routes = append(routes, p.buildPythonRoute(file, module, routePrefix, function))
+		}
+	}
+	return dedupeRoutes(routes, opts.WarnOnDuplicates)
+}
+
+// StartSidecar runs the Node sidecar for the handlers of dir on port.
+// Handlers are imported once, so changed modules need a restart.
+func (nodeAdapter) StartSidecar(dir string, port int) (func(), error) {
+	script, err := os.CreateTemp("", "htmlnojs-node-*.mjs")
+	if err != nil {
+		return nil, fmt.Errorf("failed to write node sidecar: %w", err)
+	}
+	_, err = script.Write(nodeSidecarScript)
+	script.Close()
+	if err != nil {
+		os.Remove(script.Name())
+		return nil, fmt.Errorf("failed to write node sidecar: %w", err)
+	}
+
+	cmd := exec.Command("node", script.Name(), dir, strconv.Itoa(port))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		os.Remove(script.Name())
+		return nil, fmt.Errorf("failed to start node sidecar: %w", err)
+	}
+	log.Printf("Started Node sidecar (pid %d) for %s on port %d", cmd.Process.Pid, dir, port)
+
+	exited := make(chan struct{})
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			log.Printf("WARNING: Node sidecar exited: %v", err)
+		}
+		close(exited)
+	}()
+
+	return func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+		<-exited
+		os.Remove(script.Name())
+	}, nil
+}
+
+var (
+	nodeFunctionRegex = regexp.MustCompile(`(?m)^export\s+(?:async\s+)?function\s*\*?\s*(htmx_\w+)\s*\(([^)]*)\)`)
+	nodeConstRegex    = regexp.MustCompile(`(?m)^export\s+const\s+(htmx_\w+)\s*=\s*(?:async\s+)?(?:function\s*\w*\s*)?\(([^)]*)\)`)
+)
+
+// nodeFunctions lists the exported htmx_ functions of a module
+func nodeFunctions(p *PythonRouteBuilder, source string) []FunctionInfo {
+	var functions []FunctionInfo
+	for _, regex := range []*regexp.Regexp{nodeFunctionRegex, nodeConstRegex} {
+		for _, match := range regex.FindAllStringSubmatchIndex(source, -1) {
+			functions = append(functions, FunctionInfo{
+				Name:          source[match[2]:match[3]],
+				Parameters:    p.parseParameters(source[match[4]:match[5]]),
+				Documentation: nodeDoc(source[:match[0]]),
+			})
+		}
+	}
+	return functions
+}
+
+// nodeDoc returns the /** */ block or // lines ending right before a
+// function, without comment markers
+func nodeDoc(before string) string {
+	before = strings.TrimRight(before, " \t\r\n")
+	var comment []string
+	if strings.HasSuffix(before, "*/") {
+		start := strings.LastIndex(before, "/*")
+		if start < 0 {
+			return ""
+		}
+		comment = strings.Split(before[start+2:len(before)-2], "\n")
+	} else {
+		lines := strings.Split(before, "\n")
+		for i := len(lines) - 1; i >= 0; i-- {
+			trimmed := strings.TrimSpace(lines[i])
+			if !strings.HasPrefix(trimmed, "//") {
+				break
+			}
+			comment = append([]string{strings.TrimPrefix(trimmed, "//")}, comment...)
+		}
+	}
+
+	var doc []string
+	for _, line := range comment {
+		if line = strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "*")); line != "" {
+			doc = append(doc, line)
+		}
+	}
+	return strings.Join(doc, " ")
+}
@@ -0,0 +1,109 @@
+// HTMLnoJS Node sidecar: serves the htmx_ exports of js_htmx/*.mjs to the
+// Go server. Started by the node handler adapter as
+//
+//   node node_sidecar.mjs <js_htmx dir> <port>
+//
+// js_htmx/items.mjs exporting htmx_post_save is served at /items/post_save.
+// A handler receives the request and returns HTML, or an object with html,
+// status and headers.
+import http from "node:http";
+import { readdir } from "node:fs/promises";
+import path from "node:path";
+import { pathToFileURL } from "node:url";
+
+const dir = path.resolve(process.argv[2] ?? "js_htmx");
+const port = Number(process.argv[3] ?? 8082);
+const handlers = new Map();
+
+async function discover(current) {
+  for (const entry of await readdir(current, { withFileTypes: true })) {
+    const file = path.join(current, entry.name);
+    if (entry.isDirectory()) {
+      await discover(file);
+      continue;
+    }
+    if (!entry.name.endsWith(".mjs")) {
+      continue;
+    }
+    const module = path.relative(dir, file).slice(0, -".mjs".length).split(path.sep).join("/");
+    const exports = await import(pathToFileURL(file).href);
+    for (const [name, fn] of Object.entries(exports)) {
+      if (name.startsWith("htmx_") && typeof fn === "function") {
+        handlers.set(`/${module}/${name.slice("htmx_".length)}`, fn);
+      }
+    }
+  }
+}
+
+function escapeHTML(s) {
+  return String(s).replace(/[&<>"']/g, (c) => `&#${c.charCodeAt(0)};`);
+}
+
+async function readBody(req) {
+  const chunks = [];
+  for await (const chunk of req) {
+    chunks.push(chunk);
+  }
+  return Buffer.concat(chunks).toString("utf8");
+}
+
+// toRequest flattens a request like the in-process script handlers see it
+async function toRequest(req, url) {
+  const body = await readBody(req);
+  const type = req.headers["content-type"] ?? "";
+  let form = {};
+  let json = null;
+  if (type.startsWith("application/x-www-form-urlencoded")) {
+    form = Object.fromEntries(new URLSearchParams(body));
+  } else if (type.startsWith("application/json") && body !== "") {
+    json = JSON.parse(body);
+  }
+  return {
+    method: req.method,
+    path: url.pathname,
+    htmx: req.headers["hx-request"] === "true",
+    query: Object.fromEntries(url.searchParams),
+    form,
+    json,
+    body,
+    headers: req.headers,
+  };
+}
+
+function send(res, status, headers, body) {
+  res.writeHead(status, { "Content-Type": "text/html; charset=utf-8", ...headers });
+  res.end(body);
+}
+
+await discover(dir);
+
+http
+  .createServer(async (req, res) => {
+    const url = new URL(req.url, "http://localhost");
+    if (url.pathname === "/health") {
+      res.writeHead(200, { "Content-Type": "application/json" });
+      res.end(JSON.stringify({ status: "ok", handlers: handlers.size }));
+      return;
+    }
+
+    const handler = handlers.get(url.pathname);
+    if (!handler) {
+      send(res, 404, {}, `<div class="error">No handler for ${escapeHTML(url.pathname)}</div>`);
+      return;
+    }
+
+    try {
+      const result = await handler(await toRequest(req, url));
+      if (result !== null && typeof result === "object") {
+        send(res, result.status ?? 200, result.headers ?? {}, String(result.html ?? ""));
+      } else {
+        send(res, 200, {}, String(result ?? ""));
+      }
+    } catch (err) {
+      console.error(`ERROR: ${url.pathname} failed:`, err);
+      send(res, 500, {}, `<div class="error">${escapeHTML(handler.name)} failed</div>`);
+    }
+  })
+  .listen(port, "127.0.0.1", () => {
+    console.log(`Node sidecar serving ${handlers.size} handler(s) from ${dir} on port ${port}`);
+  });
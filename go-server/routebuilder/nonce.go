@@ -0,0 +1,52 @@
+package routebuilder
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"html/template"
+	"net/http"
+)
+
+// Nonce names: htmx sends an action's nonce in NonceHeader, plain forms in
+// the NonceField form field
+const (
+	NonceHeader = "X-HTMLnoJS-Nonce"
+	NonceField  = "htmlnojs_nonce"
+)
+
+// NonceIssuer makes a single-use nonce for one request to the handler
+// route action
+type NonceIssuer func(action string) (string, error)
+
+type nonceIssuerKey struct{}
+
+// WithNonceIssuer lets the pages rendered for a request embed nonces for
+// the handlers marked @nonce
+func WithNonceIssuer(ctx context.Context, issue NonceIssuer) context.Context {
+	return context.WithValue(ctx, nonceIssuerKey{}, issue)
+}
+
+func requestNonceIssuer(r *http.Request) NonceIssuer {
+	issue, _ := r.Context().Value(nonceIssuerKey{}).(NonceIssuer)
+	return issue
+}
+
+// Nonce returns a nonce for one request to the handler route action, for
+// hx-headers='{"X-HTMLnoJS-Nonce": "{{.Nonce "/api/orders/delete"}}"}'
+func (d TemplateData) Nonce(action string) (string, error) {
+	if d.nonces == nil {
+		return "", fmt.Errorf("nonces aren't available on this route")
+	}
+	return d.nonces(action)
+}
+
+// NonceField returns a hidden form field carrying a nonce for action, for
+// {{.NonceField "/api/orders/delete"}} inside a form
+func (d TemplateData) NonceField(action string) (template.HTML, error) {
+	nonce, err := d.Nonce(action)
+	if err != nil {
+		return "", err
+	}
+	return template.HTML(`<input type="hidden" name="` + NonceField + `" value="` + html.EscapeString(nonce) + `">`), nil
+}
@@ -0,0 +1,72 @@
+package routebuilder
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// UnownedRoute is the owner reported for routes nobody claimed
+const UnownedRoute = "unowned"
+
+var ownerAnnotationRegex = regexp.MustCompile(`@owner\(\s*["']?([\w.\-]+)["']?\s*\)`)
+
+// extractOwner reads an @owner(team) annotation from a docstring
+func (p *PythonRouteBuilder) extractOwner(doc string) string {
+	if matches := ownerAnnotationRegex.FindStringSubmatch(doc); matches != nil {
+		return matches[1]
+	}
+	return ""
+}
+
+// assignOwners fills in the owner of every route not tagged by front-matter
+// or @owner using the project's route pattern map. The longest matching
+// pattern wins.
+func (a *AllRoutesBuilder) assignOwners() {
+	patterns := make([]string, 0, len(a.project.Owners))
+	for pattern := range a.project.Owners {
+		patterns = append(patterns, pattern)
+	}
+	sort.Slice(patterns, func(i, j int) bool {
+		if len(patterns[i]) != len(patterns[j]) {
+			return len(patterns[i]) > len(patterns[j])
+		}
+		return patterns[i] < patterns[j]
+	})
+
+	lookup := func(route string) string {
+		for _, pattern := range patterns {
+			if MatchRoutePattern(pattern, route) {
+				return a.project.Owners[pattern]
+			}
+		}
+		return UnownedRoute
+	}
+
+	for i, route := range a.Collection.HTMLRoutes {
+		if route.Owner == "" {
+			a.Collection.HTMLRoutes[i].Owner = lookup(route.Route)
+		}
+	}
+	for i, route := range a.Collection.PythonRoutes {
+		if route.Owner == "" {
+			a.Collection.PythonRoutes[i].Owner = lookup(route.Route)
+		}
+	}
+}
+
+// MatchRoutePattern matches a route against an exact path, a path.Match
+// glob, or a prefix ending in "*" such as "/api/billing/*"
+func MatchRoutePattern(pattern, route string) bool {
+	if pattern == route {
+		return true
+	}
+	if ok, _ := path.Match(pattern, route); ok {
+		return true
+	}
+	if strings.HasSuffix(pattern, "*") {
+		return strings.HasPrefix(route, strings.TrimSuffix(pattern, "*"))
+	}
+	return false
+}
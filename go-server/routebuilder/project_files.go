@@ -0,0 +1,78 @@
+package routebuilder
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"htmlnojs/projectfs"
+)
+
+var (
+	projectRootMu sync.RWMutex
+	projectRoot   *projectfs.Root
+)
+
+// SetProjectRoot makes templates, stylesheets and fonts be read through
+// the project directory opened with a symlink policy ("inside", "deny" or
+// "follow"), so no path or symlink leads out of it. Setting the same
+// directory and policy again keeps the open root.
+func SetProjectRoot(dir, policy string) error {
+	if policy == "" {
+		policy = projectfs.SymlinksInside
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	projectRootMu.Lock()
+	defer projectRootMu.Unlock()
+	if projectRoot != nil && projectRoot.Dir() == abs && projectRoot.Policy() == policy {
+		return nil
+	}
+	root, err := projectfs.Open(dir, policy)
+	if err != nil {
+		return err
+	}
+	// The previous root stays open for requests still reading through it
+	projectRoot = root
+	return nil
+}
+
+// ProjectRoot returns the root set with SetProjectRoot, or nil
+func ProjectRoot() *projectfs.Root {
+	projectRootMu.RLock()
+	defer projectRootMu.RUnlock()
+	return projectRoot
+}
+
+// readProjectFile reads a file of the project by its OS path. Tools that
+// never set a root read the file directly.
+func readProjectFile(path string) ([]byte, error) {
+	if root := ProjectRoot(); root != nil {
+		return root.ReadPath(path)
+	}
+	return os.ReadFile(path)
+}
+
+// openProjectFile opens a file of the project by its OS path
+func openProjectFile(path string) (fs.File, error) {
+	if root := ProjectRoot(); root != nil {
+		return root.OpenPath(path)
+	}
+	return os.Open(path)
+}
+
+// statProjectFile describes a file of the project by its OS path
+func statProjectFile(path string) (fs.FileInfo, error) {
+	if root := ProjectRoot(); root != nil {
+		name, err := root.Name(path)
+		if err != nil {
+			return nil, err
+		}
+		return root.Stat(name)
+	}
+	return os.Stat(path)
+}
@@ -0,0 +1,265 @@
+package routebuilder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limits on the last good responses a breaker keeps per backend
+const (
+	breakerCacheEntries = 256
+	breakerCacheBody    = 64 << 10
+)
+
+// defaultBreakerWait is how long a circuit stays open when
+// proxy.breaker.cooldown isn't set
+const defaultBreakerWait = 10 * time.Second
+
+// CircuitOpenError is returned instead of calling a backend whose circuit
+// is open and that has no cached response for the request
+type CircuitOpenError struct {
+	Backend    string
+	Fallback   string        // HTML fragment configured for this case, may be empty
+	RetryAfter time.Duration // Until the next trial request
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("circuit open for backend %s", e.Backend)
+}
+
+// breakerTransport keeps a circuit per backend host. After failures
+// consecutive errors the circuit opens and requests fail fast until the
+// cooldown has passed; then a single trial request decides whether it
+// closes again.
+type breakerTransport struct {
+	base     http.RoundTripper
+	failures int
+	cooldown time.Duration
+	fallback string
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	failures  int
+	openUntil time.Time // Zero while closed
+	trial     bool      // A trial request is in flight
+	lastGood  map[string]cachedResponse
+}
+
+// cachedResponse is a successful GET served again while the circuit is open
+type cachedResponse struct {
+	header http.Header
+	body   []byte
+}
+
+func newBreakerTransport(base http.RoundTripper, failures int, cooldown time.Duration, fallback string) *breakerTransport {
+	return &breakerTransport{
+		base:     base,
+		failures: failures,
+		cooldown: cooldown,
+		fallback: fallback,
+		circuits: make(map[string]*circuit),
+	}
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backend := req.URL.Host
+	if wait, ok := t.allow(backend); !ok {
+		if resp := t.cached(backend, req); resp != nil {
+			return resp, nil
+		}
+		return nil, &CircuitOpenError{Backend: backend, Fallback: t.fallback, RetryAfter: wait}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	switch {
+	case err != nil:
+		// A client that went away says nothing about the backend
+		if req.Context().Err() == nil || errors.Is(err, context.DeadlineExceeded) {
+			t.record(backend, false)
+		} else {
+			t.release(backend)
+		}
+	case resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout:
+		t.record(backend, false)
+	default:
+		t.record(backend, true)
+		if req.Method == http.MethodGet && resp.StatusCode == http.StatusOK {
+			t.capture(backend, req, resp)
+		}
+	}
+	return resp, err
+}
+
+// circuitFor returns the state of backend, t.mu must be held
+func (t *breakerTransport) circuitFor(backend string) *circuit {
+	c, ok := t.circuits[backend]
+	if !ok {
+		c = &circuit{lastGood: make(map[string]cachedResponse)}
+		t.circuits[backend] = c
+	}
+	return c
+}
+
+// allow reports whether a request may go to backend, letting a single
+// trial through once an open circuit has cooled down. Refused requests
+// also get how long the circuit stays open.
+func (t *breakerTransport) allow(backend string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c := t.circuitFor(backend)
+	if c.openUntil.IsZero() {
+		return 0, true
+	}
+	if wait := time.Until(c.openUntil); wait > 0 || c.trial {
+		return max(wait, 0), false
+	}
+	c.trial = true
+	return 0, true
+}
+
+// record counts the outcome of a request, opening or closing the circuit
+func (t *breakerTransport) record(backend string, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c := t.circuitFor(backend)
+	wasOpen := !c.openUntil.IsZero()
+	c.trial = false
+	if ok {
+		c.failures = 0
+		if wasOpen {
+			c.openUntil = time.Time{}
+			log.Printf("Circuit for backend %s closed", backend)
+		}
+		return
+	}
+
+	c.failures++
+	if wasOpen || c.failures >= t.failures {
+		c.openUntil = time.Now().Add(t.cooldown)
+		if !wasOpen {
+			log.Printf("WARNING: Circuit for backend %s opened after %d failures, retrying in %v", backend, c.failures, t.cooldown)
+		}
+	}
+}
+
+// release gives up a trial slot without judging the backend
+func (t *breakerTransport) release(backend string) {
+	t.mu.Lock()
+	t.circuitFor(backend).trial = false
+	t.mu.Unlock()
+}
+
+func cacheKey(req *http.Request) string {
+	return req.Header.Get("HX-Request") + " " + req.URL.String()
+}
+
+// cached returns the last good response to the same GET, marked so
+// clients can tell it's stale
+func (t *breakerTransport) cached(backend string, req *http.Request) *http.Response {
+	if req.Method != http.MethodGet {
+		return nil
+	}
+	t.mu.Lock()
+	entry, ok := t.circuitFor(backend).lastGood[cacheKey(req)]
+	t.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	header := entry.header.Clone()
+	header.Set("X-Circuit-Breaker", "open")
+	header.Set("Content-Length", strconv.Itoa(len(entry.body)))
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(entry.body)),
+		ContentLength: int64(len(entry.body)),
+		Request:       req,
+	}
+}
+
+// capture keeps a copy of a successful response as it streams to the
+// client, as long as it's small enough
+func (t *breakerTransport) capture(backend string, req *http.Request, resp *http.Response) {
+	if resp.ContentLength > breakerCacheBody {
+		return
+	}
+	key := cacheKey(req)
+	header := resp.Header.Clone()
+	resp.Body = &captureBody{
+		ReadCloser: resp.Body,
+		done: func(body []byte) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			lastGood := t.circuitFor(backend).lastGood
+			if _, ok := lastGood[key]; !ok && len(lastGood) >= breakerCacheEntries {
+				for old := range lastGood {
+					delete(lastGood, old)
+					break
+				}
+			}
+			lastGood[key] = cachedResponse{header: header, body: body}
+		},
+	}
+}
+
+// captureBody copies what's read through it and hands the copy to done
+// once the whole body has been read, unless it grew too large
+type captureBody struct {
+	io.ReadCloser
+	buf      bytes.Buffer
+	overflow bool
+	done     func([]byte)
+}
+
+func (c *captureBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if !c.overflow {
+		if c.buf.Len()+n > breakerCacheBody {
+			c.overflow = true
+			c.buf = bytes.Buffer{}
+		} else {
+			c.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF && !c.overflow && c.done != nil {
+		c.done(bytes.Clone(c.buf.Bytes()))
+		c.done = nil
+	}
+	return n, err
+}
+
+// writeCircuitOpen answers a request that wasn't sent because its backend's
+// circuit is open
+func writeCircuitOpen(w http.ResponseWriter, functionName string, err *CircuitOpenError) {
+	w.Header().Set("X-Circuit-Breaker", "open")
+	w.Header().Set("Retry-After", strconv.Itoa(max(int(err.RetryAfter.Seconds()+0.999), 1)))
+	if err.Fallback != "" {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		io.WriteString(w, err.Fallback)
+		return
+	}
+	WriteFragment(w, http.StatusServiceUnavailable, ErrorFragment(
+		"Temporarily Unavailable",
+		fmt.Sprintf("%s is failing and was not called; try again shortly", functionName),
+		"",
+	))
+}
@@ -0,0 +1,65 @@
+package routebuilder
+
+import (
+	"context"
+	"net/http"
+
+	"htmlnojs/config"
+)
+
+// headerRulesKey carries the proxy_headers rules of the route being served
+type headerRulesKey struct{}
+
+// applyHeaderRules makes every handler route carry the proxy_headers rules
+// matching it, for the proxy to apply to its request and response
+func (a *AllRoutesBuilder) applyHeaderRules(routes []PythonRoute) {
+	if len(a.project.ProxyHeaders) == 0 {
+		return
+	}
+	for i, route := range routes {
+		var rules []config.HeaderRule
+		for _, rule := range a.project.ProxyHeaders {
+			if rule.Match == "" || MatchRoutePattern(rule.Match, route.Route) {
+				rules = append(rules, rule)
+			}
+		}
+		if len(rules) == 0 {
+			continue
+		}
+		handler := route.Handler
+		routes[i].Handler = func(w http.ResponseWriter, r *http.Request) {
+			handler(w, r.WithContext(context.WithValue(r.Context(), headerRulesKey{}, rules)))
+		}
+	}
+}
+
+// rewriteRequestHeaders applies the route's request rules to an outgoing request
+func rewriteRequestHeaders(ctx context.Context, header http.Header) {
+	rules, _ := ctx.Value(headerRulesKey{}).([]config.HeaderRule)
+	for _, rule := range rules {
+		rewriteHeaders(header, rule.Request)
+	}
+}
+
+// rewriteResponseHeaders applies the route's response rules to a backend response
+func rewriteResponseHeaders(ctx context.Context, header http.Header) {
+	rules, _ := ctx.Value(headerRulesKey{}).([]config.HeaderRule)
+	for _, rule := range rules {
+		rewriteHeaders(header, rule.Response)
+	}
+}
+
+func rewriteHeaders(header http.Header, rewrite config.HeaderRewrite) {
+	for from, to := range rewrite.Rename {
+		if values := header.Values(from); len(values) > 0 {
+			header.Del(from)
+			header[http.CanonicalHeaderKey(to)] = values
+		}
+	}
+	for _, name := range rewrite.Remove {
+		header.Del(name)
+	}
+	for name, value := range rewrite.Set {
+		header.Set(name, value)
+	}
+}
@@ -0,0 +1,227 @@
+package routebuilder
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"htmlnojs/config"
+)
+
+// defaultLogBody is how many bytes of a body the proxy log shows when
+// proxy_log.max_body isn't set
+const defaultLogBody = 1024
+
+// Logged headers and body fields always masked, on top of proxy_log.redact.
+// A signed URL's signature opens the page it was made for, so it is a
+// credential too.
+var (
+	redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie", "X-Api-Key"}
+	redactedFields  = []string{"password", "secret", "token", SignedURLSignature}
+)
+
+// redactedQuery matches the values of redactedFields in a query string
+var redactedQuery = regexp.MustCompile(`(?i)((?:^|&)(?:` + strings.Join(redactedFields, "|") + `)=)[^&]*`)
+
+// RedactQuery masks the values of secret fields in a raw query string,
+// for logs, samples and captures
+func RedactQuery(rawQuery string) string {
+	return redactedQuery.ReplaceAllString(rawQuery, "${1}[redacted]")
+}
+
+// redactedField reports whether name is a field whose value is masked
+func redactedField(name string) bool {
+	for _, field := range redactedFields {
+		if strings.EqualFold(name, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactURL masks secret fields in the query string of rawURL
+func redactURL(rawURL string) string {
+	path, query, ok := strings.Cut(rawURL, "?")
+	if !ok {
+		return rawURL
+	}
+	return path + "?" + RedactQuery(query)
+}
+
+// loggingTransport logs each request it sends and the response it gets,
+// with headers and the start of both bodies. Bodies are captured as the
+// proxy streams them, so logging doesn't hold up large or slow responses;
+// the exchange is logged once the response body is closed.
+type loggingTransport struct {
+	base    http.RoundTripper
+	maxBody int
+	headers map[string]bool // Canonical names of masked headers
+	fields  *regexp.Regexp  // Matches masked JSON and form values
+}
+
+// newLoggingTransport wraps base, or returns it as is when the log is off.
+// Headers named in secretHeaders are masked too, e.g. configured API keys.
+func newLoggingTransport(base http.RoundTripper, cfg config.ProxyLogConfig, secretHeaders ...string) http.RoundTripper {
+	if !cfg.Enabled || base == nil {
+		return base
+	}
+	t := &loggingTransport{base: base, maxBody: cfg.MaxBody, headers: map[string]bool{}}
+	if t.maxBody == 0 {
+		t.maxBody = defaultLogBody
+	}
+
+	names := append(append(append([]string(nil), redactedHeaders...), secretHeaders...), cfg.Redact...)
+	for _, name := range names {
+		t.headers[http.CanonicalHeaderKey(name)] = true
+	}
+	fields := append(append([]string(nil), redactedFields...), cfg.Redact...)
+	for i, field := range fields {
+		fields[i] = regexp.QuoteMeta(field)
+	}
+	alternatives := strings.Join(fields, "|")
+	// "field": "value" or "field": 123 in JSON, field=value in forms and queries
+	t.fields = regexp.MustCompile(`(?i)("(?:` + alternatives + `)"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\s]+)` +
+		`|((?:^|[&?])(?:` + alternatives + `)=)[^&\s]*`)
+	return t
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	var reqBody *capturingBody
+	if req.Body != nil && req.Body != http.NoBody {
+		// RoundTrippers must not modify the caller's request
+		req = req.Clone(req.Context())
+		reqBody = &capturingBody{ReadCloser: req.Body, limit: t.maxBody}
+		req.Body = reqBody
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	request := fmt.Sprintf("%s %s", req.Method, t.redactQuery(req.URL.String()))
+	sent := t.formatHeaders(req.Header, ">") + t.formatBody(reqBody, ">")
+	if err != nil {
+		log.Printf("DEBUG: proxy %s failed after %v: %v\n%s", request, time.Since(start).Round(time.Millisecond), err, sent)
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusSwitchingProtocols {
+		// The proxy needs the upgraded connection itself as the body
+		log.Printf("DEBUG: proxy %s -> %s\n%s%s", request, resp.Status, sent, t.formatHeaders(resp.Header, "<"))
+		return resp, nil
+	}
+
+	respBody := &capturingBody{ReadCloser: resp.Body, limit: t.maxBody}
+	respBody.onClose = func() {
+		log.Printf("DEBUG: proxy %s -> %s in %v\n%s%s%s", request, resp.Status, time.Since(start).Round(time.Millisecond),
+			sent, t.formatHeaders(resp.Header, "<"), t.formatBody(respBody, "<"))
+	}
+	resp.Body = respBody
+	return resp, nil
+}
+
+// formatHeaders lists headers one per line in name order, marked > when
+// sent and < when received, masking secrets
+func (t *loggingTransport) formatHeaders(header http.Header, mark string) string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		for _, value := range header[name] {
+			if t.headers[http.CanonicalHeaderKey(name)] {
+				value = "[redacted]"
+			}
+			fmt.Fprintf(&b, "  %s %s: %s\n", mark, name, value)
+		}
+	}
+	return b.String()
+}
+
+// formatBody shows the captured start of a body with masked fields
+func (t *loggingTransport) formatBody(body *capturingBody, mark string) string {
+	if body == nil {
+		return ""
+	}
+	head, total := body.snapshot()
+	switch {
+	case total == 0:
+		return ""
+	case !utf8.Valid(head):
+		return fmt.Sprintf("  %s body: %d bytes of binary data\n", mark, total)
+	case len(head) < total:
+		return fmt.Sprintf("  %s body (first %d of %d bytes): %s\n", mark, len(head), total, t.redactFields(string(head)))
+	}
+	return fmt.Sprintf("  %s body (%d bytes): %s\n", mark, total, t.redactFields(string(head)))
+}
+
+// redactFields masks the values of secret fields in a JSON or form body
+func (t *loggingTransport) redactFields(text string) string {
+	return t.fields.ReplaceAllStringFunc(text, func(match string) string {
+		groups := t.fields.FindStringSubmatch(match)
+		if groups[1] != "" {
+			return groups[1] + `"[redacted]"`
+		}
+		return groups[3] + "[redacted]"
+	})
+}
+
+// redactQuery masks secret fields in a URL's query string
+func (t *loggingTransport) redactQuery(rawURL string) string {
+	path, query, ok := strings.Cut(rawURL, "?")
+	if !ok {
+		return rawURL
+	}
+	return path + "?" + strings.TrimPrefix(t.redactFields("&"+query), "&")
+}
+
+// capturingBody keeps the first limit bytes read through it. The transport
+// may still be sending a request body while the response is logged, hence
+// the lock.
+type capturingBody struct {
+	io.ReadCloser
+	limit   int
+	onClose func()
+
+	mu     sync.Mutex
+	head   []byte
+	total  int
+	closed bool
+}
+
+func (b *capturingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.mu.Lock()
+	if room := b.limit - len(b.head); room > 0 {
+		b.head = append(b.head, p[:min(n, room)]...)
+	}
+	b.total += n
+	b.mu.Unlock()
+	return n, err
+}
+
+func (b *capturingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.mu.Lock()
+	first := !b.closed
+	b.closed = true
+	b.mu.Unlock()
+	if first && b.onClose != nil {
+		b.onClose()
+	}
+	return err
+}
+
+// snapshot returns what was captured and how many bytes were read
+func (b *capturingBody) snapshot() ([]byte, int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.head...), b.total
+}
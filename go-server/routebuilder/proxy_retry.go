@@ -0,0 +1,78 @@
+package routebuilder
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// defaultRetryBackoff is the delay before the first retry when
+// proxy.retry_backoff isn't set
+const defaultRetryBackoff = 100 * time.Millisecond
+
+// retryTransport retries idempotent requests that failed to reach the
+// backend or that it answered with 502/503, e.g. while it restarts
+type retryTransport struct {
+	base    http.RoundTripper
+	retries int
+	backoff time.Duration
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !retryable(req) {
+		return t.base.RoundTrip(req)
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := t.base.RoundTrip(req)
+		if attempt == t.retries || !shouldRetry(req, resp, err) {
+			return resp, err
+		}
+
+		var reason string
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			// Release the connection before trying again
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			resp.Body.Close()
+		}
+
+		delay := t.delay(attempt)
+		log.Printf("WARNING: Retrying %s %s in %v (%d/%d): %s", req.Method, req.URL.Path, delay, attempt+1, t.retries, reason)
+		select {
+		case <-time.After(delay):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// delay doubles the backoff for every retry and picks a random point in
+// its upper half, so clients that failed together don't retry together
+func (t *retryTransport) delay(attempt int) time.Duration {
+	d := t.backoff << attempt
+	return d/2 + rand.N(d/2+1)
+}
+
+// retryable reports whether req may be sent again: only bodyless GET and
+// HEAD requests are
+func retryable(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	return req.Body == nil || req.Body == http.NoBody
+}
+
+func shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		// The client went away or the route's deadline passed
+		return req.Context().Err() == nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	return resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable
+}
@@ -0,0 +1,164 @@
+package routebuilder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"htmlnojs/config"
+)
+
+// defaultTimeoutProfile applies to routes without @timeout
+const defaultTimeoutProfile = "default"
+
+var timeoutAnnotationRegex = regexp.MustCompile(`@timeout\(\s*([A-Za-z0-9_-]+)\s*\)`)
+
+// routeTimeouts are the deadlines of one proxied route. Zero Connect and
+// ResponseHeader leave those phases to the transport's own settings.
+type routeTimeouts struct {
+	Profile        string // Profile the deadlines came from, empty for the built-in default
+	Connect        time.Duration
+	ResponseHeader time.Duration
+	Total          time.Duration
+}
+
+// SetTimeoutProfiles sets the named deadline profiles @timeout(name) picks
+// from; the "default" profile applies to routes without @timeout
+func (p *PythonRouteBuilder) SetTimeoutProfiles(profiles map[string]config.TimeoutProfile) {
+	p.timeoutProfiles = profiles
+}
+
+// resolveTimeouts returns the deadlines for a handler documented by doc.
+// @timeout(30) sets the total to 30 seconds on top of the default profile,
+// @timeout(slow) uses the "slow" profile, filled in from the default one.
+func (p *PythonRouteBuilder) resolveTimeouts(functionName, doc string) routeTimeouts {
+	timeouts := routeTimeouts{Total: defaultProxyTimeout}
+	if profile, ok := p.timeoutProfiles[defaultTimeoutProfile]; ok {
+		timeouts = applyTimeoutProfile(timeouts, profile)
+		timeouts.Profile = defaultTimeoutProfile
+	}
+
+	matches := timeoutAnnotationRegex.FindStringSubmatch(doc)
+	if matches == nil {
+		return timeouts
+	}
+	if seconds, err := strconv.Atoi(matches[1]); err == nil {
+		if seconds > 0 {
+			timeouts.Total = time.Duration(seconds) * time.Second
+		}
+		return timeouts
+	}
+	profile, ok := p.timeoutProfiles[matches[1]]
+	if !ok {
+		log.Printf("WARNING: Ignoring @timeout(%s) on %s, no such profile in timeouts", matches[1], functionName)
+		return timeouts
+	}
+	timeouts = applyTimeoutProfile(timeouts, profile)
+	timeouts.Profile = matches[1]
+	return timeouts
+}
+
+// applyTimeoutProfile overrides the deadlines profile sets. Config
+// validation has already checked the durations.
+func applyTimeoutProfile(timeouts routeTimeouts, profile config.TimeoutProfile) routeTimeouts {
+	timeouts.Connect = durationOr(profile.Connect, timeouts.Connect)
+	timeouts.ResponseHeader = durationOr(profile.ResponseHeader, timeouts.ResponseHeader)
+	timeouts.Total = durationOr(profile.Total, timeouts.Total)
+	return timeouts
+}
+
+// connectTimeoutKey carries the route's connect deadline to the dialer
+type connectTimeoutKey struct{}
+
+// withConnectTimeout makes connections dialled for ctx's request give up
+// after d instead of the transport's dial timeout
+func withConnectTimeout(ctx context.Context, d time.Duration) context.Context {
+	if d <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, connectTimeoutKey{}, d)
+}
+
+// ResponseHeaderTimeoutError is returned when a backend accepted a request
+// but didn't start responding within the route's response_header deadline
+type ResponseHeaderTimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *ResponseHeaderTimeoutError) Error() string {
+	return fmt.Sprintf("no response headers within %v", e.Timeout)
+}
+
+// Is makes the error count as a deadline, e.g. for the circuit breaker
+func (e *ResponseHeaderTimeoutError) Is(target error) bool {
+	return target == context.DeadlineExceeded
+}
+
+// headerTimeoutTransport cancels requests whose response headers don't
+// arrive in time. Unlike a context deadline it stops once they do, so
+// slowly streamed bodies only run into the route's total deadline.
+type headerTimeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *headerTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Upgraded connections need the backend's writable body
+	if isWebSocketUpgrade(req) {
+		return t.base.RoundTrip(req)
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	var (
+		mu       sync.Mutex
+		done     bool
+		timedOut bool
+	)
+	timer := time.AfterFunc(t.timeout, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if !done {
+			timedOut = true
+			cancel()
+		}
+	})
+
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	timer.Stop()
+	mu.Lock()
+	done = true
+	expired := timedOut
+	mu.Unlock()
+
+	if expired {
+		if resp != nil {
+			resp.Body.Close()
+		}
+		cancel()
+		return nil, &ResponseHeaderTimeoutError{Timeout: t.timeout}
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The body still reads through ctx, so cancel it with the body
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases a request's context once its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,168 @@
+package routebuilder
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"htmlnojs/config"
+)
+
+// Transport defaults for handler backends, used where htmlnojs.json leaves
+// a setting out
+const (
+	defaultMaxIdleConns    = 100
+	defaultIdleConnTimeout = 90 * time.Second
+	defaultDialTimeout     = 5 * time.Second
+	defaultTLSHandshake    = 10 * time.Second
+)
+
+// UnixSocketHost stands in for the Python backend's host when it listens on
+// proxy.unix_socket; the transport dials the socket for any port on it
+const UnixSocketHost = "unix"
+
+var (
+	proxyTransportsMu sync.Mutex
+	proxyTransports   = map[config.ProxyConfig]http.RoundTripper{}
+)
+
+// ProxyTransport returns the transport for cfg. Route rebuilds with the
+// same settings share one transport, so they keep its pooled connections.
+func ProxyTransport(cfg config.ProxyConfig) (http.RoundTripper, error) {
+	proxyTransportsMu.Lock()
+	defer proxyTransportsMu.Unlock()
+
+	if transport, ok := proxyTransports[cfg]; ok {
+		return transport, nil
+	}
+	base, err := newProxyTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := newSigningTransport(base, cfg.Signing)
+	if err != nil {
+		return nil, err
+	}
+	bufferLimit, err := parseResponseBuffer(cfg.BufferResponses)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy.buffer_responses: %w", err)
+	}
+	transport = &bufferingTransport{base: transport, limit: bufferLimit}
+	if cfg.Retries > 0 {
+		transport = &retryTransport{
+			base:    transport,
+			retries: cfg.Retries,
+			backoff: durationOr(cfg.RetryBackoff, defaultRetryBackoff),
+		}
+	}
+	if cfg.Breaker.Failures > 0 {
+		transport = newBreakerTransport(transport, cfg.Breaker.Failures,
+			durationOr(cfg.Breaker.Cooldown, defaultBreakerWait), cfg.Breaker.Fallback)
+	}
+	proxyTransports[cfg] = transport
+	return transport, nil
+}
+
+func newProxyTransport(cfg config.ProxyConfig) (*http.Transport, error) {
+	tlsConfig, err := proxyTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   durationOr(cfg.DialTimeout, defaultDialTimeout),
+		KeepAlive: 30 * time.Second,
+	}
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		d := dialer
+		// Routes with a connect timeout profile replace the dial timeout
+		if timeout, ok := ctx.Value(connectTimeoutKey{}).(time.Duration); ok {
+			routeDialer := *dialer
+			routeDialer.Timeout = timeout
+			d = &routeDialer
+		}
+		if host, _, _ := net.SplitHostPort(addr); cfg.UnixSocket != "" && host == UnixSocketHost {
+			return d.DialContext(ctx, "unix", cfg.UnixSocket)
+		}
+		return d.DialContext(ctx, network, addr)
+	}
+	return &http.Transport{
+		DialContext:           dial,
+		MaxIdleConns:          intOr(cfg.MaxIdleConns, defaultMaxIdleConns),
+		MaxIdleConnsPerHost:   intOr(cfg.MaxIdleConnsPerHost, defaultMaxIdleConns),
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		IdleConnTimeout:       durationOr(cfg.IdleConnTimeout, defaultIdleConnTimeout),
+		ResponseHeaderTimeout: durationOr(cfg.ResponseHeaderTimeout, 0),
+		TLSHandshakeTimeout:   defaultTLSHandshake,
+		TLSClientConfig:       tlsConfig,
+	}, nil
+}
+
+// BackendURL returns the base URL the Python backend on port is reached at
+func BackendURL(cfg config.ProxyConfig, port int) string {
+	switch {
+	case cfg.BackendURL != "":
+		return strings.TrimSuffix(cfg.BackendURL, "/")
+	case cfg.UnixSocket != "":
+		return fmt.Sprintf("http://%s:%d", UnixSocketHost, port)
+	}
+	return fmt.Sprintf("http://localhost:%d", port)
+}
+
+// proxyTLSConfig builds the client TLS settings for https:// backends
+func proxyTLSConfig(cfg config.ProxyTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.MinVersion == "1.3" {
+		tlsConfig.MinVersion = tls.VersionTLS13
+	}
+
+	if cfg.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load proxy client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read proxy CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in proxy CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
+// durationOr parses a validated config duration, falling back when unset
+func durationOr(value string, fallback time.Duration) time.Duration {
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return d
+	}
+	return fallback
+}
+
+func intOr(value, fallback int) int {
+	if value > 0 {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,28 @@
+package routebuilder
+
+import "net/http"
+
+// BackendPicker chooses the host:port that each proxied py_htmx/ request
+// goes to, e.g. one of several uvicorn workers
+type BackendPicker interface {
+	Pick() string
+}
+
+// SetBackendPicker spreads py_htmx/ requests over the backends picker
+// chooses from, in place of the single FastAPI port
+func (a *AllRoutesBuilder) SetBackendPicker(picker BackendPicker) {
+	a.picker = picker
+}
+
+// pickerTransport sends each request to the backend its picker chooses
+type pickerTransport struct {
+	base   http.RoundTripper
+	picker BackendPicker
+}
+
+func (t *pickerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// The request belongs to the caller, so the new host goes on a copy
+	out := req.Clone(req.Context())
+	out.URL.Host = t.picker.Pick()
+	return t.base.RoundTrip(out)
+}
@@ -1,41 +1,75 @@
 package routebuilder
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	//"net/url"
+	"net/http/httputil"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 	"log"
+
+	"htmlnojs/config"
 )
 
+// PythonRoute is a route served by a handler backend. Handlers in other
+// languages use it too; Language names the adapter that built it.
 type PythonRoute struct {
 	Name           string
+	Language       string // Handler language, "python" for py_htmx
 	FilePath       string
 	Route          string
 	Method         string
 	Handler        http.HandlerFunc
 	Function       string
 	Parameters     []string
+	Params         []HandlerParam // Parameters with type hints and defaults
 	ReturnType     string
 	RequiresAuth   bool
+	Roles          []string // Any one of them lets a client in, from @roles(admin, staff)
 	RateLimit      int
 	CacheTimeout   int
+	CacheStale     int // Seconds an expired @cache response is still served while it's refreshed, from @cache(60, stale=300)
+	Timeout        int // Seconds before the proxy gives up, 0 uses the default
+	MaxBody        int64 // Largest accepted request body in bytes, 0 means unlimited
+	MaxFile        int64 // Largest accepted file in a multipart upload, 0 means unlimited
+	Deprecation    *Deprecation // Set when the handler is marked @deprecated
+	APIVersion     string // "v1", "v2", ... from the directory or @api_version
+	AliasOf        string // Versioned route this default-version alias serves
+	DemoSafe       bool   // Allowed in demo mode even though the method mutates
+	CSRFExempt     bool   // Accepts requests without a CSRF token, marked with @csrf_exempt
+	Nonce          bool   // Needs a single-use nonce from the page, marked with @nonce
+	Warmup         bool   // Requested at startup, marked with @warmup
+	Owner          string // Team charged for this route, from @owner or config
 	Documentation  string
 	Metadata       map[string]interface{}
 }
 
+// defaultProxyTimeout bounds proxied requests when neither @timeout nor
+// the "default" timeout profile sets a total
+const defaultProxyTimeout = 30 * time.Second
+
 type PythonRouteBuilder struct {
-	pyHTMXDir     string
-	routes        []PythonRoute
-	fastAPIHost   string
-	fastAPIPort   int
-	httpClient    *http.Client
+	pyHTMXDir        string
+	routes           []PythonRoute
+	fastAPIHost      string
+	fastAPIPort      int
+	fastAPIURL       string
+	httpClient       *http.Client
+	warnOnDuplicates bool
+	healthy          func() (bool, string)
+	canary           *canaryRouter
+	hostHeader       string
+	timeoutProfiles  map[string]config.TimeoutProfile
+	dirBackends      []directoryBackend
+	dirTransport     http.RoundTripper
+	passCompressed   bool
 }
 
 // NewPythonRouteBuilder creates a new Python HTMX route builder
@@ -45,8 +79,8 @@ func NewPythonRouteBuilder(pyHTMXDir string) *PythonRouteBuilder {
 		routes:      make([]PythonRoute, 0),
 		fastAPIHost: "localhost",
 		fastAPIPort: 8081, // Default FastAPI port
+		// Deadlines are applied per request, see createProxyHandler
 		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
 			Transport: &http.Transport{
 				MaxIdleConns:       10,
 				IdleConnTimeout:    30 * time.Second,
@@ -62,8 +96,38 @@ func (p *PythonRouteBuilder) SetFastAPIServer(host string, port int) {
 	p.fastAPIPort = port
 }
 
+// SetFastAPIURL points the builder at a backend base URL such as
+// https://handlers.internal:8443 instead of its host and port; empty keeps
+// them
+func (p *PythonRouteBuilder) SetFastAPIURL(baseURL string) {
+	p.fastAPIURL = baseURL
+}
+
+// SetHostHeader sets the Host header of proxied requests: empty sends the
+// backend's address, "preserve" the client's Host, anything else as is
+func (p *PythonRouteBuilder) SetHostHeader(host string) {
+	p.hostHeader = host
+}
+
+// SetTransport makes proxied requests use transport, e.g. one tuned by
+// the project's proxy settings; nil keeps the current one
+func (p *PythonRouteBuilder) SetTransport(transport http.RoundTripper) {
+	if transport != nil {
+		p.httpClient.Transport = transport
+	}
+}
+
+// SetWarnOnDuplicates makes duplicate routes a warning instead of a build
+// error; the first handler found keeps the route
+func (p *PythonRouteBuilder) SetWarnOnDuplicates(warn bool) {
+	p.warnOnDuplicates = warn
+}
+
 // GetFastAPIURL returns the FastAPI server URL
 func (p *PythonRouteBuilder) GetFastAPIURL() string {
+	if p.fastAPIURL != "" {
+		return p.fastAPIURL
+	}
 	return fmt.Sprintf("http://%s:%d", p.fastAPIHost, p.fastAPIPort)
 }
 
@@ -82,6 +146,12 @@ func (p *PythonRouteBuilder) BuildRoutes(pythonFiles []string) ([]PythonRoute, e
 		p.routes = append(p.routes, routes...)
 	}
 
+	routes, err := dedupeRoutes(p.routes, p.warnOnDuplicates)
+	if err != nil {
+		return nil, err
+	}
+	p.routes = routes
+
 	return p.routes, nil
 }
 
@@ -101,14 +171,38 @@ func (p *PythonRouteBuilder) extractRoutesFromFile(filePath string) ([]PythonRou
 	basePath := strings.TrimSuffix(relPath, ".py")
 	basePath = strings.ReplaceAll(basePath, "\\", "/") // Handle Windows paths
 
+	// A custom prefix replaces the file path in the public URL only
+	routePrefix := p.findRoutePrefix(filePath, string(content))
+
 	for _, function := range htmxFunctions {
-		route := p.buildPythonRoute(filePath, basePath, function)
+		route := p.buildPythonRoute(filePath, basePath, routePrefix, function)
 		routes = append(routes, route)
 	}
 
 	return routes, nil
 }
 
+var routePrefixRegex = regexp.MustCompile(`(?m)^__route_prefix__\s*=\s*["']([^"']*)["']`)
+
+// findRoutePrefix returns the URL prefix declared by a module-level
+// __route_prefix__ variable or, failing that, a _prefix file in the module's
+// directory. It returns nil when the file path should be used as-is.
+func (p *PythonRouteBuilder) findRoutePrefix(filePath, content string) *string {
+	if match := routePrefixRegex.FindStringSubmatch(content); match != nil {
+		prefix := strings.Trim(match[1], "/")
+		return &prefix
+	}
+
+	prefixFile := filepath.Join(filepath.Dir(filePath), "_prefix")
+	if data, err := os.ReadFile(prefixFile); err == nil {
+		line := strings.SplitN(string(data), "\n", 2)[0]
+		prefix := strings.Trim(strings.TrimSpace(line), "/")
+		return &prefix
+	}
+
+	return nil
+}
+
 func (p *PythonRouteBuilder) findHTMXFunctions(content string) []FunctionInfo {
 	var functions []FunctionInfo
 
@@ -119,6 +213,7 @@ func (p *PythonRouteBuilder) findHTMXFunctions(content string) []FunctionInfo {
 	for _, match := range matches {
 		functionName := match[1]
 		parameters := p.parseParameters(match[2])
+		params := parseHandlerParams(match[2])
 		returnType := strings.TrimSpace(match[3])
 
 		// Extract documentation if available
@@ -127,6 +222,7 @@ func (p *PythonRouteBuilder) findHTMXFunctions(content string) []FunctionInfo {
 		functions = append(functions, FunctionInfo{
 			Name:          functionName,
 			Parameters:    parameters,
+			Params:        params,
 			ReturnType:    returnType,
 			Documentation: docstring,
 		})
@@ -140,7 +236,7 @@ func (p *PythonRouteBuilder) parseParameters(paramStr string) []string {
 		return []string{}
 	}
 
-	params := strings.Split(paramStr, ",")
+	params := splitParams(paramStr)
 	var cleaned []string
 
 	for _, param := range params {
@@ -198,23 +294,66 @@ func (p *PythonRouteBuilder) extractDocstring(content, functionName string) stri
 	return strings.Join(docLines, " ")
 }
 
-func (p *PythonRouteBuilder) buildPythonRoute(filePath, basePath string, function FunctionInfo) PythonRoute {
+func (p *PythonRouteBuilder) buildPythonRoute(filePath, basePath string, routePrefix *string, function FunctionInfo) PythonRoute {
 	// Extract HTTP method and clean route name from function name
 	method := p.determineHTTPMethod(function.Name)
 	routeName := p.extractRouteName(function.Name)
 
 	// Build API route path - this is what the Go server will expose
+	urlPath := basePath
+	if routePrefix != nil {
+		urlPath = *routePrefix
+	}
+
+	// py_htmx/v2/... is version v2; @api_version(2) versions other handlers
+	apiVersion := apiVersionOf(urlPath)
+	if annotated := p.extractAPIVersion(function.Documentation); annotated != "" {
+		switch {
+		case apiVersion == "":
+			apiVersion = annotated
+			if urlPath == "" || urlPath == "." {
+				urlPath = annotated
+			} else {
+				urlPath = annotated + "/" + urlPath
+			}
+		case apiVersion != annotated:
+			log.Printf("WARNING: Ignoring @api_version(%s) on %s, its directory sets %s", annotated, function.Name, apiVersion)
+		}
+	}
+
 	var goRoutePath string
-	if basePath == "" || basePath == "." {
+	if urlPath == "" || urlPath == "." {
 		goRoutePath = "/api/" + routeName
 	} else {
-		goRoutePath = "/api/" + basePath + "/" + routeName
+		goRoutePath = "/api/" + urlPath + "/" + routeName
 	}
 
 	// Check for special attributes
-	requiresAuth := p.checkRequiresAuth(function.Documentation)
+	roles := p.extractRoles(function.Documentation)
+	requiresAuth := p.checkRequiresAuth(function.Documentation) || len(roles) > 0
 	rateLimit := p.extractRateLimit(function.Documentation)
 	cacheTimeout := p.extractCacheTimeout(function.Documentation)
+	timeouts := p.resolveTimeouts(function.Name, function.Documentation)
+	timeout := 0 // Only listed when something other than the built-in default applies
+	if p.extractTimeout(function.Documentation) > 0 || timeouts.Profile != "" {
+		timeout = int(timeouts.Total / time.Second)
+	}
+	maxBody, err := p.extractMaxBody(function.Documentation)
+	if err != nil {
+		log.Printf("WARNING: Ignoring @max_body on %s: %v", function.Name, err)
+	}
+	maxFile, err := p.extractMaxFile(function.Documentation)
+	if err != nil {
+		log.Printf("WARNING: Ignoring @max_file on %s: %v", function.Name, err)
+	}
+	deprecation, err := p.extractDeprecation(function.Documentation)
+	if err != nil {
+		log.Printf("WARNING: Ignoring sunset on %s: %v", function.Name, err)
+	}
+	responseBuffer, bufferSet, err := p.extractResponseBuffer(function.Documentation)
+	if err != nil {
+		log.Printf("WARNING: Ignoring @buffer on %s: %v", function.Name, err)
+	}
 
 	metadata := map[string]interface{}{
 		"file":         filePath,
@@ -224,19 +363,67 @@ func (p *PythonRouteBuilder) buildPythonRoute(filePath, basePath string, functio
 		"fastapi_url":  p.GetFastAPIURL(),
 		"fastapi_path": p.buildFastAPIPath(basePath, function.Name),
 	}
+	if dirURL := p.directoryBackendURL(basePath); dirURL != "" {
+		metadata["fastapi_url"] = dirURL
+	}
+	if routePrefix != nil {
+		metadata["route_prefix"] = *routePrefix
+	}
+	if timeout > 0 {
+		metadata["timeout"] = timeout
+	}
+	if timeouts.Profile != "" {
+		metadata["timeout_profile"] = timeouts.Profile
+	}
+	if maxBody > 0 {
+		metadata["max_body"] = maxBody
+	}
+	if maxFile > 0 {
+		metadata["max_file"] = maxFile
+	}
+	if deprecation != nil {
+		metadata["deprecated"] = deprecation.String()
+	}
+	if bufferSet {
+		metadata["response_buffer"] = responseBuffer
+	}
+	if apiVersion != "" {
+		metadata["api_version"] = apiVersion
+	}
+
+	handler := p.createProxyHandler(basePath, function.Name, timeouts, maxBody, maxFile)
+	if bufferSet {
+		handler = responseBufferHandler(responseBuffer, handler)
+	}
+	if deprecation != nil {
+		handler = deprecationHandler(deprecation, handler)
+	}
 
 	route := PythonRoute{
 		Name:          routeName,
 		FilePath:      filePath,
 		Route:         goRoutePath,
 		Method:        method,
-		Handler:       p.createProxyHandler(basePath, function.Name),
+		Handler:       handler,
 		Function:      function.Name,
 		Parameters:    function.Parameters,
+		Params:        function.Params,
 		ReturnType:    function.ReturnType,
 		RequiresAuth:  requiresAuth,
+		Roles:         roles,
 		RateLimit:     rateLimit,
 		CacheTimeout:  cacheTimeout,
+		CacheStale:    p.extractCacheStale(function.Documentation),
+		Timeout:       timeout,
+		MaxBody:       maxBody,
+		MaxFile:       maxFile,
+		Deprecation:   deprecation,
+		APIVersion:    apiVersion,
+		DemoSafe:      strings.Contains(function.Documentation, "@demo_safe"),
+		CSRFExempt:    strings.Contains(function.Documentation, "@csrf_exempt"),
+		Nonce:         strings.Contains(function.Documentation, "@nonce"),
+		Warmup:        strings.Contains(function.Documentation, "@warmup"),
+		Owner:         p.extractOwner(function.Documentation),
 		Documentation: function.Documentation,
 		Metadata:      metadata,
 	}
@@ -274,121 +461,182 @@ func (p *PythonRouteBuilder) buildFastAPIPath(basePath, functionName string) str
 	}
 }
 
-// createProxyHandler creates an HTTP handler that proxies requests to FastAPI
-func (p *PythonRouteBuilder) createProxyHandler(basePath, functionName string) http.HandlerFunc {
-    return func(w http.ResponseWriter, r *http.Request) {
-        // Build the FastAPI server URL path
-        fastAPIPath := p.buildFastAPIPath(basePath, functionName)
-        targetURL := p.GetFastAPIURL() + fastAPIPath
-        log.Printf("DEBUG: Proxying %s %s -> %s", r.Method, r.URL.Path, targetURL)
-        log.Printf("DEBUG: Original Content-Type: %s", r.Header.Get("Content-Type"))
-        log.Printf("DEBUG: Original Content-Length: %s", r.Header.Get("Content-Length"))
-
-        // Read the request body
-        var body io.Reader
-        var bodyBytes []byte
-        if r.Body != nil {
-            log.Printf("DEBUG: Reading request body...")
-            var err error
-            bodyBytes, err = io.ReadAll(r.Body)
-            if err != nil {
-                log.Printf("ERROR: Failed to read request body: %v", err)
-                http.Error(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusInternalServerError)
-                return
-            }
-            body = bytes.NewReader(bodyBytes)
-            log.Printf("DEBUG: Read body of %d bytes: %q", len(bodyBytes), string(bodyBytes))
-        } else {
-            log.Printf("DEBUG: No request body to read")
-        }
-
-        // Create the proxy request
-        log.Printf("DEBUG: Creating proxy request...")
-        proxyReq, err := http.NewRequestWithContext(r.Context(), r.Method, targetURL, body)
-        if err != nil {
-            log.Printf("ERROR: Failed to create proxy request: %v", err)
-            http.Error(w, fmt.Sprintf("Failed to create proxy request: %v", err), http.StatusInternalServerError)
-            return
-        }
-
-        // Copy headers from original request (excluding hop-by-hop headers)
-        log.Printf("DEBUG: Copying headers...")
-        copyHeaders(r.Header, proxyReq.Header)
-
-        // Copy query parameters
-        if r.URL.RawQuery != "" {
-            proxyReq.URL.RawQuery = r.URL.RawQuery
-            log.Printf("DEBUG: Copied query parameters: %s", r.URL.RawQuery)
-        }
-
-        // Set content length if we read the body
-        if bodyBytes != nil {
-            proxyReq.ContentLength = int64(len(bodyBytes))
-            log.Printf("DEBUG: Set Content-Length to %d", len(bodyBytes))
-        }
-
-        log.Printf("DEBUG: Final proxy request - Method: %s, URL: %s, Content-Length: %d",
-            proxyReq.Method, proxyReq.URL.String(), proxyReq.ContentLength)
-        log.Printf("DEBUG: Final proxy Content-Type: %s", proxyReq.Header.Get("Content-Type"))
-
-        // Make the request to the FastAPI server
-        log.Printf("DEBUG: Sending request to FastAPI...")
-        resp, err := p.httpClient.Do(proxyReq)
-        if err != nil {
-            log.Printf("ERROR: FastAPI request failed: %v", err)
-            // FastAPI server is not available
-            http.Error(w, fmt.Sprintf(`
-                <div class="htmx-error" style="color: red; padding: 10px; border: 1px solid red; border-radius: 4px;">
-                    <strong>Service Unavailable</strong><br>
-                    The Python handler server is not running on %s<br>
-                    <small>Error: %v</small>
-                </div>
-            `, p.GetFastAPIURL(), err), http.StatusServiceUnavailable)
-            return
-        }
-        defer resp.Body.Close()
-
-        log.Printf("DEBUG: FastAPI responded with status: %d", resp.StatusCode)
-
-        // Copy response headers (excluding hop-by-hop headers)
-        copyHeaders(resp.Header, w.Header())
-
-        // Copy status code
-        w.WriteHeader(resp.StatusCode)
-
-        // Copy response body
-        if _, err := io.Copy(w, resp.Body); err != nil {
-            // Log the error but don't send another response since headers are already sent
-            log.Printf("ERROR: Failed to copy response body from FastAPI: %v", err)
-        } else {
-            log.Printf("DEBUG: Successfully copied response body to client")
-        }
-    }
-}
-
-// copyHeaders copies HTTP headers, excluding hop-by-hop headers
-func copyHeaders(src, dst http.Header) {
-	// Hop-by-hop headers that shouldn't be copied
-	hopByHopHeaders := map[string]bool{
-		"connection":          true,
-		"keep-alive":          true,
-		"proxy-authenticate":  true,
-		"proxy-authorization": true,
-		"te":                  true,
-		"trailers":            true,
-		"transfer-encoding":   true,
-		"upgrade":             true,
-	}
-
-	for key, values := range src {
-		if !hopByHopHeaders[strings.ToLower(key)] {
-			for _, value := range values {
-				dst.Add(key, value)
+// createProxyHandler creates an HTTP handler that proxies requests to
+// FastAPI. Request bodies stream through without being held in memory,
+// and responses over the buffering threshold are flushed as the backend
+// writes them, so large uploads, downloads and streamed fragments pass
+// straight through.
+func (p *PythonRouteBuilder) createProxyHandler(basePath, functionName string, timeouts routeTimeouts, maxBody, maxFile int64) http.HandlerFunc {
+	// Directories with their own backend skip the FastAPI backend's
+	// transport, canary and health checks
+	dirURL := p.directoryBackendURL(basePath)
+	backendURL := func(r *http.Request) string {
+		if dirURL != "" {
+			return dirURL
+		}
+		return p.backendURL(r)
+	}
+	transport := p.httpClient.Transport
+	if dirURL != "" {
+		transport = p.dirTransport
+	}
+	if timeouts.ResponseHeader > 0 {
+		transport = &headerTimeoutTransport{base: transport, timeout: timeouts.ResponseHeader}
+	}
+	transport = &serverTimingTransport{base: transport}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			target, _ := url.Parse(backendURL(pr.In) + p.buildFastAPIPath(basePath, functionName))
+			target.RawQuery = pr.In.URL.RawQuery
+			pr.Out.URL = target
+			switch p.hostHeader {
+			case "":
+				pr.Out.Host = ""
+			case "preserve":
+				pr.Out.Host = pr.In.Host
+			default:
+				pr.Out.Host = p.hostHeader
+			}
+			pr.SetXForwarded()
+			if hasJSONFragment(pr.In.Context()) {
+				// Let the transport negotiate and decode compression, so JSON
+				// arrives readable for the fragment template
+				pr.Out.Header.Del("Accept-Encoding")
+			}
+			rewriteRequestHeaders(pr.In.Context(), pr.Out.Header)
+		},
+		Transport:     transport,
+		FlushInterval: -1,
+		ModifyResponse: func(resp *http.Response) error {
+			rewriteResponseHeaders(resp.Request.Context(), resp.Header)
+			mapBackendError(resp, functionName)
+			if err := renderJSONFragment(resp); err != nil {
+				return err
+			}
+			if err := transformResponse(resp, backendURL(resp.Request)); err != nil {
+				return err
+			}
+			if err := wrapFragmentPage(resp); err != nil {
+				return err
+			}
+			applyFragmentFreshness(resp)
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			var maxBytesErr *http.MaxBytesError
+			var fileErr *FileTooLargeError
+			var circuitErr *CircuitOpenError
+			var headerErr *ResponseHeaderTimeoutError
+			var decodeErr *RequestDecodeError
+			switch {
+			case errors.As(err, &circuitErr):
+				writeCircuitOpen(w, functionName, circuitErr)
+			case errors.As(err, &maxBytesErr):
+				writeBodyTooLarge(w, functionName, maxBytesErr.Limit)
+			case errors.As(err, &decodeErr):
+				writeRequestDecodeError(w, functionName, decodeErr)
+			case errors.As(err, &fileErr):
+				writeFileTooLarge(w, functionName, fileErr)
+			case errors.As(err, &headerErr):
+				// The handler accepted the request but never started answering
+				log.Printf("ERROR: FastAPI request failed: %v", err)
+				WriteFragment(w, http.StatusGatewayTimeout, ErrorFragment(
+					"Request Timed Out",
+					fmt.Sprintf("%s did not start responding within %v", functionName, headerErr.Timeout),
+					"",
+				))
+			case errors.Is(err, context.DeadlineExceeded):
+				// The handler took longer than its deadline
+				log.Printf("ERROR: FastAPI request failed: %v", err)
+				WriteFragment(w, http.StatusGatewayTimeout, ErrorFragment(
+					"Request Timed Out",
+					fmt.Sprintf("%s did not respond within %v", functionName, timeouts.Total),
+					"",
+				))
+			default:
+				// FastAPI server is not available
+				log.Printf("ERROR: FastAPI request failed: %v", err)
+				p.writeBackendUnavailable(w, backendURL(r), fmt.Sprintf("Error: %v", err))
+			}
+		},
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if p.canary != nil && dirURL == "" {
+			r = p.canary.route(w, r)
+		}
+		log.Printf("DEBUG: Proxying %s %s -> %s%s", r.Method, r.URL.Path, backendURL(r), p.buildFastAPIPath(basePath, functionName))
+
+		// The health checks already know the backend is down
+		if p.healthy != nil && dirURL == "" && !isCanary(r.Context()) {
+			if ok, reason := p.healthy(); !ok {
+				w.Header().Set("X-Backend-Status", "down")
+				p.writeBackendUnavailable(w, backendURL(r), fmt.Sprintf("Health check: %s", reason))
+				return
+			}
+		}
+
+		// Handlers get plain bodies whichever way the client compressed them
+		limit := maxBody
+		if !p.passCompressed {
+			decompressed, err := decompressRequest(r)
+			if err != nil {
+				writeRequestDecodeError(w, functionName, err)
+				return
+			}
+			if decompressed && limit == 0 {
+				limit = maxDecompressedBody
+			}
+		}
+
+		// Reject oversized bodies before anything is forwarded; bodies without
+		// a length are cut off while they stream
+		if limit > 0 {
+			if r.ContentLength > limit {
+				writeBodyTooLarge(w, functionName, limit)
+				return
 			}
+			if r.Body != nil {
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+		}
+		limitUploadFiles(r, maxFile)
+
+		// WebSockets (hx-ext="ws") stay open for as long as the page does,
+		// so they're tunnelled without the route's deadline
+		if isWebSocketUpgrade(r) {
+			serveUpgrade(proxy, w, r)
+			return
 		}
+
+		// Proxy with the route's deadlines
+		ctx := withConnectTimeout(withRouteCacheControl(r.Context(), w), timeouts.Connect)
+		ctx, cancel := context.WithTimeout(ctx, timeouts.Total)
+		defer cancel()
+		proxy.ServeHTTP(w, r.WithContext(ctx))
 	}
 }
 
+// writeBackendUnavailable answers in place of a backend that can't be reached
+func (p *PythonRouteBuilder) writeBackendUnavailable(w http.ResponseWriter, backendURL, detail string) {
+	WriteFragment(w, http.StatusServiceUnavailable, ErrorFragment(
+		"Service Unavailable",
+		fmt.Sprintf("The Python handler server is not running on %s", backendURL),
+		detail,
+	))
+}
+
+// writeBodyTooLarge rejects a request whose body exceeds the route's @max_body
+func writeBodyTooLarge(w http.ResponseWriter, functionName string, maxBody int64) {
+	log.Printf("WARNING: Rejected request body over %s for %s", formatByteSize(maxBody), functionName)
+	WriteFragment(w, http.StatusRequestEntityTooLarge, ErrorFragment(
+		"Request Too Large",
+		fmt.Sprintf("%s accepts request bodies up to %s", functionName, formatByteSize(maxBody)),
+		"",
+	))
+}
+
 // determineHTTPMethod extracts HTTP method from function name
 func (p *PythonRouteBuilder) determineHTTPMethod(functionName string) string {
 	name := strings.ToLower(functionName)
@@ -445,7 +693,7 @@ func (p *PythonRouteBuilder) extractRateLimit(doc string) int {
 }
 
 func (p *PythonRouteBuilder) extractCacheTimeout(doc string) int {
-	cacheRegex := regexp.MustCompile(`@cache\((\d+)\)|cache[:\s]+(\d+)`)
+	cacheRegex := regexp.MustCompile(`@cache\(\s*(\d+)\s*(?:,[^)]*)?\)|cache[:\s]+(\d+)`)
 	matches := cacheRegex.FindStringSubmatch(doc)
 	if len(matches) > 1 {
 		if matches[1] != "" {
@@ -458,10 +706,89 @@ func (p *PythonRouteBuilder) extractCacheTimeout(doc string) int {
 	return 0
 }
 
+// extractCacheStale reads stale= from @cache(60, stale=300)
+func (p *PythonRouteBuilder) extractCacheStale(doc string) int {
+	staleRegex := regexp.MustCompile(`@cache\(\s*\d+\s*,\s*stale\s*=\s*(\d+)\s*\)`)
+	if matches := staleRegex.FindStringSubmatch(doc); matches != nil {
+		return parseInt(matches[1])
+	}
+	return 0
+}
+
+func (p *PythonRouteBuilder) extractTimeout(doc string) int {
+	timeoutRegex := regexp.MustCompile(`@timeout\((\d+)\)`)
+	if matches := timeoutRegex.FindStringSubmatch(doc); matches != nil {
+		return parseInt(matches[1])
+	}
+	return 0
+}
+
+func (p *PythonRouteBuilder) extractMaxFile(doc string) (int64, error) {
+	maxFileRegex := regexp.MustCompile(`@max_file\(\s*([^)]*?)\s*\)`)
+	if matches := maxFileRegex.FindStringSubmatch(doc); matches != nil {
+		return parseByteSize(matches[1])
+	}
+	return 0, nil
+}
+
+func (p *PythonRouteBuilder) extractMaxBody(doc string) (int64, error) {
+	maxBodyRegex := regexp.MustCompile(`@max_body\(\s*([^)]*?)\s*\)`)
+	if matches := maxBodyRegex.FindStringSubmatch(doc); matches != nil {
+		return parseByteSize(matches[1])
+	}
+	return 0, nil
+}
+
+// byteSizeUnits are the suffixes accepted by parseByteSize
+var byteSizeUnits = map[string]int64{
+	"":   1,
+	"B":  1,
+	"KB": 1 << 10,
+	"MB": 1 << 20,
+	"GB": 1 << 30,
+}
+
+// parseByteSize parses sizes such as "512", "64KB" or "1.5MB"
+func parseByteSize(s string) (int64, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	unitStart := strings.IndexFunc(value, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	number, unit := value, ""
+	if unitStart >= 0 {
+		number, unit = value[:unitStart], strings.TrimSpace(value[unitStart:])
+	}
+
+	multiplier, ok := byteSizeUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("unknown size unit %q in %q", unit, s)
+	}
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// formatByteSize renders a byte count using the largest whole unit
+func formatByteSize(n int64) string {
+	switch {
+	case n >= 1<<30 && n%(1<<30) == 0:
+		return fmt.Sprintf("%dGB", n>>30)
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dMB", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%dKB", n>>10)
+	default:
+		return fmt.Sprintf("%dB", n)
+	}
+}
+
 // Helper types and functions
 type FunctionInfo struct {
 	Name          string
 	Parameters    []string
+	Params        []HandlerParam
 	ReturnType    string
 	Documentation string
 }
@@ -476,11 +803,30 @@ func parseInt(s string) int {
 	return result
 }
 
+// SetHealthy makes proxied requests fail fast while healthy reports the
+// backend down; nil always proxies
+func (p *PythonRouteBuilder) SetHealthy(healthy func() (ok bool, reason string)) {
+	p.healthy = healthy
+}
+
+// SetCanary sends part of the traffic to the canary backend in cfg
+func (p *PythonRouteBuilder) SetCanary(cfg config.CanaryConfig) {
+	p.canary = newCanaryRouter(cfg)
+}
+
+// backendURL returns the backend a request was routed to
+func (p *PythonRouteBuilder) backendURL(r *http.Request) string {
+	if p.canary != nil && isCanary(r.Context()) {
+		return p.canary.url
+	}
+	return p.GetFastAPIURL()
+}
+
 // Health check for FastAPI server connectivity
-func (p *PythonRouteBuilder) CheckFastAPIHealth() error {
+func (p *PythonRouteBuilder) CheckFastAPIHealth(ctx context.Context) error {
 	healthURL := p.GetFastAPIURL() + "/health"
 
-	req, err := http.NewRequest("GET", healthURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", healthURL, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create health check request: %w", err)
 	}
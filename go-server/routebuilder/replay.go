@@ -0,0 +1,209 @@
+package routebuilder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Replay limits: request bodies over maxReplayBody can't be replayed, and
+// replayed responses are shown up to maxReplayResponse bytes
+const (
+	defaultFailedRequests = 50
+	maxReplayBody         = 1 << 20
+	maxReplayResponse     = 64 << 10
+)
+
+// FailedRequest is a proxied request the backend answered with a 5xx or
+// never answered, kept so it can be sent again
+type FailedRequest struct {
+	ID            string      `json:"id"`
+	Time          time.Time   `json:"time"`
+	Method        string      `json:"method"`
+	URL           string      `json:"url"`     // Secret query fields masked
+	Headers       http.Header `json:"headers"` // Credentials masked
+	Body          string      `json:"body,omitempty"`
+	BodyTruncated bool        `json:"body_truncated,omitempty"` // Too large to replay
+	Status        int         `json:"status,omitempty"`         // 0 when the request failed
+	Error         string      `json:"error,omitempty"`
+
+	url       string      // As sent, signature included
+	header    http.Header // As sent, credentials included
+	body      []byte
+	transport http.RoundTripper // Reaches the backend it was sent to
+}
+
+// ReplayResult is the backend's answer to a replayed request
+type ReplayResult struct {
+	Status        int         `json:"status,omitempty"`
+	Headers       http.Header `json:"headers,omitempty"`
+	Body          string      `json:"body,omitempty"`
+	BodyTruncated bool        `json:"body_truncated,omitempty"`
+	DurationMs    float64     `json:"duration_ms"`
+	Error         string      `json:"error,omitempty"`
+}
+
+// FailedRequests is a ring of the latest failed proxied requests
+type FailedRequests struct {
+	mu       sync.Mutex
+	requests []*FailedRequest
+	next     int
+	capacity int
+	seq      int
+}
+
+// NewFailedRequests keeps the last capacity failed requests, 50 when
+// capacity isn't positive
+func NewFailedRequests(capacity int) *FailedRequests {
+	if capacity <= 0 {
+		capacity = defaultFailedRequests
+	}
+	return &FailedRequests{capacity: capacity}
+}
+
+func (f *FailedRequests) add(request *FailedRequest) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.seq++
+	request.ID = strconv.Itoa(f.seq)
+	if len(f.requests) < f.capacity {
+		f.requests = append(f.requests, request)
+	} else {
+		f.requests[f.next] = request
+		f.next = (f.next + 1) % f.capacity
+	}
+}
+
+// List returns the kept requests newest first
+func (f *FailedRequests) List() []*FailedRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	out := make([]*FailedRequest, 0, len(f.requests))
+	for i := len(f.requests) - 1; i >= 0; i-- {
+		out = append(out, f.requests[(f.next+i)%len(f.requests)])
+	}
+	return out
+}
+
+// Get returns the kept request with id, nil once it was dropped
+func (f *FailedRequests) Get(id string) *FailedRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, request := range f.requests {
+		if request.ID == id {
+			return request
+		}
+	}
+	return nil
+}
+
+// Replay sends the request with id to its backend again, exactly as it was
+// first sent, and returns what came back
+func (f *FailedRequests) Replay(ctx context.Context, id string) (*ReplayResult, error) {
+	failed := f.Get(id)
+	if failed == nil {
+		return nil, fmt.Errorf("no failed request %s is kept", id)
+	}
+	if failed.BodyTruncated {
+		return nil, fmt.Errorf("request %s can't be replayed, its body was over %d bytes", id, maxReplayBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, failed.Method, failed.url, bytes.NewReader(failed.body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header = failed.header.Clone()
+
+	start := time.Now()
+	resp, err := failed.transport.RoundTrip(req)
+	result := &ReplayResult{}
+	if err != nil {
+		result.DurationMs = durationMs(time.Since(start))
+		result.Error = err.Error()
+		return result, nil
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxReplayResponse+1))
+	result.DurationMs = durationMs(time.Since(start))
+	result.Status = resp.StatusCode
+	result.Headers = redactHeader(resp.Header)
+	if len(body) > maxReplayResponse {
+		body = body[:maxReplayResponse]
+		result.BodyTruncated = true
+	}
+	result.Body = string(body)
+	return result, nil
+}
+
+// failureTransport keeps the requests that fail, with their bodies, in
+// failures
+type failureTransport struct {
+	base     http.RoundTripper
+	failures *FailedRequests
+}
+
+func (t *failureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body *capturingBody
+	if req.Body != nil && req.Body != http.NoBody {
+		// RoundTrippers must not modify the caller's request
+		req = req.Clone(req.Context())
+		body = &capturingBody{ReadCloser: req.Body, limit: maxReplayBody + 1}
+		req.Body = body
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	switch {
+	case err != nil && errors.Is(err, context.Canceled):
+		// The client went away, the backend didn't fail
+		return resp, err
+	case err == nil && resp.StatusCode < 500:
+		return resp, err
+	}
+
+	failed := &FailedRequest{
+		Time:      time.Now(),
+		Method:    req.Method,
+		URL:       redactURL(req.URL.String()),
+		Headers:   redactHeader(req.Header),
+		url:       req.URL.String(),
+		header:    req.Header.Clone(),
+		transport: t.base,
+	}
+	if err != nil {
+		failed.Error = err.Error()
+	} else {
+		failed.Status = resp.StatusCode
+	}
+	if body != nil {
+		head, total := body.snapshot()
+		// The backend may have failed before reading all of it
+		failed.BodyTruncated = len(head) > maxReplayBody || (req.ContentLength > 0 && int64(total) < req.ContentLength)
+		if !failed.BodyTruncated {
+			failed.body = head
+			failed.Body = string(head)
+		}
+	}
+	t.failures.add(failed)
+	return resp, err
+}
+
+// redactHeader copies header with credentials masked
+func redactHeader(header http.Header) http.Header {
+	out := header.Clone()
+	for _, name := range redactedHeaders {
+		if _, ok := out[name]; ok {
+			out[name] = []string{"[redacted]"}
+		}
+	}
+	return out
+}
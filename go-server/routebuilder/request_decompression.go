@@ -0,0 +1,123 @@
+package routebuilder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// maxDecompressedBody bounds the decompressed body of routes without
+// @max_body, so a small compressed upload can't expand without limit.
+// Decompressed bodies up to bufferedBody are sent with a Content-Length,
+// which every framework reads; larger ones stream chunked.
+const (
+	maxDecompressedBody = 32 << 20
+	bufferedBody        = 1 << 20
+)
+
+// SetRequestEncoding picks what happens to request bodies a client
+// compressed: "decompress" (default) hands handlers plain bodies,
+// "passthrough" forwards them with their Content-Encoding
+func (p *PythonRouteBuilder) SetRequestEncoding(mode string) {
+	p.passCompressed = mode == "passthrough"
+}
+
+// RequestDecodeError reports a request body that isn't valid for its
+// Content-Encoding
+type RequestDecodeError struct {
+	Encoding string
+	Err      error
+}
+
+func (e *RequestDecodeError) Error() string {
+	return fmt.Sprintf("request body isn't valid %s: %v", e.Encoding, e.Err)
+}
+
+func (e *RequestDecodeError) Unwrap() error {
+	return e.Err
+}
+
+// decompressRequest replaces a gzip or deflate request body with its
+// decompressed bytes and drops the headers describing the compressed one.
+// It reports whether it did, and fails for encodings it doesn't know.
+func decompressRequest(r *http.Request) (bool, error) {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" || r.Body == nil || r.Body == http.NoBody {
+		return false, nil
+	}
+
+	var decoded io.Reader
+	var err error
+	switch encoding {
+	case "gzip", "x-gzip":
+		decoded, err = gzip.NewReader(r.Body)
+	case "deflate":
+		decoded, err = zlib.NewReader(r.Body)
+	default:
+		return false, fmt.Errorf("unsupported Content-Encoding %q", encoding)
+	}
+	if err != nil {
+		return false, &RequestDecodeError{Encoding: encoding, Err: err}
+	}
+
+	body := &decodedBody{Reader: decoded, body: r.Body, encoding: encoding}
+	head, err := io.ReadAll(io.LimitReader(body, bufferedBody+1))
+	if err != nil {
+		return false, err
+	}
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	if len(head) <= bufferedBody {
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(head))
+		r.ContentLength = int64(len(head))
+		return true, nil
+	}
+	body.Reader = io.MultiReader(bytes.NewReader(head), body.Reader)
+	r.Body = body
+	r.ContentLength = -1
+	return true, nil
+}
+
+// decodedBody reads a decompressed request body, reporting corrupt input
+// as a *RequestDecodeError
+type decodedBody struct {
+	io.Reader
+	body     io.ReadCloser
+	encoding string
+}
+
+func (b *decodedBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err != nil && err != io.EOF {
+		err = &RequestDecodeError{Encoding: b.encoding, Err: err}
+	}
+	return n, err
+}
+
+func (b *decodedBody) Close() error {
+	return b.body.Close()
+}
+
+// writeRequestDecodeError rejects a request whose body can't be decompressed
+func writeRequestDecodeError(w http.ResponseWriter, functionName string, err error) {
+	log.Printf("WARNING: Rejected request body for %s: %v", functionName, err)
+	status := http.StatusBadRequest
+	var decodeErr *RequestDecodeError
+	if !errors.As(err, &decodeErr) {
+		// Unknown encodings are the client's choice, RFC 7694 says which work
+		status = http.StatusUnsupportedMediaType
+		w.Header().Set("Accept-Encoding", "gzip, deflate")
+	}
+	WriteFragment(w, status, ErrorFragment(
+		"Bad Request Body",
+		fmt.Sprintf("%s couldn't read the request body: %v", functionName, err),
+		"",
+	))
+}
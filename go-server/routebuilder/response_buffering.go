@@ -0,0 +1,87 @@
+package routebuilder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// defaultResponseBuffer is the largest response held in memory when
+// proxy.buffer_responses isn't set
+const defaultResponseBuffer = 64 << 10
+
+// responseBufferKey carries a route's @buffer limit to bufferingTransport
+type responseBufferKey struct{}
+
+// parseResponseBuffer parses a buffering threshold: a size such as "64KB",
+// or "off" to stream every response. Empty means the default.
+func parseResponseBuffer(s string) (int64, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "":
+		return defaultResponseBuffer, nil
+	case "off":
+		return 0, nil
+	}
+	return parseByteSize(s)
+}
+
+// bufferingTransport reads responses up to limit bytes into memory before
+// handing them on. The backend's connection is released at once, length
+// based transformations see the whole body, and a backend that drops the
+// connection halfway is reported as a failed round trip, which
+// retryTransport can try again. Larger responses, and those without a
+// Content-Length such as streamed fragments, pass through as they arrive.
+type bufferingTransport struct {
+	base  http.RoundTripper
+	limit int64
+}
+
+func (t *bufferingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	limit := t.limit
+	if override, ok := req.Context().Value(responseBufferKey{}).(int64); ok {
+		limit = override
+	}
+	if limit <= 0 || resp.ContentLength < 0 || resp.ContentLength > limit ||
+		req.Method == http.MethodHead || resp.StatusCode == http.StatusSwitchingProtocols {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from %s: %w", req.URL.Host, err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// responseBufferHandler applies a route's @buffer limit to its requests
+func responseBufferHandler(limit int64, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(context.WithValue(r.Context(), responseBufferKey{}, limit)))
+	}
+}
+
+// extractResponseBuffer reads @buffer(size) or @buffer(off); ok is false
+// when the handler has neither
+func (p *PythonRouteBuilder) extractResponseBuffer(doc string) (limit int64, ok bool, err error) {
+	bufferRegex := regexp.MustCompile(`@buffer\(\s*([^)]*?)\s*\)`)
+	matches := bufferRegex.FindStringSubmatch(doc)
+	if matches == nil {
+		return 0, false, nil
+	}
+	if strings.TrimSpace(matches[1]) == "" {
+		return 0, false, fmt.Errorf("expected a size or \"off\"")
+	}
+	limit, err = parseResponseBuffer(matches[1])
+	return limit, err == nil, err
+}
@@ -0,0 +1,230 @@
+package routebuilder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+
+	"htmlnojs/config"
+)
+
+// maxTransformBody bounds the responses transforms are applied to; larger
+// ones stream through untouched
+const maxTransformBody = 4 << 20
+
+// Fragment is a backend HTML response on its way to the client
+type Fragment struct {
+	Request    *http.Request // The client's request
+	Header     http.Header   // Response headers, may be changed
+	BackendURL string        // Base URL of the backend that answered
+	Body       []byte
+}
+
+// ResponseTransform post-processes proxied HTML fragments, e.g. to rewrite
+// URLs or add markup, before they're written to the client
+type ResponseTransform interface {
+	Transform(f *Fragment) error
+}
+
+// ResponseTransformFunc adapts a function to ResponseTransform
+type ResponseTransformFunc func(f *Fragment) error
+
+func (fn ResponseTransformFunc) Transform(f *Fragment) error { return fn(f) }
+
+// ResponseTransformFactory creates a transform from its options in htmlnojs.json
+type ResponseTransformFactory func(options map[string]string) (ResponseTransform, error)
+
+var (
+	responseTransformsMu sync.RWMutex
+	responseTransforms   = map[string]ResponseTransformFactory{
+		"rewrite_urls": newRewriteURLsTransform,
+		"csrf":         newCSRFTransform,
+		"wrap":         newWrapTransform,
+		"sanitize":     newSanitizeTransform,
+	}
+)
+
+// RegisterResponseTransform makes a transform available to the project's
+// "transforms" config under name
+func RegisterResponseTransform(name string, factory ResponseTransformFactory) {
+	responseTransformsMu.Lock()
+	defer responseTransformsMu.Unlock()
+	responseTransforms[name] = factory
+}
+
+// newResponseTransform creates the transform registered as name
+func newResponseTransform(name string, options map[string]string) (ResponseTransform, error) {
+	responseTransformsMu.RLock()
+	factory, ok := responseTransforms[name]
+	responseTransformsMu.RUnlock()
+	if !ok {
+		responseTransformsMu.RLock()
+		names := make([]string, 0, len(responseTransforms))
+		for name := range responseTransforms {
+			names = append(names, name)
+		}
+		responseTransformsMu.RUnlock()
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown transform %q (available: %v)", name, names)
+	}
+	return factory(options)
+}
+
+// transformsKey carries the transforms of the route being served
+type transformsKey struct{}
+
+// applyTransforms makes every handler route carry the configured transforms
+// matching it, for the proxy to run on its responses
+func (a *AllRoutesBuilder) applyTransforms(routes []PythonRoute) error {
+	configs := a.project.Transforms
+	if a.project.Sanitize {
+		// Last, so markup added by other transforms is checked too
+		configs = append(configs[:len(configs):len(configs)], config.TransformConfig{Name: "sanitize"})
+	}
+	if len(configs) == 0 {
+		return nil
+	}
+	transforms := make([]ResponseTransform, len(configs))
+	for i, cfg := range configs {
+		transform, err := newResponseTransform(cfg.Name, cfg.Options)
+		if err != nil {
+			return fmt.Errorf("transforms[%d]: %w", i, err)
+		}
+		transforms[i] = transform
+	}
+
+	for i, route := range routes {
+		var matched []ResponseTransform
+		for j, cfg := range configs {
+			if cfg.Match == "" || MatchRoutePattern(cfg.Match, route.Route) {
+				matched = append(matched, transforms[j])
+			}
+		}
+		if len(matched) == 0 {
+			continue
+		}
+		handler := route.Handler
+		routes[i].Handler = func(w http.ResponseWriter, r *http.Request) {
+			handler(w, r.WithContext(context.WithValue(r.Context(), transformsKey{}, matched)))
+		}
+	}
+	return nil
+}
+
+// transformResponse runs the route's transforms over an HTML response from
+// backendURL. Encoded, oversized and bodyless responses are left alone.
+func transformResponse(resp *http.Response, backendURL string) error {
+	transforms, _ := resp.Request.Context().Value(transformsKey{}).([]ResponseTransform)
+	if len(transforms) == 0 || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/html" {
+		return nil
+	}
+	if resp.Header.Get("Content-Encoding") != "" || resp.ContentLength > maxTransformBody {
+		return nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTransformBody+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxTransformBody {
+		// Too large after all, pass it on as it came
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return nil
+	}
+	resp.Body.Close()
+
+	fragment := &Fragment{Request: resp.Request, Header: resp.Header, BackendURL: backendURL, Body: body}
+	for _, transform := range transforms {
+		if err := transform.Transform(fragment); err != nil {
+			log.Printf("WARNING: Response transform failed for %s: %v", resp.Request.URL.Path, err)
+			break
+		}
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(fragment.Body))
+	resp.ContentLength = int64(len(fragment.Body))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(fragment.Body)))
+	return nil
+}
+
+// newRewriteURLsTransform replaces the absolute backend URL ("from",
+// default the backend's base URL) with "to", default empty so links become
+// relative to the server
+func newRewriteURLsTransform(options map[string]string) (ResponseTransform, error) {
+	from, to := options["from"], options["to"]
+	return ResponseTransformFunc(func(f *Fragment) error {
+		old := from
+		if old == "" {
+			old = f.BackendURL
+		}
+		if old != "" {
+			f.Body = bytes.ReplaceAll(f.Body, []byte(old), []byte(to))
+		}
+		return nil
+	}), nil
+}
+
+var postFormRegex = regexp.MustCompile(`(?i)<form\b[^>]*\bmethod\s*=\s*["']?post\b[^>]*>`)
+
+// newCSRFTransform adds a hidden "field" input (default CSRFField) to
+// every POST form, holding the CSRF token of the client's session, the one
+// the server checks. Without CSRF protection on there is no token and the
+// forms are left alone. A fragment that gets the token is one client's
+// only, so it is marked private and no-store to keep caches from serving
+// it to others.
+func newCSRFTransform(options map[string]string) (ResponseTransform, error) {
+	field := options["field"]
+	if field == "" {
+		field = CSRFField
+	}
+	return ResponseTransformFunc(func(f *Fragment) error {
+		token := csrfToken(f.Request)
+		if token == "" || !postFormRegex.Match(f.Body) {
+			return nil
+		}
+		input := fmt.Sprintf(`<input type="hidden" name="%s" value="%s">`, html.EscapeString(field), html.EscapeString(token))
+		f.Body = postFormRegex.ReplaceAllFunc(f.Body, func(tag []byte) []byte {
+			return append(tag, input...)
+		})
+		f.Header.Set("Cache-Control", "private, no-store")
+		return nil
+	}), nil
+}
+
+// newWrapTransform wraps fragments in a "tag" (default div) with the given
+// "class" and "id"
+func newWrapTransform(options map[string]string) (ResponseTransform, error) {
+	tag := options["tag"]
+	if tag == "" {
+		tag = "div"
+	}
+	if !regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9-]*$`).MatchString(tag) {
+		return nil, fmt.Errorf("wrap: invalid tag %q", tag)
+	}
+	open := "<" + tag
+	for _, attr := range []string{"id", "class"} {
+		if value := options[attr]; value != "" {
+			open += fmt.Sprintf(` %s="%s"`, attr, html.EscapeString(value))
+		}
+	}
+	open += ">"
+	return ResponseTransformFunc(func(f *Fragment) error {
+		f.Body = append(append([]byte(open), f.Body...), "</"+tag+">"...)
+		return nil
+	}), nil
+}
@@ -0,0 +1,79 @@
+package routebuilder
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSRFTransform(t *testing.T) {
+	tests := []struct {
+		name         string
+		options      map[string]string
+		token        string // The session's token, none when CSRF protection is off
+		body         string
+		want         string
+		cacheControl string
+	}{
+		{
+			"post form", nil, "session-token",
+			`<form method="post" action="/api/save"><button>Save</button></form>`,
+			`<form method="post" action="/api/save"><input type="hidden" name="csrf_token" value="session-token"><button>Save</button></form>`,
+			"private, no-store",
+		},
+		{
+			"field option", map[string]string{"field": "_token"}, "session-token",
+			`<form method=POST>`,
+			`<form method=POST><input type="hidden" name="_token" value="session-token">`,
+			"private, no-store",
+		},
+		{
+			"token escaped", nil, `"><script>`,
+			`<form method="post">`,
+			`<form method="post"><input type="hidden" name="csrf_token" value="&#34;&gt;&lt;script&gt;">`,
+			"private, no-store",
+		},
+		{
+			"get form", nil, "session-token",
+			`<form method="get" action="/search"></form>`,
+			`<form method="get" action="/search"></form>`,
+			"public, max-age=60",
+		},
+		{
+			"no form", nil, "session-token",
+			`<p>Saved</p>`,
+			`<p>Saved</p>`,
+			"public, max-age=60",
+		},
+		{
+			"csrf protection off", nil, "",
+			`<form method="post"></form>`,
+			`<form method="post"></form>`,
+			"public, max-age=60",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transform, err := newCSRFTransform(tt.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest("GET", "/api/form", nil)
+			// A client's csrf_token cookie isn't the session's token
+			r.AddCookie(&http.Cookie{Name: "csrf_token", Value: "cookie-token"})
+			if tt.token != "" {
+				r = r.WithContext(WithCSRFToken(r.Context(), tt.token))
+			}
+			f := &Fragment{Request: r, Header: http.Header{"Cache-Control": {"public, max-age=60"}}, Body: []byte(tt.body)}
+			if err := transform.Transform(f); err != nil {
+				t.Fatal(err)
+			}
+			if string(f.Body) != tt.want {
+				t.Errorf("body = %s, want %s", f.Body, tt.want)
+			}
+			if cacheControl := f.Header.Get("Cache-Control"); cacheControl != tt.cacheControl {
+				t.Errorf("Cache-Control = %q, want %q", cacheControl, tt.cacheControl)
+			}
+		})
+	}
+}
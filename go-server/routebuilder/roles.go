@@ -0,0 +1,47 @@
+package routebuilder
+
+import (
+	"regexp"
+	"strings"
+)
+
+// AdminRole is the role templates named *_admin require
+const AdminRole = "admin"
+
+var rolesAnnotationRegex = regexp.MustCompile(`@roles\(([^)]*)\)`)
+
+// extractRoles reads an @roles(admin, staff) annotation from a docstring;
+// any one of the roles lets a client in
+func (p *PythonRouteBuilder) extractRoles(doc string) []string {
+	matches := rolesAnnotationRegex.FindStringSubmatch(doc)
+	if matches == nil {
+		return nil
+	}
+	var roles []string
+	for _, role := range strings.Split(matches[1], ",") {
+		if role = strings.Trim(strings.TrimSpace(role), `"'`); role != "" {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// frontMatterRoles reads the roles a template's front-matter requires,
+// written as "roles: [admin, staff]" or "roles: admin"
+func frontMatterRoles(frontMatter FrontMatter) []string {
+	switch value := frontMatter["roles"].(type) {
+	case string:
+		if value != "" {
+			return []string{value}
+		}
+	case []interface{}:
+		var roles []string
+		for _, item := range value {
+			if role, ok := item.(string); ok && role != "" {
+				roles = append(roles, role)
+			}
+		}
+		return roles
+	}
+	return nil
+}
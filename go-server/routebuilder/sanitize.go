@@ -0,0 +1,214 @@
+package routebuilder
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// droppedElements are removed together with their content
+var droppedElements = map[string]bool{
+	"script":   true,
+	"template": true,
+	"iframe":   true,
+	"frame":    true,
+	"frameset": true,
+	"object":   true,
+	"embed":    true,
+	"applet":   true,
+	// Their content is raw text that would pass through unchecked
+	"noscript":  true,
+	"noembed":   true,
+	"noframes":  true,
+	"xmp":       true,
+	"plaintext": true,
+}
+
+// strippedElements lose their tag but keep their content
+var strippedElements = map[string]bool{
+	"base": true,
+	"meta": true,
+	"link": true,
+}
+
+// urlAttributes may hold javascript: URLs
+var urlAttributes = map[string]bool{
+	"href":       true,
+	"src":        true,
+	"action":     true,
+	"formaction": true,
+	"xlink:href": true,
+	"hx-get":     true,
+	"hx-post":    true,
+	"hx-put":     true,
+	"hx-patch":   true,
+	"hx-delete":  true,
+}
+
+// animationValues are the attributes of SVG animate and set elements that
+// give the animated attribute its values
+var animationValues = map[string]bool{
+	"values": true,
+	"from":   true,
+	"to":     true,
+	"by":     true,
+}
+
+// SanitizeHTML removes what would run JavaScript from an HTML fragment:
+// script and embedding elements, inline event handlers, hx-on attributes,
+// javascript: URLs, js: expressions in hx-vals, hx-trigger event filters
+// and SVG animations of URL attributes. It returns the cleaned fragment
+// and how many elements and attributes were removed or changed.
+func SanitizeHTML(fragment []byte) ([]byte, int) {
+	var out bytes.Buffer
+	removed := 0
+	skipping := "" // Element whose content is being dropped
+	depth := 0
+
+	z := html.NewTokenizer(bytes.NewReader(fragment))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				// The tokenizer only fails on read errors, which bytes.Reader
+				// doesn't have; keep what was cleaned so far
+				log.Printf("WARNING: Sanitizer stopped early: %v", z.Err())
+			}
+			return out.Bytes(), removed
+		}
+		// Token unescapes text in place, so keep the raw bytes first
+		raw := bytes.Clone(z.Raw())
+		token := z.Token()
+
+		if skipping != "" {
+			switch {
+			case tt == html.StartTagToken && token.Data == skipping:
+				depth++
+			case tt == html.EndTagToken && token.Data == skipping:
+				depth--
+				if depth == 0 {
+					skipping = ""
+				}
+			}
+			continue
+		}
+
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if droppedElements[token.Data] {
+				removed++
+				if tt == html.StartTagToken {
+					skipping, depth = token.Data, 1
+				}
+				continue
+			}
+			if strippedElements[token.Data] {
+				removed++
+				continue
+			}
+			// An animation could set an href to a javascript: URL
+			animatesURL := animatesURL(token)
+			kept := token.Attr[:0]
+			for _, attr := range token.Attr {
+				if unsafeAttribute(attr) || (animatesURL && animationValues[strings.ToLower(attr.Key)]) {
+					removed++
+					continue
+				}
+				if name := strings.ToLower(attr.Key); (name == "hx-trigger" || name == "data-hx-trigger") && strings.Contains(attr.Val, "[") {
+					attr.Val = stripTriggerFilters(attr.Val)
+					removed++
+				}
+				kept = append(kept, attr)
+			}
+			token.Attr = kept
+			out.WriteString(token.String())
+		case html.EndTagToken:
+			if droppedElements[token.Data] || strippedElements[token.Data] {
+				continue
+			}
+			out.WriteString(token.String())
+		default:
+			// Text, comments and doctypes pass through as written, so the
+			// contents of style elements aren't escaped
+			out.Write(raw)
+		}
+	}
+}
+
+// unsafeAttribute reports whether attr can run JavaScript
+func unsafeAttribute(attr html.Attribute) bool {
+	name := strings.ToLower(attr.Key)
+	if attr.Namespace != "" {
+		name = attr.Namespace + ":" + name
+	}
+	value := strings.ToLower(strings.TrimSpace(attr.Val))
+	value = strings.Map(func(r rune) rune {
+		// Browsers ignore control characters and whitespace inside schemes
+		if r <= ' ' {
+			return -1
+		}
+		return r
+	}, value)
+
+	switch {
+	case strings.HasPrefix(name, "on"):
+		return true
+	case strings.HasPrefix(name, "hx-on") || strings.HasPrefix(name, "data-hx-on"):
+		return true
+	case name == "srcdoc" || name == "hx-vars" || name == "data-hx-vars":
+		return true
+	case name == "hx-vals" || name == "data-hx-vals" || name == "hx-headers" || name == "data-hx-headers":
+		return strings.HasPrefix(value, "js:") || strings.HasPrefix(value, "javascript:")
+	case urlAttributes[strings.TrimPrefix(name, "data-")]:
+		return strings.HasPrefix(value, "javascript:") || strings.HasPrefix(value, "vbscript:") || strings.HasPrefix(value, "data:text/html")
+	}
+	return false
+}
+
+// animatesURL reports whether token is an SVG animate or set element
+// changing an attribute that holds a URL
+func animatesURL(token html.Token) bool {
+	if token.Data != "animate" && token.Data != "set" {
+		return false
+	}
+	for _, attr := range token.Attr {
+		if strings.ToLower(attr.Key) == "attributename" {
+			return urlAttributes[strings.ToLower(strings.TrimSpace(attr.Val))]
+		}
+	}
+	return false
+}
+
+// stripTriggerFilters removes the event filters from an hx-trigger value,
+// e.g. "[ctrlKey]" in "click[ctrlKey]", which htmx evaluates as JavaScript.
+// Everything after a "[" that isn't closed goes too.
+func stripTriggerFilters(trigger string) string {
+	var b strings.Builder
+	depth := 0
+	for _, r := range trigger {
+		switch {
+		case r == '[':
+			depth++
+		case r == ']' && depth > 0:
+			depth--
+		case depth == 0:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// newSanitizeTransform runs SanitizeHTML over every fragment
+func newSanitizeTransform(options map[string]string) (ResponseTransform, error) {
+	return ResponseTransformFunc(func(f *Fragment) error {
+		body, removed := SanitizeHTML(f.Body)
+		if removed > 0 {
+			log.Printf("WARNING: Removed %d scripts, handlers or unsafe attributes from %s", removed, f.Request.URL.Path)
+			f.Body = body
+		}
+		return nil
+	}), nil
+}
@@ -0,0 +1,47 @@
+package routebuilder
+
+import "testing"
+
+func TestSanitizeHTML(t *testing.T) {
+	tests := []struct {
+		name    string
+		html    string
+		want    string
+		removed int
+	}{
+		{"plain fragment", `<p class="note">Saved <b>2</b> items</p>`, `<p class="note">Saved <b>2</b> items</p>`, 0},
+		{"script", `<p>Hi</p><script>alert(1)</script><p>Bye</p>`, `<p>Hi</p><p>Bye</p>`, 1},
+		{"nested objects", `<object><object></object>x</object><p>ok</p>`, `<p>ok</p>`, 1},
+		{"event handler", `<img src="/a.png" onerror="alert(1)">`, `<img src="/a.png">`, 1},
+		{"hx-on", `<button hx-on:click="alert(1)" data-hx-on-click="alert(1)">Go</button>`, `<button>Go</button>`, 2},
+		{"javascript URL", `<a href="javascript:alert(1)">x</a>`, `<a>x</a>`, 1},
+		{"javascript URL with whitespace", "<a href=\" java\tscript:alert(1)\">x</a>", `<a>x</a>`, 1},
+		{"htmx javascript URL", `<div hx-get="JavaScript:alert(1)" data-hx-post="javascript:alert(1)"></div>`, `<div></div>`, 2},
+		{"data HTML URL", `<a href="data:text/html,<script>alert(1)</script>">x</a>`, `<a>x</a>`, 1},
+		{"safe URL", `<a href="/orders?id=1" hx-get="/api/orders">x</a>`, `<a href="/orders?id=1" hx-get="/api/orders">x</a>`, 0},
+		{"hx-vals expression", `<div hx-vals="js:{a: alert(1)}"></div>`, `<div></div>`, 1},
+		{"hx-vals JSON", `<div hx-vals='{"a": 1}'></div>`, `<div hx-vals="{&#34;a&#34;: 1}"></div>`, 0},
+		{"hx-vars", `<div hx-vars="a:alert(1)"></div>`, `<div></div>`, 1},
+		{"trigger filter", `<button hx-trigger="click[alert(document.cookie)]">x</button>`, `<button hx-trigger="click">x</button>`, 1},
+		{"trigger filter with modifiers", `<input hx-trigger="keyup[key=='Enter'] changed delay:500ms, load">`, `<input hx-trigger="keyup changed delay:500ms, load">`, 1},
+		{"nested trigger filter", `<div data-hx-trigger="click[a[0]]"></div>`, `<div data-hx-trigger="click"></div>`, 1},
+		{"unclosed trigger filter", `<div hx-trigger="click[alert(1)"></div>`, `<div hx-trigger="click"></div>`, 1},
+		{"trigger without filter", `<div hx-trigger="every 2s"></div>`, `<div hx-trigger="every 2s"></div>`, 0},
+		{"animated href", `<svg><a><animate attributeName="href" values="javascript:alert(1)"/><text>x</text></a></svg>`, `<svg><a><animate attributename="href"/><text>x</text></a></svg>`, 1},
+		{"set xlink:href", `<svg><a><set attributeName="xlink:href" to="javascript:alert(1)"></set></a></svg>`, `<svg><a><set attributename="xlink:href"></set></a></svg>`, 1},
+		{"animated color", `<svg><animate attributeName="fill" from="red" to="blue"/></svg>`, `<svg><animate attributename="fill" from="red" to="blue"/></svg>`, 0},
+		{"style kept as written", `<style>p > b { color: red }</style>`, `<style>p > b { color: red }</style>`, 0},
+		{"base and meta", `<base href="https://evil.example/"><meta http-equiv="refresh" content="0;url=javascript:alert(1)"><p>x</p>`, `<p>x</p>`, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, removed := SanitizeHTML([]byte(tt.html))
+			if string(got) != tt.want {
+				t.Errorf("SanitizeHTML() = %s, want %s", got, tt.want)
+			}
+			if removed != tt.removed {
+				t.Errorf("removed = %d, want %d", removed, tt.removed)
+			}
+		})
+	}
+}
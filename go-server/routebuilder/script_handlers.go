@@ -0,0 +1,107 @@
+package routebuilder
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultScriptTimeout bounds embedded script handlers without @timeout;
+// they are meant for tiny jobs, not slow ones
+const defaultScriptTimeout = 5 * time.Second
+
+// scriptRequest is the request as embedded scripts see it
+type scriptRequest struct {
+	Method  string
+	Path    string
+	HTMX    bool
+	Query   map[string]string
+	Form    map[string]string
+	Headers map[string]string // Lowercase names
+}
+
+// newScriptRequest flattens r to the first value of every parameter
+func newScriptRequest(r *http.Request) scriptRequest {
+	r.ParseForm()
+	req := scriptRequest{
+		Method:  r.Method,
+		Path:    r.URL.Path,
+		HTMX:    r.Header.Get("HX-Request") == "true",
+		Query:   map[string]string{},
+		Form:    map[string]string{},
+		Headers: map[string]string{},
+	}
+	for key, values := range r.URL.Query() {
+		req.Query[key] = values[0]
+	}
+	for key, values := range r.PostForm {
+		req.Form[key] = values[0]
+	}
+	for key, values := range r.Header {
+		req.Headers[strings.ToLower(key)] = values[0]
+	}
+	return req
+}
+
+// scriptFunc runs one script handler and returns its HTML and status
+type scriptFunc func(ctx context.Context, req scriptRequest) (string, int, error)
+
+// scriptHandler adapts fn to HTTP, bounding it by timeoutSeconds and
+// turning script errors into the usual error fragment
+func scriptHandler(function string, timeoutSeconds int, fn scriptFunc) http.HandlerFunc {
+	timeout := defaultScriptTimeout
+	if timeoutSeconds > 0 {
+		timeout = time.Duration(timeoutSeconds) * time.Second
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		body, status, err := fn(ctx, newScriptRequest(r))
+		if err != nil {
+			log.Printf("ERROR: Script handler %s failed: %v", function, err)
+			WriteFragment(w, http.StatusInternalServerError, ErrorFragment(
+				"Handler Error", fmt.Sprintf("%s failed", function), "",
+			))
+			return
+		}
+		if status == 0 {
+			status = http.StatusOK
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(status)
+		fmt.Fprint(w, body)
+	}
+}
+
+// scriptModule returns the URL module of a script, like py_htmx module paths
+func scriptModule(dir, file string) string {
+	relPath, _ := filepath.Rel(dir, file)
+	return filepath.ToSlash(strings.TrimSuffix(relPath, filepath.Ext(relPath)))
+}
+
+// buildScriptRoutes loads every file with load and builds its routes
+func buildScriptRoutes(opts HandlerAdapterOptions, files []string, load func(file, module string, source []byte) ([]GoHandler, error)) ([]PythonRoute, error) {
+	var routes []PythonRoute
+	for _, file := range files {
+		source, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		handlers, err := load(file, scriptModule(opts.Dir, file), source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", file, err)
+		}
+		for _, handler := range handlers {
+			handler.file = file
+			routes = append(routes, inProcessRoute(opts, handler))
+		}
+	}
+	return dedupeRoutes(routes, opts.WarnOnDuplicates)
+}
@@ -0,0 +1,28 @@
+package routebuilder
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// addServerTiming adds a Server-Timing metric, which browsers show with
+// the request in their network panel. Metrics the backend sent are kept.
+func addServerTiming(header http.Header, name string, d time.Duration) {
+	header.Add("Server-Timing", fmt.Sprintf("%s;dur=%.1f", name, durationMs(d)))
+}
+
+// serverTimingTransport reports how long the backend took to start
+// answering, retries and waits for a connection included
+type serverTimingTransport struct {
+	base http.RoundTripper
+}
+
+func (t *serverTimingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		addServerTiming(resp.Header, "upstream", time.Since(start))
+	}
+	return resp, err
+}
@@ -0,0 +1,91 @@
+package routebuilder
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestURLSignerVerify(t *testing.T) {
+	signer := NewURLSigner([]byte("url-secret"), time.Hour)
+	sign := func(target string) string {
+		t.Helper()
+		signed, err := signer.Sign(target, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	// signedAt signs path and query as Sign would, with exp set to expires
+	signedAt := func(path string, query url.Values, expires string) string {
+		query.Set(SignedURLExpires, expires)
+		query.Set(SignedURLSignature, signer.signature(path, query))
+		return path + "?" + query.Encode()
+	}
+	valid := sign("/api/reports/download?id=7&format=csv")
+	validURL, _ := url.Parse(valid)
+	reordered := validURL.Query()
+	past := strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10)
+
+	tests := []struct {
+		name   string
+		target string
+		err    error
+	}{
+		{"valid", valid, nil},
+		{"valid without a query", sign("/reports/weekly"), nil},
+		{"parameters reordered", "/api/reports/download?" + reorder(reordered), nil},
+		{"not signed", "/api/reports/download?id=7&format=csv", ErrURLNotSigned},
+		{"empty signature", "/api/reports/download?id=7&" + SignedURLSignature + "=", ErrURLNotSigned},
+		{"other path", strings.Replace(valid, "/download", "/delete", 1), ErrURLSignature},
+		{"changed parameter", strings.Replace(valid, "id=7", "id=8", 1), ErrURLSignature},
+		{"added parameter", valid + "&admin=1", ErrURLSignature},
+		{"removed parameter", strings.Replace(valid, "format=csv&", "", 1), ErrURLSignature},
+		{"extended expiry", withExpiry(valid, time.Now().Add(24*time.Hour)), ErrURLSignature},
+		{"other secret", otherSigner(t, "/api/reports/download?id=7"), ErrURLSignature},
+		{"expired", signedAt("/api/reports/download", url.Values{"id": {"7"}}, past), ErrURLExpired},
+		{"expiry not a number", signedAt("/api/reports/download", url.Values{"id": {"7"}}, "tomorrow"), ErrURLSignature},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := signer.Verify(u); !errors.Is(err, tt.err) {
+				t.Errorf("Verify(%q) = %v, want %v", tt.target, err, tt.err)
+			}
+		})
+	}
+}
+
+// reorder encodes query with its parameters in reverse order
+func reorder(query url.Values) string {
+	encoded := strings.Split(query.Encode(), "&")
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+	return strings.Join(encoded, "&")
+}
+
+// withExpiry replaces the expiry of a signed URL, keeping its signature
+func withExpiry(signed string, expires time.Time) string {
+	u, _ := url.Parse(signed)
+	query := u.Query()
+	query.Set(SignedURLExpires, strconv.FormatInt(expires.Unix(), 10))
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// otherSigner signs target with a different secret
+func otherSigner(t *testing.T, target string) string {
+	t.Helper()
+	signed, err := NewURLSigner([]byte("other-secret"), time.Hour).Sign(target, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
@@ -0,0 +1,137 @@
+package routebuilder
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"sort"
+	"strings"
+
+	"go.starlark.net/lib/json"
+	startime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// StarlarkHandlerLanguage runs star_htmx/*.star handlers in-process
+const StarlarkHandlerLanguage = "starlark"
+
+// maxStarlarkSteps stops runaway loops well before the request timeout
+const maxStarlarkSteps = 10_000_000
+
+// starlarkPredeclared is available to every .star file
+var starlarkPredeclared = starlark.StringDict{
+	"struct": starlark.NewBuiltin("struct", starlarkstruct.Make),
+	"json":   json.Module,
+	"time":   startime.Module,
+	"escape": starlark.NewBuiltin("escape", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var s string
+		if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &s); err != nil {
+			return nil, err
+		}
+		return starlark.String(html.EscapeString(s)), nil
+	}),
+}
+
+// starlarkAdapter serves star_htmx/*.star files. Each htmx_ function takes
+// the request and returns HTML, or a (html, status) tuple:
+//
+//	def htmx_today(request):
+//	    """Today's date @cache(60)"""
+//	    return "<time>%s</time>" % time.now().format("2006-01-02")
+type starlarkAdapter struct{}
+
+func (starlarkAdapter) Name() string { return StarlarkHandlerLanguage }
+
+func (starlarkAdapter) Dir() string { return "star_htmx" }
+
+func (starlarkAdapter) InProcess() bool { return true }
+
+func (starlarkAdapter) Match(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), ".star")
+}
+
+func (starlarkAdapter) BuildRoutes(opts HandlerAdapterOptions, files []string) ([]PythonRoute, error) {
+	return buildScriptRoutes(opts, files, loadStarlark)
+}
+
+// loadStarlark executes a .star file once; its frozen globals are then
+// shared by every request, each running on its own thread
+func loadStarlark(file, module string, source []byte) ([]GoHandler, error) {
+	thread := &starlark.Thread{Name: "load " + file}
+	thread.SetMaxExecutionSteps(maxStarlarkSteps)
+	globals, err := starlark.ExecFileOptions(&syntax.FileOptions{}, thread, file, source, starlarkPredeclared)
+	if err != nil {
+		return nil, err
+	}
+	globals.Freeze()
+
+	names := make([]string, 0, len(globals))
+	for name := range globals {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var handlers []GoHandler
+	for _, name := range names {
+		fn, ok := globals[name].(*starlark.Function)
+		if !ok || !strings.HasPrefix(name, "htmx_") {
+			continue
+		}
+		p := NewPythonRouteBuilder("")
+		handlers = append(handlers, GoHandler{
+			Module:   module,
+			Function: name,
+			Doc:      fn.Doc(),
+			Handler:  scriptHandler(name, p.extractTimeout(fn.Doc()), starlarkFunc(fn)),
+		})
+	}
+	return handlers, nil
+}
+
+// starlarkFunc calls fn with the request as a struct
+func starlarkFunc(fn *starlark.Function) scriptFunc {
+	return func(ctx context.Context, req scriptRequest) (string, int, error) {
+		thread := &starlark.Thread{Name: fn.Name()}
+		thread.SetMaxExecutionSteps(maxStarlarkSteps)
+		stop := context.AfterFunc(ctx, func() { thread.Cancel(ctx.Err().Error()) })
+		defer stop()
+
+		request := starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+			"method":  starlark.String(req.Method),
+			"path":    starlark.String(req.Path),
+			"htmx":    starlark.Bool(req.HTMX),
+			"query":   starlarkDict(req.Query),
+			"form":    starlarkDict(req.Form),
+			"headers": starlarkDict(req.Headers),
+		})
+		result, err := starlark.Call(thread, fn, starlark.Tuple{request}, nil)
+		if err != nil {
+			return "", 0, err
+		}
+
+		switch v := result.(type) {
+		case starlark.String:
+			return string(v), 0, nil
+		case starlark.Tuple:
+			if len(v) == 2 {
+				body, ok := v[0].(starlark.String)
+				status, err := starlark.AsInt32(v[1])
+				if ok && err == nil {
+					return string(body), status, nil
+				}
+			}
+		}
+		return "", 0, fmt.Errorf("%s must return a string or (string, status), got %s", fn.Name(), result.Type())
+	}
+}
+
+func starlarkDict(values map[string]string) *starlark.Dict {
+	dict := starlark.NewDict(len(values))
+	for key, value := range values {
+		dict.SetKey(starlark.String(key), starlark.String(value))
+	}
+	dict.Freeze()
+	return dict
+}
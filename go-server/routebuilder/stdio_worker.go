@@ -0,0 +1,242 @@
+package routebuilder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// stdioWorkerScript is the Python worker run by StartStdioWorker
+//
+//go:embed stdio_worker.py
+var stdioWorkerScript []byte
+
+// errWorkerExited fails requests the worker can no longer answer
+var errWorkerExited = errors.New("python worker exited")
+
+// StdioWorker is a long-lived Python process running py_htmx/ handlers.
+// It is an http.RoundTripper, so proxied routes reach it the way they reach
+// the FastAPI backend: each request becomes a JSON-RPC call written to the
+// worker's stdin and its reply, read from stdout, becomes the response.
+type StdioWorker struct {
+	cmd    *exec.Cmd
+	script string
+	nextID atomic.Int64
+
+	writeMu sync.Mutex
+	stdin   io.WriteCloser
+
+	mu      sync.Mutex
+	pending map[int64]chan rpcReply
+	exited  chan struct{}
+}
+
+type rpcRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      int64  `json:"id"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+type rpcReply struct {
+	ID     int64           `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// workerRequest and workerResponse are the params and result of "handle";
+// bodies are base64 encoded
+type workerRequest struct {
+	Method  string      `json:"method"`
+	Path    string      `json:"path"`
+	Query   string      `json:"query,omitempty"`
+	Headers http.Header `json:"headers"`
+	Body    []byte      `json:"body,omitempty"`
+}
+
+type workerResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    []byte            `json:"body"`
+}
+
+// StartStdioWorker runs python on the worker script for the handlers of dir.
+// Handlers are imported on first use, so changed modules need a restart.
+func StartStdioWorker(python, dir string) (*StdioWorker, error) {
+	script, err := os.CreateTemp("", "htmlnojs-worker-*.py")
+	if err != nil {
+		return nil, fmt.Errorf("failed to write python worker: %w", err)
+	}
+	_, err = script.Write(stdioWorkerScript)
+	script.Close()
+	if err != nil {
+		os.Remove(script.Name())
+		return nil, fmt.Errorf("failed to write python worker: %w", err)
+	}
+
+	cmd := exec.Command(python, script.Name(), dir)
+	cmd.Stderr = os.Stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		os.Remove(script.Name())
+		return nil, fmt.Errorf("failed to start python worker: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		os.Remove(script.Name())
+		return nil, fmt.Errorf("failed to start python worker: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		os.Remove(script.Name())
+		return nil, fmt.Errorf("failed to start python worker: %w", err)
+	}
+	log.Printf("Started Python worker (pid %d) for %s", cmd.Process.Pid, dir)
+
+	w := &StdioWorker{
+		cmd:     cmd,
+		script:  script.Name(),
+		stdin:   stdin,
+		pending: make(map[int64]chan rpcReply),
+		exited:  make(chan struct{}),
+	}
+	go w.readReplies(stdout)
+	return w, nil
+}
+
+// readReplies hands every reply to the request waiting for it until the
+// worker's stdout closes
+func (w *StdioWorker) readReplies(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64<<10), 64<<20)
+	for scanner.Scan() {
+		var reply rpcReply
+		if err := json.Unmarshal(scanner.Bytes(), &reply); err != nil {
+			log.Printf("WARNING: Ignoring malformed python worker reply: %v", err)
+			continue
+		}
+		w.mu.Lock()
+		ch, ok := w.pending[reply.ID]
+		delete(w.pending, reply.ID)
+		w.mu.Unlock()
+		if ok {
+			ch <- reply
+		}
+	}
+
+	if err := w.cmd.Wait(); err != nil {
+		log.Printf("WARNING: Python worker exited: %v", err)
+	}
+	close(w.exited)
+}
+
+// call sends method to the worker and decodes its result into out
+func (w *StdioWorker) call(ctx context.Context, method string, params, out any) error {
+	id := w.nextID.Add(1)
+	ch := make(chan rpcReply, 1)
+	w.mu.Lock()
+	w.pending[id] = ch
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		delete(w.pending, id)
+		w.mu.Unlock()
+	}()
+
+	line, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: id, Method: method, Params: params})
+	if err != nil {
+		return fmt.Errorf("failed to encode %s call: %w", method, err)
+	}
+	w.writeMu.Lock()
+	_, err = w.stdin.Write(append(line, '\n'))
+	w.writeMu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to send %s call: %w", method, errWorkerExited)
+	}
+
+	select {
+	case reply := <-ch:
+		if reply.Error != nil {
+			return fmt.Errorf("python worker %s failed: %s", method, reply.Error.Message)
+		}
+		return json.Unmarshal(reply.Result, out)
+	case <-w.exited:
+		return errWorkerExited
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RoundTrip sends req to the handler at its path
+func (w *StdioWorker) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var result workerResponse
+	err := w.call(req.Context(), "handle", workerRequest{
+		Method:  req.Method,
+		Path:    req.URL.Path,
+		Query:   req.URL.RawQuery,
+		Headers: req.Header,
+		Body:    body,
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make(http.Header, len(result.Headers)+1)
+	for name, value := range result.Headers {
+		header.Set(name, value)
+	}
+	header.Set("Content-Length", strconv.Itoa(len(result.Body)))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", result.Status, http.StatusText(result.Status)),
+		StatusCode:    result.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(result.Body)),
+		ContentLength: int64(len(result.Body)),
+		Request:       req,
+	}, nil
+}
+
+// Check pings the worker, for health checks
+func (w *StdioWorker) Check(ctx context.Context) error {
+	var pong string
+	return w.call(ctx, "ping", nil, &pong)
+}
+
+// Stop ends the worker and waits for it to exit
+func (w *StdioWorker) Stop() {
+	w.stdin.Close()
+	syscall.Kill(-w.cmd.Process.Pid, syscall.SIGTERM)
+	<-w.exited
+	os.Remove(w.script)
+}
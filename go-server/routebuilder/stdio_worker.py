@@ -0,0 +1,124 @@
+# HTMLnoJS stdio worker: runs the htmx_ functions of py_htmx/*.py for the Go
+# server without an HTTP server of its own. Started by the server as
+#
+#   python3 stdio_worker.py <py_htmx dir>
+#
+# Requests arrive on stdin and responses leave on stdout, one JSON-RPC 2.0
+# message per line. "handle" calls py_htmx/items.py's htmx_post_save for the
+# path /items/post_save; "ping" answers health checks. Handlers that print
+# write to stderr, since stdout carries the protocol.
+import asyncio
+import base64
+import importlib.util
+import inspect
+import json
+import pathlib
+import sys
+import traceback
+from urllib.parse import parse_qs
+
+PY_HTMX_DIR = pathlib.Path(sys.argv[1] if len(sys.argv) > 1 else "py_htmx").resolve()
+
+_protocol = sys.stdout
+sys.stdout = sys.stderr
+
+_modules = {}
+_loop = asyncio.new_event_loop()
+
+
+def _load(module):
+    """Import py_htmx/<module>.py once, by file path."""
+    if module not in _modules:
+        file_path = (PY_HTMX_DIR / f"{module}.py").resolve()
+        if PY_HTMX_DIR not in file_path.parents or not file_path.is_file():
+            raise LookupError(f"no handler module {module}")
+        spec = importlib.util.spec_from_file_location(module.replace("/", "."), file_path)
+        mod = importlib.util.module_from_spec(spec)
+        spec.loader.exec_module(mod)
+        _modules[module] = mod
+    return _modules[module]
+
+
+def _first(values):
+    return {k: v[0] if v else "" for k, v in values.items()}
+
+
+def _request_data(params):
+    """Collect JSON, form or query values the same way the FastAPI app does."""
+    if params["method"] in ("GET", "HEAD", "OPTIONS"):
+        return _first(parse_qs(params.get("query", "")))
+
+    body = base64.b64decode(params.get("body") or "").decode("utf-8", errors="replace")
+    content_type = (params.get("headers", {}).get("Content-Type") or [""])[0]
+    if content_type.startswith("application/json"):
+        return json.loads(body) if body else {}
+    return _first(parse_qs(body))
+
+
+def _call(handler_func, data):
+    """Pass request data first, plus any later parameter named in the request."""
+    params = list(inspect.signature(handler_func).parameters.values())[1:]
+    kwargs = {p.name: data[p.name] for p in params
+              if p.name in data and p.kind in (p.POSITIONAL_OR_KEYWORD, p.KEYWORD_ONLY)}
+    return handler_func(data, **kwargs)
+
+
+def _response(status, content, content_type="text/html; charset=utf-8"):
+    return {
+        "status": status,
+        "headers": {"Content-Type": content_type},
+        "body": base64.b64encode(str(content).encode("utf-8")).decode("ascii"),
+    }
+
+
+def _json_response(status, data):
+    return {
+        "status": status,
+        "headers": {"Content-Type": "application/json"},
+        "body": base64.b64encode(json.dumps(data).encode("utf-8")).decode("ascii"),
+    }
+
+
+def handle(params):
+    module, _, name = params["path"].strip("/").rpartition("/")
+    try:
+        handler_func = getattr(_load(module), "htmx_" + name)
+    except (LookupError, AttributeError):
+        return _response(404, f"No handler for {params['path']}", "text/plain; charset=utf-8")
+
+    try:
+        result = _call(handler_func, _request_data(params))
+        if inspect.isawaitable(result):
+            result = _loop.run_until_complete(result)
+        if isinstance(result, (dict, list)):
+            # Rendered by templates/_fragments/<route>.html when it exists
+            return _json_response(200, result)
+        return _response(200, result)
+    except Exception:
+        traceback.print_exc()
+        # Shown by the Go server only while errors.detail is "full"
+        return _response(500, traceback.format_exc(), "text/plain; charset=utf-8")
+
+
+def main():
+    methods = {"handle": handle, "ping": lambda params: "pong"}
+    for line in sys.stdin:
+        if not line.strip():
+            continue
+        message = json.loads(line)
+        reply = {"jsonrpc": "2.0", "id": message.get("id")}
+        method = methods.get(message.get("method"))
+        if method is None:
+            reply["error"] = {"code": -32601, "message": f"unknown method {message.get('method')}"}
+        else:
+            try:
+                reply["result"] = method(message.get("params") or {})
+            except Exception as e:
+                traceback.print_exc()
+                reply["error"] = {"code": -32000, "message": str(e)}
+        _protocol.write(json.dumps(reply) + "\n")
+        _protocol.flush()
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,243 @@
+package routebuilder
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/flosch/pongo2/v6"
+)
+
+// TemplateEngine renders template source into HTML
+type TemplateEngine interface {
+	// Name returns the engine name used in project configuration
+	Name() string
+	// Render executes the template source found at templatePath
+	Render(templatePath string, source []byte, data TemplateData) ([]byte, error)
+}
+
+// TemplateEngineFactory creates an engine for a templates directory
+type TemplateEngineFactory func(templatesDir string) (TemplateEngine, error)
+
+// DefaultTemplateEngine is used when a project does not select an engine
+const DefaultTemplateEngine = "go"
+
+var (
+	templateEnginesMu sync.RWMutex
+	templateEngines   = map[string]TemplateEngineFactory{
+		"go":    newGoTemplateEngine,
+		"jinja": newJinjaTemplateEngine,
+	}
+)
+
+// RegisterTemplateEngine makes an engine selectable by name
+func RegisterTemplateEngine(name string, factory TemplateEngineFactory) {
+	templateEnginesMu.Lock()
+	defer templateEnginesMu.Unlock()
+	templateEngines[name] = factory
+}
+
+// TemplateEngineNames returns the names of all registered engines
+func TemplateEngineNames() []string {
+	templateEnginesMu.RLock()
+	defer templateEnginesMu.RUnlock()
+
+	names := make([]string, 0, len(templateEngines))
+	for name := range templateEngines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewTemplateEngine creates the named engine for a templates directory
+func NewTemplateEngine(name, templatesDir string) (TemplateEngine, error) {
+	if name == "" {
+		name = DefaultTemplateEngine
+	}
+
+	templateEnginesMu.RLock()
+	factory, ok := templateEngines[name]
+	templateEnginesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown template engine %q (available: %v)", name, TemplateEngineNames())
+	}
+
+	return factory(templatesDir)
+}
+
+// goTemplateEngine renders templates with html/template
+type goTemplateEngine struct{}
+
+func newGoTemplateEngine(templatesDir string) (TemplateEngine, error) {
+	return &goTemplateEngine{}, nil
+}
+
+func (e *goTemplateEngine) Name() string {
+	return "go"
+}
+
+func (e *goTemplateEngine) Render(templatePath string, source []byte, data TemplateData) ([]byte, error) {
+	tmpl, err := template.New(filepath.Base(templatePath)).Funcs(TemplateFuncs()).Parse(string(source))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// jinjaTemplateEngine renders Jinja2/Django-style templates with pongo2.
+// Includes and extends are resolved relative to the templates directory.
+type jinjaTemplateEngine struct {
+	set *pongo2.TemplateSet
+}
+
+func newJinjaTemplateEngine(templatesDir string) (TemplateEngine, error) {
+	var loader pongo2.TemplateLoader
+	if root := ProjectRoot(); root != nil {
+		name, err := root.Name(templatesDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create jinja loader for %s: %w", templatesDir, err)
+		}
+		templates, err := fs.Sub(root, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create jinja loader for %s: %w", templatesDir, err)
+		}
+		loader = rootedLoader{templates: templates}
+	} else {
+		local, err := pongo2.NewLocalFileSystemLoader(templatesDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create jinja loader for %s: %w", templatesDir, err)
+		}
+		loader = local
+	}
+
+	set := pongo2.NewSet("htmlnojs", loader)
+	set.Options.TrimBlocks = true
+	set.Options.LStripBlocks = true
+
+	return &jinjaTemplateEngine{set: set}, nil
+}
+
+// rootedLoader resolves includes and extends against the templates
+// directory through the project root, so a name such as "../../secret"
+// doesn't load anything
+type rootedLoader struct {
+	templates fs.FS
+}
+
+func (l rootedLoader) Abs(base, name string) string {
+	return name
+}
+
+func (l rootedLoader) Get(name string) (io.Reader, error) {
+	source, err := fs.ReadFile(l.templates, name)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(source), nil
+}
+
+func (e *jinjaTemplateEngine) Name() string {
+	return "jinja"
+}
+
+func (e *jinjaTemplateEngine) Render(templatePath string, source []byte, data TemplateData) ([]byte, error) {
+	tmpl, err := e.set.FromBytes(source)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := pongo2.Context{
+		"route": data.Route,
+		"path":  data.Path,
+		"query": data.Query,
+		"htmx":  data.HTMX,
+		"meta":  data.Meta,
+		"data":  data.Data,
+
+		"boosted":       data.Boosted,
+		"target":        data.Target,
+		"trigger":       data.Trigger,
+		"trigger_name":  data.TriggerName,
+		"current_url":   data.CurrentURL,
+		"csrf_token":    data.CSRFToken,
+		"flashes":       jinjaFlashes(data.Flashes),
+		"flash_banners": pongo2.AsSafeValue(FlashFragment(data.Flashes)),
+		"nonce":         jinjaFunc(data.Nonce),
+		"nonce_field":   jinjaFunc(data.NonceField),
+	}
+	for name, fn := range TemplateFuncs() {
+		ctx[name] = jinjaFunc(fn)
+	}
+
+	return tmpl.ExecuteBytes(ctx)
+}
+
+// jinjaFlashes lists flash messages with the lower-case keys Jinja
+// templates expect, e.g. {{ flash.message }}
+func jinjaFlashes(flashes []Flash) []map[string]string {
+	result := make([]map[string]string, len(flashes))
+	for i, flash := range flashes {
+		result[i] = map[string]string{"level": flash.Level, "message": flash.Message}
+	}
+	return result
+}
+
+var (
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+	htmlType  = reflect.TypeOf(template.HTML(""))
+)
+
+// jinjaFunc adapts an html/template function so pongo2 can call it. Results
+// of type template.HTML are marked safe so they are not escaped twice.
+func jinjaFunc(fn interface{}) func(args ...interface{}) (*pongo2.Value, error) {
+	fnValue := reflect.ValueOf(fn)
+	fnType := fnValue.Type()
+
+	return func(args ...interface{}) (*pongo2.Value, error) {
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			var want reflect.Type
+			switch {
+			case fnType.IsVariadic() && i >= fnType.NumIn()-1:
+				want = fnType.In(fnType.NumIn() - 1).Elem()
+			case i < fnType.NumIn():
+				want = fnType.In(i)
+			default:
+				return nil, fmt.Errorf("too many arguments")
+			}
+
+			value := reflect.ValueOf(arg)
+			if !value.IsValid() {
+				value = reflect.Zero(want)
+			} else if value.Type().ConvertibleTo(want) {
+				value = value.Convert(want)
+			}
+			in[i] = value
+		}
+
+		out := fnValue.Call(in)
+		if len(out) == 2 && fnType.Out(1).Implements(errorType) && !out[1].IsNil() {
+			return nil, out[1].Interface().(error)
+		}
+		if len(out) == 0 {
+			return pongo2.AsValue(nil), nil
+		}
+
+		if out[0].Type() == htmlType {
+			return pongo2.AsSafeValue(out[0].String()), nil
+		}
+		return pongo2.AsValue(out[0].Interface()), nil
+	}
+}
@@ -0,0 +1,104 @@
+package routebuilder
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	templateFuncsMu sync.RWMutex
+	templateFuncs   = template.FuncMap{"signed_url": signedURL}
+)
+
+// templateHelperClient calls Python helpers backing template functions
+var templateHelperClient = &http.Client{Timeout: 5 * time.Second}
+
+// SetTemplateHelperTransport makes Python helpers reach the backend through
+// transport, e.g. the one dialing proxy.unix_socket
+func SetTemplateHelperTransport(transport http.RoundTripper) {
+	templateHelperClient.Transport = transport
+}
+
+// RegisterTemplateFunc makes fn available to every template under name.
+// Functions follow html/template rules: one result, or a result and an error.
+func RegisterTemplateFunc(name string, fn interface{}) {
+	templateFuncsMu.Lock()
+	defer templateFuncsMu.Unlock()
+	templateFuncs[name] = fn
+}
+
+// RegisterTemplateFuncs registers every entry of funcs
+func RegisterTemplateFuncs(funcs template.FuncMap) {
+	for name, fn := range funcs {
+		RegisterTemplateFunc(name, fn)
+	}
+}
+
+// TemplateFuncs returns a snapshot of the registered template functions
+func TemplateFuncs() template.FuncMap {
+	templateFuncsMu.RLock()
+	defer templateFuncsMu.RUnlock()
+
+	funcs := make(template.FuncMap, len(templateFuncs))
+	for name, fn := range templateFuncs {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+// RegisterPythonTemplateFunc registers a template function implemented by a
+// Python helper. The helper is given as "module.htmx_function" (e.g.
+// "utils.htmx_current_time") and is called on the FastAPI server with its
+// arguments passed as arg0, arg1, ... query parameters. The returned HTML is
+// inserted without escaping.
+func RegisterPythonTemplateFunc(name, helper, fastAPIURL string) error {
+	dot := strings.LastIndex(helper, ".")
+	if dot <= 0 || dot == len(helper)-1 {
+		return fmt.Errorf("template func %s: helper %q must be module.function", name, helper)
+	}
+
+	module := strings.ReplaceAll(helper[:dot], ".", "/")
+	function := helper[dot+1:]
+	if !strings.HasPrefix(function, "htmx_") {
+		return fmt.Errorf("template func %s: helper %q must be an htmx_ function", name, helper)
+	}
+
+	// Same path the FastAPI app mounts the helper at (see buildFastAPIPath)
+	helperURL := fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(fastAPIURL, "/"), module, strings.TrimPrefix(function, "htmx_"))
+
+	RegisterTemplateFunc(name, func(args ...interface{}) (template.HTML, error) {
+		query := url.Values{}
+		for i, arg := range args {
+			query.Set(fmt.Sprintf("arg%d", i), fmt.Sprint(arg))
+		}
+
+		target := helperURL
+		if len(query) > 0 {
+			target += "?" + query.Encode()
+		}
+
+		resp, err := templateHelperClient.Get(target)
+		if err != nil {
+			return "", fmt.Errorf("template func %s: %w", name, err)
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return "", fmt.Errorf("template func %s: %w", name, err)
+		}
+		if resp.StatusCode >= 400 {
+			return "", fmt.Errorf("template func %s: helper returned status %d", name, resp.StatusCode)
+		}
+
+		return template.HTML(body), nil
+	})
+
+	return nil
+}
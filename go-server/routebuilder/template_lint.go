@@ -0,0 +1,86 @@
+package routebuilder
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	// templateActionRegex matches {{ ... }} actions, including trim markers
+	templateActionRegex = regexp.MustCompile(`\{\{-?\s*(.*?)\s*-?\}\}`)
+	// jinjaTagRegex matches Jinja/Django statement and comment tags
+	jinjaTagRegex = regexp.MustCompile(`\{%-?\s*(\w*).*?%\}|\{#.*?#\}`)
+	// bareIdentifierRegex matches an action starting with a plain variable name
+	bareIdentifierRegex = regexp.MustCompile(`^([A-Za-z_]\w*)`)
+)
+
+// goTemplateWords are keywords and builtin functions of html/template
+var goTemplateWords = map[string]bool{
+	"if": true, "else": true, "end": true, "range": true, "with": true,
+	"define": true, "template": true, "block": true, "break": true, "continue": true,
+	"nil": true, "true": true, "false": true,
+	"and": true, "or": true, "not": true, "len": true, "index": true, "slice": true,
+	"print": true, "printf": true, "println": true, "html": true, "js": true,
+	"urlquery": true, "call": true, "eq": true, "ne": true, "lt": true,
+	"le": true, "gt": true, "ge": true,
+}
+
+// goOnlyActionRegex matches actions that only make sense in Go templates
+var goOnlyActionRegex = regexp.MustCompile(`^(\.|\$|/\*|(end|range|define|template|block|with|else)\b|if\s)`)
+
+// LintTemplateSyntax reports syntax that belongs to a different engine than
+// the one selected for the project. Mixing them fails silently: Jinja tags
+// render as literal text under the Go engine and vice versa.
+func LintTemplateSyntax(engine, templatePath string, source []byte) []LintIssue {
+	if engine == "" {
+		engine = DefaultTemplateEngine
+	}
+
+	funcs := TemplateFuncs()
+	var issues []LintIssue
+
+	for i, line := range strings.Split(string(source), "\n") {
+		lineNo := i + 1
+
+		switch engine {
+		case "go":
+			for _, match := range jinjaTagRegex.FindAllString(line, -1) {
+				issues = append(issues, LintIssue{
+					File:    templatePath,
+					Line:    lineNo,
+					Rule:    "template-syntax",
+					Message: fmt.Sprintf("Jinja/Django tag %q is not supported by the go template engine", match),
+				})
+			}
+
+			for _, match := range templateActionRegex.FindAllStringSubmatch(line, -1) {
+				ident := bareIdentifierRegex.FindString(match[1])
+				if ident == "" || goTemplateWords[ident] || funcs[ident] != nil {
+					continue
+				}
+				issues = append(issues, LintIssue{
+					File:    templatePath,
+					Line:    lineNo,
+					Rule:    "template-syntax",
+					Message: fmt.Sprintf("%q looks like a Jinja variable; the go template engine expects {{ .%s }}", match[0], ident),
+				})
+			}
+
+		case "jinja":
+			for _, match := range templateActionRegex.FindAllStringSubmatch(line, -1) {
+				if !goOnlyActionRegex.MatchString(match[1]) {
+					continue
+				}
+				issues = append(issues, LintIssue{
+					File:    templatePath,
+					Line:    lineNo,
+					Rule:    "template-syntax",
+					Message: fmt.Sprintf("%q is Go template syntax, which the jinja template engine does not support", match[0]),
+				})
+			}
+		}
+	}
+
+	return issues
+}
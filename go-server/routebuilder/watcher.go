@@ -0,0 +1,98 @@
+package routebuilder
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Watcher polls a handler directory and invokes a callback whenever handler
+// files are added, removed, renamed or modified
+type Watcher struct {
+	dir       string
+	extension string
+	interval  time.Duration
+	onChange  func()
+	snapshot  map[string]fileState
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewWatcher creates a watcher for files with the given extension under dir
+func NewWatcher(dir, extension string, interval time.Duration, onChange func()) *Watcher {
+	return &Watcher{
+		dir:       dir,
+		extension: strings.ToLower(extension),
+		interval:  interval,
+		onChange:  onChange,
+		stop:      make(chan struct{}),
+	}
+}
+
+// Start begins polling in the background
+func (w *Watcher) Start() {
+	w.snapshot = w.scan()
+	log.Printf("Watching %s for %s changes (every %v)", w.dir, w.extension, w.interval)
+
+	go func() {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				current := w.scan()
+				if w.changed(current) {
+					w.snapshot = current
+					log.Printf("Detected handler changes in %s, rebuilding routes...", w.dir)
+					w.onChange()
+				}
+			}
+		}
+	}()
+}
+
+// Stop ends polling
+func (w *Watcher) Stop() {
+	w.stopOnce.Do(func() { close(w.stop) })
+}
+
+func (w *Watcher) scan() map[string]fileState {
+	files := make(map[string]fileState)
+
+	filepath.Walk(w.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info.IsDir() || !strings.HasSuffix(strings.ToLower(path), w.extension) {
+			return nil
+		}
+		files[path] = fileState{modTime: info.ModTime(), size: info.Size()}
+		return nil
+	})
+
+	return files
+}
+
+func (w *Watcher) changed(current map[string]fileState) bool {
+	if len(current) != len(w.snapshot) {
+		return true
+	}
+	for path, state := range current {
+		prev, ok := w.snapshot[path]
+		if !ok || prev != state {
+			return true
+		}
+	}
+	return false
+}
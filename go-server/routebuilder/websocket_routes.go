@@ -0,0 +1,108 @@
+package routebuilder
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// WebSocketRoute tunnels WebSocket connections on Route to Target, for
+// hx-ext="ws" endpoints that aren't handler routes
+type WebSocketRoute struct {
+	Route   string
+	Target  string
+	Method  string
+	Handler http.HandlerFunc
+}
+
+// BuildWebSocketRoutes creates a tunnel for each configured endpoint,
+// keyed by the path it's served at, connecting through transport
+func BuildWebSocketRoutes(endpoints map[string]string, transport http.RoundTripper) ([]WebSocketRoute, error) {
+	paths := make([]string, 0, len(endpoints))
+	for path := range endpoints {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	routes := make([]WebSocketRoute, 0, len(paths))
+	for _, path := range paths {
+		target, err := url.Parse(endpoints[path])
+		if err != nil {
+			return nil, fmt.Errorf("invalid websocket endpoint for %s: %w", path, err)
+		}
+		routes = append(routes, WebSocketRoute{
+			Route:   path,
+			Target:  target.String(),
+			Method:  "GET",
+			Handler: newWebSocketHandler(target, transport),
+		})
+	}
+	return routes, nil
+}
+
+// newWebSocketHandler tunnels upgrades to target; plain requests are refused
+func newWebSocketHandler(target *url.URL, transport http.RoundTripper) http.HandlerFunc {
+	backend := *target
+	switch backend.Scheme {
+	case "ws":
+		backend.Scheme = "http"
+	case "wss":
+		backend.Scheme = "https"
+	}
+
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			out := backend
+			if out.RawQuery == "" {
+				out.RawQuery = pr.In.URL.RawQuery
+			}
+			pr.Out.URL = &out
+			pr.Out.Host = ""
+			pr.SetXForwarded()
+		},
+		Transport: transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("ERROR: WebSocket tunnel to %s failed: %v", target, err)
+			http.Error(w, "WebSocket endpoint unavailable", http.StatusBadGateway)
+		},
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !isWebSocketUpgrade(r) {
+			w.Header().Set("Upgrade", "websocket")
+			http.Error(w, "This endpoint only accepts WebSocket connections", http.StatusUpgradeRequired)
+			return
+		}
+		serveUpgrade(proxy, w, r)
+	}
+}
+
+// isWebSocketUpgrade reports whether r asks to switch to the WebSocket protocol
+func isWebSocketUpgrade(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, value := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serveUpgrade hands an upgrade to proxy, which tunnels the connection both
+// ways until either side closes it. The server's read and write timeouts
+// would cut long-lived sockets off, so they're lifted for this connection.
+func serveUpgrade(proxy *httputil.ReverseProxy, w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	rc.SetReadDeadline(time.Time{})
+	rc.SetWriteDeadline(time.Time{})
+	proxy.ServeHTTP(w, r)
+}
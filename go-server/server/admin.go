@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Admin says who may use the /_admin endpoints, which change the running
+// server and expose the traffic going through it
+type Admin struct {
+	Token string   // Sent as "Authorization: Bearer <token>"
+	Roles []string // Clients signed in with any of them get in too
+}
+
+// adminOnly serves next to requests carrying the admin token, or signed in
+// with one of the admin roles, and turns the rest away. Requests a browser
+// sends on behalf of another site are refused whatever they carry, so a
+// page open in the operator's browser can't use their session.
+func (s *Server) adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	check := func(w http.ResponseWriter, r *http.Request) {
+		if crossSite(r) {
+			http.Error(w, "admin endpoints can't be called from another site", http.StatusForbidden)
+			return
+		}
+		if !s.isAdmin(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "admin endpoints need the admin token", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+	if s.sessions == nil {
+		return check
+	}
+	return s.sessions.middleware(http.HandlerFunc(check)).ServeHTTP
+}
+
+// isAdmin reports whether r carries the admin token or an identity with
+// an admin role
+func (s *Server) isAdmin(r *http.Request) bool {
+	if s.admin == nil {
+		return false
+	}
+	if s.admin.Token != "" {
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		if ok && strings.EqualFold(scheme, "Bearer") && secretsEqual(token, s.admin.Token) {
+			return true
+		}
+	}
+	if len(s.admin.Roles) == 0 {
+		return false
+	}
+	// A signed URL opens one page, it doesn't sign anyone in
+	id := s.authenticate(r)
+	if id == nil || id.Method == "signed_url" {
+		return false
+	}
+	for _, role := range s.rolesOf(id) {
+		for _, admin := range s.admin.Roles {
+			if role == admin {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// crossSite reports whether a browser sent r for a page on another origin
+func crossSite(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site == "cross-site" || site == "same-site" {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+	u, err := url.Parse(origin)
+	return err != nil || u.Host != r.Host
+}
+
+// isLoopback reports whether the client behind r is on this machine
+func isLoopback(r *http.Request) bool {
+	ip := net.ParseIP(clientAddress(r))
+	return ip != nil && ip.IsLoopback()
+}
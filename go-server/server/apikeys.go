@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"htmlnojs/routebuilder"
+	"htmlnojs/store"
+)
+
+// APIKeyHeader tells handlers which API key a request used, by its name
+const APIKeyHeader = "X-HTMLnoJS-API-Key"
+
+// APIKey is one programmatic client's key
+type APIKey struct {
+	Name      string // Client the key belongs to, shown in logs and metrics
+	Key       string
+	RateLimit int // Requests per minute, 0 means unlimited
+}
+
+// APIKeyAuth authenticates handler requests carrying an API key in a
+// header or query parameter, and holds each key to its rate limit
+type APIKeyAuth struct {
+	Header string // Header carrying the key (default "X-API-Key")
+	Query  string // Query parameter also carrying it, empty to accept only the header
+
+	keys  map[[32]byte]APIKey // By the key's hash, so lookups don't leak timing
+	limit store.Store
+	usage sync.Map // Key name to *apiKeyUsage
+}
+
+// apiKeyUsage counts one key's requests
+type apiKeyUsage struct {
+	mu      sync.Mutex
+	total   int64
+	limited int64
+}
+
+// NewAPIKeyAuth creates an API key authenticator; rate limits are counted
+// in st, so servers sharing a store share them
+func NewAPIKeyAuth(st store.Store, header, query string, keys []APIKey) *APIKeyAuth {
+	if header == "" {
+		header = "X-API-Key"
+	}
+	a := &APIKeyAuth{
+		Header: header,
+		Query:  query,
+		keys:   make(map[[32]byte]APIKey, len(keys)),
+		limit:  store.WithPrefix(st, "ratelimit:apikey:"),
+	}
+	for _, key := range keys {
+		a.keys[sha256.Sum256([]byte(key.Key))] = key
+	}
+	return a
+}
+
+// presented returns the key a request carries, or ""
+func (a *APIKeyAuth) presented(r *http.Request) string {
+	if key := r.Header.Get(a.Header); key != "" {
+		return key
+	}
+	if a.Query != "" {
+		return r.URL.Query().Get(a.Query)
+	}
+	return ""
+}
+
+func (a *APIKeyAuth) Authenticate(r *http.Request) *Identity {
+	key, ok := a.keys[sha256.Sum256([]byte(a.presented(r)))]
+	if !ok {
+		return nil
+	}
+	return &Identity{Subject: key.Name, Method: "api_key"}
+}
+
+// guard checks the API key a handler request carries: unknown keys get a
+// 401 and keys over their rate limit a 429. The key is taken off the
+// request, and handlers see its name in APIKeyHeader instead.
+func (a *APIKeyAuth) guard(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del(APIKeyHeader)
+		presented := a.presented(r)
+		if presented == "" {
+			next(w, r)
+			return
+		}
+		key, ok := a.keys[sha256.Sum256([]byte(presented))]
+		if !ok {
+			routebuilder.WriteFragment(w, http.StatusUnauthorized, routebuilder.ErrorFragment(
+				"Invalid API Key",
+				"The API key sent with this request isn't valid",
+				"",
+			))
+			return
+		}
+
+		usage := a.usageOf(key.Name)
+		if key.RateLimit > 0 {
+			remaining, reset, allowed := a.allow(r.Context(), key)
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(key.RateLimit))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+			w.Header().Set("X-RateLimit-Reset", strconv.Itoa(reset))
+			if !allowed {
+				usage.record(true)
+				w.Header().Set("Retry-After", strconv.Itoa(reset))
+				routebuilder.WriteFragment(w, http.StatusTooManyRequests, routebuilder.ErrorFragment(
+					"Rate Limit Reached",
+					fmt.Sprintf("This API key allows %d requests a minute", key.RateLimit),
+					"",
+				))
+				return
+			}
+		}
+		usage.record(false)
+
+		r.Header.Del(a.Header)
+		if a.Query != "" && r.URL.Query().Has(a.Query) {
+			query := r.URL.Query()
+			query.Del(a.Query)
+			r.URL.RawQuery = query.Encode()
+		}
+		r.Header.Set(APIKeyHeader, key.Name)
+		id := &Identity{Subject: key.Name, Method: "api_key"}
+		next(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+	}
+}
+
+// allow counts a request against key's limit for the current minute and
+// reports what's left of it and the seconds until it resets
+func (a *APIKeyAuth) allow(ctx context.Context, key APIKey) (remaining, reset int, allowed bool) {
+	now := time.Now()
+	window := now.Truncate(time.Minute)
+	reset = int(window.Add(time.Minute).Sub(now).Seconds()) + 1
+	count, err := a.limit.Incr(ctx, key.Name+":"+strconv.FormatInt(window.Unix(), 10), 1, 2*time.Minute)
+	if err != nil {
+		// A store outage shouldn't lock every client out
+		log.Printf("WARNING: API key rate limit not checked, the store failed: %v", err)
+		return key.RateLimit, reset, true
+	}
+	return max(0, key.RateLimit-int(count)), reset, count <= int64(key.RateLimit)
+}
+
+func (a *APIKeyAuth) usageOf(name string) *apiKeyUsage {
+	usage, _ := a.usage.LoadOrStore(name, &apiKeyUsage{})
+	return usage.(*apiKeyUsage)
+}
+
+func (u *apiKeyUsage) record(limited bool) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.total++
+	if limited {
+		u.limited++
+	}
+}
+
+// writeMetrics lists each key's requests and rate limited requests
+func (a *APIKeyAuth) writeMetrics(w io.Writer) {
+	var names []string
+	a.usage.Range(func(name, _ any) bool {
+		names = append(names, name.(string))
+		return true
+	})
+	sort.Strings(names)
+	for _, name := range names {
+		usage := a.usageOf(name)
+		usage.mu.Lock()
+		fmt.Fprintf(w, "api_key_requests_total{key=%q} %d\n", name, usage.total)
+		fmt.Fprintf(w, "api_key_rate_limited_total{key=%q} %d\n", name, usage.limited)
+		usage.mu.Unlock()
+	}
+}
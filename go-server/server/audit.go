@@ -0,0 +1,182 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AuditEntry records who did what through a protected handler
+type AuditEntry struct {
+	Time       string  `json:"time"`
+	User       string  `json:"user"`
+	Auth       string  `json:"auth"` // How the user signed in, e.g. "session" or "api_key"
+	Method     string  `json:"method"`
+	Path       string  `json:"path"`
+	Route      string  `json:"route"`
+	Status     int     `json:"status"`
+	Address    string  `json:"address"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// AuditLog appends an entry for every state-changing request a signed-in
+// user makes to a handler needing auth or roles, to a file as JSON lines
+// and to a webhook
+type AuditLog struct {
+	methods []string
+
+	mu     sync.Mutex
+	file   *os.File
+	closed bool
+
+	webhook string
+	client  *http.Client
+	queue   chan AuditEntry
+	done    chan struct{}
+
+	entries  atomic.Int64
+	failures atomic.Int64 // Entries that didn't reach the file or webhook
+}
+
+// NewAuditLog opens file for appending and starts delivering to webhook;
+// either may be empty. Methods default to POST, PUT, PATCH and DELETE.
+func NewAuditLog(file, webhook string, methods []string) (*AuditLog, error) {
+	if len(methods) == 0 {
+		methods = []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	}
+	a := &AuditLog{methods: methods, webhook: webhook}
+	if file != "" {
+		f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open audit log %s: %w", file, err)
+		}
+		a.file = f
+	}
+	if webhook != "" {
+		a.client = &http.Client{Timeout: 10 * time.Second}
+		a.queue = make(chan AuditEntry, 1024)
+		a.done = make(chan struct{})
+		go a.deliver()
+	}
+	return a, nil
+}
+
+// Close delivers the entries still queued for the webhook and closes the
+// file; entries recorded afterwards are dropped
+func (a *AuditLog) Close() {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return
+	}
+	a.closed = true
+	if a.file != nil {
+		a.file.Close()
+	}
+	if a.queue != nil {
+		close(a.queue)
+	}
+	a.mu.Unlock()
+	if a.done != nil {
+		<-a.done
+	}
+}
+
+// record writes entry to the file before the response is done, and queues
+// it for the webhook
+func (a *AuditLog) record(entry AuditEntry) {
+	a.entries.Add(1)
+	line, _ := json.Marshal(entry)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		a.failures.Add(1)
+		return
+	}
+	if a.file != nil {
+		if _, err := a.file.Write(append(line, '\n')); err != nil {
+			a.failures.Add(1)
+			log.Printf("ERROR: Failed to write audit log entry for %s %s by %s: %v", entry.Method, entry.Path, entry.User, err)
+		}
+	}
+	if a.queue != nil {
+		select {
+		case a.queue <- entry:
+		default:
+			a.failures.Add(1)
+			log.Printf("WARNING: Audit webhook is falling behind, dropped the entry for %s %s by %s", entry.Method, entry.Path, entry.User)
+		}
+	}
+}
+
+// deliver posts queued entries to the webhook one at a time, as JSON
+func (a *AuditLog) deliver() {
+	defer close(a.done)
+	for entry := range a.queue {
+		body, _ := json.Marshal(entry)
+		resp, err := a.client.Post(a.webhook, "application/json", bytes.NewReader(body))
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				err = fmt.Errorf("status %d", resp.StatusCode)
+			}
+		}
+		if err != nil {
+			a.failures.Add(1)
+			log.Printf("WARNING: Audit webhook failed for %s %s by %s: %v", entry.Method, entry.Path, entry.User, err)
+		}
+	}
+}
+
+// writeMetrics reports how many entries were recorded and lost
+func (a *AuditLog) writeMetrics(w io.Writer) {
+	fmt.Fprintf(w, "audit_entries_total %d\n", a.entries.Load())
+	fmt.Fprintf(w, "audit_failures_total %d\n", a.failures.Load())
+}
+
+// auditRequests records the state-changing requests signed-in users make
+// to next, with the status they got; it runs inside authentication, so
+// requests without a user never reach it
+func (s *Server) auditRequests(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := IdentityFrom(r.Context())
+		if id == nil || !slices.Contains(s.audit.methods, r.Method) {
+			next(w, r)
+			return
+		}
+
+		cw := &countingWriter{wrappedWriter: wrappedWriter{w}}
+		start := time.Now()
+		next(cw, r)
+
+		status := cw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		route := r.Pattern
+		if route == "" {
+			route = r.URL.Path
+		}
+		s.audit.record(AuditEntry{
+			Time:       start.UTC().Format(time.RFC3339Nano),
+			User:       id.Subject,
+			Auth:       id.Method,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Route:      route,
+			Status:     status,
+			Address:    clientAddress(r),
+			DurationMs: durationMs(time.Since(start)),
+		})
+	}
+}
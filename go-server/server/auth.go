@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"htmlnojs/routebuilder"
+)
+
+// Identity is who an authenticated request came from
+type Identity struct {
+	Subject string         // User name, token name, session owner or JWT "sub"
+	Method  string         // "basic", "token", "session", "jwt", "api_key" or "signed_url"
+	Claims  map[string]any // A JWT's claims, nil for other methods
+	Roles   []string       // From the JWT or session; Server adds those configured for Subject
+}
+
+// Authenticator identifies the client behind a request. Routes marked
+// @auth, and templates named *_auth or *_admin, are only served to
+// requests one of the server's authenticators identifies.
+type Authenticator interface {
+	// Authenticate returns who sent r, or nil when r carries no valid
+	// credentials for this authenticator
+	Authenticate(r *http.Request) *Identity
+}
+
+// challenger is an Authenticator that tells clients how to authenticate
+// in a WWW-Authenticate header
+type challenger interface {
+	Challenge() string
+}
+
+type identityKey struct{}
+
+// IdentityFrom returns who a request on an authenticated route came from,
+// or nil on other routes
+func IdentityFrom(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
+
+// authMiddleware serves next to requests an authenticator identifies and
+// turns the rest away
+func (s *Server) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if IdentityFrom(r.Context()) != nil {
+			next(w, r)
+			return
+		}
+		if id := s.authenticate(r); id != nil {
+			next(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+			return
+		}
+		s.requireLogin(w, r)
+	}
+}
+
+// authenticate returns the identity the first authenticator finds in the
+// request, or nil
+func (s *Server) authenticate(r *http.Request) *Identity {
+	_, jwtChecked := r.Context().Value(jwtCheckedKey{}).(bool)
+	for _, authenticator := range s.authenticators {
+		if _, ok := authenticator.(*JWTAuth); ok && jwtChecked {
+			continue
+		}
+		if id := authenticator.Authenticate(r); id != nil {
+			return id
+		}
+	}
+	return nil
+}
+
+// requireLogin sends browsers to the login page when there is one, and
+// answers everything else with a 401
+func (s *Server) requireLogin(w http.ResponseWriter, r *http.Request) {
+	// A signed link that no longer opens says so rather than asking to sign in
+	if r.URL.Query().Has(routebuilder.SignedURLSignature) {
+		for _, authenticator := range s.authenticators {
+			if a, ok := authenticator.(*SignedURLAuth); ok {
+				writeSignedURLError(w, a.signer().Verify(r.URL))
+				return
+			}
+		}
+	}
+	w.Header().Add("Vary", "Authorization")
+	w.Header().Add("Vary", "Cookie")
+	if s.loginURL != "" {
+		target := s.loginURL + "?next=" + url.QueryEscape(r.URL.RequestURI())
+		if strings.Contains(s.loginURL, "?") {
+			target = s.loginURL + "&next=" + url.QueryEscape(r.URL.RequestURI())
+		}
+		// htmx follows HX-Redirect whatever the status, so the page
+		// changes instead of a 401 fragment being swapped in
+		if r.Header.Get("HX-Request") == "true" {
+			w.Header().Set("HX-Redirect", target)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if (r.Method == http.MethodGet || r.Method == http.MethodHead) && strings.Contains(r.Header.Get("Accept"), "text/html") {
+			http.Redirect(w, r, target, http.StatusSeeOther)
+			return
+		}
+	}
+
+	for _, authenticator := range s.authenticators {
+		if c, ok := authenticator.(challenger); ok {
+			w.Header().Add("WWW-Authenticate", c.Challenge())
+		}
+	}
+	routebuilder.WriteFragment(w, http.StatusUnauthorized, routebuilder.ErrorFragment(
+		"Sign In Required",
+		"You need to sign in to see this",
+		"",
+	))
+}
+
+// warnUnauthenticated warns when routes need a sign in but there are no
+// credentials or login page to sign in with, so only sessions signed in by
+// Go code get in
+func (s *Server) warnUnauthenticated(routes *routebuilder.RouteCollection) {
+	if s.login != nil {
+		return
+	}
+	for _, authenticator := range s.authenticators {
+		switch authenticator.(type) {
+		case SessionAuth, *SignedURLAuth:
+		default:
+			return
+		}
+	}
+	count := 0
+	for _, route := range routes.HTMLRoutes {
+		if route.RequiresAuth {
+			count++
+		}
+	}
+	for _, route := range routes.PythonRoutes {
+		if route.RequiresAuth {
+			count++
+		}
+	}
+	if count > 0 {
+		log.Printf("WARNING: %d routes require auth but no users or tokens are configured, they refuse clients that aren't signed in to their session", count)
+	}
+}
+
+// BasicAuth authenticates HTTP Basic credentials against a fixed list of
+// users
+type BasicAuth struct {
+	Realm string            // Shown by the browser's sign in prompt
+	Users map[string]string // Passwords by user name
+}
+
+func (a *BasicAuth) Authenticate(r *http.Request) *Identity {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return nil
+	}
+	want, known := a.Users[user]
+	if !secretsEqual(password, want) || !known {
+		return nil
+	}
+	return &Identity{Subject: user, Method: "basic"}
+}
+
+func (a *BasicAuth) Challenge() string {
+	realm := a.Realm
+	if realm == "" {
+		realm = "htmlnojs"
+	}
+	return `Basic realm="` + strings.ReplaceAll(realm, `"`, `'`) + `", charset="UTF-8"`
+}
+
+// TokenAuth authenticates "Authorization: Bearer <token>" headers against
+// a fixed list of tokens
+type TokenAuth struct {
+	Tokens map[string]string // Tokens by the name they identify
+}
+
+func (a *TokenAuth) Authenticate(r *http.Request) *Identity {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil
+	}
+	// Every token is compared, so timing doesn't tell which one was close
+	var subject string
+	for name, want := range a.Tokens {
+		if secretsEqual(token, want) {
+			subject = name
+		}
+	}
+	if subject == "" {
+		return nil
+	}
+	return &Identity{Subject: subject, Method: "token"}
+}
+
+func (a *TokenAuth) Challenge() string {
+	return "Bearer"
+}
+
+// SessionAuth authenticates the user signed in to the request's session
+// with Session.SetUser
+type SessionAuth struct{}
+
+func (SessionAuth) Authenticate(r *http.Request) *Identity {
+	session := SessionFrom(r.Context())
+	if session == nil || session.User() == "" {
+		return nil
+	}
+	return &Identity{Subject: session.User(), Method: "session", Roles: session.Roles()}
+}
+
+// secretsEqual compares secrets in constant time, whatever their lengths
+func secretsEqual(got, want string) bool {
+	a := sha256.Sum256([]byte(got))
+	b := sha256.Sum256([]byte(want))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
@@ -0,0 +1,26 @@
+package server
+
+import "testing"
+
+func TestSecretsEqual(t *testing.T) {
+	tests := []struct {
+		name      string
+		got, want string
+		equal     bool
+	}{
+		{"same", "s3cret-token", "s3cret-token", true},
+		{"both empty", "", "", true},
+		{"different", "s3cret-token", "s3cret-tokem", false},
+		{"prefix", "s3cret", "s3cret-token", false},
+		{"longer", "s3cret-token-and-more", "s3cret-token", false},
+		{"empty against secret", "", "s3cret-token", false},
+		{"case", "S3CRET-TOKEN", "s3cret-token", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if equal := secretsEqual(tt.got, tt.want); equal != tt.equal {
+				t.Errorf("secretsEqual(%q, %q) = %v, want %v", tt.got, tt.want, equal, tt.equal)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"htmlnojs/backend"
+	"htmlnojs/routebuilder"
+)
+
+// BackendProcess returns the processes serving a handler language, or nil
+// when the server doesn't manage them
+type BackendProcess func(language string) []backend.Status
+
+// backendState is how /health and /_routes report a handler backend
+type backendState struct {
+	Language  string           `json:"language"`
+	Status    string           `json:"status"`
+	Reason    string           `json:"reason,omitempty"`
+	Processes []backend.Status `json:"processes,omitempty"`
+}
+
+// backendStates reports the backend of every handler language with proxied
+// routes, ordered by language. Without a status func backends are assumed up.
+func (s *Server) backendStates(routes *routebuilder.RouteCollection) []backendState {
+	seen := make(map[string]bool)
+	var languages []string
+	for _, route := range routes.PythonRoutes {
+		language := route.Language
+		if language == "" {
+			language = routebuilder.DefaultHandlerLanguage
+		}
+		if seen[language] || routebuilder.IsInProcess(language) {
+			continue
+		}
+		seen[language] = true
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+
+	states := make([]backendState, 0, len(languages))
+	for _, language := range languages {
+		state := backendState{Language: language, Status: "up"}
+		if s.backendStatus != nil {
+			if ok, reason := s.backendStatus(language); !ok {
+				state.Status = "down"
+				state.Reason = reason
+			}
+		}
+		if s.backendProcess != nil {
+			state.Processes = s.backendProcess(language)
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// writeBackendMetrics writes the size of every managed backend pool and
+// the requests each of its processes took
+func (s *Server) writeBackendMetrics(w io.Writer) {
+	if s.backendProcess == nil {
+		return
+	}
+	routes := s.GetRoutes()
+	for _, state := range s.backendStates(routes) {
+		if len(state.Processes) == 0 {
+			continue
+		}
+		running := 0
+		for _, process := range state.Processes {
+			if process.State == "running" {
+				running++
+			}
+		}
+		fmt.Fprintf(w, "backend_workers{language=%q} %d\n", state.Language, len(state.Processes))
+		fmt.Fprintf(w, "backend_workers_running{language=%q} %d\n", state.Language, running)
+		for _, process := range state.Processes {
+			fmt.Fprintf(w, "backend_worker_requests_total{language=%q,port=\"%d\"} %d\n", state.Language, process.Port, process.Requests)
+			fmt.Fprintf(w, "backend_worker_restarts_total{language=%q,port=\"%d\"} %d\n", state.Language, process.Port, process.Restarts)
+		}
+	}
+}
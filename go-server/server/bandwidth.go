@@ -0,0 +1,100 @@
+package server
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// byteStat aggregates the response sizes of a single route
+type byteStat struct {
+	Route string
+	Kind  string
+	Count int64
+	Total int64
+	Max   int64
+}
+
+// bandwidthStats tracks how many bytes each route sends
+type bandwidthStats struct {
+	mu     sync.Mutex
+	routes map[string]*byteStat
+}
+
+func newBandwidthStats() *bandwidthStats {
+	return &bandwidthStats{routes: make(map[string]*byteStat)}
+}
+
+func (bs *bandwidthStats) record(kind, route string, n int64) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	stat, ok := bs.routes[route]
+	if !ok {
+		stat = &byteStat{Route: route, Kind: kind}
+		bs.routes[route] = stat
+	}
+	stat.Count++
+	stat.Total += n
+	if n > stat.Max {
+		stat.Max = n
+	}
+}
+
+// bandwidthEntry is the JSON shape of a /_stats bandwidth row
+type bandwidthEntry struct {
+	Route      string `json:"route"`
+	Kind       string `json:"kind"`
+	Count      int64  `json:"count"`
+	TotalBytes int64  `json:"total_bytes"`
+	AvgBytes   int64  `json:"avg_bytes"`
+	MaxBytes   int64  `json:"max_bytes"`
+}
+
+// snapshot returns the heaviest routes ordered like the timing rows
+func (bs *bandwidthStats) snapshot(sortBy string, top int) []bandwidthEntry {
+	bs.mu.Lock()
+	entries := make([]bandwidthEntry, 0, len(bs.routes))
+	for _, stat := range bs.routes {
+		entry := bandwidthEntry{
+			Route:      stat.Route,
+			Kind:       stat.Kind,
+			Count:      stat.Count,
+			TotalBytes: stat.Total,
+			MaxBytes:   stat.Max,
+		}
+		if stat.Count > 0 {
+			entry.AvgBytes = stat.Total / stat.Count
+		}
+		entries = append(entries, entry)
+	}
+	bs.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		switch sortBy {
+		case "avg":
+			return entries[i].AvgBytes > entries[j].AvgBytes
+		case "max":
+			return entries[i].MaxBytes > entries[j].MaxBytes
+		case "count":
+			return entries[i].Count > entries[j].Count
+		default:
+			return entries[i].TotalBytes > entries[j].TotalBytes
+		}
+	})
+
+	if top > 0 && len(entries) > top {
+		entries = entries[:top]
+	}
+	return entries
+}
+
+// countBandwidth wraps a route handler so the body bytes it sends are
+// recorded under kind, e.g. "html" or "css"
+func (s *Server) countBandwidth(kind, route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cw := &countingWriter{wrappedWriter: wrappedWriter{w}}
+		next(cw, r)
+		s.bandwidth.record(kind, route, cw.bytes)
+	}
+}
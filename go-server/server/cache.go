@@ -0,0 +1,498 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"htmlnojs/routebuilder"
+	"htmlnojs/store"
+)
+
+// CacheStatusHeader reports what the response cache did with a request,
+// following RFC 9211: "htmlnojs; hit; ttl=42", "htmlnojs; fwd=miss" or
+// "htmlnojs; fwd=bypass"
+const CacheStatusHeader = "Cache-Status"
+
+// CacheTagsHeader is how a handler tags its response, e.g. "products,
+// product-42", so it can be purged by tag; the header isn't sent on
+const CacheTagsHeader = "X-HTMLnoJS-Cache-Tags"
+
+// cacheVaryAlways are request headers every cached response varies on,
+// since htmx requests get fragments where browsers get whole pages
+var cacheVaryAlways = []string{"HX-Request"}
+
+// uncachedHeaders are response headers never replayed from the cache,
+// because they describe one request rather than the response
+var uncachedHeaders = []string{"Set-Cookie", "Date", "Age", CacheStatusHeader, "X-RateLimit-Limit", "X-RateLimit-Remaining", "Retry-After"}
+
+// cachedResponse is a handler response kept in the store
+type cachedResponse struct {
+	Status  int         `json:"status"`
+	Header  http.Header `json:"header"` // Only the headers the handler set
+	Body    []byte      `json:"body"`
+	Stored  time.Time   `json:"stored"`
+	Expires time.Time   `json:"expires"`
+	Tags    []string    `json:"tags,omitempty"`
+}
+
+// cacheStats counts what the response cache did, for /_metrics
+type cacheStats struct {
+	hits        atomic.Int64
+	stale       atomic.Int64 // Hits on expired responses being refreshed
+	misses      atomic.Int64
+	refreshes   atomic.Int64
+	revalidated atomic.Int64 // Expired responses the handler said were unchanged
+	stored      atomic.Int64
+	purged      atomic.Int64
+}
+
+// cacheStore holds cached responses under "entry:<path>?<hash>" and the
+// request headers each path varies on under "vary:<path>"
+func (s *Server) cacheStore() store.Store {
+	return store.WithPrefix(s.store, "cache:")
+}
+
+// cacheRefreshLock bounds how long one background refresh holds an entry,
+// should it never finish
+const cacheRefreshLock = time.Minute
+
+// cacheRevalidateWindow is how long past its stale time a response with an
+// ETag or Last-Modified is kept, so the handler can be asked whether it
+// changed instead of rendering it again
+const cacheRevalidateWindow = 10 * time.Minute
+
+// cachedRoute is the response cache of one @cache handler
+type cachedRoute struct {
+	server  *Server
+	next    http.HandlerFunc
+	timeout int           // Seconds responses are fresh, for Cache-Control
+	ttl     time.Duration // How long responses are fresh
+	stale   time.Duration // How long after that they're served while refreshed
+}
+
+// cacheMiddleware answers GET requests to a handler marked @cache(seconds)
+// from the store, keeping the handler's 200 responses for that long. They
+// are keyed by path, query, the request headers the handler varies on and
+// the signed-in user, or the session of an anonymous client that kept its
+// cookie, so nobody is served another user's response.
+// Responses that set cookies, leave a flash message or say no-store
+// aren't kept. With @cache(seconds, stale=N), an expired response is
+// still served for N more seconds while one request refreshes it in the
+// background.
+func (s *Server) cacheMiddleware(next http.HandlerFunc, timeout, stale int) http.HandlerFunc {
+	route := &cachedRoute{
+		server:  s,
+		next:    next,
+		timeout: timeout,
+		ttl:     time.Duration(timeout) * time.Second,
+		stale:   time.Duration(stale) * time.Second,
+	}
+	return route.serve
+}
+
+func (c *cachedRoute) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set(CacheStatusHeader, "htmlnojs; fwd=bypass")
+		c.next(w, r)
+		return
+	}
+
+	// The identity the backend will see is part of the key; resolved here,
+	// forwardIdentity doesn't authenticate the request again. Anonymous
+	// clients are told apart by their session, which the backend gets in
+	// SessionHeader and may render per visitor, e.g. a cart.
+	id := IdentityFrom(r.Context())
+	if id == nil {
+		if id = c.server.authenticate(r); id != nil {
+			r = r.WithContext(context.WithValue(r.Context(), identityKey{}, id))
+		}
+	}
+	var user string
+	if id != nil {
+		user = id.Method + ":" + id.Subject
+	} else if session := SessionFrom(r.Context()); session != nil && session.persisted() {
+		user = "anonymous session:" + session.ID()
+	}
+
+	cached, key, ok := c.server.lookupCache(r.Context(), r, user)
+	if ok {
+		now := time.Now()
+		switch {
+		case now.Before(cached.Expires):
+			c.server.cacheStats.hits.Add(1)
+			writeCached(w, r, cached, hitStatus(cached, ""))
+			return
+		case now.Before(cached.Expires.Add(c.stale)):
+			c.server.cacheStats.stale.Add(1)
+			c.refresh(r, user, key, cached)
+			writeCached(w, r, cached, hitStatus(cached, "stale"))
+			return
+		}
+	} else {
+		cached = nil
+	}
+	c.server.cacheStats.misses.Add(1)
+	c.fetch(w, r, user, cached)
+}
+
+// fetch answers r from the handler and keeps the response. With an expired
+// response that has an ETag or Last-Modified, the handler is only asked
+// whether it changed.
+func (c *cachedRoute) fetch(w http.ResponseWriter, r *http.Request, user string, expired *cachedResponse) {
+	if expired != nil && hasValidators(expired.Header) {
+		c.revalidate(w, r, user, expired)
+		return
+	}
+	c.forward(w, r, user)
+}
+
+// forward runs the handler for r, writing its response to w, and keeps the
+// response when it may be
+func (c *cachedRoute) forward(w http.ResponseWriter, r *http.Request, user string) {
+	before := w.Header().Clone()
+	// Set before the handler runs so the proxy knows the route chose a
+	// Cache-Control; one the backend sends replaces it
+	cacheControl := "public, max-age=" + strconv.Itoa(c.timeout)
+	if user != "" {
+		cacheControl = "private, max-age=" + strconv.Itoa(c.timeout)
+	}
+	w.Header().Set("Cache-Control", cacheControl)
+
+	storable := r.Method == http.MethodGet
+	var tags []string
+	recorder := &cacheWriter{recordingWriter: &recordingWriter{wrappedWriter: wrappedWriter{w}}}
+	recorder.onHeader = func(status int) {
+		header := w.Header()
+		if values := header.Values("Cache-Control"); len(values) > 1 && values[0] == cacheControl {
+			header["Cache-Control"] = values[1:]
+		}
+		tags = parseCacheTags(header.Values(CacheTagsHeader))
+		header.Del(CacheTagsHeader)
+		header.Set(CacheStatusHeader, "htmlnojs; fwd=miss")
+		storable = storable && cacheable(status, header, user != "")
+	}
+	c.next(recorder, r)
+	if !storable || recorder.truncated || recorder.status != http.StatusOK {
+		return
+	}
+
+	c.keep(r, user, &cachedResponse{
+		Status:  recorder.status,
+		Header:  handlerHeaders(before, w.Header()),
+		Body:    recorder.body.Bytes(),
+		Stored:  time.Now(),
+		Expires: time.Now().Add(c.ttl),
+		Tags:    tags,
+	})
+}
+
+// revalidate asks the handler whether expired changed, sending its ETag
+// and Last-Modified in If-None-Match and If-Modified-Since. When it didn't,
+// expired is kept for another ttl and served without the handler sending
+// it again; otherwise the new response is served and kept.
+func (c *cachedRoute) revalidate(w http.ResponseWriter, r *http.Request, user string, expired *cachedResponse) {
+	req := r.Clone(r.Context())
+	req.Method = http.MethodGet
+	req.Header.Del("If-None-Match")
+	req.Header.Del("If-Modified-Since")
+	if etag := expired.Header.Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if modified := expired.Header.Get("Last-Modified"); modified != "" {
+		req.Header.Set("If-Modified-Since", modified)
+	}
+
+	recorder := httptest.NewRecorder()
+	c.forward(recorder, req, user)
+
+	// The proxy turns an unchanged fragment into a 204 for htmx requests
+	unchanged := recorder.Code == http.StatusNotModified ||
+		(recorder.Code == http.StatusNoContent && expired.Header.Get("ETag") != "" && recorder.Header().Get("ETag") == expired.Header.Get("ETag"))
+	if unchanged {
+		renewed := *expired
+		renewed.Stored = time.Now()
+		renewed.Expires = renewed.Stored.Add(c.ttl)
+		c.server.cacheStats.revalidated.Add(1)
+		c.keep(r, user, &renewed)
+		writeCached(w, r, &renewed, "htmlnojs; fwd=stale; fwd-status=304")
+		return
+	}
+
+	for name, values := range recorder.Header() {
+		w.Header()[name] = values
+	}
+	w.Header().Set(CacheStatusHeader, "htmlnojs; fwd=stale; fwd-status="+strconv.Itoa(recorder.Code))
+	if recorder.Code == http.StatusOK && notModified(r, recorder.Header()) {
+		writeNotModified(w, r)
+		return
+	}
+	w.WriteHeader(recorder.Code)
+	if r.Method != http.MethodHead {
+		w.Write(recorder.Body.Bytes())
+	}
+}
+
+// keep stores response for r, for ttl plus the stale time, and longer when
+// it can be revalidated
+func (c *cachedRoute) keep(r *http.Request, user string, response *cachedResponse) {
+	lifetime := c.ttl + c.stale
+	if hasValidators(response.Header) {
+		lifetime += cacheRevalidateWindow
+	}
+	ctx := context.WithoutCancel(r.Context())
+	if err := c.server.storeCache(ctx, r, user, *response, lifetime); err != nil {
+		log.Printf("WARNING: Failed to cache %s: %v", r.URL.Path, err)
+		return
+	}
+	c.server.cacheStats.stored.Add(1)
+}
+
+// refresh fetches the stale entry at key again in the background, unless
+// another request already is
+func (c *cachedRoute) refresh(r *http.Request, user, key string, stale *cachedResponse) {
+	entries := c.server.cacheStore()
+	ctx := context.WithoutCancel(r.Context())
+	lock := "refresh:" + key
+	if n, err := entries.Incr(ctx, lock, 1, cacheRefreshLock); err != nil || n > 1 {
+		return
+	}
+	c.server.cacheStats.refreshes.Add(1)
+
+	req := r.Clone(ctx)
+	req.Method = http.MethodGet
+	req.Body = http.NoBody
+	go func() {
+		defer entries.Delete(ctx, lock)
+		c.fetch(httptest.NewRecorder(), req, user, stale)
+	}()
+}
+
+// lookupCache returns the response kept for r, fresh or stale, and its key
+func (s *Server) lookupCache(ctx context.Context, r *http.Request, user string) (*cachedResponse, string, bool) {
+	entries := s.cacheStore()
+	vary, err := entries.Get(ctx, "vary:"+r.URL.Path)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("WARNING: Response cache lookup failed: %v", err)
+		}
+		return nil, "", false
+	}
+	key := cacheKey(r, strings.Split(string(vary), ","), user)
+	data, err := entries.Get(ctx, key)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("WARNING: Response cache lookup failed: %v", err)
+		}
+		return nil, "", false
+	}
+	var cached cachedResponse
+	if err := json.Unmarshal(data, &cached); err != nil {
+		return nil, "", false
+	}
+	return &cached, key, true
+}
+
+// storeCache keeps response for r, and the headers it varies on, for
+// lifetime
+func (s *Server) storeCache(ctx context.Context, r *http.Request, user string, response cachedResponse, lifetime time.Duration) error {
+	entries := s.cacheStore()
+	vary := slices.Clone(cacheVaryAlways)
+	for _, value := range response.Header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = http.CanonicalHeaderKey(strings.TrimSpace(name)); name != "" && !slices.Contains(vary, name) {
+				vary = append(vary, name)
+			}
+		}
+	}
+	slices.Sort(vary)
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	if err := entries.Set(ctx, "vary:"+r.URL.Path, []byte(strings.Join(vary, ",")), lifetime); err != nil {
+		return err
+	}
+	return entries.Set(ctx, cacheKey(r, vary, user), data, lifetime)
+}
+
+// cacheKey is where the response to r is kept, given the request headers
+// the path varies on
+func cacheKey(r *http.Request, vary []string, user string) string {
+	parts := []string{r.URL.RawQuery, user}
+	for _, name := range vary {
+		parts = append(parts, name+": "+strings.Join(r.Header.Values(name), ", "))
+	}
+	return "entry:" + r.URL.Path + "?" + hashParts(parts...)
+}
+
+// parseCacheTags splits CacheTagsHeader values on commas and spaces
+func parseCacheTags(values []string) []string {
+	var tags []string
+	for _, value := range values {
+		for _, tag := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+			if !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// cacheable reports whether a response with status and header may be kept;
+// private responses are only kept when the key has the user in it
+func cacheable(status int, header http.Header, perUser bool) bool {
+	if status != http.StatusOK || header.Get("Set-Cookie") != "" || header.Get(FlashHeader) != "" {
+		return false
+	}
+	for _, value := range header.Values("Vary") {
+		if strings.TrimSpace(value) == "*" {
+			return false
+		}
+	}
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			switch strings.ToLower(strings.TrimSpace(directive)) {
+			case "no-store", "no-cache":
+				return false
+			case "private":
+				if !perUser {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// handlerHeaders returns the headers in after that the handler set, not
+// those outer middleware had set before it ran, e.g. CORS headers for one
+// origin
+func handlerHeaders(before, after http.Header) http.Header {
+	header := http.Header{}
+	for name, values := range after {
+		if slices.Contains(uncachedHeaders, name) || slices.Equal(before[name], values) {
+			continue
+		}
+		header[name] = slices.Clone(values)
+	}
+	return header
+}
+
+// hitStatus is the Cache-Status of a response served from the cache;
+// detail is added to it, e.g. "stale"
+func hitStatus(cached *cachedResponse, detail string) string {
+	ttl := int(math.Floor(time.Until(cached.Expires).Seconds())) // Negative once stale
+	status := fmt.Sprintf("htmlnojs; hit; ttl=%d", ttl)
+	if detail != "" {
+		status += "; detail=" + detail
+	}
+	return status
+}
+
+// writeCached answers r with a kept response, or with nothing when r's
+// conditional headers show the client already has it
+func writeCached(w http.ResponseWriter, r *http.Request, cached *cachedResponse, cacheStatus string) {
+	for name, values := range cached.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Age", strconv.Itoa(int(time.Since(cached.Stored).Seconds())))
+	w.Header().Set(CacheStatusHeader, cacheStatus)
+	if notModified(r, cached.Header) {
+		writeNotModified(w, r)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(cached.Body)))
+	w.WriteHeader(cached.Status)
+	if r.Method != http.MethodHead {
+		w.Write(cached.Body)
+	}
+}
+
+// hasValidators reports whether a response carries an ETag or
+// Last-Modified to revalidate it with
+func hasValidators(header http.Header) bool {
+	return header.Get("ETag") != "" || header.Get("Last-Modified") != ""
+}
+
+// notModified reports whether r's If-None-Match, or If-Modified-Since
+// without it, shows the client has the response with header
+func notModified(r *http.Request, header http.Header) bool {
+	if ifNoneMatch := r.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		etag := header.Get("ETag")
+		return etag != "" && routebuilder.ETagMatches(ifNoneMatch, etag)
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(header.Get("Last-Modified"))
+	return err == nil && !modified.After(since)
+}
+
+// writeNotModified answers a conditional request for an unchanged
+// response: a 304, or for htmx a 204, which leaves the target alone, as
+// the proxy does for unchanged fragments
+func writeNotModified(w http.ResponseWriter, r *http.Request) {
+	status := http.StatusNotModified
+	if r.Header.Get("HX-Request") == "true" {
+		status = http.StatusNoContent
+	}
+	w.Header().Del("Content-Type")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(status)
+}
+
+// cacheWriter records the response like recordingWriter, calling onHeader
+// just before the status goes out so headers can still be added
+type cacheWriter struct {
+	*recordingWriter
+	onHeader    func(status int)
+	wroteHeader bool
+}
+
+func (cw *cacheWriter) WriteHeader(code int) {
+	if !cw.wroteHeader {
+		cw.wroteHeader = true
+		cw.onHeader(code)
+	}
+	cw.recordingWriter.WriteHeader(code)
+}
+
+func (cw *cacheWriter) Write(b []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	return cw.recordingWriter.Write(b)
+}
+
+func (cw *cacheWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	cw.recordingWriter.Flush()
+}
+
+// writeMetrics reports cache hits, misses, refreshes, revalidations and
+// responses kept and purged
+func (c *cacheStats) writeMetrics(w io.Writer) {
+	fmt.Fprintf(w, "cache_hits_total %d\n", c.hits.Load())
+	fmt.Fprintf(w, "cache_stale_hits_total %d\n", c.stale.Load())
+	fmt.Fprintf(w, "cache_misses_total %d\n", c.misses.Load())
+	fmt.Fprintf(w, "cache_refreshes_total %d\n", c.refreshes.Load())
+	fmt.Fprintf(w, "cache_revalidated_total %d\n", c.revalidated.Load())
+	fmt.Fprintf(w, "cache_stored_total %d\n", c.stored.Load())
+	fmt.Fprintf(w, "cache_purged_total %d\n", c.purged.Load())
+}
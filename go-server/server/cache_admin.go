@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"htmlnojs/store"
+)
+
+// CacheEntry describes a response kept by the response cache
+type CacheEntry struct {
+	Path    string    `json:"path"`
+	Tags    []string  `json:"tags,omitempty"`
+	Size    int       `json:"size"`
+	Stored  time.Time `json:"stored"`
+	Expires time.Time `json:"expires"`
+}
+
+// CacheEntries lists the responses the cache holds
+func (s *Server) CacheEntries(ctx context.Context) ([]CacheEntry, error) {
+	var entries []CacheEntry
+	err := s.eachCached(ctx, "", func(key string, cached *cachedResponse) error {
+		entries = append(entries, CacheEntry{
+			Path:    cachedPath(key),
+			Tags:    cached.Tags,
+			Size:    len(cached.Body),
+			Stored:  cached.Stored,
+			Expires: cached.Expires,
+		})
+		return nil
+	})
+	slices.SortFunc(entries, func(a, b CacheEntry) int { return strings.Compare(a.Path, b.Path) })
+	return entries, err
+}
+
+// PurgeCacheRoute drops the responses kept for path, whatever their query
+// string or headers, and returns how many there were
+func (s *Server) PurgeCacheRoute(ctx context.Context, path string) (int, error) {
+	return s.purgeCache(ctx, path+"?", nil)
+}
+
+// PurgeCachePrefix drops the responses kept for paths starting with
+// prefix, e.g. "/api/products/"; "" drops everything
+func (s *Server) PurgeCachePrefix(ctx context.Context, prefix string) (int, error) {
+	return s.purgeCache(ctx, prefix, nil)
+}
+
+// PurgeCacheTag drops the responses whose handler tagged them with tag in
+// the X-HTMLnoJS-Cache-Tags header
+func (s *Server) PurgeCacheTag(ctx context.Context, tag string) (int, error) {
+	return s.purgeCache(ctx, "", func(cached *cachedResponse) bool {
+		return slices.Contains(cached.Tags, tag)
+	})
+}
+
+// purgeCache deletes the entries for paths starting with prefix that
+// match, or all of them when match is nil
+func (s *Server) purgeCache(ctx context.Context, prefix string, match func(*cachedResponse) bool) (int, error) {
+	entries := s.cacheStore()
+	purged := 0
+	err := s.eachCached(ctx, prefix, func(key string, cached *cachedResponse) error {
+		if match != nil && !match(cached) {
+			return nil
+		}
+		if err := entries.Delete(ctx, key); err != nil {
+			return err
+		}
+		purged++
+		return nil
+	})
+	s.cacheStats.purged.Add(int64(purged))
+	return purged, err
+}
+
+// eachCached calls fn with every entry for paths starting with prefix,
+// skipping those that expired while it ran
+func (s *Server) eachCached(ctx context.Context, prefix string, fn func(key string, cached *cachedResponse) error) error {
+	entries := s.cacheStore()
+	keys, err := entries.Scan(ctx, "entry:"+prefix)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		data, err := entries.Get(ctx, key)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var cached cachedResponse
+		if err := json.Unmarshal(data, &cached); err != nil {
+			continue
+		}
+		if err := fn(key, &cached); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cachedPath returns the path of an "entry:<path>?<hash>" key
+func cachedPath(key string) string {
+	key = strings.TrimPrefix(key, "entry:")
+	if i := strings.LastIndex(key, "?"); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// handleCache lists the cached responses on GET /_admin/cache, and purges
+// them with POST /_admin/cache/purge taking one of the form values route (a
+// path), prefix or tag, or all=true. It runs behind adminOnly, so the backend
+// purging after its data changed sends the admin token.
+func (s *Server) handleCache(w http.ResponseWriter, r *http.Request) {
+	var result any
+	switch strings.TrimPrefix(r.URL.Path, "/_admin/cache") {
+	case "", "/":
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "GET the cached responses, or POST to /_admin/cache/purge", http.StatusMethodNotAllowed)
+			return
+		}
+		entries, err := s.CacheEntries(r.Context())
+		if err != nil {
+			log.Printf("ERROR: Failed to list cached responses: %v", err)
+			http.Error(w, "failed to list cached responses", http.StatusInternalServerError)
+			return
+		}
+		result = map[string]any{"entries": entries}
+	case "/purge":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "POST to /_admin/cache/purge", http.StatusMethodNotAllowed)
+			return
+		}
+		route, prefix, tag, all := r.FormValue("route"), r.FormValue("prefix"), r.FormValue("tag"), r.FormValue("all") == "true"
+
+		var purged int
+		var err error
+		switch {
+		case route != "":
+			purged, err = s.PurgeCacheRoute(r.Context(), route)
+		case prefix != "":
+			purged, err = s.PurgeCachePrefix(r.Context(), prefix)
+		case tag != "":
+			purged, err = s.PurgeCacheTag(r.Context(), tag)
+		case all:
+			purged, err = s.PurgeCachePrefix(r.Context(), "")
+		default:
+			http.Error(w, "purge needs route, prefix, tag or all=true", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("ERROR: Failed to purge cached responses: %v", err)
+			http.Error(w, "failed to purge cached responses", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Purged %d cached response(s) for %s", purged, r.Form.Encode())
+		result = map[string]int{"purged": purged}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("ERROR: Failed to encode cache response: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"htmlnojs/routebuilder"
+)
+
+func TestCacheKeyPerUser(t *testing.T) {
+	tests := []struct {
+		name           string
+		target1, user1 string
+		target2, user2 string
+		same           bool
+	}{
+		{"same user", "/dashboard", "session:ada", "/dashboard", "session:ada", true},
+		{"anonymous", "/dashboard", "", "/dashboard", "", true},
+		{"other user", "/dashboard", "session:ada", "/dashboard", "session:bob", false},
+		{"user and anonymous", "/dashboard", "session:ada", "/dashboard", "", false},
+		{"same subject, other method", "/dashboard", "session:ada", "/dashboard", "token:ada", false},
+		{"other query", "/dashboard?page=1", "session:ada", "/dashboard?page=2", "session:ada", false},
+		{"other path", "/dashboard", "session:ada", "/settings", "session:ada", false},
+		{"user in query", "/dashboard?u=ada", "", "/dashboard", "u=ada", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key1 := cacheKey(httptest.NewRequest("GET", tt.target1, nil), cacheVaryAlways, tt.user1)
+			key2 := cacheKey(httptest.NewRequest("GET", tt.target2, nil), cacheVaryAlways, tt.user2)
+			if same := key1 == key2; same != tt.same {
+				t.Errorf("cacheKey() same = %v, want %v (%q, %q)", same, tt.same, key1, key2)
+			}
+		})
+	}
+}
+
+func TestCachePerAnonymousSession(t *testing.T) {
+	sessions, err := NewCookieSessions([]byte("session-secret"), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cart := func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "cart of "+r.Header.Get(SessionHeader))
+	}
+	s := NewBuilder().
+		WithSessions(sessions).
+		WithRoutes(&routebuilder.RouteCollection{PythonRoutes: []routebuilder.PythonRoute{
+			{Name: "cart", Route: "/api/cart", Method: "GET", CacheTimeout: 60, Handler: cart},
+		}}).
+		Build()
+	cookie := func(id string) string {
+		value, err := sessions.codec.save(context.Background(), id, map[string]string{"visited": "yes"}, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+	ann, bob := cookie("session-ann"), cookie("session-bob")
+
+	tests := []struct {
+		name   string
+		cookie string
+		body   string
+		hit    bool
+	}{
+		{"first visitor", ann, "cart of session-ann", false},
+		{"first visitor again", ann, "cart of session-ann", true},
+		{"second visitor", bob, "cart of session-bob", false},
+		{"second visitor again", bob, "cart of session-bob", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/cart", nil)
+			r.AddCookie(&http.Cookie{Name: sessions.Cookie, Value: tt.cookie})
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			if w.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", w.Body.String(), tt.body)
+			}
+			if hit := strings.Contains(w.Header().Get(CacheStatusHeader), "; hit"); hit != tt.hit {
+				t.Errorf("%s = %q, want hit %v", CacheStatusHeader, w.Header().Get(CacheStatusHeader), tt.hit)
+			}
+			if cacheControl := w.Header().Get("Cache-Control"); !strings.HasPrefix(cacheControl, "private") {
+				t.Errorf("Cache-Control = %q, want private", cacheControl)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"htmlnojs/config"
+)
+
+// ConfigValidator checks a proposed htmlnojs.json against the running
+// config and, when apply is set and the proposal is valid, switches to it
+type ConfigValidator func(data []byte, apply bool) *config.CheckResult
+
+// maxConfigSize bounds the proposed config accepted by /_admin/config/validate
+const maxConfigSize = 1 << 20
+
+// handleConfigValidate takes a proposed config as the POST body and reports
+// errors and changes; ?apply=true also applies it. It runs behind
+// adminOnly. Browsers can't send a JSON body to another origin without a
+// preflight, and requests from any page are refused, so only tools reach it.
+func (s *Server) handleConfigValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "POST the proposed htmlnojs.json", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Header.Get("Origin") != "" {
+		http.Error(w, "the config can't be changed from a web page", http.StatusForbidden)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		http.Error(w, "POST the proposed htmlnojs.json as application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	apply, _ := strconv.ParseBool(r.URL.Query().Get("apply"))
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxConfigSize))
+	if err != nil {
+		http.Error(w, "failed to read config: "+err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	result := s.configValidator(data, apply)
+
+	w.Header().Set("Content-Type", "application/json")
+	if !result.Valid {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+	}
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("ERROR: Failed to encode config check: %v", err)
+	}
+}
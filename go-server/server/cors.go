@@ -0,0 +1,148 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"htmlnojs/routebuilder"
+)
+
+// Defaults of a CORSRule, which let htmx on another origin make and read
+// requests the way it does on the same one
+var (
+	corsMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	corsHeaders = []string{
+		"Content-Type", "Authorization", routebuilder.CSRFHeader,
+		"HX-Request", "HX-Target", "HX-Trigger", "HX-Trigger-Name", "HX-Current-URL",
+		"HX-Boosted", "HX-Prompt", "HX-History-Restore-Request",
+	}
+	corsExpose = []string{
+		"HX-Location", "HX-Push-Url", "HX-Redirect", "HX-Refresh", "HX-Replace-Url",
+		"HX-Reswap", "HX-Retarget", "HX-Reselect",
+		"HX-Trigger", "HX-Trigger-After-Settle", "HX-Trigger-After-Swap",
+	}
+)
+
+// CORSRule says which other origins may call the server from a browser and
+// how. Empty lists fall back to the defaults, which cover htmx's headers.
+type CORSRule struct {
+	Origins     []string // "https://app.example.com", "https://*.example.com" or "*"
+	Methods     []string
+	Headers     []string // Request headers clients may send
+	Expose      []string // Response headers scripts may read
+	Credentials bool     // Send cookies and Authorization along; needs listed origins
+	MaxAge      time.Duration
+}
+
+// allowsOrigin reports whether origin is one of the rule's
+func (rule CORSRule) allowsOrigin(origin string) bool {
+	for _, allowed := range rule.Origins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		// "https://*.example.com" matches any subdomain, not the domain itself
+		if scheme, domain, ok := strings.Cut(allowed, "://*."); ok {
+			host, found := strings.CutPrefix(strings.ToLower(origin), strings.ToLower(scheme)+"://")
+			if found && strings.HasSuffix(host, "."+strings.ToLower(domain)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CORS answers preflights and adds CORS headers to responses for allowed
+// origins: the global rule, or the one of the longest route pattern
+// matching the path
+type CORS struct {
+	global   CORSRule
+	routes   map[string]CORSRule
+	patterns []string // Longest first
+}
+
+// NewCORS creates the CORS handling; routes are keyed by patterns such as
+// "/api/*" and replace the global rule on the paths they match
+func NewCORS(global CORSRule, routes map[string]CORSRule) *CORS {
+	c := &CORS{global: withCORSDefaults(global), routes: make(map[string]CORSRule, len(routes))}
+	for pattern, rule := range routes {
+		c.routes[pattern] = withCORSDefaults(rule)
+		c.patterns = append(c.patterns, pattern)
+	}
+	sort.Slice(c.patterns, func(i, j int) bool {
+		if len(c.patterns[i]) != len(c.patterns[j]) {
+			return len(c.patterns[i]) > len(c.patterns[j])
+		}
+		return c.patterns[i] < c.patterns[j]
+	})
+	return c
+}
+
+func withCORSDefaults(rule CORSRule) CORSRule {
+	if len(rule.Methods) == 0 {
+		rule.Methods = corsMethods
+	}
+	if len(rule.Headers) == 0 {
+		rule.Headers = corsHeaders
+	}
+	if len(rule.Expose) == 0 {
+		rule.Expose = corsExpose
+	}
+	return rule
+}
+
+// rule returns the rule that applies to path
+func (c *CORS) rule(path string) CORSRule {
+	for _, pattern := range c.patterns {
+		if routebuilder.MatchRoutePattern(pattern, path) {
+			return c.routes[pattern]
+		}
+	}
+	return c.global
+}
+
+// handleCORS adds the CORS headers a cross-origin request from an allowed
+// origin needs, and answers its preflight, reporting whether the request
+// has been answered. Other origins get no CORS headers, so browsers keep
+// their pages from reading the response.
+func (s *Server) handleCORS(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if s.cors == nil || origin == "" {
+		return false
+	}
+	w.Header().Add("Vary", "Origin")
+	rule := s.cors.rule(r.URL.Path)
+	if !rule.allowsOrigin(origin) {
+		return false
+	}
+
+	if rule.Credentials || !slices.Contains(rule.Origins, "*") {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	}
+	if rule.Credentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	method := r.Header.Get("Access-Control-Request-Method")
+	if r.Method != http.MethodOptions || method == "" {
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(rule.Expose, ", "))
+		return false
+	}
+
+	// A preflight never reaches the route; the browser makes the request
+	// itself next if the answer allows it
+	w.Header().Add("Vary", "Access-Control-Request-Method")
+	w.Header().Add("Vary", "Access-Control-Request-Headers")
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(rule.Methods, ", "))
+	w.Header().Set("Access-Control-Allow-Headers", strings.Join(rule.Headers, ", "))
+	if rule.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(rule.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
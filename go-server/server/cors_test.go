@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandleCORS(t *testing.T) {
+	s := &Server{cors: NewCORS(
+		CORSRule{Origins: []string{"https://app.example.com", "https://*.example.org"}, Credentials: true, MaxAge: time.Hour},
+		map[string]CORSRule{"/api/public/*": {Origins: []string{"*"}, Methods: []string{http.MethodGet}}},
+	)}
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		origin      string
+		preflight   string // Access-Control-Request-Method
+		answered    bool
+		allowOrigin string
+		credentials string
+		methods     string
+		maxAge      string
+	}{
+		{"same origin", "GET", "/api/orders", "", "", false, "", "", "", ""},
+		{"listed origin", "GET", "/api/orders", "https://app.example.com", "", false, "https://app.example.com", "true", "", ""},
+		{"listed origin, other case", "GET", "/api/orders", "HTTPS://APP.EXAMPLE.COM", "", false, "HTTPS://APP.EXAMPLE.COM", "true", "", ""},
+		{"other origin", "POST", "/api/orders", "https://evil.example.net", "", false, "", "", "", ""},
+		{"lookalike origin", "GET", "/api/orders", "https://app.example.com.evil.net", "", false, "", "", "", ""},
+		{"subdomain wildcard", "GET", "/api/orders", "https://shop.example.org", "", false, "https://shop.example.org", "true", "", ""},
+		{"wildcard isn't the domain itself", "GET", "/api/orders", "https://example.org", "", false, "", "", "", ""},
+		{"wildcard needs the scheme", "GET", "/api/orders", "http://shop.example.org", "", false, "", "", "", ""},
+		{"suffix without a dot", "GET", "/api/orders", "https://evilexample.org", "", false, "", "", "", ""},
+		{"preflight", "OPTIONS", "/api/orders", "https://app.example.com", "DELETE", true, "https://app.example.com", "true", "GET, HEAD, POST, PUT, PATCH, DELETE", "3600"},
+		{"preflight from another origin", "OPTIONS", "/api/orders", "https://evil.example.net", "DELETE", false, "", "", "", ""},
+		{"OPTIONS that isn't a preflight", "OPTIONS", "/api/orders", "https://app.example.com", "", false, "https://app.example.com", "true", "", ""},
+		{"route rule, any origin", "GET", "/api/public/prices", "https://evil.example.net", "", false, "*", "", "", ""},
+		{"route rule preflight", "OPTIONS", "/api/public/prices", "https://evil.example.net", "GET", true, "*", "", "GET", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight != "" {
+				r.Header.Set("Access-Control-Request-Method", tt.preflight)
+			}
+			w := httptest.NewRecorder()
+			if answered := s.handleCORS(w, r); answered != tt.answered {
+				t.Errorf("answered = %v, want %v", answered, tt.answered)
+			}
+			for header, want := range map[string]string{
+				"Access-Control-Allow-Origin":      tt.allowOrigin,
+				"Access-Control-Allow-Credentials": tt.credentials,
+				"Access-Control-Allow-Methods":     tt.methods,
+				"Access-Control-Max-Age":           tt.maxAge,
+			} {
+				if got := w.Header().Get(header); got != want {
+					t.Errorf("%s = %q, want %q", header, got, want)
+				}
+			}
+			if tt.answered && w.Code != http.StatusNoContent {
+				t.Errorf("preflight status = %d, want %d", w.Code, http.StatusNoContent)
+			}
+		})
+	}
+}
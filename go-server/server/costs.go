@@ -0,0 +1,234 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ownerCost is the usage charged to one route owner
+type ownerCost struct {
+	Requests    int64
+	Errors      int64
+	HandlerTime time.Duration
+	BytesIn     int64
+	BytesOut    int64
+}
+
+func (c ownerCost) sub(prev ownerCost) ownerCost {
+	return ownerCost{
+		Requests:    c.Requests - prev.Requests,
+		Errors:      c.Errors - prev.Errors,
+		HandlerTime: c.HandlerTime - prev.HandlerTime,
+		BytesIn:     c.BytesIn - prev.BytesIn,
+		BytesOut:    c.BytesOut - prev.BytesOut,
+	}
+}
+
+// ownerCosts aggregates usage per owner so shared deployments can charge
+// teams for their routes
+type ownerCosts struct {
+	mu     sync.Mutex
+	owners map[string]*ownerCost
+}
+
+func newOwnerCosts() *ownerCosts {
+	return &ownerCosts{owners: make(map[string]*ownerCost)}
+}
+
+func (oc *ownerCosts) record(owner string, status int, d time.Duration, bytesIn, bytesOut int64) {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	cost, ok := oc.owners[owner]
+	if !ok {
+		cost = &ownerCost{}
+		oc.owners[owner] = cost
+	}
+	cost.Requests++
+	if status >= 500 {
+		cost.Errors++
+	}
+	cost.HandlerTime += d
+	cost.BytesIn += bytesIn
+	cost.BytesOut += bytesOut
+}
+
+// snapshot returns the cumulative usage of every owner
+func (oc *ownerCosts) snapshot() map[string]ownerCost {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	out := make(map[string]ownerCost, len(oc.owners))
+	for owner, cost := range oc.owners {
+		out[owner] = *cost
+	}
+	return out
+}
+
+func sortedOwners(costs map[string]ownerCost) []string {
+	owners := make([]string, 0, len(costs))
+	for owner := range costs {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+	return owners
+}
+
+// countingWriter records the status and body size of a response
+type countingWriter struct {
+	wrappedWriter
+	status int
+	bytes  int64
+}
+
+func (cw *countingWriter) WriteHeader(code int) {
+	if cw.status == 0 {
+		cw.status = code
+	}
+	cw.ResponseWriter.WriteHeader(code)
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	n, err := cw.ResponseWriter.Write(b)
+	cw.bytes += int64(n)
+	return n, err
+}
+
+// countingReader counts the request body bytes a handler consumes
+type countingReader struct {
+	io.ReadCloser
+	bytes int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(p)
+	cr.bytes += int64(n)
+	return n, err
+}
+
+// accountOwner charges requests, handler time and bytes to the route owner
+func (s *Server) accountOwner(owner string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body *countingReader
+		if r.Body != nil && r.Body != http.NoBody {
+			body = &countingReader{ReadCloser: r.Body}
+			r.Body = body
+		}
+		cw := &countingWriter{wrappedWriter: wrappedWriter{w}}
+
+		start := time.Now()
+		next(cw, r)
+
+		var bytesIn int64
+		if body != nil {
+			bytesIn = body.bytes
+		}
+		status := cw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		s.costs.record(owner, status, time.Since(start), bytesIn, cw.bytes)
+	}
+}
+
+// writeOwnerMetrics appends per-owner usage to the /_metrics output
+func (s *Server) writeOwnerMetrics(w io.Writer) {
+	costs := s.costs.snapshot()
+	for _, owner := range sortedOwners(costs) {
+		cost := costs[owner]
+		fmt.Fprintf(w, "owner_requests_total{owner=%q} %d\n", owner, cost.Requests)
+		fmt.Fprintf(w, "owner_errors_total{owner=%q} %d\n", owner, cost.Errors)
+		fmt.Fprintf(w, "owner_handler_seconds_total{owner=%q} %.6f\n", owner, cost.HandlerTime.Seconds())
+		fmt.Fprintf(w, "owner_bytes_in_total{owner=%q} %d\n", owner, cost.BytesIn)
+		fmt.Fprintf(w, "owner_bytes_out_total{owner=%q} %d\n", owner, cost.BytesOut)
+	}
+}
+
+// costReportLine is one owner's usage for a report period
+type costReportLine struct {
+	PeriodStart string  `json:"period_start"`
+	PeriodEnd   string  `json:"period_end"`
+	Owner       string  `json:"owner"`
+	Requests    int64   `json:"requests"`
+	Errors      int64   `json:"errors"`
+	HandlerMs   float64 `json:"handler_ms"`
+	BytesIn     int64   `json:"bytes_in"`
+	BytesOut    int64   `json:"bytes_out"`
+}
+
+// StartCostReports logs each owner's usage every interval and, when file is
+// set, appends it there as JSON lines. Call the returned func to stop.
+func (s *Server) StartCostReports(interval time.Duration, file string) func() {
+	done := make(chan struct{})
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		previous := s.costs.snapshot()
+		periodStart := time.Now()
+
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				current := s.costs.snapshot()
+				s.writeCostReport(periodStart, now, current, previous, file)
+				previous, periodStart = current, now
+			}
+		}
+	}()
+
+	return func() { close(done) }
+}
+
+func (s *Server) writeCostReport(start, end time.Time, current, previous map[string]ownerCost, file string) {
+	var lines []costReportLine
+	for _, owner := range sortedOwners(current) {
+		delta := current[owner].sub(previous[owner])
+		if delta.Requests == 0 {
+			continue
+		}
+		lines = append(lines, costReportLine{
+			PeriodStart: start.UTC().Format(time.RFC3339),
+			PeriodEnd:   end.UTC().Format(time.RFC3339),
+			Owner:       owner,
+			Requests:    delta.Requests,
+			Errors:      delta.Errors,
+			HandlerMs:   durationMs(delta.HandlerTime),
+			BytesIn:     delta.BytesIn,
+			BytesOut:    delta.BytesOut,
+		})
+		log.Printf("Cost report: %s requests=%d errors=%d handler=%v in=%dB out=%dB",
+			owner, delta.Requests, delta.Errors, delta.HandlerTime.Round(time.Millisecond), delta.BytesIn, delta.BytesOut)
+	}
+
+	if file == "" || len(lines) == 0 {
+		return
+	}
+
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Printf("ERROR: Failed to open cost report %s: %v", file, err)
+		return
+	}
+	defer f.Close()
+
+	encoder := json.NewEncoder(f)
+	for _, line := range lines {
+		if err := encoder.Encode(line); err != nil {
+			log.Printf("ERROR: Failed to write cost report %s: %v", file, err)
+			return
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+
+	"htmlnojs/routebuilder"
+)
+
+// csrfSessionKey holds a session's CSRF token among its values
+const csrfSessionKey = "csrf"
+
+// maxCSRFFormBody bounds the form body read to find the csrf_token and
+// nonce fields
+const maxCSRFFormBody = 1 << 20
+
+// sessionCSRFToken returns the CSRF token of the request's session,
+// creating one the first time
+func sessionCSRFToken(r *http.Request) string {
+	session := SessionFrom(r.Context())
+	if session == nil {
+		return ""
+	}
+	token := session.Get(csrfSessionKey)
+	if token == "" {
+		b := make([]byte, 32)
+		rand.Read(b)
+		token = base64.RawURLEncoding.EncodeToString(b)
+		session.Set(csrfSessionKey, token)
+	}
+	return token
+}
+
+// provideCSRFToken hands the session's CSRF token to the page being
+// rendered, which adds it to its forms and htmx requests
+func provideCSRFToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token := sessionCSRFToken(r); token != "" {
+			r = r.WithContext(routebuilder.WithCSRFToken(r.Context(), token))
+		}
+		next(w, r)
+	}
+}
+
+// checkCSRF refuses state-changing requests that don't carry their
+// session's CSRF token, in the X-CSRF-Token header or the csrf_token field
+// of a urlencoded form. Requests signed in by a valid bearer token, JWT or
+// API key don't rely on a browser's cookies, so they are let through; a
+// bearer header that signs nobody in doesn't count.
+func (s *Server) checkCSRF(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next(w, r)
+			return
+		}
+		id := IdentityFrom(r.Context())
+		if id == nil {
+			if id = s.bearerIdentity(r); id != nil {
+				r = r.WithContext(context.WithValue(r.Context(), identityKey{}, id))
+			}
+		}
+		if id != nil && (id.Method == "token" || id.Method == "jwt" || id.Method == "api_key") {
+			next(w, r)
+			return
+		}
+
+		got := r.Header.Get(routebuilder.CSRFHeader)
+		if got == "" {
+			got = peekFormField(r, routebuilder.CSRFField)
+		}
+		var want string
+		if session := SessionFrom(r.Context()); session != nil {
+			want = session.Get(csrfSessionKey)
+		}
+		if got == "" || want == "" || !secretsEqual(got, want) {
+			routebuilder.WriteFragment(w, http.StatusForbidden, routebuilder.ErrorFragment(
+				"Page Expired",
+				"This page is out of date. Reload it and try again.",
+				"",
+			))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// bearerIdentity returns who the request's bearer token or JWT signs in,
+// leaving its cookies aside, or nil
+func (s *Server) bearerIdentity(r *http.Request) *Identity {
+	_, jwtChecked := r.Context().Value(jwtCheckedKey{}).(bool)
+	for _, authenticator := range s.authenticators {
+		switch authenticator.(type) {
+		case *TokenAuth:
+		case *JWTAuth:
+			if jwtChecked {
+				continue
+			}
+		default:
+			continue
+		}
+		if id := authenticator.Authenticate(r); id != nil {
+			return id
+		}
+	}
+	return nil
+}
+
+// peekFormField reads the name field of a urlencoded form body, leaving
+// the body for the handler
+func peekFormField(r *http.Request, name string) string {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/x-www-form-urlencoded" || r.Body == nil {
+		return ""
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxCSRFFormBody))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+	if err != nil {
+		return ""
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return ""
+	}
+	return form.Get(name)
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"htmlnojs/routebuilder"
+)
+
+func TestCheckCSRF(t *testing.T) {
+	s := &Server{authenticators: []Authenticator{&TokenAuth{Tokens: map[string]string{"deploy": "deploy-token"}}}}
+	handler := s.checkCSRF(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	form := url.Values{routebuilder.CSRFField: {"csrf-token"}}.Encode()
+
+	tests := []struct {
+		name     string
+		method   string
+		header   map[string]string
+		body     string
+		session  string    // The session's CSRF token, none when empty
+		identity *Identity // Already signed in by the route's auth
+		status   int
+	}{
+		{"GET", "GET", nil, "", "", nil, http.StatusNoContent},
+		{"HEAD", "HEAD", nil, "", "", nil, http.StatusNoContent},
+		{"header token", "POST", map[string]string{routebuilder.CSRFHeader: "csrf-token"}, "", "csrf-token", nil, http.StatusNoContent},
+		{"form token", "POST", map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, form, "csrf-token", nil, http.StatusNoContent},
+		{"no token", "POST", nil, "", "csrf-token", nil, http.StatusForbidden},
+		{"wrong token", "DELETE", map[string]string{routebuilder.CSRFHeader: "guessed"}, "", "csrf-token", nil, http.StatusForbidden},
+		{"no session token", "POST", map[string]string{routebuilder.CSRFHeader: ""}, "", "", nil, http.StatusForbidden},
+		{"form token, JSON body", "POST", map[string]string{"Content-Type": "application/json"}, form, "csrf-token", nil, http.StatusForbidden},
+		{"valid bearer token", "POST", map[string]string{"Authorization": "Bearer deploy-token"}, "", "", nil, http.StatusNoContent},
+		{"invalid bearer token", "POST", map[string]string{"Authorization": "Bearer guessed"}, "", "csrf-token", nil, http.StatusForbidden},
+		{"bearer header without a token", "PUT", map[string]string{"Authorization": "Bearer"}, "", "csrf-token", nil, http.StatusForbidden},
+		{"signed in by API key", "POST", nil, "", "", &Identity{Subject: "ci", Method: "api_key"}, http.StatusNoContent},
+		{"signed in by JWT", "POST", nil, "", "", &Identity{Subject: "ada", Method: "jwt"}, http.StatusNoContent},
+		{"signed in by session", "POST", nil, "", "csrf-token", &Identity{Subject: "ada", Method: "session"}, http.StatusForbidden},
+		{"signed in by basic auth", "POST", nil, "", "csrf-token", &Identity{Subject: "ada", Method: "basic"}, http.StatusForbidden},
+		{"signed URL", "POST", nil, "", "csrf-token", &Identity{Subject: "/api/report", Method: "signed_url"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/items", strings.NewReader(tt.body))
+			for name, value := range tt.header {
+				r.Header.Set(name, value)
+			}
+			ctx := r.Context()
+			session := &Session{values: map[string]string{}}
+			if tt.session != "" {
+				session.values[csrfSessionKey] = tt.session
+			}
+			ctx = context.WithValue(ctx, sessionKey{}, session)
+			if tt.identity != nil {
+				ctx = context.WithValue(ctx, identityKey{}, tt.identity)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r.WithContext(ctx))
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d", w.Code, tt.status)
+			}
+		})
+	}
+}
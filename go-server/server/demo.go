@@ -0,0 +1,33 @@
+package server
+
+import (
+	"log"
+	"net/http"
+
+	"htmlnojs/routebuilder"
+)
+
+// demoModeMiddleware refuses mutating requests in demo mode. HTMX requests
+// get a 200 so the notice is swapped into the page where the result would
+// have appeared; other clients get a 403.
+func (s *Server) demoModeMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			next(w, r)
+			return
+		}
+
+		log.Printf("Demo mode: refused %s %s", r.Method, r.URL.Path)
+
+		status := http.StatusForbidden
+		if IsHTMXRequest(r) {
+			status = http.StatusOK
+		}
+		w.Header().Set("X-Demo-Mode", "true")
+		routebuilder.WriteFragment(w, status, routebuilder.NoticeFragment(
+			"Demo mode",
+			"This is a read-only demo, so changes are not saved. Everything else works as usual.",
+		))
+	}
+}
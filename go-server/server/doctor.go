@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"htmlnojs/doctor"
+)
+
+// Doctor checks the environment of the running server
+type Doctor func(ctx context.Context) []doctor.Result
+
+// handleDoctor serves the doctor's results as text, or as JSON to clients
+// asking for it. Failing checks make it answer 503. Each run starts Python
+// and probes ports, so only localhost may run it unless it's made public.
+func (s *Server) handleDoctor(w http.ResponseWriter, r *http.Request) {
+	if !s.config.PublicDoctor && !isLoopback(r) {
+		http.NotFound(w, r)
+		return
+	}
+	results := s.doctor(r.Context())
+	failed := 0
+	for _, result := range results {
+		if !result.OK() {
+			failed++
+		}
+	}
+	code := http.StatusOK
+	if failed > 0 {
+		code = http.StatusServiceUnavailable
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(struct {
+			OK      bool            `json:"ok"`
+			Results []doctor.Result `json:"results"`
+		}{failed == 0, results})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(code)
+	for _, result := range results {
+		fmt.Fprintln(w, result)
+	}
+	if failed > 0 {
+		fmt.Fprintf(w, "%d check(s) failed\n", failed)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"htmlnojs/routebuilder"
+	"htmlnojs/store"
 )
 
 // ServerBuilder provides a fluent interface for building servers
@@ -24,6 +25,13 @@ func (b *ServerBuilder) Host(host string) *ServerBuilder {
 	return b
 }
 
+// Listen serves every address instead of host:port, each "host:port" or
+// "unix:/path/to.sock", e.g. a local port and a socket for a fronting nginx
+func (b *ServerBuilder) Listen(addresses ...string) *ServerBuilder {
+	b.server.listen = addresses
+	return b
+}
+
 // Port sets the server port
 func (b *ServerBuilder) Port(port int) *ServerBuilder {
 	b.server.port = port
@@ -45,6 +53,15 @@ func (b *ServerBuilder) WithTimeout(read, write, idle, shutdown time.Duration) *
 	return b
 }
 
+// WithShutdownTimeout sets how long requests may drain on shutdown; zero
+// keeps the current value
+func (b *ServerBuilder) WithShutdownTimeout(timeout time.Duration) *ServerBuilder {
+	if timeout > 0 {
+		b.server.config.ShutdownTimeout = timeout
+	}
+	return b
+}
+
 // EnableCORS enables or disables CORS
 func (b *ServerBuilder) EnableCORS(enable bool) *ServerBuilder {
 	b.server.config.EnableCORS = enable
@@ -63,12 +80,264 @@ func (b *ServerBuilder) EnableMetrics(enable bool) *ServerBuilder {
 	return b
 }
 
+// EnableDemoMode enables or disables read-only demo mode
+func (b *ServerBuilder) EnableDemoMode(enable bool) *ServerBuilder {
+	b.server.config.DemoMode = enable
+	return b
+}
+
+// EnableCSRF requires the session's CSRF token on POST, PUT, PATCH and
+// DELETE requests to handlers and gateways, and adds it to rendered pages
+func (b *ServerBuilder) EnableCSRF(enable bool) *ServerBuilder {
+	b.server.config.CSRF = enable
+	return b
+}
+
+// WithSampling captures a fraction of requests, plus every 5xx when errors
+// is set, for inspection at /_samples
+func (b *ServerBuilder) WithSampling(config SamplingConfig) *ServerBuilder {
+	b.server.config.Sampling = config
+	b.server.sampler = newSampler(config)
+	return b
+}
+
+// WithSlowRequestLog logs page and handler requests taking longer than
+// threshold with their route
+func (b *ServerBuilder) WithSlowRequestLog(threshold time.Duration) *ServerBuilder {
+	b.server.config.SlowRequests = threshold
+	return b
+}
+
+// WithIdempotency keeps the answers to POST and PATCH handler requests
+// with an Idempotency-Key for window, replaying them to repeats
+func (b *ServerBuilder) WithIdempotency(window time.Duration) *ServerBuilder {
+	b.server.config.IdempotencyWindow = window
+	return b
+}
+
+// WithTLS serves HTTPS with the PEM certificate and key files instead of
+// plain HTTP
+func (b *ServerBuilder) WithTLS(certFile, keyFile string) *ServerBuilder {
+	if certFile == "" && keyFile == "" {
+		b.server.tls = nil
+		return b
+	}
+	if b.server.tls == nil {
+		b.server.tls = &TLS{}
+	}
+	b.server.tls.CertFile = certFile
+	b.server.tls.KeyFile = keyFile
+	return b
+}
+
+// WithHTTPSRedirect listens for plain HTTP on port too, redirecting every
+// request to HTTPS. It needs WithTLS.
+func (b *ServerBuilder) WithHTTPSRedirect(port int) *ServerBuilder {
+	if port == 0 && b.server.tls == nil {
+		return b
+	}
+	if b.server.tls == nil {
+		b.server.tls = &TLS{}
+	}
+	b.server.tls.RedirectPort = port
+	return b
+}
+
+// WithNonceLifetime sets how long the action nonces a page embeds stay
+// valid when unused
+func (b *ServerBuilder) WithNonceLifetime(lifetime time.Duration) *ServerBuilder {
+	b.server.config.NonceLifetime = lifetime
+	return b
+}
+
+// WithConfigValidator enables /_admin/config/validate, which checks and
+// optionally applies a proposed project config
+func (b *ServerBuilder) WithConfigValidator(validate ConfigValidator) *ServerBuilder {
+	b.server.configValidator = validate
+	return b
+}
+
+// WithAdmin sets who may use the /_admin endpoints; without it nobody can
+func (b *ServerBuilder) WithAdmin(admin *Admin) *ServerBuilder {
+	b.server.admin = admin
+	return b
+}
+
+// WithTrustedProxies believes the client addresses the proxies forward in
+// Forwarded or X-Forwarded-For
+func (b *ServerBuilder) WithTrustedProxies(proxies *TrustedProxies) *ServerBuilder {
+	b.server.trustedProxies = proxies
+	return b
+}
+
+// WithStatusPage serves the uptime page recorded by health.Default's
+// history at config.Path
+func (b *ServerBuilder) WithStatusPage(config StatusPageConfig) *ServerBuilder {
+	b.server.config.StatusPage = config
+	return b
+}
+
+// WithBackendStatus reports handler backends as up or down on /health
+// and /_routes
+func (b *ServerBuilder) WithBackendStatus(status routebuilder.BackendStatus) *ServerBuilder {
+	b.server.backendStatus = status
+	return b
+}
+
+// WithBackendProcess adds the state of backend processes the server
+// manages, e.g. a uvicorn it restarts after crashes, to /health and /_routes
+func (b *ServerBuilder) WithBackendProcess(process BackendProcess) *ServerBuilder {
+	b.server.backendProcess = process
+	return b
+}
+
+// WithDoctor serves the environment checks run by doctor at /_doctor
+func (b *ServerBuilder) WithDoctor(doctor Doctor) *ServerBuilder {
+	b.server.doctor = doctor
+	return b
+}
+
+// EnablePublicDoctor lets any client run /_doctor's checks, which spawn
+// processes and reveal the environment; by default only localhost can
+func (b *ServerBuilder) EnablePublicDoctor(public bool) *ServerBuilder {
+	b.server.config.PublicDoctor = public
+	return b
+}
+
+// WithHARRecorder serves /_admin/har to start, stop and download HAR
+// captures of proxied traffic; the routes must be built with the same har
+func (b *ServerBuilder) WithHARRecorder(har *routebuilder.HARRecorder) *ServerBuilder {
+	b.server.har = har
+	return b
+}
+
+// WithFailedRequests serves /_admin/replay to list and replay the failed
+// requests the routes keep in failures
+func (b *ServerBuilder) WithFailedRequests(failures *routebuilder.FailedRequests) *ServerBuilder {
+	b.server.failures = failures
+	return b
+}
+
+// WithStore sets where sessions, caches and rate limits persist; the
+// default is an in-memory store
+func (b *ServerBuilder) WithStore(st store.Store) *ServerBuilder {
+	b.server.store = st
+	b.server.limiter = newRateLimiter(st)
+	return b
+}
+
+// WithSessions sets how sessions are kept; the default is cookies
+// encrypted with a key made at startup
+func (b *ServerBuilder) WithSessions(sessions *Sessions) *ServerBuilder {
+	b.server.sessions = sessions
+	return b
+}
+
+// WithAuthenticators sets who may reach routes that require auth; each
+// request is tried against the authenticators in order
+func (b *ServerBuilder) WithAuthenticators(authenticators ...Authenticator) *ServerBuilder {
+	b.server.authenticators = authenticators
+	return b
+}
+
+// WithAPIKeys lets programmatic clients call handlers with the keys in
+// auth, which also sign them in on routes that require auth
+func (b *ServerBuilder) WithAPIKeys(auth *APIKeyAuth) *ServerBuilder {
+	b.server.apiKeys = auth
+	return b
+}
+
+// WithRoles gives identities roles by their subject, on top of those
+// their JWT or session carries
+func (b *ServerBuilder) WithRoles(roles map[string][]string) *ServerBuilder {
+	b.server.roles = roles
+	return b
+}
+
+// WithIPAccess limits which client addresses reach the server, globally
+// and by route pattern
+func (b *ServerBuilder) WithIPAccess(access *IPAccess) *ServerBuilder {
+	b.server.ipAccess = access
+	return b
+}
+
+// WithCORS lets the origins cors allows call the server from a browser,
+// in place of the blanket "*" of EnableCORS
+func (b *ServerBuilder) WithCORS(cors *CORS) *ServerBuilder {
+	b.server.cors = cors
+	if cors != nil {
+		b.server.config.EnableCORS = false
+	}
+	return b
+}
+
+// WithAuditLog records the state-changing requests signed-in users make
+// to handlers needing auth or roles
+func (b *ServerBuilder) WithAuditLog(audit *AuditLog) *ServerBuilder {
+	b.server.audit = audit
+	return b
+}
+
+// WithMaintenance lets the site be taken down for maintenance at runtime
+func (b *ServerBuilder) WithMaintenance(maintenance *Maintenance) *ServerBuilder {
+	b.server.maintenance = maintenance
+	return b
+}
+
+// WithUserAgentRules blocks, rate limits or serves only static content to
+// the user agents rules match
+func (b *ServerBuilder) WithUserAgentRules(rules UserAgentRules) *ServerBuilder {
+	b.server.userAgents = rules
+	return b
+}
+
+// WithLogin serves the login and logout routes; browsers that aren't
+// signed in are sent to the login page unless WithLoginURL says otherwise
+func (b *ServerBuilder) WithLogin(login *Login) *ServerBuilder {
+	if login == nil {
+		b.server.login = nil
+		return b
+	}
+	b.server.login = login.withDefaults()
+	if b.server.loginURL == "" {
+		b.server.loginURL = b.server.login.Path
+	}
+	return b
+}
+
+// WithIdentitySecret signs the identity headers sent to backends with
+// secret, so they can tell the headers came from the server
+func (b *ServerBuilder) WithIdentitySecret(secret []byte) *ServerBuilder {
+	b.server.identitySecret = secret
+	return b
+}
+
+// WithLoginURL sends browsers that aren't signed in to url, with the page
+// they asked for in ?next=, instead of answering with a 401
+func (b *ServerBuilder) WithLoginURL(url string) *ServerBuilder {
+	b.server.loginURL = url
+	return b
+}
+
 // WithMiddleware adds middleware to the server
 func (b *ServerBuilder) WithMiddleware(mw MiddlewareFunc) *ServerBuilder {
 	b.server.AddMiddleware(mw)
 	return b
 }
 
+// Use adds middleware such as func(http.Handler) http.Handler around
+// every route, applied in the order given
+func (b *ServerBuilder) Use(middleware ...MiddlewareFunc) *ServerBuilder {
+	b.server.Use(middleware...)
+	return b
+}
+
+// UseFor adds middleware around the routes of one group, e.g. GroupAPI
+func (b *ServerBuilder) UseFor(group RouteGroup, middleware ...MiddlewareFunc) *ServerBuilder {
+	b.server.UseFor(group, middleware...)
+	return b
+}
+
 // WithLoggingMiddleware adds request logging middleware
 func (b *ServerBuilder) WithLoggingMiddleware() *ServerBuilder {
 	b.server.AddMiddleware(LoggingMiddleware)
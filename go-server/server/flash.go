@@ -0,0 +1,177 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"htmlnojs/routebuilder"
+)
+
+// FlashHeader is how handlers leave a flash message: "Order placed", or
+// with a level first, "success; Order placed". It's removed before the
+// response reaches the client.
+const FlashHeader = "X-HTMLnoJS-Flash"
+
+// flashSessionKey holds a session's waiting flash messages among its values
+const flashSessionKey = "flash"
+
+// maxFlashes caps the flash messages waiting in a session, so a cookie
+// session stays small when nothing shows them
+const maxFlashes = 10
+
+// AddFlash leaves a flash message for the next page the client sees.
+// Levels are "info", "success", "warning" and "error".
+func (s *Session) AddFlash(level, message string) {
+	if !routebuilder.IsFlashLevel(level) {
+		level = "info"
+	}
+	flashes := s.peekFlashes()
+	flashes = append(flashes, routebuilder.Flash{Level: level, Message: message})
+	if len(flashes) > maxFlashes {
+		flashes = flashes[len(flashes)-maxFlashes:]
+	}
+	data, _ := json.Marshal(flashes)
+	s.Set(flashSessionKey, string(data))
+}
+
+// Flashes returns the waiting flash messages and forgets them, so each is
+// shown once
+func (s *Session) Flashes() []routebuilder.Flash {
+	flashes := s.peekFlashes()
+	if len(flashes) > 0 {
+		s.Delete(flashSessionKey)
+	}
+	return flashes
+}
+
+func (s *Session) peekFlashes() []routebuilder.Flash {
+	var flashes []routebuilder.Flash
+	if data := s.Get(flashSessionKey); data != "" {
+		json.Unmarshal([]byte(data), &flashes)
+	}
+	return flashes
+}
+
+// parseFlash splits a FlashHeader value into its level and message
+func parseFlash(value string) (level, message string) {
+	if level, message, ok := strings.Cut(value, ";"); ok && routebuilder.IsFlashLevel(strings.TrimSpace(level)) {
+		return strings.TrimSpace(level), strings.TrimSpace(message)
+	}
+	return "info", strings.TrimSpace(value)
+}
+
+// provideFlashes hands the page being rendered the flash messages waiting
+// in its session
+func provideFlashes(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if session := SessionFrom(r.Context()); session != nil {
+			if flashes := session.Flashes(); len(flashes) > 0 {
+				r = r.WithContext(routebuilder.WithFlashes(r.Context(), flashes))
+			}
+		}
+		next(w, r)
+	}
+}
+
+// captureFlashes keeps the flash messages a handler sends in FlashHeader
+// in the session. An htmx request that stays on its page also gets a
+// "flash" event in HX-Trigger, so an element with
+// hx-get="/_flash" hx-trigger="flash from:body" shows them right away.
+func captureFlashes(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		fw := &flashWriter{wrappedWriter: wrappedWriter{w}, r: r}
+		next(fw, r)
+		fw.capture()
+	}
+}
+
+// flashWriter moves FlashHeader into the session before the response's
+// headers are sent
+type flashWriter struct {
+	wrappedWriter
+	r      *http.Request
+	status int
+	done   bool
+}
+
+func (fw *flashWriter) capture() {
+	if fw.done {
+		return
+	}
+	fw.done = true
+	header := fw.Header()
+	values := header.Values(FlashHeader)
+	if len(values) == 0 {
+		return
+	}
+	header.Del(FlashHeader)
+	session := SessionFrom(fw.r.Context())
+	if session == nil {
+		return
+	}
+	for _, value := range values {
+		session.AddFlash(parseFlash(value))
+	}
+
+	redirecting := header.Get("HX-Redirect") != "" || header.Get("HX-Location") != "" ||
+		(fw.status >= 300 && fw.status < 400)
+	if fw.r.Header.Get("HX-Request") == "true" && !redirecting {
+		addHXTrigger(header, "flash")
+	}
+}
+
+func (fw *flashWriter) WriteHeader(code int) {
+	if fw.status == 0 {
+		fw.status = code
+	}
+	fw.capture()
+	fw.ResponseWriter.WriteHeader(code)
+}
+
+func (fw *flashWriter) Write(b []byte) (int, error) {
+	if fw.status == 0 {
+		fw.status = http.StatusOK
+	}
+	fw.capture()
+	return fw.ResponseWriter.Write(b)
+}
+
+func (fw *flashWriter) Flush() {
+	fw.capture()
+	fw.wrappedWriter.Flush()
+}
+
+// addHXTrigger adds an event to HX-Trigger, keeping the events the
+// handler already triggers whether it listed their names or sent JSON
+func addHXTrigger(header http.Header, event string) {
+	existing := strings.TrimSpace(header.Get("HX-Trigger"))
+	if existing == "" {
+		header.Set("HX-Trigger", event)
+		return
+	}
+	if !strings.HasPrefix(existing, "{") {
+		header.Set("HX-Trigger", existing+", "+event)
+		return
+	}
+	events := map[string]json.RawMessage{}
+	if err := json.Unmarshal([]byte(existing), &events); err != nil {
+		return
+	}
+	if _, ok := events[event]; !ok {
+		events[event] = json.RawMessage("null")
+	}
+	data, _ := json.Marshal(events)
+	header.Set("HX-Trigger", string(data))
+}
+
+// handleFlash renders the waiting flash messages as banners and forgets
+// them; an empty response swaps in nothing
+func (s *Server) handleFlash(w http.ResponseWriter, r *http.Request) {
+	var flashes []routebuilder.Flash
+	if session := SessionFrom(r.Context()); session != nil {
+		flashes = session.Flashes()
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	routebuilder.WriteFragment(w, http.StatusOK, routebuilder.FlashFragment(flashes))
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// handleHAR controls the HAR capture of proxied traffic: POST
+// /_admin/har/start and /_admin/har/stop begin and end it, and GET
+// /_admin/har downloads what it recorded. Captures hold request data, so
+// it runs behind adminOnly.
+func (s *Server) handleHAR(w http.ResponseWriter, r *http.Request) {
+	action := strings.TrimPrefix(r.URL.Path, "/_admin/har")
+	switch action {
+	case "", "/":
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "GET the capture, or POST to /_admin/har/start or /_admin/har/stop", http.StatusMethodNotAllowed)
+			return
+		}
+		data, err := s.har.HAR()
+		if err != nil {
+			http.Error(w, "failed to encode HAR: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		name := fmt.Sprintf("htmlnojs-%s.har", time.Now().Format("20060102-150405"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+		w.Write(data)
+		return
+	case "/start", "/stop":
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "POST to "+r.URL.Path, http.StatusMethodNotAllowed)
+		return
+	}
+	if action == "/start" {
+		s.har.Start()
+		log.Printf("Started HAR capture of proxied requests")
+	} else {
+		s.har.Stop()
+		log.Printf("Stopped HAR capture with %d requests", s.har.Status().Entries)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.har.Status()); err != nil {
+		log.Printf("ERROR: Failed to encode HAR status: %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"htmlnojs/routebuilder"
+	"htmlnojs/store"
+)
+
+// Idempotency limits: keys are at most maxIdempotencyKey characters, and
+// requests or responses with bodies over maxIdempotentBody aren't kept. A
+// request still running after idempotencyLock no longer holds its key.
+const (
+	maxIdempotencyKey = 255
+	maxIdempotentBody = 1 << 20
+	idempotencyLock   = 5 * time.Minute
+)
+
+// idempotencySessionKey is set in a new session whose ID scopes a
+// client's keys, so that it is saved and the client's retry brings it back
+const idempotencySessionKey = "idempotency"
+
+// idempotentResponse is the stored answer to an Idempotency-Key
+type idempotentResponse struct {
+	Fingerprint string      `json:"fingerprint"` // Hash of the request it answered
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header"`
+	Body        []byte      `json:"body"`
+}
+
+// idempotent answers POST and PATCH requests that repeat an earlier
+// request's Idempotency-Key with the stored response, instead of running
+// the handler again. Keys are scoped to the route and to who the client is,
+// its signed-in identity or else its session, so one client can't read
+// another's answers and a rotated cookie doesn't lose them. A client
+// without a session cookie gets one. 5xx responses aren't kept, so a
+// retry after a failure reaches the handler. Only the headers the handler
+// set are kept, not those of the middleware around it.
+func (s *Server) idempotent(route string, next http.HandlerFunc) http.HandlerFunc {
+	responses := store.WithPrefix(s.store, "idempotency:")
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" || (r.Method != http.MethodPost && r.Method != http.MethodPatch) {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			routebuilder.WriteFragment(w, http.StatusBadRequest, routebuilder.ErrorFragment(
+				"Bad Idempotency-Key",
+				"Idempotency-Key must be at most "+strconv.Itoa(maxIdempotencyKey)+" characters",
+				"",
+			))
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+		if err != nil {
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxIdempotentBody {
+			log.Printf("WARNING: Idempotency-Key ignored for %s %s, its body is over %d bytes", r.Method, r.URL.Path, maxIdempotentBody)
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+			next(w, r)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		scope := s.idempotencyScope(r)
+		if scope == "" {
+			routebuilder.WriteFragment(w, http.StatusBadRequest, routebuilder.ErrorFragment(
+				"Bad Idempotency-Key",
+				"Idempotency-Key needs credentials or a session",
+				"",
+			))
+			return
+		}
+		id := hashParts(route, scope, key)
+		fingerprint := hashParts(r.Method, r.URL.RequestURI(), r.Header.Get("Content-Type"), string(body))
+		ctx := r.Context()
+
+		if data, err := responses.Get(ctx, id); err == nil {
+			var stored idempotentResponse
+			if err := json.Unmarshal(data, &stored); err == nil {
+				replayIdempotent(w, &stored, fingerprint)
+				return
+			}
+		} else if !errors.Is(err, store.ErrNotFound) {
+			log.Printf("WARNING: Idempotency-Key not checked, the store failed: %v", err)
+			next(w, r)
+			return
+		}
+
+		// The first request with a key runs, copies arriving meanwhile wait
+		// for its answer by retrying
+		if n, err := responses.Incr(ctx, id+":running", 1, idempotencyLock); err == nil && n > 1 {
+			w.Header().Set("Retry-After", "1")
+			routebuilder.WriteFragment(w, http.StatusConflict, routebuilder.ErrorFragment(
+				"Already In Progress",
+				"A request with this Idempotency-Key is still being handled",
+				"",
+			))
+			return
+		}
+		// The handler ran even if the client left, so its answer is kept
+		ctx = context.WithoutCancel(ctx)
+		defer responses.Delete(ctx, id+":running")
+
+		before := w.Header().Clone()
+		recorder := &recordingWriter{wrappedWriter: wrappedWriter{w}}
+		next(recorder, r)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+			recorder.header = w.Header().Clone()
+		}
+		if recorder.status >= 500 || recorder.truncated {
+			return
+		}
+		data, err := json.Marshal(idempotentResponse{
+			Fingerprint: fingerprint,
+			Status:      recorder.status,
+			Header:      handlerHeaders(before, recorder.header),
+			Body:        recorder.body.Bytes(),
+		})
+		if err == nil {
+			err = responses.Set(ctx, id, data, s.config.IdempotencyWindow)
+		}
+		if err != nil {
+			log.Printf("WARNING: Failed to keep the response for an Idempotency-Key: %v", err)
+		}
+	}
+}
+
+// idempotencyScope names who sent r: the identity the backend will see,
+// or else the client's session, which is kept for a client that didn't
+// have one yet. It is empty without sessions.
+func (s *Server) idempotencyScope(r *http.Request) string {
+	id := IdentityFrom(r.Context())
+	if id == nil {
+		id = s.authenticate(r)
+	}
+	if id != nil {
+		return "user:" + id.Method + ":" + id.Subject
+	}
+	session := SessionFrom(r.Context())
+	if session == nil {
+		return ""
+	}
+	if !session.persisted() {
+		session.Set(idempotencySessionKey, "1")
+	}
+	return "session:" + session.ID()
+}
+
+// replayIdempotent writes a stored response again, unless the request
+// reusing its key isn't the one it answered
+func replayIdempotent(w http.ResponseWriter, stored *idempotentResponse, fingerprint string) {
+	if stored.Fingerprint != fingerprint {
+		routebuilder.WriteFragment(w, http.StatusUnprocessableEntity, routebuilder.ErrorFragment(
+			"Idempotency-Key Reused",
+			"This Idempotency-Key was already used for a different request",
+			"",
+		))
+		return
+	}
+	for name, values := range stored.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(stored.Status)
+	w.Write(stored.Body)
+}
+
+// hashParts hashes parts into a hex string, keeping them apart
+func hashParts(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		io.WriteString(h, strconv.Itoa(len(part))+":"+part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// recordingWriter keeps a copy of the response it passes on, up to
+// maxIdempotentBody bytes
+type recordingWriter struct {
+	wrappedWriter
+	status    int
+	header    http.Header // As the handler left it, before outer writers add theirs
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+	if rw.status == 0 {
+		rw.status = code
+		rw.header = rw.Header().Clone()
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+		rw.header = rw.Header().Clone()
+	}
+	if !rw.truncated {
+		if rw.body.Len()+len(b) > maxIdempotentBody {
+			rw.truncated = true
+			rw.body.Reset()
+		} else {
+			rw.body.Write(b)
+		}
+	}
+	return rw.ResponseWriter.Write(b)
+}
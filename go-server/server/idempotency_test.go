@@ -0,0 +1,92 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"htmlnojs/routebuilder"
+)
+
+func TestIdempotencyKeyScope(t *testing.T) {
+	orders := 0
+	create := func(w http.ResponseWriter, r *http.Request) {
+		orders++
+		w.Header().Set("X-Order", strconv.Itoa(orders))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("order " + strconv.Itoa(orders)))
+	}
+	s := NewBuilder().
+		WithIdempotency(time.Hour).
+		WithAuthenticators(&TokenAuth{Tokens: map[string]string{"deploy": "deploy-token"}}).
+		WithRoutes(&routebuilder.RouteCollection{PythonRoutes: []routebuilder.PythonRoute{
+			{Name: "create", Route: "/api/orders", Method: "POST", Handler: create},
+		}}).
+		Build()
+	requests := 0
+	s.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.Header().Set("X-Request", strconv.Itoa(requests))
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	cookies := map[string]*http.Cookie{} // Session cookies by client
+	tests := []struct {
+		name     string
+		client   string // Keeps its session cookie between requests
+		token    string
+		body     string
+		replayed bool
+	}{
+		{"first client", "ann", "", "order 1", false},
+		{"first client retries", "ann", "", "order 1", true},
+		{"other client, same key", "bob", "", "order 2", false},
+		{"other client retries", "bob", "", "order 2", true},
+		{"client without cookies", "", "", "order 3", false},
+		{"another client without cookies", "", "", "order 4", false},
+		{"signed in, same key", "", "deploy-token", "order 5", false},
+		{"signed in retries", "", "deploy-token", "order 5", true},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/orders", strings.NewReader("item=7"))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.Header.Set("Idempotency-Key", "order-key")
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if cookie := cookies[tt.client]; cookie != nil {
+				r.AddCookie(cookie)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			for _, cookie := range w.Result().Cookies() {
+				if tt.client != "" {
+					cookies[tt.client] = cookie
+				}
+			}
+
+			if w.Code != http.StatusCreated || w.Body.String() != tt.body {
+				t.Fatalf("got %d %q, want 201 %q", w.Code, w.Body.String(), tt.body)
+			}
+			if replayed := w.Header().Get("Idempotent-Replayed") == "true"; replayed != tt.replayed {
+				t.Errorf("replayed = %v, want %v", replayed, tt.replayed)
+			}
+			if want := strings.TrimPrefix(tt.body, "order "); w.Header().Get("X-Order") != want {
+				t.Errorf("X-Order = %q, want %q", w.Header().Get("X-Order"), want)
+			}
+			// Middleware around the handler answers for this request, not the stored one
+			if want := strconv.Itoa(i + 1); w.Header().Get("X-Request") != want {
+				t.Errorf("X-Request = %q, want %q", w.Header().Get("X-Request"), want)
+			}
+			if tt.replayed && len(w.Result().Cookies()) > 0 {
+				t.Errorf("replay set cookies %v", w.Result().Cookies())
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers carrying the signed-in identity to backends, next to
+// SessionUserHeader. Copies sent by clients are dropped, so backends can
+// trust them.
+const (
+	IdentityRolesHeader     = "X-HTMLnoJS-Roles"              // Comma-separated
+	IdentityAuthHeader      = "X-HTMLnoJS-Auth"               // How the user signed in, e.g. "session" or "jwt"
+	IdentitySignatureHeader = "X-HTMLnoJS-Identity-Signature" // Apart from proxy.signing's header, so both can be on
+)
+
+// SignIdentity returns the IdentitySignatureHeader value for the identity
+// headers of a request: "t=<unix time>,v1=<hex HMAC-SHA256>" over the time,
+// user, roles and auth method, one per line. Backends reject old times so
+// a captured set of headers can't be reused for long.
+func SignIdentity(secret []byte, at time.Time, user, roles, auth string) string {
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.Join([]string{timestamp, user, roles, auth}, "\n")))
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// forwardIdentity tells the backend who the request is signed in as, in
+// SessionUserHeader, IdentityRolesHeader and IdentityAuthHeader, signed
+// with the identity secret when there is one. Routes that don't require
+// auth still forward an identity the request carries.
+func (s *Server) forwardIdentity(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del(IdentityRolesHeader)
+		r.Header.Del(IdentityAuthHeader)
+		r.Header.Del(IdentitySignatureHeader)
+
+		id := IdentityFrom(r.Context())
+		if id == nil {
+			id = s.authenticate(r)
+		}
+		if id == nil {
+			next(w, r)
+			return
+		}
+
+		roles := strings.Join(s.rolesOf(id), ",")
+		r.Header.Set(SessionUserHeader, id.Subject)
+		r.Header.Set(IdentityAuthHeader, id.Method)
+		if roles != "" {
+			r.Header.Set(IdentityRolesHeader, roles)
+		}
+		if len(s.identitySecret) > 0 {
+			r.Header.Set(IdentitySignatureHeader, SignIdentity(s.identitySecret, time.Now(), id.Subject, roles, id.Method))
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"htmlnojs/config"
+	"htmlnojs/routebuilder"
+)
+
+func TestIdentitySignatureWithProxySigning(t *testing.T) {
+	var got http.Header
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+	}))
+	defer backend.Close()
+	transport, err := routebuilder.ProxyTransport(config.ProxyConfig{Signing: config.SigningConfig{Secret: "proxy-secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	backendURL, _ := url.Parse(backend.URL)
+
+	s := &Server{
+		authenticators: []Authenticator{&TokenAuth{Tokens: map[string]string{"deploy": "deploy-token"}}},
+		roles:          map[string][]string{"deploy": {"staff"}},
+		identitySecret: []byte("identity-secret"),
+	}
+	handler := s.forwardIdentity(func(w http.ResponseWriter, r *http.Request) {
+		req := r.Clone(r.Context())
+		req.URL.Scheme, req.URL.Host, req.RequestURI = backendURL.Scheme, backendURL.Host, ""
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	})
+	r := httptest.NewRequest("GET", "/api/items", nil)
+	r.Header.Set("Authorization", "Bearer deploy-token")
+	handler(httptest.NewRecorder(), r)
+
+	fields := signatureFields(got.Get(IdentitySignatureHeader))
+	at, err := strconv.ParseInt(fields["t"], 10, 64)
+	if err != nil {
+		t.Fatalf("%s = %q", IdentitySignatureHeader, got.Get(IdentitySignatureHeader))
+	}
+	if want := SignIdentity([]byte("identity-secret"), time.Unix(at, 0), "deploy", "staff", "token"); got.Get(IdentitySignatureHeader) != want {
+		t.Errorf("%s = %q, want %q", IdentitySignatureHeader, got.Get(IdentitySignatureHeader), want)
+	}
+
+	fields = signatureFields(got.Get("X-HTMLnoJS-Signature"))
+	mac := hmac.New(sha256.New, []byte("proxy-secret"))
+	mac.Write([]byte(fields["t"] + "./api/items"))
+	if want := hex.EncodeToString(mac.Sum(nil)); fields["v1"] != want {
+		t.Errorf("proxy signature v1 = %q, want %q", fields["v1"], want)
+	}
+}
+
+// signatureFields splits "t=...,v1=..." into its fields
+func signatureFields(value string) map[string]string {
+	fields := map[string]string{}
+	for _, part := range strings.Split(value, ",") {
+		if name, field, ok := strings.Cut(part, "="); ok {
+			fields[name] = field
+		}
+	}
+	return fields
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"htmlnojs/routebuilder"
+)
+
+// IPRule lets in or turns away client addresses. Deny wins; with an Allow
+// list, only addresses in it get in.
+type IPRule struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+}
+
+// ParseIPRule reads CIDR ranges such as "10.0.0.0/8", or single addresses
+func ParseIPRule(allow, deny []string) (IPRule, error) {
+	var rule IPRule
+	var err error
+	if rule.Allow, err = parsePrefixes(allow); err != nil {
+		return IPRule{}, err
+	}
+	if rule.Deny, err = parsePrefixes(deny); err != nil {
+		return IPRule{}, err
+	}
+	return rule, nil
+}
+
+func parsePrefixes(ranges []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(ranges))
+	for _, value := range ranges {
+		value = strings.TrimSpace(value)
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q: %w", value, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR range %q: %w", value, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+func (rule IPRule) allows(addr netip.Addr) bool {
+	for _, prefix := range rule.Deny {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if len(rule.Allow) == 0 {
+		return true
+	}
+	for _, prefix := range rule.Allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// IPAccess checks client addresses before a request reaches any route: the
+// global rule first, then the rule of the longest route pattern matching
+// the path, e.g. "/admin/*" only from office ranges
+type IPAccess struct {
+	global   IPRule
+	routes   map[string]IPRule
+	patterns []string // Longest first
+}
+
+// NewIPAccess creates the address checks; routes are keyed by patterns
+// such as "/admin/*" or "/api/billing/export"
+func NewIPAccess(global IPRule, routes map[string]IPRule) *IPAccess {
+	a := &IPAccess{global: global, routes: routes}
+	for pattern := range routes {
+		a.patterns = append(a.patterns, pattern)
+	}
+	sort.Slice(a.patterns, func(i, j int) bool {
+		if len(a.patterns[i]) != len(a.patterns[j]) {
+			return len(a.patterns[i]) > len(a.patterns[j])
+		}
+		return a.patterns[i] < a.patterns[j]
+	})
+	return a
+}
+
+// allows reports whether a request for path from addr gets in
+func (a *IPAccess) allows(addr netip.Addr, path string) bool {
+	if !a.global.allows(addr) {
+		return false
+	}
+	for _, pattern := range a.patterns {
+		if routebuilder.MatchRoutePattern(pattern, path) {
+			return a.routes[pattern].allows(addr)
+		}
+	}
+	return true
+}
+
+// checkIPAccess answers requests from addresses that aren't let in with a
+// 403, reporting whether the request may go on
+func (s *Server) checkIPAccess(w http.ResponseWriter, r *http.Request) bool {
+	if s.ipAccess == nil {
+		return true
+	}
+	addr, err := netip.ParseAddr(clientAddress(r))
+	if err == nil && s.ipAccess.allows(addr.Unmap(), r.URL.Path) {
+		return true
+	}
+	log.Printf("WARNING: Refused %s %s from %s, the address isn't allowed", r.Method, r.URL.Path, clientAddress(r))
+	routebuilder.WriteFragment(w, http.StatusForbidden, routebuilder.ErrorFragment(
+		"Access Denied",
+		"This page isn't available from your network",
+		"",
+	))
+	return false
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"htmlnojs/routebuilder"
+)
+
+func TestIPAccess(t *testing.T) {
+	global, err := ParseIPRule(nil, []string{"203.0.113.0/24", "2001:db8:bad::/48"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := ParseIPRule([]string{"10.0.0.0/8", "192.0.2.10"}, []string{"10.6.6.6"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxies, err := ParseTrustedProxies([]string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := func(w http.ResponseWriter, r *http.Request) {}
+	s := NewBuilder().
+		WithIPAccess(NewIPAccess(global, map[string]IPRule{"/admin/*": admin})).
+		WithTrustedProxies(proxies).
+		WithRoutes(&routebuilder.RouteCollection{HTMLRoutes: []routebuilder.HTMLRoute{
+			{Name: "home", Route: "/", Method: "GET", Handler: ok},
+			{Name: "admin", Route: "/admin/users", Method: "GET", Handler: ok},
+		}}).
+		Build()
+
+	tests := []struct {
+		name      string
+		peer      string
+		forwarded string // X-Forwarded-For
+		path      string
+		allowed   bool
+	}{
+		{"anyone on public pages", "198.51.100.7:4000", "", "/", true},
+		{"denied everywhere", "203.0.113.9:4000", "", "/", false},
+		{"denied IPv6 range", "[2001:db8:bad::1]:4000", "", "/", false},
+		{"IPv4-mapped IPv6", "[::ffff:203.0.113.9]:4000", "", "/", false},
+		{"admin from the office", "10.1.2.3:4000", "", "/admin/users", true},
+		{"admin from a single address", "192.0.2.10:4000", "", "/admin/users", true},
+		{"admin from elsewhere", "198.51.100.7:4000", "", "/admin/users", false},
+		{"admin denied within the allowed range", "10.6.6.6:4000", "", "/admin/users", false},
+		{"denied client behind a trusted proxy", "127.0.0.1:4000", "203.0.113.9", "/", false},
+		{"office client behind a trusted proxy", "127.0.0.1:4000", "10.1.2.3", "/admin/users", true},
+		{"forwarded address from an untrusted peer", "198.51.100.7:4000", "10.1.2.3", "/admin/users", false},
+		{"no address", "", "", "/", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			r.RemoteAddr = tt.peer
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			want := http.StatusForbidden
+			if tt.allowed {
+				want = http.StatusOK
+			}
+			if w.Code != want {
+				t.Errorf("status = %d, want %d", w.Code, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,448 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JWKS caching: keys are fetched again after jwksTTL, and an unknown key
+// ID triggers a fetch at most every jwksRefetch
+const (
+	jwksTTL       = time.Hour
+	jwksRefetch   = time.Minute
+	jwksTimeout   = 10 * time.Second
+	defaultLeeway = time.Minute
+)
+
+// JWTAuth authenticates "Authorization: Bearer <jwt>" headers, checking
+// the signature against keys from a JWKS URL or a shared HMAC secret, and
+// the token's issuer, audience and validity times
+type JWTAuth struct {
+	JWKSURL  string            // Where the signing keys are published
+	Secret   []byte            // Shared HS256/384/512 secret, instead of or besides JWKS
+	Issuer   string            // Required "iss", empty accepts any
+	Audience string            // Required in "aud", empty accepts any
+	Leeway   time.Duration     // Clock skew allowed on "exp" and "nbf" (default 1m)
+	Claims   map[string]string // Request headers set from claims for backends, by claim name
+	Roles    string            // Claim listing the token's roles (default "roles")
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetched   time.Time
+	lastFetch time.Time
+	fetching  chan struct{} // Closed when the fetch in progress is done
+	client    *http.Client
+}
+
+// jwtHeader is the first part of a JWT
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+func (a *JWTAuth) Authenticate(r *http.Request) *Identity {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || strings.Count(token, ".") != 2 {
+		return nil
+	}
+	claims, err := a.verify(r.Context(), token)
+	if err != nil {
+		return nil
+	}
+	subject, _ := claims["sub"].(string)
+	rolesClaim := a.Roles
+	if rolesClaim == "" {
+		rolesClaim = "roles"
+	}
+	return &Identity{Subject: subject, Method: "jwt", Claims: claims, Roles: claimRoles(claims[rolesClaim])}
+}
+
+func (a *JWTAuth) Challenge() string {
+	return "Bearer"
+}
+
+// verify checks token's signature and claims and returns the claims
+func (a *JWTAuth) verify(ctx context.Context, token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid header encoding")
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, fmt.Errorf("invalid header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding")
+	}
+	if err := a.checkSignature(ctx, header, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload encoding")
+	}
+	claims := map[string]any{}
+	decoder := json.NewDecoder(strings.NewReader(string(payload)))
+	decoder.UseNumber()
+	if err := decoder.Decode(&claims); err != nil {
+		return nil, fmt.Errorf("invalid payload")
+	}
+	return claims, a.checkClaims(claims)
+}
+
+// checkSignature verifies signature with the key and algorithm the header
+// names; the algorithm must suit the key, so a public key can't be used
+// as an HMAC secret
+func (a *JWTAuth) checkSignature(ctx context.Context, header jwtHeader, signed, signature []byte) error {
+	var hash crypto.Hash
+	switch header.Alg[min(2, len(header.Alg)):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+
+	if strings.HasPrefix(header.Alg, "HS") {
+		if len(a.Secret) == 0 {
+			return fmt.Errorf("unsupported algorithm %q, no secret is configured", header.Alg)
+		}
+		mac := hmac.New(hash.New, a.Secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return fmt.Errorf("bad signature")
+		}
+		return nil
+	}
+
+	key, err := a.key(ctx, header.Kid)
+	if err != nil {
+		return err
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		switch {
+		case strings.HasPrefix(header.Alg, "RS"):
+			err = rsa.VerifyPKCS1v15(key, hash, digest, signature)
+		case strings.HasPrefix(header.Alg, "PS"):
+			err = rsa.VerifyPSS(key, hash, digest, signature, nil)
+		default:
+			err = fmt.Errorf("algorithm %q doesn't suit an RSA key", header.Alg)
+		}
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(header.Alg, "ES") || len(signature) != 2*size {
+			return fmt.Errorf("algorithm %q doesn't suit an EC key", header.Alg)
+		}
+		rInt := new(big.Int).SetBytes(signature[:size])
+		sInt := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(key, digest, rInt, sInt) {
+			err = fmt.Errorf("bad signature")
+		}
+	default:
+		err = fmt.Errorf("unsupported key type")
+	}
+	return err
+}
+
+// checkClaims checks the token's validity times, issuer and audience
+func (a *JWTAuth) checkClaims(claims map[string]any) error {
+	leeway := a.Leeway
+	if leeway <= 0 {
+		leeway = defaultLeeway
+	}
+	now := time.Now()
+	if exp, ok := numericClaim(claims, "exp"); ok && now.After(exp.Add(leeway)) {
+		return fmt.Errorf("token expired at %v", exp)
+	}
+	if nbf, ok := numericClaim(claims, "nbf"); ok && now.Add(leeway).Before(nbf) {
+		return fmt.Errorf("token not valid before %v", nbf)
+	}
+	if a.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != a.Issuer {
+			return fmt.Errorf("issuer %q isn't %q", iss, a.Issuer)
+		}
+	}
+	if a.Audience != "" {
+		var audiences []string
+		switch aud := claims["aud"].(type) {
+		case string:
+			audiences = []string{aud}
+		case []any:
+			for _, value := range aud {
+				if s, ok := value.(string); ok {
+					audiences = append(audiences, s)
+				}
+			}
+		}
+		if !slices.Contains(audiences, a.Audience) {
+			return fmt.Errorf("audience %q not in %v", a.Audience, audiences)
+		}
+	}
+	return nil
+}
+
+// numericClaim reads a NumericDate claim such as "exp"
+func numericClaim(claims map[string]any, name string) (time.Time, bool) {
+	number, ok := claims[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	seconds, err := number.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(seconds), 0), true
+}
+
+// key returns the JWKS key with ID kid, fetching the key set when it's
+// stale or doesn't have it yet
+func (a *JWTAuth) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if a.JWKSURL == "" {
+		return nil, fmt.Errorf("no jwks_url is configured for asymmetric tokens")
+	}
+	a.mu.Lock()
+	key, ok := a.lookup(kid)
+	if !ok || time.Since(a.fetched) > jwksTTL {
+		if done := a.refresh(ctx); done != nil {
+			a.mu.Unlock()
+			select {
+			case <-done:
+			case <-ctx.Done():
+			}
+			a.mu.Lock()
+			key, ok = a.lookup(kid)
+		}
+	}
+	a.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+// refresh fetches the key set in the background, unless a fetch is
+// already running, which callers then share, or one ran in the last
+// jwksRefetch. It returns a channel closed when the fetch is done, or nil.
+// a.mu must be held; it isn't during the fetch, so a slow JWKS URL doesn't
+// hold up tokens whose keys are cached.
+func (a *JWTAuth) refresh(ctx context.Context) chan struct{} {
+	if a.fetching != nil {
+		return a.fetching
+	}
+	if time.Since(a.lastFetch) <= jwksRefetch {
+		return nil
+	}
+	a.lastFetch = time.Now()
+	done := make(chan struct{})
+	a.fetching = done
+	go func() {
+		keys, err := a.fetchKeys(ctx)
+		a.mu.Lock()
+		if err != nil {
+			log.Printf("WARNING: Failed to fetch JWKS from %s: %v", a.JWKSURL, err)
+		} else {
+			a.keys, a.fetched = keys, time.Now()
+		}
+		a.fetching = nil
+		a.mu.Unlock()
+		close(done)
+	}()
+	return done
+}
+
+// lookup finds a cached key; without a kid the only key is used
+func (a *JWTAuth) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(a.keys) == 1 {
+		for _, key := range a.keys {
+			return key, true
+		}
+	}
+	key, ok := a.keys[kid]
+	return key, ok
+}
+
+// jwk is one key of a JSON Web Key Set
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// fetchKeys downloads the key set, skipping keys it can't use
+func (a *JWTAuth) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	if a.client == nil {
+		a.client = &http.Client{Timeout: jwksTimeout}
+	}
+	req, err := http.NewRequestWithContext(context.WithoutCancel(ctx), http.MethodGet, a.JWKSURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("invalid key set: %w", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Printf("WARNING: Skipping JWKS key %q: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("no usable signing keys")
+	}
+	return keys, nil
+}
+
+// publicKey decodes an RSA or EC key
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	decode := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("invalid key parameter")
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("point isn't on %s", k.Crv)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// jwtCheckedKey marks requests whose JWT forwardClaims already checked
+type jwtCheckedKey struct{}
+
+// forwardClaims passes a valid JWT's claims to the backend in the headers
+// named by Claims, dropping any the client sent. Routes that require auth
+// reuse the identity instead of checking the token again.
+func (a *JWTAuth) forwardClaims(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, header := range a.Claims {
+			r.Header.Del(header)
+		}
+		ctx := context.WithValue(r.Context(), jwtCheckedKey{}, true)
+		if id := a.Authenticate(r); id != nil {
+			for claim, header := range a.Claims {
+				if value, ok := claimHeader(id.Claims[claim]); ok {
+					r.Header.Set(header, value)
+				}
+			}
+			ctx = context.WithValue(ctx, identityKey{}, id)
+		}
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// claimHeader renders a claim as a header value: strings and numbers as
+// they are, lists comma separated, objects as JSON
+func claimHeader(value any) (string, bool) {
+	switch v := value.(type) {
+	case nil:
+		return "", false
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := claimHeader(item); ok {
+				items = append(items, s)
+			}
+		}
+		return strings.Join(items, ","), true
+	default:
+		data, err := json.Marshal(v)
+		return string(data), err == nil
+	}
+}
+
+// jwtAuth returns the server's JWT authenticator, if it has one
+func (s *Server) jwtAuth() *JWTAuth {
+	for _, authenticator := range s.authenticators {
+		if a, ok := authenticator.(*JWTAuth); ok {
+			return a
+		}
+	}
+	return nil
+}
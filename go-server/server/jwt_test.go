@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestJWTCheckSignature(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPublic, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("shared-secret")
+	signed := []byte("eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiJhZGEifQ")
+	digest := sha256.Sum256(signed)
+
+	hmacSHA256 := func(key []byte) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(signed)
+		return mac.Sum(nil)
+	}
+	rs256, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps256, err := rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, digest[:], nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	es256 := append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+
+	tests := []struct {
+		name      string
+		secret    []byte // The authenticator's HMAC secret, besides the JWKS
+		alg, kid  string
+		signature []byte
+		ok        bool
+	}{
+		{"HS256", secret, "HS256", "", hmacSHA256(secret), true},
+		{"HS256 wrong secret", secret, "HS256", "", hmacSHA256([]byte("guessed")), false},
+		{"HS256 without a secret", nil, "HS256", "", hmacSHA256(nil), false},
+		{"HS256 signed with the RSA public key", nil, "HS256", "rsa", hmacSHA256(rsaPublic), false},
+		{"HS256 signed with the RSA public key, secret set", secret, "HS256", "rsa", hmacSHA256(rsaPublic), false},
+		{"RS256", nil, "RS256", "rsa", rs256, true},
+		{"PS256", nil, "PS256", "rsa", ps256, true},
+		{"ES256", nil, "ES256", "ec", es256, true},
+		{"RS256 with the EC key", nil, "RS256", "ec", rs256, false},
+		{"ES256 with the RSA key", nil, "ES256", "rsa", es256, false},
+		{"PS256 signature as RS256", nil, "RS256", "rsa", ps256, false},
+		{"RS384 signature of SHA-256", nil, "RS384", "rsa", rs256, false},
+		{"unknown key", nil, "RS256", "other", rs256, false},
+		{"none", secret, "none", "", nil, false},
+		{"empty alg", secret, "", "", hmacSHA256(secret), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &JWTAuth{
+				JWKSURL: "https://keys.example.com/jwks.json",
+				Secret:  tt.secret,
+				keys: map[string]crypto.PublicKey{
+					"rsa": &rsaKey.PublicKey,
+					"ec":  &ecKey.PublicKey,
+				},
+				fetched:   time.Now(),
+				lastFetch: time.Now(),
+			}
+			err := a.checkSignature(context.Background(), jwtHeader{Alg: tt.alg, Kid: tt.kid}, signed, tt.signature)
+			if (err == nil) != tt.ok {
+				t.Errorf("checkSignature() error = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unixPrefix marks listen addresses that are Unix socket paths
+const unixPrefix = "unix:"
+
+// listenAddresses returns where the server listens: the addresses given to
+// Listen, or host:port
+func (s *Server) listenAddresses() []string {
+	if len(s.listen) > 0 {
+		return s.listen
+	}
+	return []string{net.JoinHostPort(s.host, strconv.Itoa(s.port))}
+}
+
+// listen opens addr, either "host:port" or "unix:/path/to.sock". A socket
+// file left behind by a server that is gone is replaced; one still being
+// listened on is an error.
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixPrefix)
+	if !ok {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		return ln, nil
+	}
+
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("failed to listen on %s: %s exists and isn't a socket", addr, path)
+		}
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to listen on %s: another server is listening there", addr)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket %s: %w", path, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return ln, nil
+}
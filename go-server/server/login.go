@@ -0,0 +1,267 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"htmlnojs/routebuilder"
+)
+
+// LoginPage is what the login form shows
+type LoginPage struct {
+	Action    string // Where the form posts, the login path
+	Next      string // Page to go to once signed in
+	Username  string // Kept from a failed attempt
+	Error     string
+	CSRFToken string // Empty when CSRF protection is off
+}
+
+// defaultLoginTemplate renders the login form unless auth.login.template
+// replaces it
+var defaultLoginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>Sign in</title>
+</head>
+<body style="font-family: system-ui, sans-serif; display: flex; justify-content: center; padding-top: 10vh;">
+    <form class="htmlnojs-login" method="post" action="{{.Action}}" style="display: flex; flex-direction: column; gap: 10px; width: 280px;">
+        <h1 style="font-size: 1.4em;">Sign in</h1>
+        {{- if .Error}}
+        <div class="htmx-error" role="alert" style="color: #991b1b; background: #fef2f2; padding: 10px; border: 1px solid #fca5a5; border-radius: 4px;">{{.Error}}</div>
+        {{- end}}
+        {{- if .CSRFToken}}
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        {{- end}}
+        <input type="hidden" name="next" value="{{.Next}}">
+        <label>Username <input name="username" value="{{.Username}}" autocomplete="username" required autofocus style="width: 100%;"></label>
+        <label>Password <input name="password" type="password" autocomplete="current-password" required style="width: 100%;"></label>
+        <button type="submit">Sign in</button>
+    </form>
+</body>
+</html>
+`))
+
+// Login serves a login form, hands the credentials posted to it to a
+// handler route for checking and signs the session in when the handler
+// names a user. POSTing to the logout path signs it out.
+type Login struct {
+	Path       string // Login form (default "/login")
+	LogoutPath string // Signs out with a POST (default "/logout")
+	Handler    string // Route of the handler checking credentials, e.g. "/api/accounts/verify"
+	Redirect   string // Where to go once signed in without ?next= (default "/")
+	RateLimit  int    // Attempts a minute per address (default 10)
+	Template   *template.Template
+}
+
+// loginResult is what the credential handler answers with; no user means
+// the credentials were wrong
+type loginResult struct {
+	User  string   `json:"user"`
+	Roles []string `json:"roles"`
+}
+
+func (l *Login) withDefaults() *Login {
+	login := *l
+	if login.Path == "" {
+		login.Path = "/login"
+	}
+	if login.LogoutPath == "" {
+		login.LogoutPath = "/logout"
+	}
+	if login.Redirect == "" {
+		login.Redirect = "/"
+	}
+	if login.RateLimit == 0 {
+		login.RateLimit = 10
+	}
+	if login.Template == nil {
+		login.Template = defaultLoginTemplate
+	}
+	return &login
+}
+
+// registerLogin adds the login and logout routes, which need sessions
+func (s *Server) registerLogin(mux *http.ServeMux, routes *routebuilder.RouteCollection) {
+	if s.login == nil {
+		return
+	}
+	if s.sessions == nil {
+		log.Printf("WARNING: Login routes need sessions, %s isn't served", s.login.Path)
+		return
+	}
+	for _, route := range routes.HTMLRoutes {
+		if route.Route == s.login.Path {
+			log.Printf("WARNING: The built-in login page replaces the %s template, set auth.login.template to use it as the form", route.Name)
+		}
+	}
+	if s.findRoute(routes, s.login.Handler) == nil {
+		log.Printf("WARNING: Login handler %s isn't a handler route, nobody can sign in", s.login.Handler)
+	}
+
+	login := http.HandlerFunc(s.handleLogin)
+	logout := http.HandlerFunc(s.handleLogout)
+	if s.config.CSRF {
+		login = s.checkCSRF(login)
+		logout = s.checkCSRF(logout)
+	}
+	mux.Handle("GET "+s.login.Path, s.chain(GroupHTML, login))
+	mux.Handle("POST "+s.login.Path, s.chain(GroupHTML, login))
+	mux.Handle(s.login.LogoutPath, s.chain(GroupHTML, logout))
+	log.Printf("Registered login routes: %s, %s -> %s", s.login.Path, s.login.LogoutPath, s.login.Handler)
+}
+
+// findRoute returns the handler route at path, or nil
+func (s *Server) findRoute(routes *routebuilder.RouteCollection, path string) *routebuilder.PythonRoute {
+	for i := range routes.PythonRoutes {
+		if routes.PythonRoutes[i].Route == path {
+			return &routes.PythonRoutes[i]
+		}
+	}
+	return nil
+}
+
+// handleLogin shows the login form on GET, and on POST checks the
+// credentials with the login handler
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	page := LoginPage{Action: s.login.Path, Next: localNext(r.FormValue("next"))}
+	if s.config.CSRF {
+		page.CSRFToken = sessionCSRFToken(r)
+	}
+	if r.Method == http.MethodGet {
+		s.renderLogin(w, http.StatusOK, page)
+		return
+	}
+
+	remaining, wait, by := s.limiter.allow(r.Context(), "login", clientAddress(r), "", s.login.RateLimit)
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(s.login.RateLimit))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+	if by != "" {
+		retryAfter := int(math.Ceil(wait.Seconds()))
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		page.Error = "Too many attempts, try again in " + strconv.Itoa(retryAfter) + " seconds"
+		s.renderLogin(w, http.StatusTooManyRequests, page)
+		return
+	}
+
+	page.Username = r.PostFormValue("username")
+	result, ok := s.verifyLogin(r)
+	if !ok {
+		log.Printf("WARNING: Failed sign in as %q from %s", page.Username, clientAddress(r))
+		page.Error = "The username or password isn't right"
+		s.renderLogin(w, http.StatusUnauthorized, page)
+		return
+	}
+
+	session := SessionFrom(r.Context())
+	session.SetUser(result.User)
+	session.SetRoles(result.Roles...)
+	log.Printf("%s signed in from %s", result.User, clientAddress(r))
+
+	target := page.Next
+	if target == "" {
+		target = s.login.Redirect
+	}
+	redirect(w, r, target)
+}
+
+// verifyLogin hands the posted form, without its CSRF token, to the login
+// handler; it signs in the user the handler answers with
+func (s *Server) verifyLogin(r *http.Request) (loginResult, bool) {
+	route := s.findRoute(s.GetRoutes(), s.login.Handler)
+	if route == nil {
+		log.Printf("ERROR: Login handler %s isn't a handler route", s.login.Handler)
+		return loginResult{}, false
+	}
+
+	form := url.Values{}
+	for key, values := range r.PostForm {
+		if key != routebuilder.CSRFField && key != "next" {
+			form[key] = values
+		}
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, s.login.Handler, strings.NewReader(form.Encode()))
+	if err != nil {
+		log.Printf("ERROR: Failed to call login handler: %v", err)
+		return loginResult{}, false
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", r.UserAgent())
+	req.RemoteAddr = r.RemoteAddr
+
+	recorder := httptest.NewRecorder()
+	route.Handler(recorder, req)
+	if recorder.Code < 200 || recorder.Code >= 300 {
+		if recorder.Code >= 500 {
+			log.Printf("ERROR: Login handler %s failed with status %d", s.login.Handler, recorder.Code)
+		}
+		return loginResult{}, false
+	}
+	var result loginResult
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		log.Printf("ERROR: Login handler %s didn't answer with JSON: %v", s.login.Handler, err)
+		return loginResult{}, false
+	}
+	return result, result.User != ""
+}
+
+// handleLogout signs the session out on POST and goes to next, or the
+// home page. GET is refused, so a link or image can't sign anyone out.
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "POST to "+s.login.LogoutPath+" to sign out", http.StatusMethodNotAllowed)
+		return
+	}
+	session := SessionFrom(r.Context())
+	if user := session.User(); user != "" {
+		session.SetUser("")
+		log.Printf("%s signed out", user)
+	}
+	target := localNext(r.FormValue("next"))
+	if target == "" {
+		target = "/"
+	}
+	redirect(w, r, target)
+}
+
+func (s *Server) renderLogin(w http.ResponseWriter, status int, page LoginPage) {
+	var buf bytes.Buffer
+	if err := s.login.Template.Execute(&buf, page); err != nil {
+		log.Printf("WARNING: Login template failed, using the built-in one: %v", err)
+		buf.Reset()
+		defaultLoginTemplate.Execute(&buf, page)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	routebuilder.WriteFragment(w, status, buf.String())
+}
+
+// localNext returns next when it is a path on this site, so the login
+// form can't be used to send users elsewhere
+func localNext(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return ""
+	}
+	return next
+}
+
+// redirect sends the browser to target; htmx requests get HX-Redirect so
+// the whole page changes
+func redirect(w http.ResponseWriter, r *http.Request, target string) {
+	if IsHTMXRequest(r) {
+		w.Header().Set("HX-Redirect", target)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"htmlnojs/routebuilder"
+)
+
+func TestLoginReplacesTheSignedInUser(t *testing.T) {
+	sessions, err := NewCookieSessions([]byte("session-secret"), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accounts := map[string]loginResult{
+		"ada": {User: "ada", Roles: []string{"admin"}},
+		"bob": {User: "bob"},
+	}
+	verify := func(w http.ResponseWriter, r *http.Request) {
+		result, ok := accounts[r.PostFormValue("username")]
+		if !ok || r.PostFormValue("password") != "right" {
+			json.NewEncoder(w).Encode(loginResult{})
+			return
+		}
+		json.NewEncoder(w).Encode(result)
+	}
+	s := NewBuilder().
+		WithSessions(sessions).
+		WithLogin(&Login{Handler: "/api/accounts/verify"}).
+		WithRoutes(&routebuilder.RouteCollection{PythonRoutes: []routebuilder.PythonRoute{
+			{Name: "verify", Route: "/api/accounts/verify", Method: "POST", Handler: verify},
+		}}).
+		Build()
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		status   int
+		user     string   // Signed in afterwards
+		roles    []string // The session's roles afterwards
+	}{
+		{"wrong password", "ada", "wrong", http.StatusUnauthorized, "", nil},
+		{"admin", "ada", "right", http.StatusSeeOther, "ada", []string{"admin"}},
+		{"same session, user without roles", "bob", "right", http.StatusSeeOther, "bob", nil},
+		{"failed attempt keeps the user", "ada", "wrong", http.StatusUnauthorized, "bob", nil},
+	}
+	var cookie *http.Cookie
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"username": {tt.username}, "password": {tt.password}}
+			r := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if cookie != nil {
+				r.AddCookie(cookie)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d", w.Code, tt.status)
+			}
+			for _, c := range w.Result().Cookies() {
+				if c.Name == sessions.Cookie {
+					cookie = c
+				}
+			}
+			session := &Session{values: map[string]string{}}
+			if cookie != nil {
+				if _, session.values, err = sessions.codec.load(context.Background(), cookie.Value); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if user := session.User(); user != tt.user {
+				t.Errorf("user = %q, want %q", user, tt.user)
+			}
+			if roles := session.Roles(); !slices.Equal(roles, tt.roles) {
+				t.Errorf("roles = %v, want %v", roles, tt.roles)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"htmlnojs/routebuilder"
+)
+
+// maintenanceOpen are paths served during maintenance whatever the
+// configuration, so load balancers and operators can still reach the server
+// and the maintenance page keeps its stylesheets and fonts
+var maintenanceOpen = []string{"/health", "/readyz", "/_admin/*", "/css/*", "/fonts/*"}
+
+// Maintenance takes the site down for maintenance without a restart: every
+// route but health checks, /_admin, stylesheets, fonts and the allowed
+// patterns answers with a 503 page. It is switched on with POST
+// /_admin/maintenance/on, or by creating the sentinel file, whose contents
+// become the page's message.
+type Maintenance struct {
+	file       string   // Sentinel file, empty for none
+	allow      []string // Route patterns still served
+	retryAfter time.Duration
+
+	mu      sync.Mutex
+	on      bool // Switched on through /_admin/maintenance
+	message string
+	since   time.Time
+
+	checked     time.Time // Last look at the sentinel
+	fileOn      bool
+	fileMessage string
+	fileSince   time.Time
+}
+
+// MaintenanceStatus reports whether maintenance is on and why
+type MaintenanceStatus struct {
+	Enabled bool      `json:"enabled"`
+	Source  string    `json:"source,omitempty"` // "admin" or "file"
+	Message string    `json:"message,omitempty"`
+	Since   time.Time `json:"since,omitzero"`
+}
+
+// NewMaintenance creates the switch; file is the sentinel, allow lists
+// route patterns such as "/admin/*" still served, and retryAfter is sent to
+// clients when set
+func NewMaintenance(file string, allow []string, retryAfter time.Duration) *Maintenance {
+	return &Maintenance{file: file, allow: allow, retryAfter: retryAfter}
+}
+
+// Set switches maintenance on with message, or off. The sentinel file
+// keeps it on until removed.
+func (m *Maintenance) Set(on bool, message string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if on && !m.on {
+		m.since = time.Now()
+	}
+	m.on = on
+	m.message = message
+	if !on {
+		m.message = ""
+		m.since = time.Time{}
+	}
+}
+
+// Status reports the current state, looking at the sentinel file at most
+// once a second
+func (m *Maintenance) Status() MaintenanceStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.on {
+		return MaintenanceStatus{Enabled: true, Source: "admin", Message: m.message, Since: m.since}
+	}
+	if m.file == "" {
+		return MaintenanceStatus{}
+	}
+	if now := time.Now(); now.Sub(m.checked) >= time.Second {
+		m.checked = now
+		m.checkFile()
+	}
+	if m.fileOn {
+		return MaintenanceStatus{Enabled: true, Source: "file", Message: m.fileMessage, Since: m.fileSince}
+	}
+	return MaintenanceStatus{}
+}
+
+// checkFile reads the sentinel, logging when it appears or goes away
+func (m *Maintenance) checkFile() {
+	data, err := os.ReadFile(m.file)
+	on := err == nil
+	if on != m.fileOn {
+		if on {
+			log.Printf("Maintenance mode on, %s exists", m.file)
+			m.fileSince = time.Now()
+		} else {
+			log.Printf("Maintenance mode off, %s was removed", m.file)
+			m.fileSince = time.Time{}
+		}
+	}
+	m.fileOn = on
+	m.fileMessage = strings.TrimSpace(string(data))
+}
+
+// open reports whether path is served during maintenance
+func (m *Maintenance) open(path string) bool {
+	for _, pattern := range maintenanceOpen {
+		if routebuilder.MatchRoutePattern(pattern, path) {
+			return true
+		}
+	}
+	for _, pattern := range m.allow {
+		if routebuilder.MatchRoutePattern(pattern, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkMaintenance answers requests with the maintenance page while
+// maintenance is on, reporting whether the request may go on
+func (s *Server) checkMaintenance(w http.ResponseWriter, r *http.Request) bool {
+	if s.maintenance == nil || s.maintenance.open(r.URL.Path) {
+		return true
+	}
+	status := s.maintenance.Status()
+	if !status.Enabled {
+		return true
+	}
+
+	page := routebuilder.MaintenancePage{Path: r.URL.Path, Message: status.Message, Since: status.Since}
+	if retry := s.maintenance.retryAfter; retry > 0 {
+		page.RetryAfter = int(retry.Seconds())
+		w.Header().Set("Retry-After", strconv.Itoa(page.RetryAfter))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	routebuilder.WriteFragment(w, http.StatusServiceUnavailable, routebuilder.RenderMaintenancePage(page))
+	return false
+}
+
+// handleMaintenance reports maintenance mode on GET /_admin/maintenance, and
+// switches it with POST /_admin/maintenance/on, taking an optional message
+// form value, and /_admin/maintenance/off. It runs behind adminOnly.
+func (s *Server) handleMaintenance(w http.ResponseWriter, r *http.Request) {
+	action := strings.TrimPrefix(r.URL.Path, "/_admin/maintenance")
+	switch action {
+	case "", "/":
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "GET the status, or POST to /_admin/maintenance/on or /_admin/maintenance/off", http.StatusMethodNotAllowed)
+			return
+		}
+	case "/on", "/off":
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "POST to "+r.URL.Path, http.StatusMethodNotAllowed)
+			return
+		}
+		if action == "/on" {
+			message := r.FormValue("message")
+			s.maintenance.Set(true, message)
+			log.Printf("Maintenance mode on, switched by %s", r.RemoteAddr)
+		} else {
+			s.maintenance.Set(false, "")
+			log.Printf("Maintenance mode off, switched by %s", r.RemoteAddr)
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.maintenance.Status()); err != nil {
+		log.Printf("ERROR: Failed to encode maintenance status: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"htmlnojs/routebuilder"
+)
+
+// anyMethod is what routes that accept every method allow
+var anyMethod = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// allowedMethods lists what a route serving methods answers, including the
+// HEAD and OPTIONS it gets for free. No methods means any.
+func allowedMethods(methods ...string) []string {
+	if len(methods) == 0 {
+		methods = anyMethod
+	}
+	allowed := slices.Clone(methods)
+	if slices.Contains(allowed, http.MethodGet) && !slices.Contains(allowed, http.MethodHead) {
+		allowed = append(allowed, http.MethodHead)
+	}
+	return append(allowed, http.MethodOptions)
+}
+
+// routeMethods answers OPTIONS for route from the methods it serves,
+// with the CORS headers of a preflight when CORS is enabled, serves HEAD as
+// a GET whose body is dropped and refuses other methods with a 405. It runs
+// before authentication, since browsers send preflights without credentials.
+func (s *Server) routeMethods(route string, methods []string, next http.HandlerFunc) http.HandlerFunc {
+	allowed := allowedMethods(methods...)
+	allow := strings.Join(allowed, ", ")
+	convertHead := slices.Contains(methods, http.MethodGet) && !slices.Contains(methods, http.MethodHead)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if route == "/" && r.URL.Path != "/" {
+			// The root pattern catches every unknown path too, which isn't
+			// the index route's to answer for
+			next(w, r)
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodOptions:
+			w.Header().Set("Allow", allow)
+			if s.config.EnableCORS && r.Header.Get("Origin") != "" {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+				w.Header().Set("Access-Control-Allow-Methods", allow)
+				headers := r.Header.Get("Access-Control-Request-Headers")
+				if headers == "" {
+					headers = "Content-Type, Authorization"
+				}
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+			}
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodHead && convertHead:
+			// Handlers and backends only know the GET; the server drops the
+			// body it writes since the client asked with HEAD
+			get := r.Clone(r.Context())
+			get.Method = http.MethodGet
+			next(w, get)
+		case len(methods) > 0 && !slices.Contains(allowed, r.Method):
+			// Don't send the backend a request it can only refuse
+			w.Header().Set("Allow", allow)
+			routebuilder.WriteFragment(w, http.StatusMethodNotAllowed, routebuilder.ErrorFragment(
+				"Method Not Allowed",
+				fmt.Sprintf("%s only answers %s, not %s.", route, allow, r.Method),
+				"",
+			))
+		default:
+			next(w, r)
+		}
+	}
+}
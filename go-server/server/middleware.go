@@ -13,7 +13,7 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 		start := time.Now()
 
 		// Create a response writer wrapper to capture status code
-		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		wrapped := &responseWriter{wrappedWriter: wrappedWriter{w}, statusCode: http.StatusOK}
 
 		next.ServeHTTP(wrapped, r)
 
@@ -33,6 +33,11 @@ func RecoveryMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
+				// A stream cut off after its headers were sent, e.g. a proxied
+				// download; net/http closes the connection
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
 				log.Printf("Panic recovered: %v\n%s", err, debug.Stack())
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			}
@@ -92,7 +97,7 @@ func HTMXMiddleware(next http.Handler) http.Handler {
 
 // responseWriter wraps http.ResponseWriter to capture status code
 type responseWriter struct {
-	http.ResponseWriter
+	wrappedWriter
 	statusCode int
 }
 
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"htmlnojs/routebuilder"
+	"htmlnojs/store"
+)
+
+// nonceSessionKey holds the key a session's nonces are bound to among its
+// values
+const nonceSessionKey = "nonce"
+
+// defaultNonceLifetime is how long unused nonces stay valid unless
+// nonces.lifetime says otherwise
+const defaultNonceLifetime = time.Hour
+
+func (s *Server) nonceLifetime() time.Duration {
+	if s.config.NonceLifetime > 0 {
+		return s.config.NonceLifetime
+	}
+	return defaultNonceLifetime
+}
+
+// sessionNonceKey returns the key binding nonces to the request's session,
+// creating one the first time; requests without a session get ""
+func sessionNonceKey(r *http.Request) string {
+	session := SessionFrom(r.Context())
+	if session == nil {
+		return ""
+	}
+	key := session.Get(nonceSessionKey)
+	if key == "" {
+		key = randomNonce()
+		session.Set(nonceSessionKey, key)
+	}
+	return key
+}
+
+func randomNonce() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// provideNonces lets the page being rendered issue nonces, each good for
+// one request to the handler it names from the same session
+func (s *Server) provideNonces(next http.HandlerFunc) http.HandlerFunc {
+	nonces := store.WithPrefix(s.store, "nonce:")
+	lifetime := s.nonceLifetime()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		issue := func(action string) (string, error) {
+			nonce := randomNonce()
+			if err := nonces.Set(r.Context(), nonce, []byte(action+"\n"+sessionNonceKey(r)), lifetime); err != nil {
+				log.Printf("ERROR: Failed to store nonce for %s: %v", action, err)
+				return "", errors.New("failed to issue nonce")
+			}
+			return nonce, nil
+		}
+		next(w, r.WithContext(routebuilder.WithNonceIssuer(r.Context(), issue)))
+	}
+}
+
+// checkNonce refuses requests to a handler marked @nonce unless they carry
+// a nonce issued for it to the same session, in the X-HTMLnoJS-Nonce header
+// or the htmlnojs_nonce field of a urlencoded form. Each nonce is taken
+// once: a replayed request gets a 409 without reaching the handler.
+func (s *Server) checkNonce(action string, next http.HandlerFunc) http.HandlerFunc {
+	nonces := store.WithPrefix(s.store, "nonce:")
+	lifetime := s.nonceLifetime()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		nonce := r.Header.Get(routebuilder.NonceHeader)
+		if nonce == "" {
+			nonce = peekFormField(r, routebuilder.NonceField)
+		}
+		if nonce == "" {
+			refuseNonce(w, http.StatusForbidden, "This action needs a page that offers it. Reload the page and try again.")
+			return
+		}
+
+		ctx := r.Context()
+		issued, err := nonces.Get(ctx, nonce)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			log.Printf("ERROR: Failed to look up nonce for %s: %v", action, err)
+			http.Error(w, "failed to check nonce", http.StatusInternalServerError)
+			return
+		}
+		issuedFor, binding, _ := strings.Cut(string(issued), "\n")
+		if err != nil || issuedFor != action || !secretsEqual(binding, sessionNonceKey(r)) {
+			// Taken nonces are gone from the store, so a replay lands here
+			// unless it raced the first request
+			if used, _ := nonces.TTL(ctx, "used:"+nonce); used > 0 {
+				s.refuseReplay(w, r)
+				return
+			}
+			refuseNonce(w, http.StatusForbidden, "This page is out of date. Reload it and try again.")
+			return
+		}
+
+		taken, err := nonces.Incr(ctx, "used:"+nonce, 1, lifetime)
+		if err != nil {
+			log.Printf("ERROR: Failed to take nonce for %s: %v", action, err)
+			http.Error(w, "failed to check nonce", http.StatusInternalServerError)
+			return
+		}
+		if taken > 1 {
+			s.refuseReplay(w, r)
+			return
+		}
+		if err := nonces.Delete(ctx, nonce); err != nil {
+			log.Printf("WARNING: Failed to delete used nonce for %s: %v", action, err)
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) refuseReplay(w http.ResponseWriter, r *http.Request) {
+	log.Printf("WARNING: Refused replayed %s %s from %s, its nonce was already used", r.Method, r.URL.Path, r.RemoteAddr)
+	refuseNonce(w, http.StatusConflict, "This was already done. Reload the page to do it again.")
+}
+
+func refuseNonce(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Cache-Control", "no-store")
+	routebuilder.WriteFragment(w, status, routebuilder.ErrorFragment("Action Expired", message, ""))
+}
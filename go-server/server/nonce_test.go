@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"htmlnojs/routebuilder"
+)
+
+var renderedNonce = regexp.MustCompile(`<i>([^<]*)</i>`)
+
+func TestCheckNonce(t *testing.T) {
+	page := filepath.Join(t.TempDir(), "orders.html")
+	os.WriteFile(page, []byte(`<html><body><i>{{.Nonce "/api/orders/delete"}}</i><i>{{.Nonce "/api/orders/archive"}}</i></body></html>`), 0o644)
+	pages, err := routebuilder.NewHTMLRouteBuilder(filepath.Dir(page), nil).BuildRoutes([]string{page})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted := 0
+	s := NewBuilder().
+		WithRoutes(&routebuilder.RouteCollection{
+			HTMLRoutes: pages,
+			PythonRoutes: []routebuilder.PythonRoute{
+				{Name: "delete", Route: "/api/orders/delete", Method: "POST", Nonce: true, Handler: func(w http.ResponseWriter, r *http.Request) {
+					deleted++
+					w.WriteHeader(http.StatusOK)
+				}},
+			},
+		}).
+		Build()
+
+	cookies := map[string]*http.Cookie{} // Session cookies by client
+	send := func(client string, r *http.Request) *httptest.ResponseRecorder {
+		if cookie := cookies[client]; cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		for _, cookie := range w.Result().Cookies() {
+			cookies[client] = cookie
+		}
+		return w
+	}
+	// issue renders the page for client and returns its delete and archive
+	// nonces
+	issue := func(client string) (string, string) {
+		w := send(client, httptest.NewRequest("GET", "/orders", nil))
+		found := renderedNonce.FindAllStringSubmatch(w.Body.String(), -1)
+		if w.Code != http.StatusOK || len(found) != 2 || found[0][1] == "" {
+			t.Fatalf("page: got %d %q", w.Code, w.Body.String())
+		}
+		return found[0][1], found[1][1]
+	}
+	deleteNonce, archiveNonce := issue("ann")
+	formNonce, _ := issue("ann")
+	bobNonce, _ := issue("bob")
+
+	tests := []struct {
+		name    string
+		client  string
+		nonce   string
+		form    bool // Send it in the form field rather than the header
+		status  int
+		deleted int
+	}{
+		{"no nonce", "ann", "", false, http.StatusForbidden, 0},
+		{"unknown nonce", "ann", "not-issued", false, http.StatusForbidden, 0},
+		{"issued for another handler", "ann", archiveNonce, false, http.StatusForbidden, 0},
+		{"issued to another session", "ann", bobNonce, false, http.StatusForbidden, 0},
+		{"issued to this session", "ann", deleteNonce, false, http.StatusOK, 1},
+		{"replayed", "ann", deleteNonce, false, http.StatusConflict, 1},
+		{"in the form", "ann", formNonce, true, http.StatusOK, 2},
+		{"replayed in the form", "ann", formNonce, true, http.StatusConflict, 2},
+		{"the other session's own", "bob", bobNonce, false, http.StatusOK, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"id": {"7"}}
+			if tt.form {
+				form.Set(routebuilder.NonceField, tt.nonce)
+			}
+			r := httptest.NewRequest("POST", "/api/orders/delete", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if !tt.form && tt.nonce != "" {
+				r.Header.Set(routebuilder.NonceHeader, tt.nonce)
+			}
+			w := send(tt.client, r)
+			if w.Code != tt.status {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if deleted != tt.deleted {
+				t.Errorf("handler ran %d times, want %d", deleted, tt.deleted)
+			}
+		})
+	}
+}
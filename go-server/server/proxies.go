@@ -0,0 +1,184 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// TrustedProxies are the reverse proxies in front of the server. Requests
+// they pass on are taken to come from the client address they put in
+// Forwarded or X-Forwarded-For, for rate limits, IP access, audit entries
+// and the localhost checks, over the scheme in Forwarded's proto or
+// X-Forwarded-Proto, for secure cookies.
+type TrustedProxies struct {
+	prefixes []netip.Prefix
+	unix     bool // Connections on unix: listeners, which have no address
+}
+
+// ParseTrustedProxies reads CIDR ranges such as "10.0.0.0/8", single
+// addresses, or "unix" for the connections on unix: listeners
+func ParseTrustedProxies(addresses []string) (*TrustedProxies, error) {
+	proxies := &TrustedProxies{}
+	var ranges []string
+	for _, address := range addresses {
+		if strings.TrimSpace(address) == "unix" {
+			proxies.unix = true
+			continue
+		}
+		ranges = append(ranges, address)
+	}
+	var err error
+	if proxies.prefixes, err = parsePrefixes(ranges); err != nil {
+		return nil, err
+	}
+	return proxies, nil
+}
+
+// trusts reports whether a connection's address, an IP or "" for a unix
+// socket, is one of the proxies
+func (p *TrustedProxies) trusts(address string) bool {
+	if address == "" || address == "@" {
+		return p.unix
+	}
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// client returns the address of the client behind a request a trusted
+// proxy sent, the last address in the forwarding chain that isn't a
+// proxy, since those before it could have been made up by the client, and
+// whether the client used HTTPS to reach the first proxy
+func (p *TrustedProxies) client(r *http.Request, peer string) (string, bool) {
+	chain := forwardedFor(r.Header)
+	if len(chain) == 0 {
+		// A proxy that only says which scheme it was reached over
+		protos := headerList(r.Header, "X-Forwarded-Proto")
+		return peer, len(protos) > 0 && strings.EqualFold(protos[len(protos)-1], "https")
+	}
+	client := len(chain) - 1
+	for client > 0 && p.trusts(chain[client].address) {
+		client--
+	}
+	return chain[client].address, strings.EqualFold(chain[client].proto, "https")
+}
+
+// forwardedHop is what a proxy recorded about the connection it received
+type forwardedHop struct {
+	address string
+	proto   string // "http", "https" or "" when not given
+}
+
+// forwardedFor lists the hops in Forwarded's "for" and "proto" parameters,
+// or in X-Forwarded-For and X-Forwarded-Proto without it, in the order
+// the proxies added them. A single X-Forwarded-Proto, which proxies set
+// rather than add to, goes with the client's hop.
+func forwardedFor(header http.Header) []forwardedHop {
+	var chain []forwardedHop
+	if values := header.Values("Forwarded"); len(values) > 0 {
+		for _, value := range values {
+			for _, element := range strings.Split(value, ",") {
+				var hop forwardedHop
+				for _, pair := range strings.Split(element, ";") {
+					name, field, ok := strings.Cut(strings.TrimSpace(pair), "=")
+					field = strings.Trim(field, `"`)
+					switch {
+					case ok && strings.EqualFold(name, "for"):
+						hop.address = forwardedHost(field)
+					case ok && strings.EqualFold(name, "proto"):
+						hop.proto = field
+					}
+				}
+				if hop.address != "" {
+					chain = append(chain, hop)
+				}
+			}
+		}
+		return chain
+	}
+	protos := headerList(header, "X-Forwarded-Proto")
+	for i, address := range headerList(header, "X-Forwarded-For") {
+		hop := forwardedHop{address: forwardedHost(address)}
+		if len(protos) > i {
+			hop.proto = protos[i]
+		}
+		chain = append(chain, hop)
+	}
+	if len(protos) == 1 {
+		for i := range chain {
+			chain[i].proto = protos[0]
+		}
+	}
+	return chain
+}
+
+// headerList splits the comma-separated values of a header
+func headerList(header http.Header, name string) []string {
+	var list []string
+	for _, value := range header.Values(name) {
+		for _, item := range strings.Split(value, ",") {
+			list = append(list, strings.TrimSpace(item))
+		}
+	}
+	return list
+}
+
+// forwardedHost strips the port and IPv6 brackets from a forwarded address
+func forwardedHost(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return strings.Trim(address, "[]")
+}
+
+type (
+	clientAddressKey  struct{}
+	forwardedHTTPSKey struct{}
+)
+
+// resolveClient records the client address of a request that came through
+// a trusted proxy, where clientAddress finds it, and whether the client
+// used HTTPS, for isHTTPS
+func (s *Server) resolveClient(r *http.Request) *http.Request {
+	if s.trustedProxies == nil {
+		return r
+	}
+	peer := peerAddress(r)
+	if !s.trustedProxies.trusts(peer) {
+		return r
+	}
+	client, https := s.trustedProxies.client(r, peer)
+	ctx := context.WithValue(r.Context(), clientAddressKey{}, client)
+	if https {
+		ctx = context.WithValue(ctx, forwardedHTTPSKey{}, true)
+	}
+	return r.WithContext(ctx)
+}
+
+// isHTTPS reports whether the client sent r over HTTPS, to this server or
+// to a trusted proxy in front of it
+func isHTTPS(r *http.Request) bool {
+	https, _ := r.Context().Value(forwardedHTTPSKey{}).(bool)
+	return r.TLS != nil || https
+}
+
+// peerAddress returns the address of the connection a request came on,
+// without its port; it is empty, or "@", for unix sockets
+func peerAddress(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxiesClientAddress(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "127.0.0.1", "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		proxies  *TrustedProxies
+		peer     string
+		header   map[string]string
+		client   string
+		loopback bool
+	}{
+		{"no proxies", nil, "10.0.0.2:4000", map[string]string{"X-Forwarded-For": "203.0.113.9"}, "10.0.0.2", false},
+		{"direct client", proxies, "203.0.113.9:4000", nil, "203.0.113.9", false},
+		{"trusted proxy", proxies, "10.0.0.2:4000", map[string]string{"X-Forwarded-For": "203.0.113.9"}, "203.0.113.9", false},
+		{"untrusted peer", proxies, "198.51.100.7:4000", map[string]string{"X-Forwarded-For": "203.0.113.9"}, "198.51.100.7", false},
+		{"spoofed loopback", proxies, "198.51.100.7:4000", map[string]string{"X-Forwarded-For": "127.0.0.1"}, "198.51.100.7", false},
+		{"spoofed entry before the client", proxies, "10.0.0.2:4000", map[string]string{"X-Forwarded-For": "127.0.0.1, 203.0.113.9"}, "203.0.113.9", false},
+		{"proxy chain", proxies, "10.0.0.2:4000", map[string]string{"X-Forwarded-For": "203.0.113.9, 10.0.0.3"}, "203.0.113.9", false},
+		{"every hop a proxy", proxies, "10.0.0.2:4000", map[string]string{"X-Forwarded-For": "10.0.0.4, 10.0.0.3"}, "10.0.0.4", false},
+		{"proxy without X-Forwarded-For", proxies, "10.0.0.2:4000", nil, "10.0.0.2", false},
+		{"Forwarded", proxies, "10.0.0.2:4000", map[string]string{"Forwarded": `for="[2001:db8::1]:4711";proto=https`, "X-Forwarded-For": "198.51.100.7"}, "2001:db8::1", false},
+		{"Forwarded loopback", proxies, "127.0.0.1:4000", map[string]string{"Forwarded": "for=127.0.0.1"}, "127.0.0.1", true},
+		{"unix socket", proxies, "@", map[string]string{"X-Forwarded-For": "203.0.113.9"}, "203.0.113.9", false},
+		{"unix socket not trusted", &TrustedProxies{}, "@", map[string]string{"X-Forwarded-For": "127.0.0.1"}, "@", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{trustedProxies: tt.proxies}
+			r := httptest.NewRequest("GET", "/status", nil)
+			r.RemoteAddr = tt.peer
+			for name, value := range tt.header {
+				r.Header.Set(name, value)
+			}
+			r = s.resolveClient(r)
+			if client := clientAddress(r); client != tt.client {
+				t.Errorf("clientAddress() = %q, want %q", client, tt.client)
+			}
+			if loopback := isLoopback(r); loopback != tt.loopback {
+				t.Errorf("isLoopback() = %v, want %v", loopback, tt.loopback)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"htmlnojs/routebuilder"
+	"htmlnojs/store"
+)
+
+// rateLimitSweep is how often buckets that have refilled are dropped
+const rateLimitSweep = time.Minute
+
+// limitBuckets hold the tokens of each client for each route. Each bucket
+// holds limit tokens and refills at limit a minute.
+type limitBuckets interface {
+	// take spends a token from key's bucket; when it's empty, wait is how
+	// long until the next token
+	take(ctx context.Context, key string, limit int, now time.Time) (remaining int, wait time.Duration, ok bool)
+	// refund gives back a token take spent
+	refund(ctx context.Context, key string, now time.Time)
+}
+
+// rateLimiter enforces @rate_limit(n), n requests a minute, with a bucket
+// per client address and another per session, so neither a shared address
+// nor a client hopping addresses gets around it
+type rateLimiter struct {
+	buckets limitBuckets
+	mu      sync.Mutex
+	counts  map[string]*rateLimitCount // By route
+}
+
+// rateLimitCount counts one route's requests by outcome
+type rateLimitCount struct {
+	Allowed   int64
+	ByAddress int64 // Refused because the client address ran out
+	BySession int64 // Refused because the session ran out
+}
+
+// newRateLimiter keeps buckets in process memory, or in st when it's
+// shared so every server behind a load balancer counts together
+func newRateLimiter(st store.Store) *rateLimiter {
+	rl := &rateLimiter{counts: make(map[string]*rateLimitCount)}
+	if st != nil && store.Shared(st) {
+		rl.buckets = &storeBuckets{store: store.WithPrefix(st, "ratelimit:route:")}
+	} else {
+		rl.buckets = &memoryBuckets{buckets: make(map[string]*tokenBucket)}
+	}
+	return rl
+}
+
+// allow checks a request against the address and session buckets of its
+// route, spending from both only when both have a token
+func (rl *rateLimiter) allow(ctx context.Context, route, address, session string, limit int) (remaining int, wait time.Duration, by string) {
+	now := time.Now()
+	addressKey := route + "|ip|" + address
+	remaining, wait, ok := rl.buckets.take(ctx, addressKey, limit, now)
+	if !ok {
+		rl.count(route, "address")
+		return 0, wait, "address"
+	}
+	if session != "" {
+		sessionRemaining, sessionWait, ok := rl.buckets.take(ctx, route+"|session|"+session, limit, now)
+		if !ok {
+			// The address keeps the token it would have spent
+			rl.buckets.refund(ctx, addressKey, now)
+			rl.count(route, "session")
+			return 0, sessionWait, "session"
+		}
+		remaining = min(remaining, sessionRemaining)
+	}
+	rl.count(route, "")
+	return remaining, 0, ""
+}
+
+func (rl *rateLimiter) count(route, by string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	count, ok := rl.counts[route]
+	if !ok {
+		count = &rateLimitCount{}
+		rl.counts[route] = count
+	}
+	switch by {
+	case "address":
+		count.ByAddress++
+	case "session":
+		count.BySession++
+	default:
+		count.Allowed++
+	}
+}
+
+func (rl *rateLimiter) snapshot() map[string]rateLimitCount {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	out := make(map[string]rateLimitCount, len(rl.counts))
+	for route, count := range rl.counts {
+		out[route] = *count
+	}
+	return out
+}
+
+// tokenBucket holds one client's tokens for one route
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// memoryBuckets are exact token buckets kept in process memory
+type memoryBuckets struct {
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func (m *memoryBuckets) take(_ context.Context, key string, limit int, now time.Time) (int, time.Duration, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweep(now)
+
+	rate := float64(limit) / float64(time.Minute)
+	bucket, found := m.buckets[key]
+	if !found {
+		bucket = &tokenBucket{tokens: float64(limit), last: now}
+		m.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(float64(limit), bucket.tokens+float64(now.Sub(bucket.last))*rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return 0, time.Duration((1 - bucket.tokens) / rate), false
+	}
+	bucket.tokens--
+	return int(bucket.tokens), 0, true
+}
+
+func (m *memoryBuckets) refund(_ context.Context, key string, _ time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if bucket, ok := m.buckets[key]; ok {
+		bucket.tokens++
+	}
+}
+
+// sweep drops buckets that have had time to refill, which are the same as
+// no bucket. The longest any bucket takes is a minute.
+func (m *memoryBuckets) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < rateLimitSweep {
+		return
+	}
+	m.lastSweep = now
+	for key, bucket := range m.buckets {
+		if now.Sub(bucket.last) >= time.Minute {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+// storeBuckets approximate token buckets with a sliding window over
+// per-minute counters in a shared store, which only offers atomic Incr:
+// the previous minute's count weighs in by how much of it is still inside
+// the window
+type storeBuckets struct {
+	store store.Store
+}
+
+func (b *storeBuckets) take(ctx context.Context, key string, limit int, now time.Time) (int, time.Duration, bool) {
+	window := now.Truncate(time.Minute)
+	current := key + ":" + strconv.FormatInt(window.Unix(), 10)
+	count, err := b.store.Incr(ctx, current, 1, 2*time.Minute)
+	if err != nil {
+		// A store outage shouldn't lock every client out
+		log.Printf("WARNING: Rate limit not checked, the store failed: %v", err)
+		return limit, 0, true
+	}
+	var previous int64
+	if data, err := b.store.Get(ctx, key+":"+strconv.FormatInt(window.Add(-time.Minute).Unix(), 10)); err == nil {
+		previous, _ = strconv.ParseInt(string(data), 10, 64)
+	}
+
+	elapsed := float64(now.Sub(window)) / float64(time.Minute)
+	used := float64(previous)*(1-elapsed) + float64(count)
+	if used <= float64(limit) {
+		return int(float64(limit) - used), 0, true
+	}
+
+	// Refused requests don't count against the client
+	b.store.Incr(ctx, current, -1, 2*time.Minute)
+	count--
+	wait := window.Add(time.Minute).Sub(now)
+	if previous > 0 && count < int64(limit) {
+		// The previous minute's weight drops enough before this one ends
+		free := 1 - float64(int64(limit)-count-1)/float64(previous)
+		wait = time.Duration((free - elapsed) * float64(time.Minute))
+	}
+	return 0, max(wait, time.Second), false
+}
+
+func (b *storeBuckets) refund(ctx context.Context, key string, now time.Time) {
+	b.store.Incr(ctx, key+":"+strconv.FormatInt(now.Truncate(time.Minute).Unix(), 10), -1, 2*time.Minute)
+}
+
+// rateLimitMiddleware enforces a route's @rate_limit, answering clients
+// that go over with a 429 and Retry-After
+func (s *Server) rateLimitMiddleware(next http.HandlerFunc, limit int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var session string
+		if sess := SessionFrom(r.Context()); sess != nil && sess.persisted() {
+			session = sess.ID()
+		}
+		remaining, wait, by := s.limiter.allow(r.Context(), r.URL.Path, clientAddress(r), session, limit)
+
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		if by != "" {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			routebuilder.WriteFragment(w, http.StatusTooManyRequests, routebuilder.ErrorFragment(
+				"Too Many Requests",
+				fmt.Sprintf("Slow down, try again in %d seconds", retryAfter),
+				"",
+			))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// clientAddress is the IP address a request came from, the one a trusted
+// proxy forwarded when it came through one
+func clientAddress(r *http.Request) string {
+	if address, ok := r.Context().Value(clientAddressKey{}).(string); ok {
+		return address
+	}
+	return peerAddress(r)
+}
+
+// writeRateLimitMetrics appends per-route rate limit counts to the
+// /_metrics output
+func (s *Server) writeRateLimitMetrics(w io.Writer) {
+	counts := s.limiter.snapshot()
+	routes := make([]string, 0, len(counts))
+	for route := range counts {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		count := counts[route]
+		fmt.Fprintf(w, "rate_limit_allowed_total{route=%q} %d\n", route, count.Allowed)
+		fmt.Fprintf(w, "rate_limit_rejected_total{route=%q,by=\"address\"} %d\n", route, count.ByAddress)
+		fmt.Fprintf(w, "rate_limit_rejected_total{route=%q,by=\"session\"} %d\n", route, count.BySession)
+	}
+}
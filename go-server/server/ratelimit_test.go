@@ -0,0 +1,84 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	type request struct {
+		path    string
+		address string
+		session string // Persisted session ID, if any
+		status  int
+	}
+	tests := []struct {
+		name     string
+		requests []request
+	}{
+		{"under the limit", []request{
+			{"/api/search", "203.0.113.1", "", http.StatusOK},
+			{"/api/search", "203.0.113.1", "", http.StatusOK},
+		}},
+		{"over the limit", []request{
+			{"/api/search", "203.0.113.1", "", http.StatusOK},
+			{"/api/search", "203.0.113.1", "", http.StatusOK},
+			{"/api/search", "203.0.113.1", "", http.StatusTooManyRequests},
+		}},
+		{"each address has its own bucket", []request{
+			{"/api/search", "203.0.113.1", "", http.StatusOK},
+			{"/api/search", "203.0.113.1", "", http.StatusOK},
+			{"/api/search", "203.0.113.2", "", http.StatusOK},
+		}},
+		{"each route has its own bucket", []request{
+			{"/api/search", "203.0.113.1", "", http.StatusOK},
+			{"/api/search", "203.0.113.1", "", http.StatusOK},
+			{"/api/export", "203.0.113.1", "", http.StatusOK},
+		}},
+		{"hopping addresses keeps the session's bucket", []request{
+			{"/api/search", "203.0.113.1", "s1", http.StatusOK},
+			{"/api/search", "203.0.113.2", "s1", http.StatusOK},
+			{"/api/search", "203.0.113.3", "s1", http.StatusTooManyRequests},
+		}},
+		{"a refused session doesn't spend the address's token", []request{
+			{"/api/search", "203.0.113.1", "s1", http.StatusOK},
+			{"/api/search", "203.0.113.2", "s1", http.StatusOK},
+			{"/api/search", "203.0.113.2", "s1", http.StatusTooManyRequests},
+			{"/api/search", "203.0.113.2", "s2", http.StatusOK},
+		}},
+		{"sessions share their address's bucket", []request{
+			{"/api/search", "203.0.113.1", "s1", http.StatusOK},
+			{"/api/search", "203.0.113.1", "s2", http.StatusOK},
+			{"/api/search", "203.0.113.1", "s3", http.StatusTooManyRequests},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{limiter: newRateLimiter(nil)}
+			handler := s.rateLimitMiddleware(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}, 2)
+			for i, req := range tt.requests {
+				r := httptest.NewRequest(http.MethodGet, req.path, nil)
+				ctx := context.WithValue(r.Context(), clientAddressKey{}, req.address)
+				if req.session != "" {
+					ctx = context.WithValue(ctx, sessionKey{}, &Session{id: req.session, values: map[string]string{}, loaded: true})
+				}
+				w := httptest.NewRecorder()
+				handler(w, r.WithContext(ctx))
+				if w.Code != req.status {
+					t.Fatalf("request %d: status = %d, want %d", i, w.Code, req.status)
+				}
+				if got := w.Header().Get("X-RateLimit-Limit"); got != "2" {
+					t.Errorf("request %d: X-RateLimit-Limit = %q, want %q", i, got, "2")
+				}
+				retryAfter := w.Header().Get("Retry-After")
+				if refused := req.status == http.StatusTooManyRequests; refused != (retryAfter != "") {
+					t.Errorf("request %d: Retry-After = %q on a %d", i, retryAfter, w.Code)
+				}
+			}
+		})
+	}
+}
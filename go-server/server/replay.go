@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// replayTimeout bounds a replayed request
+const replayTimeout = 60 * time.Second
+
+// handleReplay lists the failed proxied requests at GET /_admin/replay,
+// shows one at GET /_admin/replay/<id> and sends it to its backend again
+// with POST /_admin/replay/<id>. Requests carry their credentials, so it
+// runs behind adminOnly.
+func (s *Server) handleReplay(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/_admin/replay"), "/")
+	if id == "" {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "GET the failed requests", http.StatusMethodNotAllowed)
+			return
+		}
+		s.writeReplayJSON(w, s.failures.List())
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		failed := s.failures.Get(id)
+		if failed == nil {
+			http.Error(w, "no failed request "+id+" is kept", http.StatusNotFound)
+			return
+		}
+		s.writeReplayJSON(w, failed)
+	case http.MethodPost:
+		if s.failures.Get(id) == nil {
+			http.Error(w, "no failed request "+id+" is kept", http.StatusNotFound)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), replayTimeout)
+		defer cancel()
+		result, err := s.failures.Replay(ctx, id)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		log.Printf("Replayed failed request %s: status %d %s", id, result.Status, result.Error)
+		s.writeReplayJSON(w, result)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "GET the request or POST to replay it", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) writeReplayJSON(w http.ResponseWriter, v any) {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		log.Printf("ERROR: Failed to encode replay: %v", err)
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"htmlnojs/routebuilder"
+)
+
+// sessionRolesKey holds the roles of a session's user among its values
+const sessionRolesKey = "roles"
+
+// HasRole reports whether the identity was given role
+func (id *Identity) HasRole(role string) bool {
+	return id != nil && slices.Contains(id.Roles, role)
+}
+
+// Roles returns the roles of the user signed in to the session
+func (s *Session) Roles() []string {
+	if roles := s.Get(sessionRolesKey); roles != "" {
+		return strings.Split(roles, ",")
+	}
+	return nil
+}
+
+// SetRoles gives the user signed in to the session roles, checked by
+// @roles and *_admin templates; signing out with SetUser("") drops them
+func (s *Session) SetRoles(roles ...string) {
+	if len(roles) == 0 {
+		s.Delete(sessionRolesKey)
+		return
+	}
+	s.Set(sessionRolesKey, strings.Join(roles, ","))
+}
+
+// rolesOf returns id's own roles along with those the config gives its
+// subject
+func (s *Server) rolesOf(id *Identity) []string {
+	roles := slices.Clone(id.Roles)
+	for _, role := range s.roles[id.Subject] {
+		if !slices.Contains(roles, role) {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// requireRoles serves next to identities with any of roles and answers
+// the rest with a 403. It runs inside authMiddleware, so there is always
+// an identity. Signed URLs were signed for a page the signer could see, so
+// they need no role.
+func (s *Server) requireRoles(roles []string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := IdentityFrom(r.Context())
+		if id != nil && id.Method == "signed_url" {
+			next(w, r)
+			return
+		}
+		if id != nil {
+			have := s.rolesOf(id)
+			for _, role := range roles {
+				if slices.Contains(have, role) {
+					next(w, r)
+					return
+				}
+			}
+		}
+		page := routebuilder.ForbiddenPage{Path: r.URL.Path, Roles: roles}
+		if id != nil {
+			page.User = id.Subject
+		}
+		routebuilder.WriteFragment(w, http.StatusForbidden, routebuilder.RenderForbiddenPage(page))
+	}
+}
+
+// claimRoles reads a JWT's roles claim, a list or a space separated
+// string such as OAuth's "scope"
+func claimRoles(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		var roles []string
+		for _, item := range v {
+			if role, ok := item.(string); ok && role != "" {
+				roles = append(roles, role)
+			}
+		}
+		return roles
+	}
+	return nil
+}
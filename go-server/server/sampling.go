@@ -0,0 +1,293 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	mathrand "math/rand/v2"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"htmlnojs/routebuilder"
+)
+
+// SamplingConfig controls which requests are captured in full
+type SamplingConfig struct {
+	Rate     float64 // Fraction of requests captured, e.g. 0.001 for 0.1%
+	Errors   bool    // Always capture 5xx responses
+	Capacity int     // Samples kept in memory, oldest are dropped first
+}
+
+// Enabled reports whether any request can be captured
+func (c SamplingConfig) Enabled() bool {
+	return c.Rate > 0 || c.Errors
+}
+
+// sensitiveHeaders are never stored in samples
+var sensitiveHeaders = map[string]bool{
+	"authorization":       true,
+	"proxy-authorization": true,
+	"cookie":              true,
+	"set-cookie":          true,
+	"x-api-key":           true,
+}
+
+// timingPhase is one step of a request's timing breakdown
+type timingPhase struct {
+	Name       string  `json:"name"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// requestTiming collects the timing breakdown of a single request
+type requestTiming struct {
+	mu     sync.Mutex
+	phases []timingPhase
+}
+
+type requestTimingKey struct{}
+
+// withRequestTiming attaches an empty timing breakdown to the request
+func withRequestTiming(r *http.Request) (*http.Request, *requestTiming) {
+	timing := &requestTiming{}
+	return r.WithContext(context.WithValue(r.Context(), requestTimingKey{}, timing)), timing
+}
+
+// addTimingPhase records a phase on the request's breakdown, if any
+func addTimingPhase(r *http.Request, name string, d time.Duration) {
+	timing, ok := r.Context().Value(requestTimingKey{}).(*requestTiming)
+	if !ok {
+		return
+	}
+	timing.mu.Lock()
+	timing.phases = append(timing.phases, timingPhase{Name: name, DurationMs: durationMs(d)})
+	timing.mu.Unlock()
+}
+
+func (t *requestTiming) snapshot() []timingPhase {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]timingPhase(nil), t.phases...)
+}
+
+// requestSample is the full record of a captured request
+type requestSample struct {
+	ID              string              `json:"id"`
+	Reason          string              `json:"reason"`
+	Time            string              `json:"time"`
+	Method          string              `json:"method"`
+	Path            string              `json:"path"`
+	Query           string              `json:"query,omitempty"` // Secret fields masked
+	Route           string              `json:"route"`
+	Status          int                 `json:"status"`
+	DurationMs      float64             `json:"duration_ms"`
+	Timing          []timingPhase       `json:"timing"`
+	RequestHeaders  map[string][]string `json:"request_headers"`
+	ResponseHeaders map[string][]string `json:"response_headers"`
+	BytesIn         int64               `json:"bytes_in"`
+	BytesOut        int64               `json:"bytes_out"`
+	RemoteAddr      string              `json:"remote_addr"`
+}
+
+// sampler keeps a bounded ring of captured requests and the latest
+// exemplar per route
+type sampler struct {
+	config    SamplingConfig
+	mu        sync.Mutex
+	samples   []*requestSample
+	next      int
+	exemplars map[string]*requestSample
+	counts    map[string]int64
+}
+
+func newSampler(config SamplingConfig) *sampler {
+	if config.Capacity <= 0 {
+		config.Capacity = 200
+	}
+	return &sampler{
+		config:    config,
+		samples:   make([]*requestSample, 0, config.Capacity),
+		exemplars: make(map[string]*requestSample),
+		counts:    make(map[string]int64),
+	}
+}
+
+func (sp *sampler) add(sample *requestSample) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	if len(sp.samples) < sp.config.Capacity {
+		sp.samples = append(sp.samples, sample)
+	} else {
+		sp.samples[sp.next] = sample
+		sp.next = (sp.next + 1) % sp.config.Capacity
+	}
+	sp.exemplars[sample.Route] = sample
+	sp.counts[sample.Route]++
+}
+
+// list returns samples newest first
+func (sp *sampler) list() []*requestSample {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	out := make([]*requestSample, 0, len(sp.samples))
+	for i := len(sp.samples) - 1; i >= 0; i-- {
+		out = append(out, sp.samples[(sp.next+i)%len(sp.samples)])
+	}
+	return out
+}
+
+func (sp *sampler) get(id string) *requestSample {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	for _, sample := range sp.samples {
+		if sample.ID == id {
+			return sample
+		}
+	}
+	return nil
+}
+
+// sampleRequests captures a sample of requests, plus every 5xx when
+// configured, with headers and the timing breakdown
+func (s *Server) sampleRequests(route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Decide up front so unsampled requests only pay for the error check
+		sampled := s.sampler.config.Rate > 0 && mathrand.Float64() < s.sampler.config.Rate
+
+		r, timing := withRequestTiming(r)
+		requestHeaders := redactHeaders(r.Header)
+		var body *countingReader
+		if r.Body != nil && r.Body != http.NoBody {
+			body = &countingReader{ReadCloser: r.Body}
+			r.Body = body
+		}
+		cw := &countingWriter{wrappedWriter: wrappedWriter{w}}
+
+		start := time.Now()
+		next(cw, r)
+		duration := time.Since(start)
+
+		status := cw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		reason := ""
+		switch {
+		case status >= 500 && s.sampler.config.Errors:
+			reason = "error"
+		case sampled:
+			reason = "sampled"
+		default:
+			return
+		}
+
+		sample := &requestSample{
+			ID:              newSampleID(),
+			Reason:          reason,
+			Time:            start.UTC().Format(time.RFC3339Nano),
+			Method:          r.Method,
+			Path:            r.URL.Path,
+			Query:           routebuilder.RedactQuery(r.URL.RawQuery),
+			Route:           route,
+			Status:          status,
+			DurationMs:      durationMs(duration),
+			Timing:          timing.snapshot(),
+			RequestHeaders:  requestHeaders,
+			ResponseHeaders: redactHeaders(cw.Header()),
+			BytesOut:        cw.bytes,
+			RemoteAddr:      r.RemoteAddr,
+		}
+		if body != nil {
+			sample.BytesIn = body.bytes
+		}
+		s.sampler.add(sample)
+	}
+}
+
+func redactHeaders(h http.Header) map[string][]string {
+	out := make(map[string][]string, len(h))
+	for key, values := range h {
+		if sensitiveHeaders[strings.ToLower(key)] {
+			out[key] = []string{"[redacted]"}
+			continue
+		}
+		out[key] = append([]string(nil), values...)
+	}
+	return out
+}
+
+func newSampleID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// handleSamples lists captured samples as JSON, newest first. Filter with
+// ?route=, ?reason=error|sampled and ?limit=, or fetch one via /_samples/<id>.
+func (s *Server) handleSamples(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if id := strings.TrimPrefix(r.URL.Path, "/_samples/"); id != r.URL.Path && id != "" {
+		sample := s.sampler.get(id)
+		if sample == nil {
+			http.Error(w, `{"error":"sample not found"}`, http.StatusNotFound)
+			return
+		}
+		if err := json.NewEncoder(w).Encode(sample); err != nil {
+			log.Printf("ERROR: Failed to encode sample: %v", err)
+		}
+		return
+	}
+
+	query := r.URL.Query()
+	limit := 50
+	fmt.Sscanf(query.Get("limit"), "%d", &limit)
+
+	samples := make([]*requestSample, 0)
+	for _, sample := range s.sampler.list() {
+		if route := query.Get("route"); route != "" && sample.Route != route {
+			continue
+		}
+		if reason := query.Get("reason"); reason != "" && sample.Reason != reason {
+			continue
+		}
+		samples = append(samples, sample)
+		if limit > 0 && len(samples) >= limit {
+			break
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(samples); err != nil {
+		log.Printf("ERROR: Failed to encode samples: %v", err)
+	}
+}
+
+// writeSampleExemplars appends per-route sample counts to /_metrics, each
+// carrying the latest sample as an OpenMetrics exemplar
+func (s *Server) writeSampleExemplars(w io.Writer) {
+	s.sampler.mu.Lock()
+	routes := make([]string, 0, len(s.sampler.counts))
+	for route := range s.sampler.counts {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	for _, route := range routes {
+		exemplar := s.sampler.exemplars[route]
+		fmt.Fprintf(w, "sampled_requests_total{route=%q} %d # {sample_id=%q} %.6f\n",
+			route, s.sampler.counts[route], exemplar.ID, exemplar.DurationMs/1000)
+	}
+	s.sampler.mu.Unlock()
+}
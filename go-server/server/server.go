@@ -4,45 +4,100 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 	"encoding/json"
 
+	"htmlnojs/health"
 	"htmlnojs/routebuilder"
+	"htmlnojs/store"
 )
 
 type Server struct {
 	host           string
 	port           int
-	mux            *http.ServeMux
+	mux            atomic.Pointer[http.ServeMux]
 	server         *http.Server
-	routes         *routebuilder.RouteCollection
+	routes         atomic.Pointer[routebuilder.RouteCollection]
 	middleware     []MiddlewareFunc
+	registerMu     sync.Mutex       // Guards use, groupUse and route registration
+	use            []MiddlewareFunc // Added with Use, around every route
+	groupUse       map[RouteGroup][]MiddlewareFunc // Added with UseFor
 	config         ServerConfig
+	stats          *routeStats
+	bandwidth      *bandwidthStats
+	costs          *ownerCosts
+	limiter        *rateLimiter
+	sampler        *sampler
+	configValidator ConfigValidator
+	store          store.Store
+	backendStatus  routebuilder.BackendStatus
+	backendProcess BackendProcess
+	doctor         Doctor
+	har            *routebuilder.HARRecorder
+	failures       *routebuilder.FailedRequests
+	authenticators []Authenticator // Tried in order on routes that require auth
+	loginURL       string          // Where browsers are sent to sign in
+	apiKeys        *APIKeyAuth     // Keys programmatic clients call handlers with
+	roles          map[string][]string // Roles by identity subject, besides those it carries
+	ipAccess       *IPAccess           // Client addresses let in, checked before routing
+	cors           *CORS               // Origins allowed to call the server from a browser
+	audit          *AuditLog           // Records state-changing requests to protected handlers
+	maintenance    *Maintenance        // Serves the maintenance page while switched on
+	userAgents     UserAgentRules      // Act on scrapers and bad bots, checked before routing
+	login          *Login              // Built-in login and logout routes
+	identitySecret []byte              // Signs the identity headers sent to backends
+	admin          *Admin              // Who may use the /_admin endpoints
+	trustedProxies *TrustedProxies     // Proxies whose forwarded client addresses are believed
+	sessions       *Sessions
+	cacheStats     cacheStats // What @cache handlers' response cache did
+	listen         []string     // Addresses served, "host:port" or "unix:/path"; host:port when empty
+	tls            *TLS         // Serve HTTPS with this certificate, plain HTTP when nil
+	redirect       *http.Server // Plain HTTP listener redirecting to HTTPS
+	inflight       atomic.Int64 // Requests being served, hijacked ones included
+	shutdownHooks  []func()
 }
 
 type ServerConfig struct {
-	ReadTimeout     time.Duration
-	WriteTimeout    time.Duration
-	IdleTimeout     time.Duration
-	ShutdownTimeout time.Duration
-	EnableCORS      bool
-	EnableLogging   bool
-	EnableMetrics   bool
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	ShutdownTimeout   time.Duration
+	EnableCORS        bool
+	EnableLogging     bool
+	EnableMetrics     bool
+	DemoMode          bool // Refuse mutating requests so public demos stay read-only
+	CSRF              bool // Require the session's CSRF token on mutating handler requests
+	Sampling          SamplingConfig
+	StatusPage        StatusPageConfig
+	PublicDoctor      bool // Let any client run /_doctor, not just localhost
+	SlowRequests      time.Duration // Log requests taking longer, 0 disables
+	IdempotencyWindow time.Duration // How long Idempotency-Key answers are kept, 0 disables
+	NonceLifetime     time.Duration // How long unused action nonces stay valid, 0 for an hour
 }
 
 type MiddlewareFunc func(http.Handler) http.Handler
 
 // NewServer creates a new HTMLnoJS server
 func NewServer(host string, port int) *Server {
-	return &Server{
+	s := &Server{
 		host:       host,
 		port:       port,
-		mux:        http.NewServeMux(),
 		middleware: make([]MiddlewareFunc, 0),
+		stats:      newRouteStats(),
+		bandwidth:  newBandwidthStats(),
+		costs:      newOwnerCosts(),
+		limiter:    newRateLimiter(nil),
+		sampler:    newSampler(SamplingConfig{}),
+		store:      store.NewMemory(),
+		sessions:   defaultSessions(),
 		config: ServerConfig{
 			ReadTimeout:     15 * time.Second,
 			WriteTimeout:    15 * time.Second,
@@ -53,6 +108,8 @@ func NewServer(host string, port int) *Server {
 			EnableMetrics:   false,
 		},
 	}
+	s.mux.Store(http.NewServeMux())
+	return s
 }
 
 // SetConfig updates server configuration
@@ -65,51 +122,183 @@ func (s *Server) AddMiddleware(mw MiddlewareFunc) {
 	s.middleware = append(s.middleware, mw)
 }
 
-// RegisterRoutes registers all routes from a RouteCollection
+// RegisterRoutes registers all routes from a RouteCollection. The routes are
+// compiled into a fresh route table which atomically replaces the current one,
+// so it is safe to call again while the server is running.
 func (s *Server) RegisterRoutes(routes *routebuilder.RouteCollection) error {
+	s.registerMu.Lock()
+	defer s.registerMu.Unlock()
+	return s.registerRoutes(routes)
+}
+
+// registerRoutes does RegisterRoutes for callers holding registerMu
+func (s *Server) registerRoutes(routes *routebuilder.RouteCollection) (err error) {
 	log.Printf("Registering %d routes with HTTP server...", routes.Metadata.TotalRoutes)
 
-	s.routes = routes
+	// ServeMux panics on conflicting patterns; keep the current table instead
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to register routes: %v", r)
+		}
+	}()
+
+	mux := http.NewServeMux()
 
 	// Register HTML routes
 	for _, route := range routes.HTMLRoutes {
-		handler := s.wrapHandler(route.Handler, route.RequiresAuth)
-		s.mux.HandleFunc(route.Route, handler)
+		handler := s.wrapHandler(s.countBandwidth("html", route.Route, s.timeTemplate(route.Name, route.Route, route.Handler)), route.RequiresAuth, route.Roles)
+		if s.config.DemoMode {
+			handler = s.demoModeMiddleware(handler)
+		}
+		handler = s.accountOwner(route.Owner, handler)
+		if s.config.Sampling.Enabled() {
+			handler = s.sampleRequests(route.Route, handler)
+		}
+		if s.config.CSRF {
+			handler = provideCSRFToken(handler)
+		}
+		handler = provideFlashes(s.provideNonces(handler))
+		mux.Handle(route.Route, s.chain(GroupHTML, s.routeMethods(route.Route, []string{route.Method}, handler)))
 		log.Printf("Registered HTML route: %s %s", route.Method, route.Route)
 	}
 
 	// Register CSS routes
 	for _, route := range routes.CSSRoutes {
-		handler := s.wrapStaticHandler(route.Handler)
-		s.mux.HandleFunc(route.Route, handler)
+		handler := s.wrapStaticHandler(s.countBandwidth("css", route.Route, route.Handler))
+		mux.Handle(route.Route, s.chain(GroupCSS, s.routeMethods(route.Route, []string{route.Method}, handler)))
 		log.Printf("Registered CSS route: %s %s", route.Method, route.Route)
 	}
 
+	// Register font routes
+	for _, route := range routes.FontRoutes {
+		handler := s.wrapStaticHandler(s.countBandwidth("font", route.Route, route.Handler))
+		mux.Handle(route.Route, s.chain(GroupFonts, s.routeMethods(route.Route, []string{route.Method}, handler)))
+		log.Printf("Registered font route: %s %s", route.Method, route.Route)
+	}
+
 	// Register Python API routes
 	for _, route := range routes.PythonRoutes {
-		handler := s.wrapAPIHandler(route.Handler, route.RequiresAuth, route.RateLimit, route.CacheTimeout)
-		s.mux.HandleFunc(route.Route, handler)
+		// Reached directly, the login handler would check passwords without
+		// the login form's rate limit
+		if s.login != nil && (route.Route == s.login.Handler || route.AliasOf == s.login.Handler) {
+			log.Printf("Login handler %s is only reached through %s", route.Route, s.login.Path)
+			continue
+		}
+		routeHandler := route.Handler
+		if s.config.CSRF {
+			// For the csrf response transform, which adds it to forms
+			routeHandler = provideCSRFToken(routeHandler)
+		}
+		if route.Nonce {
+			routeHandler = s.checkNonce(route.Route, routeHandler)
+		}
+		if s.config.IdempotencyWindow > 0 {
+			routeHandler = s.idempotent(route.Route, routeHandler)
+		}
+		handler := s.wrapAPIHandler(s.countBandwidth("api", route.Route, s.timeBackend(route.Function, route.Route, routeHandler)), route.RequiresAuth, route.Roles, route.RateLimit, route.CacheTimeout, route.CacheStale)
+		if s.config.DemoMode && !route.DemoSafe {
+			handler = s.demoModeMiddleware(handler)
+		}
+		handler = s.accountOwner(route.Owner, handler)
+		if s.config.Sampling.Enabled() {
+			handler = s.sampleRequests(route.Route, handler)
+		}
+		if s.config.CSRF && !route.CSRFExempt {
+			handler = s.checkCSRF(handler)
+		}
+		if s.apiKeys != nil {
+			handler = s.apiKeys.guard(handler)
+		}
+		if jwt := s.jwtAuth(); jwt != nil {
+			handler = jwt.forwardClaims(handler)
+		}
+		mux.Handle(route.Route, s.chain(GroupAPI, s.routeMethods(route.Route, []string{route.Method}, forwardSession(captureFlashes(handler)))))
 		log.Printf("Registered Python route: %s %s", route.Method, route.Route)
 	}
 
+	// Register WebSocket tunnels
+	for _, route := range routes.WebSocketRoutes {
+		mux.Handle(route.Route, s.chain(GroupWebSocket, s.routeMethods(route.Route, []string{route.Method}, forwardSession(route.Handler))))
+		log.Printf("Registered WebSocket route: %s -> %s", route.Route, route.Target)
+	}
+
+	// Register third-party API passthroughs
+	for _, route := range routes.GatewayRoutes {
+		handler := s.countBandwidth("gateway", route.Route, route.Handler)
+		if s.config.DemoMode {
+			handler = s.demoModeMiddleware(handler)
+		}
+		if s.config.CSRF {
+			handler = s.checkCSRF(handler)
+		}
+		mux.Handle(route.Route, s.chain(GroupGateway, s.routeMethods(route.Route, route.Methods, handler)))
+		log.Printf("Registered gateway route: %s -> %s", route.Route, route.Target)
+	}
+
+	// Register the status page unless a template already serves its path
+	if path := s.config.StatusPage.Path; path != "" {
+		if routeTaken(routes, path) {
+			log.Printf("WARNING: Status page not served, %s is already a route", path)
+		} else {
+			mux.HandleFunc(path, s.handleStatus)
+		}
+	}
+
 	// Register built-in routes
-	s.registerBuiltinRoutes()
+	s.registerLogin(mux, routes)
+	s.registerBuiltinRoutes(mux)
+	s.warnUnauthenticated(routes)
+
+	// Swap the route table in one step so in-flight requests finish on the old one
+	s.routes.Store(routes)
+	s.mux.Store(mux)
 
 	log.Printf("All routes registered successfully!")
 	return nil
 }
 
-func (s *Server) registerBuiltinRoutes() {
+// ServeHTTP dispatches the request through the current route table
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.inflight.Add(1)
+	defer s.inflight.Add(-1)
+	r = s.resolveClient(r)
+	if !s.checkIPAccess(w, r) {
+		return
+	}
+	r, ok := s.checkUserAgent(w, r)
+	if !ok {
+		return
+	}
+	if s.handleCORS(w, r) {
+		return
+	}
+	if !s.checkMaintenance(w, r) {
+		return
+	}
+	s.mux.Load().ServeHTTP(w, r)
+}
+
+func (s *Server) registerBuiltinRoutes(mux *http.ServeMux) {
 	// Health check endpoint
-	s.mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		// The server itself is live even while a backend is down
+		routes := s.GetRoutes()
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, `{"status":"ok","routes":%d}`, s.routes.Metadata.TotalRoutes)
+		json.NewEncoder(w).Encode(struct {
+			Status   string         `json:"status"`
+			Routes   int            `json:"routes"`
+			Backends []backendState `json:"backends"`
+		}{"ok", routes.Metadata.TotalRoutes, s.backendStates(routes)})
 	})
 
+	// Readiness endpoint, reports degraded optional subsystems
+	mux.HandleFunc("/readyz", s.handleReadyz)
+
 	// Route map endpoint
-	s.mux.HandleFunc("/_routes", func(w http.ResponseWriter, r *http.Request) {
-		if s.routes == nil {
+	mux.HandleFunc("/_routes", func(w http.ResponseWriter, r *http.Request) {
+		routes := s.GetRoutes()
+		if routes == nil {
 			http.Error(w, "No routes loaded", http.StatusInternalServerError)
 			return
 		}
@@ -119,7 +308,7 @@ func (s *Server) registerBuiltinRoutes() {
 
 		// HTML Routes
 		fmt.Fprintf(w, "HTML ROUTES:\n")
-		for _, route := range s.routes.HTMLRoutes {
+		for _, route := range routes.HTMLRoutes {
 			auth := ""
 			if route.RequiresAuth {
 				auth = " [AUTH]"
@@ -129,26 +318,76 @@ func (s *Server) registerBuiltinRoutes() {
 
 		// CSS Routes
 		fmt.Fprintf(w, "\nCSS ROUTES:\n")
-		for _, route := range s.routes.CSSRoutes {
+		for _, route := range routes.CSSRoutes {
 			fmt.Fprintf(w, "  %s %s -> %s [%s]\n", route.Method, route.Route, route.Name, route.Category)
 		}
 
+		// Font Routes
+		if len(routes.FontRoutes) > 0 {
+			fmt.Fprintf(w, "\nFONT ROUTES:\n")
+			for _, route := range routes.FontRoutes {
+				fmt.Fprintf(w, "  %s %s -> %s [%s]\n", route.Method, route.Route, route.Name, route.Format)
+			}
+		}
+
+		// WebSocket Routes
+		if len(routes.WebSocketRoutes) > 0 {
+			fmt.Fprintf(w, "\nWEBSOCKET ROUTES:\n")
+			for _, route := range routes.WebSocketRoutes {
+				fmt.Fprintf(w, "  %s %s -> %s\n", route.Method, route.Route, route.Target)
+			}
+		}
+
+		// Gateway Routes
+		if len(routes.GatewayRoutes) > 0 {
+			fmt.Fprintf(w, "\nGATEWAY ROUTES:\n")
+			for _, route := range routes.GatewayRoutes {
+				fmt.Fprintf(w, "  %s %s -> %s\n", strings.Join(route.Methods, ","), route.Route, route.Target)
+			}
+		}
+
+		// Handler backends
+		if backends := s.backendStates(routes); len(backends) > 0 {
+			fmt.Fprintf(w, "\nBACKENDS:\n")
+			for _, backend := range backends {
+				reason := ""
+				if backend.Reason != "" {
+					reason = " (" + backend.Reason + ")"
+				}
+				for _, p := range backend.Processes {
+					if p.PID != 0 {
+						reason += fmt.Sprintf(" [port %d: %s, pid %d, %d restarts]", p.Port, p.State, p.PID, p.Restarts)
+					} else {
+						reason += fmt.Sprintf(" [port %d: %s, %d restarts]", p.Port, p.State, p.Restarts)
+					}
+				}
+				fmt.Fprintf(w, "  %s: %s%s\n", backend.Language, backend.Status, reason)
+			}
+		}
+
 		// Python Routes
 		fmt.Fprintf(w, "\nPYTHON API ROUTES:\n")
-		for _, route := range s.routes.PythonRoutes {
+		for _, route := range routes.PythonRoutes {
 			auth := ""
 			if route.RequiresAuth {
 				auth = " [AUTH]"
 			}
+			if route.Deprecation != nil {
+				auth += fmt.Sprintf(" [%s]", route.Deprecation)
+			}
+			if route.AliasOf != "" {
+				auth += fmt.Sprintf(" [ALIAS:%s]", route.AliasOf)
+			}
 			fmt.Fprintf(w, "  %s %s -> %s%s\n", route.Method, route.Route, route.Function, auth)
 		}
 
 		// Summary
-		fmt.Fprintf(w, "\nSUMMARY: %d total routes\n", s.routes.Metadata.TotalRoutes)
+		fmt.Fprintf(w, "\nSUMMARY: %d total routes\n", s.GetRoutes().Metadata.TotalRoutes)
 	})
 
-    s.mux.HandleFunc("/_routes.json", func(w http.ResponseWriter, r *http.Request) {
-        if s.routes == nil {
+    mux.HandleFunc("/_routes.json", func(w http.ResponseWriter, r *http.Request) {
+        routes := s.GetRoutes()
+        if routes == nil {
             http.Error(w, "No routes loaded", http.StatusInternalServerError)
             return
         }
@@ -160,25 +399,37 @@ func (s *Server) registerBuiltinRoutes() {
             Route       string   `json:"route"`
             Name        string   `json:"name,omitempty"`
             Function    string   `json:"function,omitempty"`
+            Language    string   `json:"language,omitempty"`
+            Module      string   `json:"module,omitempty"`
+            FastAPIPath string   `json:"fastapi_path,omitempty"`
             Deps        []string `json:"dependencies,omitempty"`
             Auth        bool     `json:"requires_auth,omitempty"`
+            Deprecated  bool     `json:"deprecated,omitempty"`
+            Sunset      string   `json:"sunset,omitempty"`
+            Successor   string   `json:"successor,omitempty"`
+            APIVersion  string   `json:"api_version,omitempty"`
+            AliasOf     string   `json:"alias_of,omitempty"`
+            Owner       string   `json:"owner,omitempty"`
+            Params      []routebuilder.HandlerParam `json:"params,omitempty"`
         }
         var out struct {
             HTML   []jr `json:"html_routes"`
             CSS    []jr `json:"css_routes"`
             Python []jr `json:"python_routes"`
             Total  int  `json:"total_routes"`
+            Backends []backendState `json:"backends,omitempty"`
         }
 
-        for _, h := range s.routes.HTMLRoutes {
+        for _, h := range routes.HTMLRoutes {
             out.HTML = append(out.HTML, jr{
                 Method: h.Method,
                 Route:  h.Route,
                 Name:   h.Name,
                 Auth:   h.RequiresAuth,
+                Owner:  h.Owner,
             })
         }
-        for _, c := range s.routes.CSSRoutes {
+        for _, c := range routes.CSSRoutes {
             out.CSS = append(out.CSS, jr{
                 Method: c.Method,
                 Route:  c.Route,
@@ -186,15 +437,33 @@ func (s *Server) registerBuiltinRoutes() {
                 Deps:   c.Dependencies,
             })
         }
-        for _, p := range s.routes.PythonRoutes {
-            out.Python = append(out.Python, jr{
-                Method:   p.Method,
-                Route:    p.Route,
-                Function: p.Function,
-                Auth:     p.RequiresAuth,
-            })
+        for _, p := range routes.PythonRoutes {
+            module, _ := p.Metadata["base_path"].(string)
+            fastAPIPath, _ := p.Metadata["fastapi_path"].(string)
+            entry := jr{
+                Method:      p.Method,
+                Route:       p.Route,
+                Function:    p.Function,
+                Language:    p.Language,
+                Module:      module,
+                FastAPIPath: fastAPIPath,
+                Auth:        p.RequiresAuth,
+                APIVersion:  p.APIVersion,
+                AliasOf:     p.AliasOf,
+                Owner:       p.Owner,
+                Params:      p.Params,
+            }
+            if p.Deprecation != nil {
+                entry.Deprecated = true
+                entry.Successor = p.Deprecation.Successor
+                if !p.Deprecation.Sunset.IsZero() {
+                    entry.Sunset = p.Deprecation.Sunset.Format("2006-01-02")
+                }
+            }
+            out.Python = append(out.Python, entry)
         }
-        out.Total = s.routes.Metadata.TotalRoutes
+        out.Total = s.GetRoutes().Metadata.TotalRoutes
+        out.Backends = s.backendStates(routes)
 
         // log the exact error if encode blows up
         if err := json.NewEncoder(w).Encode(out); err != nil {
@@ -203,17 +472,97 @@ func (s *Server) registerBuiltinRoutes() {
         }
     })
 
-	// Metrics endpoint (if enabled)
+	// FastAPI app, request models and typed client for the current
+	// handlers, generated on demand
+	generated := map[string]func([]routebuilder.PythonRoute) ([]byte, error){
+		"/_fastapi/main.py": func(routes []routebuilder.PythonRoute) ([]byte, error) {
+			return routebuilder.GenerateFastAPIApp(routes, routebuilder.FastAPIAppOptions{})
+		},
+		"/_fastapi/models.py": routebuilder.GenerateModels,
+		"/_fastapi/client.py": routebuilder.GenerateClient,
+	}
+	for path, generate := range generated {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			source, err := generate(s.GetRoutes().PythonRoutes)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.Header().Set("Content-Type", "text/x-python; charset=utf-8")
+			w.Write(source)
+		})
+	}
+
+	// Full route metadata for editors, generators and dashboards
+	mux.HandleFunc("/_introspect", func(w http.ResponseWriter, r *http.Request) {
+		routes := s.GetRoutes()
+		if routes == nil {
+			http.Error(w, "No routes loaded", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		if _, pretty := r.URL.Query()["pretty"]; pretty {
+			encoder.SetIndent("", "  ")
+		}
+		if err := encoder.Encode(routebuilder.Introspect(routes)); err != nil {
+			log.Printf("ERROR: Failed to encode introspection: %v", err)
+		}
+	})
+
+	// Flash messages waiting in the session, fetched on the "flash" event
+	if s.sessions != nil {
+		mux.Handle("/_flash", s.sessions.middleware(http.HandlerFunc(s.handleFlash)))
+	}
+
+	// Environment diagnostics, available when the caller can run them
+	if s.doctor != nil {
+		mux.HandleFunc("/_doctor", s.handleDoctor)
+	}
+
+	// HAR capture of proxied traffic, available when routes record it
+	if s.har != nil {
+		mux.HandleFunc("/_admin/har", s.adminOnly(s.handleHAR))
+		mux.HandleFunc("/_admin/har/", s.adminOnly(s.handleHAR))
+	}
+
+	// Replay of failed proxied requests, available when routes keep them
+	if s.failures != nil {
+		mux.HandleFunc("/_admin/replay", s.adminOnly(s.handleReplay))
+		mux.HandleFunc("/_admin/replay/", s.adminOnly(s.handleReplay))
+	}
+
+	// Maintenance mode switch, available when the server can go down for it
+	if s.maintenance != nil {
+		mux.HandleFunc("/_admin/maintenance", s.adminOnly(s.handleMaintenance))
+		mux.HandleFunc("/_admin/maintenance/", s.adminOnly(s.handleMaintenance))
+	}
+
+	// Response cache listing and purging
+	mux.HandleFunc("/_admin/cache", s.adminOnly(s.handleCache))
+	mux.HandleFunc("/_admin/cache/", s.adminOnly(s.handleCache))
+
+	// Config validation, available when the caller can check and apply configs
+	if s.configValidator != nil {
+		mux.HandleFunc("/_admin/config/validate", s.adminOnly(s.handleConfigValidate))
+	}
+
+	// Metrics endpoints (if enabled)
 	if s.config.EnableMetrics {
-		s.mux.HandleFunc("/_metrics", s.handleMetrics)
+		mux.HandleFunc("/_metrics", s.handleMetrics)
+		mux.HandleFunc("/_stats", s.handleStats)
+		mux.HandleFunc("/_samples", s.handleSamples)
+		mux.HandleFunc("/_samples/", s.handleSamples)
 	}
 }
 
 
-func (s *Server) wrapHandler(handler http.HandlerFunc, requiresAuth bool) http.HandlerFunc {
-	wrapped := http.Handler(handler)
-
+func (s *Server) wrapHandler(handler http.HandlerFunc, requiresAuth bool, roles []string) http.HandlerFunc {
 	// Apply authentication if required
+	if len(roles) > 0 {
+		handler = s.requireRoles(roles, handler)
+	}
+	wrapped := http.Handler(handler)
 	if requiresAuth {
 		wrapped = http.HandlerFunc(s.authMiddleware(handler))
 	}
@@ -242,12 +591,12 @@ func (s *Server) wrapStaticHandler(handler http.HandlerFunc) http.HandlerFunc {
 	return wrapped
 }
 
-func (s *Server) wrapAPIHandler(handler http.HandlerFunc, requiresAuth bool, rateLimit int, cacheTimeout int) http.HandlerFunc {
-	wrapped := handler
+func (s *Server) wrapAPIHandler(handler http.HandlerFunc, requiresAuth bool, roles []string, rateLimit int, cacheTimeout, cacheStale int) http.HandlerFunc {
+	wrapped := s.forwardIdentity(handler)
 
 	// Apply caching if configured
 	if cacheTimeout > 0 {
-		wrapped = s.cacheMiddleware(wrapped, cacheTimeout)
+		wrapped = s.cacheMiddleware(wrapped, cacheTimeout, cacheStale)
 	}
 
 	// Apply rate limiting if configured
@@ -256,6 +605,12 @@ func (s *Server) wrapAPIHandler(handler http.HandlerFunc, requiresAuth bool, rat
 	}
 
 	// Apply authentication if required
+	if len(roles) > 0 {
+		wrapped = s.requireRoles(roles, wrapped)
+	}
+	if s.audit != nil && (requiresAuth || len(roles) > 0) {
+		wrapped = s.auditRequests(wrapped)
+	}
 	if requiresAuth {
 		wrapped = s.authMiddleware(wrapped)
 	}
@@ -266,29 +621,105 @@ func (s *Server) wrapAPIHandler(handler http.HandlerFunc, requiresAuth bool, rat
 	return wrapped
 }
 
-// Start starts the HTTP server
+// Start starts the HTTP server on every listen address
 func (s *Server) Start() error {
-	addr := fmt.Sprintf("%s:%d", s.host, s.port)
+	addrs := s.listenAddresses()
 
 	s.server = &http.Server{
-		Addr:         addr,
-		Handler:      s.mux,
+		Addr:         addrs[0],
+		Handler:      s,
 		ReadTimeout:  s.config.ReadTimeout,
 		WriteTimeout: s.config.WriteTimeout,
 		IdleTimeout:  s.config.IdleTimeout,
 	}
 
-	log.Printf("HTMLnoJS server starting on %s", addr)
+	scheme := "http"
+	if s.tls != nil {
+		tlsConfig, err := s.tls.tlsConfig()
+		if err != nil {
+			return err
+		}
+		s.server.TLSConfig = tlsConfig
+		scheme = "https"
+	}
+
+	// Sockets passed by systemd take the place of the listen addresses
+	listeners, labels, err := systemdListeners()
+	if err != nil {
+		return err
+	}
+	if listeners != nil {
+		addrs = labels
+		for _, label := range labels {
+			log.Printf("HTMLnoJS server starting on %s (%s)", label, scheme)
+		}
+	} else {
+		// Open every listener before serving, so a taken address fails the
+		// start rather than leaving the server half up
+		for _, addr := range addrs {
+			ln, err := listen(addr)
+			if err != nil {
+				for _, opened := range listeners {
+					opened.Close()
+				}
+				return err
+			}
+			listeners = append(listeners, ln)
+		}
+		for _, addr := range addrs {
+			if path, ok := strings.CutPrefix(addr, unixPrefix); ok {
+				log.Printf("HTMLnoJS server starting on unix socket %s (%s)", path, scheme)
+				if s.trustedProxies == nil || !s.trustedProxies.unix {
+					log.Printf("WARNING: Connections on %s have no client address; add \"unix\" to trusted_proxies so rate limits and ip_access use the one the proxy forwards", path)
+				}
+			} else {
+				log.Printf("HTMLnoJS server starting on %s://%s", scheme, addr)
+			}
+		}
+	}
 	log.Printf("Server configuration:")
 	log.Printf("  - Read timeout: %v", s.config.ReadTimeout)
 	log.Printf("  - Write timeout: %v", s.config.WriteTimeout)
-	log.Printf("  - CORS enabled: %v", s.config.EnableCORS)
+	if s.cors != nil {
+		log.Printf("  - CORS: origins %s", strings.Join(s.cors.global.Origins, ", "))
+	} else {
+		log.Printf("  - CORS enabled: %v", s.config.EnableCORS)
+	}
 	log.Printf("  - Logging enabled: %v", s.config.EnableLogging)
+	if s.config.DemoMode {
+		log.Printf("  - Demo mode: read-only, mutating requests are refused")
+	}
+
+	if s.tls != nil && s.tls.RedirectPort > 0 {
+		s.startRedirect()
+	}
+	watchdog := make(chan struct{})
+	s.server.RegisterOnShutdown(func() { close(watchdog) })
+	startWatchdog(watchdog)
+	sdNotify("READY=1\nSTATUS=Serving on " + strings.Join(addrs, ", "))
+	for i, ln := range listeners[1:] {
+		go func() {
+			if err := s.serve(ln); err != nil && err != http.ErrServerClosed {
+				log.Printf("ERROR: Listener on %s failed: %v", addrs[i+1], err)
+			}
+		}()
+	}
+	return s.serve(listeners[0])
+}
 
-	return s.server.ListenAndServe()
+// serve answers requests arriving on ln until the server shuts down
+func (s *Server) serve(ln net.Listener) error {
+	if s.tls != nil {
+		// The certificate is already in TLSConfig
+		return s.server.ServeTLS(ln, "", "")
+	}
+	return s.server.Serve(ln)
 }
 
-// StartWithGracefulShutdown starts the server with graceful shutdown handling
+// StartWithGracefulShutdown starts the server and, on SIGINT or SIGTERM,
+// stops accepting requests, lets those in flight finish within the
+// shutdown timeout and then runs the shutdown hooks, e.g. stopping the
+// backends the requests were waiting on. A second signal skips the wait.
 func (s *Server) StartWithGracefulShutdown() error {
 	// Start server in goroutine
 	go func() {
@@ -302,18 +733,60 @@ func (s *Server) StartWithGracefulShutdown() error {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("Server shutting down...")
+	log.Printf("Server shutting down, draining %d request(s) for up to %v...", s.inflight.Load(), s.config.ShutdownTimeout)
+	sdNotify("STOPPING=1")
 
 	// Create shutdown context with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
 	defer cancel()
+	go func() {
+		select {
+		case <-quit:
+			log.Println("WARNING: Second signal, not waiting for requests")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
 
-	// Shutdown server
-	if err := s.server.Shutdown(ctx); err != nil {
-		return fmt.Errorf("server forced to shutdown: %w", err)
+	if s.redirect != nil {
+		s.redirect.Close()
 	}
 
+	// Shutdown waits for requests but not for hijacked connections such
+	// as WebSockets, whose handlers the in-flight count still covers
+	err := s.server.Shutdown(ctx)
+	if err == nil {
+		err = s.waitForRequests(ctx)
+	}
+	if err != nil {
+		log.Printf("WARNING: %d request(s) still in flight, closing their connections", s.inflight.Load())
+		s.server.Close()
+		err = fmt.Errorf("server forced to shutdown: %w", err)
+	}
+
+	for _, hook := range s.shutdownHooks {
+		hook()
+	}
 	log.Println("Server exited")
+	return err
+}
+
+// OnShutdown runs hook once StartWithGracefulShutdown has drained requests
+func (s *Server) OnShutdown(hook func()) {
+	s.shutdownHooks = append(s.shutdownHooks, hook)
+}
+
+// waitForRequests waits until no request is being served or ctx ends
+func (s *Server) waitForRequests(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for s.inflight.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
 	return nil
 }
 
@@ -323,6 +796,10 @@ func (s *Server) Stop() error {
 		return nil
 	}
 
+	if s.redirect != nil {
+		s.redirect.Close()
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
 	defer cancel()
 
@@ -331,29 +808,22 @@ func (s *Server) Stop() error {
 
 // GetRoutes returns the registered routes
 func (s *Server) GetRoutes() *routebuilder.RouteCollection {
-	return s.routes
+	return s.routes.Load()
+}
+
+// Store returns the key-value store shared by the server's subsystems
+func (s *Server) Store() store.Store {
+	return s.store
 }
 
-// GetAddr returns the server address
+// GetAddr returns the server address, the first one when it listens on
+// several
 func (s *Server) GetAddr() string {
-	return fmt.Sprintf("%s:%d", s.host, s.port)
+	return s.listenAddresses()[0]
 }
 
 // Middleware implementations
 
-func (s *Server) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// TODO: Implement actual authentication
-		// For now, just check for a simple auth header
-		auth := r.Header.Get("Authorization")
-		if auth == "" {
-			http.Error(w, "Authentication required", http.StatusUnauthorized)
-			return
-		}
-		next(w, r)
-	}
-}
-
 func (s *Server) corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -390,29 +860,49 @@ func (s *Server) apiMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
-func (s *Server) cacheMiddleware(next http.HandlerFunc, timeout int) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// TODO: Implement actual caching logic
-		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", timeout))
-		next(w, r)
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	routes := s.GetRoutes()
+	w.Header().Set("Content-Type", "text/plain")
+	fmt.Fprintf(w, "# HTMLnoJS Server Metrics\n")
+	fmt.Fprintf(w, "total_routes %d\n", routes.Metadata.TotalRoutes)
+	fmt.Fprintf(w, "html_routes %d\n", routes.Metadata.HTMLCount)
+	fmt.Fprintf(w, "css_routes %d\n", routes.Metadata.CSSCount)
+	fmt.Fprintf(w, "python_routes %d\n", routes.Metadata.PythonCount)
+	fmt.Fprintf(w, "auth_required_routes %d\n", routes.Metadata.AuthRequired)
+	s.writeBackendMetrics(w)
+	s.writeOwnerMetrics(w)
+	s.writeRateLimitMetrics(w)
+	if s.apiKeys != nil {
+		s.apiKeys.writeMetrics(w)
 	}
-}
-
-func (s *Server) rateLimitMiddleware(next http.HandlerFunc, limit int) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		// TODO: Implement actual rate limiting
-		// For now, just add a header indicating the limit
-		w.Header().Set("X-RateLimit-Limit", fmt.Sprintf("%d", limit))
-		next(w, r)
+	if s.audit != nil {
+		s.audit.writeMetrics(w)
 	}
+	s.userAgents.writeMetrics(w)
+	s.cacheStats.writeMetrics(w)
+	s.writeSampleExemplars(w)
 }
+// handleReadyz reports readiness along with the state of every registered
+// subsystem. Degraded optional subsystems keep the server ready.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	subsystems := health.Default.Snapshot()
+
+	status := "ready"
+	code := http.StatusOK
+	for _, subsystem := range subsystems {
+		if subsystem.Status == health.StatusDegraded {
+			status = "degraded"
+		}
+	}
+	if s.GetRoutes() == nil || !health.Default.Ready() {
+		status = "not_ready"
+		code = http.StatusServiceUnavailable
+	}
 
-func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "text/plain")
-	fmt.Fprintf(w, "# HTMLnoJS Server Metrics\n")
-	fmt.Fprintf(w, "total_routes %d\n", s.routes.Metadata.TotalRoutes)
-	fmt.Fprintf(w, "html_routes %d\n", s.routes.Metadata.HTMLCount)
-	fmt.Fprintf(w, "css_routes %d\n", s.routes.Metadata.CSSCount)
-	fmt.Fprintf(w, "python_routes %d\n", s.routes.Metadata.PythonCount)
-	fmt.Fprintf(w, "auth_required_routes %d\n", s.routes.Metadata.AuthRequired)
-}
\ No newline at end of file
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Status     string             `json:"status"`
+		Subsystems []health.Subsystem `json:"subsystems"`
+	}{status, subsystems})
+}
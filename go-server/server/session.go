@@ -0,0 +1,368 @@
+package server
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"htmlnojs/store"
+)
+
+// Headers carrying the session to backends. Copies sent by clients are
+// dropped, so backends can trust them.
+const (
+	SessionHeader     = "X-HTMLnoJS-Session"
+	SessionUserHeader = "X-HTMLnoJS-User"
+)
+
+// Session defaults, used where htmlnojs.json leaves a setting out
+const (
+	defaultSessionCookie   = "htmlnojs_session"
+	defaultSessionLifetime = 24 * time.Hour
+)
+
+// sessionUserKey holds the signed-in user among a session's values
+const sessionUserKey = "user"
+
+// Session is one client's state between requests. Changes are saved when
+// the response starts.
+type Session struct {
+	mu      sync.Mutex
+	id      string
+	values  map[string]string
+	changed bool
+	oldID   string // Replaced ID, forgotten when the session is saved
+	loaded  bool   // Read from the client's cookie
+}
+
+// ID identifies the session; it changes when the user does
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// persisted reports whether the session came from the client's cookie,
+// rather than being new this request
+func (s *Session) persisted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.loaded
+}
+
+// Get returns a value stored in the session, or ""
+func (s *Session) Get(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[key]
+}
+
+// Set stores a value in the session
+func (s *Session) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	s.changed = true
+}
+
+// Delete removes a value from the session
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.changed = true
+	}
+}
+
+// User returns who signed in with this session, or ""
+func (s *Session) User() string {
+	return s.Get(sessionUserKey)
+}
+
+// SetUser signs user in, or out when user is empty. The session gets a
+// new ID, so one planted before signing in can't be used after, and
+// starts empty when it belonged to someone else, so nothing of theirs,
+// such as their roles, carries over.
+func (s *Session) SetUser(user string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if current := s.values[sessionUserKey]; user == "" || (current != "" && current != user) {
+		s.values = map[string]string{}
+	}
+	if user != "" {
+		s.values[sessionUserKey] = user
+	}
+	if s.oldID == "" {
+		s.oldID = s.id
+	}
+	s.id = newSessionID()
+	s.changed = true
+}
+
+type sessionKey struct{}
+
+// SessionFrom returns the session of the request ctx belongs to, or nil
+// outside the server's routes
+func SessionFrom(ctx context.Context) *Session {
+	session, _ := ctx.Value(sessionKey{}).(*Session)
+	return session
+}
+
+// sessionCodec turns sessions into cookie values and back
+type sessionCodec interface {
+	load(ctx context.Context, cookie string) (id string, values map[string]string, err error)
+	save(ctx context.Context, id string, values map[string]string, lifetime time.Duration) (cookie string, err error)
+	forget(ctx context.Context, id string) error
+}
+
+// Sessions gives every request a session kept in a cookie
+type Sessions struct {
+	Cookie   string        // Cookie name (default "htmlnojs_session")
+	Lifetime time.Duration // How long an unused session lasts (default 24h)
+	codec    sessionCodec
+}
+
+// NewCookieSessions keeps sessions in the cookie itself, encrypted and
+// authenticated with a key derived from secret
+func NewCookieSessions(secret []byte, cookie string, lifetime time.Duration) (*Sessions, error) {
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return newSessions(&cookieCodec{aead: aead}, cookie, lifetime), nil
+}
+
+// NewStoreSessions keeps sessions in st, with only their ID in the cookie
+func NewStoreSessions(st store.Store, cookie string, lifetime time.Duration) *Sessions {
+	return newSessions(&storeCodec{sessions: store.WithPrefix(st, "sessions:")}, cookie, lifetime)
+}
+
+// defaultSessions keeps sessions in cookies encrypted with a key made at
+// startup, so they end when the server restarts
+func defaultSessions() *Sessions {
+	secret := make([]byte, 32)
+	rand.Read(secret)
+	sessions, _ := NewCookieSessions(secret, "", 0) // AES-256 always accepts the key
+	return sessions
+}
+
+func newSessions(codec sessionCodec, cookie string, lifetime time.Duration) *Sessions {
+	if cookie == "" {
+		cookie = defaultSessionCookie
+	}
+	if lifetime <= 0 {
+		lifetime = defaultSessionLifetime
+	}
+	return &Sessions{Cookie: cookie, Lifetime: lifetime, codec: codec}
+}
+
+// middleware loads the request's session and saves it once the response
+// starts, if it changed
+func (m *Sessions) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := &Session{values: map[string]string{}}
+		if cookie, err := r.Cookie(m.Cookie); err == nil && cookie.Value != "" {
+			id, values, err := m.codec.load(r.Context(), cookie.Value)
+			switch {
+			case err == nil:
+				session.id, session.values, session.loaded = id, values, true
+			case !errors.Is(err, store.ErrNotFound) && !errors.Is(err, errBadSessionCookie):
+				log.Printf("WARNING: Failed to load a session: %v", err)
+			}
+		}
+		if session.id == "" {
+			session.id = newSessionID()
+		}
+
+		sw := &sessionWriter{wrappedWriter: wrappedWriter{w}, save: func() { m.save(w, r, session) }}
+		next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), sessionKey{}, session)))
+		sw.saveOnce()
+	})
+}
+
+// save writes a changed session back to the client
+func (m *Sessions) save(w http.ResponseWriter, r *http.Request, session *Session) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	if !session.changed {
+		return
+	}
+	ctx := context.WithoutCancel(r.Context())
+	if session.oldID != "" {
+		if err := m.codec.forget(ctx, session.oldID); err != nil {
+			log.Printf("WARNING: Failed to forget a replaced session: %v", err)
+		}
+	}
+
+	cookie := &http.Cookie{
+		Name:     m.Cookie,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   isHTTPS(r),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if len(session.values) == 0 {
+		cookie.MaxAge = -1
+		if err := m.codec.forget(ctx, session.id); err != nil {
+			log.Printf("WARNING: Failed to forget a session: %v", err)
+		}
+	} else {
+		value, err := m.codec.save(ctx, session.id, session.values, m.Lifetime)
+		if err != nil {
+			log.Printf("ERROR: Failed to save a session: %v", err)
+			return
+		}
+		cookie.Value = value
+		cookie.MaxAge = int(m.Lifetime / time.Second)
+	}
+	http.SetCookie(w, cookie)
+}
+
+// forwardSession tells backends about the request's session in trusted
+// headers, dropping any the client sent
+func forwardSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del(SessionHeader)
+		r.Header.Del(SessionUserHeader)
+		if session := SessionFrom(r.Context()); session != nil {
+			r.Header.Set(SessionHeader, session.ID())
+			if user := session.User(); user != "" {
+				r.Header.Set(SessionUserHeader, user)
+			}
+		}
+		next(w, r)
+	}
+}
+
+// sessionWriter saves the session before the response's headers are sent
+type sessionWriter struct {
+	wrappedWriter
+	save  func()
+	saved bool
+}
+
+func (sw *sessionWriter) saveOnce() {
+	if !sw.saved {
+		sw.saved = true
+		sw.save()
+	}
+}
+
+func (sw *sessionWriter) WriteHeader(code int) {
+	sw.saveOnce()
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *sessionWriter) Write(b []byte) (int, error) {
+	sw.saveOnce()
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *sessionWriter) Flush() {
+	sw.saveOnce()
+	sw.wrappedWriter.Flush()
+}
+
+// errBadSessionCookie is a cookie that wasn't made by this server, or
+// expired
+var errBadSessionCookie = errors.New("invalid session cookie")
+
+// cookieSession is what an encrypted session cookie holds
+type cookieSession struct {
+	ID      string            `json:"id"`
+	Values  map[string]string `json:"values"`
+	Expires int64             `json:"exp"`
+}
+
+// cookieCodec keeps the whole session in the cookie, sealed with AES-GCM
+type cookieCodec struct {
+	aead cipher.AEAD
+}
+
+func (c *cookieCodec) load(_ context.Context, cookie string) (string, map[string]string, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(cookie)
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return "", nil, errBadSessionCookie
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plain, err := c.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", nil, errBadSessionCookie
+	}
+	var session cookieSession
+	if err := json.Unmarshal(plain, &session); err != nil || time.Now().Unix() > session.Expires {
+		return "", nil, errBadSessionCookie
+	}
+	if session.Values == nil {
+		session.Values = map[string]string{}
+	}
+	return session.ID, session.Values, nil
+}
+
+func (c *cookieCodec) save(_ context.Context, id string, values map[string]string, lifetime time.Duration) (string, error) {
+	plain, err := json.Marshal(cookieSession{ID: id, Values: values, Expires: time.Now().Add(lifetime).Unix()})
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	rand.Read(nonce)
+	return base64.RawURLEncoding.EncodeToString(c.aead.Seal(nonce, nonce, plain, nil)), nil
+}
+
+// forget can't revoke a cookie the client still holds; its expiry and a
+// new ID on sign in are what end it
+func (c *cookieCodec) forget(context.Context, string) error {
+	return nil
+}
+
+// storeCodec keeps sessions in the store under their ID
+type storeCodec struct {
+	sessions store.Store
+}
+
+func (c *storeCodec) load(ctx context.Context, id string) (string, map[string]string, error) {
+	data, err := c.sessions.Get(ctx, id)
+	if err != nil {
+		return "", nil, err
+	}
+	values := map[string]string{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return "", nil, errBadSessionCookie
+	}
+	return id, values, nil
+}
+
+func (c *storeCodec) save(ctx context.Context, id string, values map[string]string, lifetime time.Duration) (string, error) {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return id, c.sessions.Set(ctx, id, data, lifetime)
+}
+
+func (c *storeCodec) forget(ctx context.Context, id string) error {
+	return c.sessions.Delete(ctx, id)
+}
+
+// newSessionID returns a random session ID
+func newSessionID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"htmlnojs/routebuilder"
+)
+
+func TestCookieCodec(t *testing.T) {
+	sessions, err := NewCookieSessions([]byte("session-secret"), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewCookieSessions([]byte("another-secret"), "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	seal := func(codec sessionCodec, values map[string]string, lifetime time.Duration) string {
+		t.Helper()
+		cookie, err := codec.save(ctx, "session-id", values, lifetime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cookie
+	}
+	valid := seal(sessions.codec, map[string]string{"user": "ada", csrfSessionKey: "token"}, time.Hour)
+
+	tests := []struct {
+		name   string
+		cookie string
+		values map[string]string // Expected when the cookie loads
+		err    error
+	}{
+		{"round trip", valid, map[string]string{"user": "ada", csrfSessionKey: "token"}, nil},
+		{"no values", seal(sessions.codec, nil, time.Hour), map[string]string{}, nil},
+		{"expired", seal(sessions.codec, map[string]string{"user": "ada"}, -time.Second), nil, errBadSessionCookie},
+		{"other key", seal(other.codec, map[string]string{"user": "ada"}, time.Hour), nil, errBadSessionCookie},
+		{"tampered", valid[:len(valid)-2] + flipped(valid[len(valid)-2:]), nil, errBadSessionCookie},
+		{"truncated", valid[:8], nil, errBadSessionCookie},
+		{"not base64", "not a cookie!", nil, errBadSessionCookie},
+		{"empty", "", nil, errBadSessionCookie},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, values, err := sessions.codec.load(ctx, tt.cookie)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("load() error = %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if id != "session-id" {
+				t.Errorf("load() id = %q, want %q", id, "session-id")
+			}
+			if !maps.Equal(values, tt.values) {
+				t.Errorf("load() values = %v, want %v", values, tt.values)
+			}
+		})
+	}
+}
+
+// flipped changes the first character of a base64 string to another one
+func flipped(s string) string {
+	if s[0] == 'A' {
+		return "B" + s[1:]
+	}
+	return "A" + s[1:]
+}
+
+func TestSessionCookieSecure(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	remember := func(w http.ResponseWriter, r *http.Request) {
+		SessionFrom(r.Context()).Set("theme", "dark")
+	}
+	s := NewBuilder().
+		WithTrustedProxies(proxies).
+		WithRoutes(&routebuilder.RouteCollection{PythonRoutes: []routebuilder.PythonRoute{
+			{Name: "remember", Route: "/api/theme", Method: "POST", Handler: remember},
+		}}).
+		Build()
+
+	tests := []struct {
+		name   string
+		peer   string
+		tls    bool
+		header map[string]string
+		secure bool
+	}{
+		{"plain HTTP", "203.0.113.9:4000", false, nil, false},
+		{"HTTPS", "203.0.113.9:4000", true, nil, true},
+		{"proxy ended HTTPS", "10.0.0.2:4000", false, map[string]string{"X-Forwarded-For": "203.0.113.9", "X-Forwarded-Proto": "https"}, true},
+		{"proxy forwarded HTTP", "10.0.0.2:4000", false, map[string]string{"X-Forwarded-For": "203.0.113.9", "X-Forwarded-Proto": "http"}, false},
+		{"proxy without X-Forwarded-For", "10.0.0.2:4000", false, map[string]string{"X-Forwarded-Proto": "https"}, true},
+		{"Forwarded proto", "10.0.0.2:4000", false, map[string]string{"Forwarded": "for=203.0.113.9;proto=https"}, true},
+		{"client's own hop", "10.0.0.2:4000", false, map[string]string{"Forwarded": "for=198.51.100.1;proto=https, for=203.0.113.9;proto=http"}, false},
+		{"untrusted peer", "203.0.113.9:4000", false, map[string]string{"X-Forwarded-Proto": "https"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/api/theme", nil)
+			r.RemoteAddr = tt.peer
+			if tt.tls {
+				r.TLS = &tls.ConnectionState{}
+			}
+			for name, value := range tt.header {
+				r.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+			cookies := w.Result().Cookies()
+			if len(cookies) != 1 {
+				t.Fatalf("cookies = %v, want the session cookie", cookies)
+			}
+			if cookies[0].Secure != tt.secure {
+				t.Errorf("Secure = %v, want %v", cookies[0].Secure, tt.secure)
+			}
+		})
+	}
+}
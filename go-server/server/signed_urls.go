@@ -0,0 +1,51 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"htmlnojs/routebuilder"
+)
+
+// SignedURLAuth lets in requests for URLs signed with the signed_url
+// template function or URLSigner.Sign, so an emailed link opens a
+// protected page or handler without a session. A signed URL opens only
+// the path and query it was signed for, whatever roles the route needs.
+type SignedURLAuth struct {
+	Signer *routebuilder.URLSigner // nil uses routebuilder.DefaultURLSigner
+}
+
+func (a *SignedURLAuth) signer() *routebuilder.URLSigner {
+	if a.Signer != nil {
+		return a.Signer
+	}
+	return routebuilder.DefaultURLSigner()
+}
+
+func (a *SignedURLAuth) Authenticate(r *http.Request) *Identity {
+	if a.signer().Verify(r.URL) != nil {
+		return nil
+	}
+	return &Identity{Subject: r.URL.Path, Method: "signed_url"}
+}
+
+// Require serves next only for validly signed URLs, for handlers outside
+// the route tree such as downloads added with Use
+func (a *SignedURLAuth) Require(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := a.signer().Verify(r.URL); err != nil {
+			writeSignedURLError(w, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeSignedURLError tells the client why its signed link doesn't open
+func writeSignedURLError(w http.ResponseWriter, err error) {
+	message := "This link isn't valid"
+	if errors.Is(err, routebuilder.ErrURLExpired) {
+		message = "This link has expired, ask for a new one"
+	}
+	routebuilder.WriteFragment(w, http.StatusForbidden, routebuilder.ErrorFragment("Link Not Valid", message, ""))
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the heatmap columns reported by /_stats
+var latencyBuckets = []time.Duration{
+	1 * time.Millisecond,
+	5 * time.Millisecond,
+	25 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+}
+
+var latencyBucketLabels = []string{"<1ms", "<5ms", "<25ms", "<100ms", "<500ms", ">=500ms"}
+
+// timingStat aggregates execution times for a single template or handler
+type timingStat struct {
+	Name    string
+	Route   string
+	Count   int64
+	Total   time.Duration
+	Max     time.Duration
+	Buckets []int64
+}
+
+// routeStats tracks template render cost separately from backend handler time
+type routeStats struct {
+	mu        sync.Mutex
+	templates map[string]*timingStat
+	backend   map[string]*timingStat
+}
+
+func newRouteStats() *routeStats {
+	return &routeStats{
+		templates: make(map[string]*timingStat),
+		backend:   make(map[string]*timingStat),
+	}
+}
+
+// recordTemplate records the render time of an HTML template
+func (rs *routeStats) recordTemplate(name, route string, d time.Duration) {
+	rs.record(rs.templates, name, route, d)
+}
+
+// recordBackend records the time spent waiting on a Python handler
+func (rs *routeStats) recordBackend(name, route string, d time.Duration) {
+	rs.record(rs.backend, name, route, d)
+}
+
+func (rs *routeStats) record(bucket map[string]*timingStat, name, route string, d time.Duration) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	stat, ok := bucket[route]
+	if !ok {
+		stat = &timingStat{
+			Name:    name,
+			Route:   route,
+			Buckets: make([]int64, len(latencyBucketLabels)),
+		}
+		bucket[route] = stat
+	}
+
+	stat.Count++
+	stat.Total += d
+	if d > stat.Max {
+		stat.Max = d
+	}
+
+	idx := len(latencyBuckets)
+	for i, bound := range latencyBuckets {
+		if d < bound {
+			idx = i
+			break
+		}
+	}
+	stat.Buckets[idx]++
+}
+
+// statEntry is the JSON shape of a single /_stats row
+type statEntry struct {
+	Name    string           `json:"name"`
+	Route   string           `json:"route"`
+	Count   int64            `json:"count"`
+	TotalMs float64          `json:"total_ms"`
+	AvgMs   float64          `json:"avg_ms"`
+	MaxMs   float64          `json:"max_ms"`
+	Heatmap map[string]int64 `json:"heatmap"`
+}
+
+// snapshot returns the worst offenders of a bucket ordered by the given key
+func (rs *routeStats) snapshot(bucket map[string]*timingStat, sortBy string, top int) []statEntry {
+	rs.mu.Lock()
+	entries := make([]statEntry, 0, len(bucket))
+	for _, stat := range bucket {
+		heatmap := make(map[string]int64, len(latencyBucketLabels))
+		for i, label := range latencyBucketLabels {
+			heatmap[label] = stat.Buckets[i]
+		}
+		entry := statEntry{
+			Name:    stat.Name,
+			Route:   stat.Route,
+			Count:   stat.Count,
+			TotalMs: durationMs(stat.Total),
+			MaxMs:   durationMs(stat.Max),
+			Heatmap: heatmap,
+		}
+		if stat.Count > 0 {
+			entry.AvgMs = entry.TotalMs / float64(stat.Count)
+		}
+		entries = append(entries, entry)
+	}
+	rs.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		switch sortBy {
+		case "avg":
+			return entries[i].AvgMs > entries[j].AvgMs
+		case "max":
+			return entries[i].MaxMs > entries[j].MaxMs
+		case "count":
+			return entries[i].Count > entries[j].Count
+		default:
+			return entries[i].TotalMs > entries[j].TotalMs
+		}
+	})
+
+	if top > 0 && len(entries) > top {
+		entries = entries[:top]
+	}
+	return entries
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// timeTemplate wraps an HTML route handler so its render cost is recorded
+func (s *Server) timeTemplate(name, route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next(w, r)
+		d := time.Since(start)
+		s.stats.recordTemplate(name, route, d)
+		addTimingPhase(r, "template", d)
+		s.logSlowRequest(r, "template "+name, route, d)
+	}
+}
+
+// timeBackend wraps a Python route handler so backend time is recorded
+func (s *Server) timeBackend(name, route string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next(w, r)
+		d := time.Since(start)
+		s.stats.recordBackend(name, route, d)
+		addTimingPhase(r, "backend", d)
+		s.logSlowRequest(r, "handler "+name, route, d)
+	}
+}
+
+// logSlowRequest logs a request to route that took longer than the slow
+// request threshold
+func (s *Server) logSlowRequest(r *http.Request, name, route string, d time.Duration) {
+	if s.config.SlowRequests <= 0 || d < s.config.SlowRequests {
+		return
+	}
+	log.Printf("WARNING: Slow request %s %s took %v (route %s, %s)", r.Method, r.URL.Path, d.Round(time.Millisecond), route, name)
+}
+
+// handleStats serves the slowest templates and backend handlers, and the
+// routes sending the most bytes, as JSON
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	sortBy := r.URL.Query().Get("sort")
+	top := 10
+	if v := r.URL.Query().Get("top"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			top = n
+		}
+	}
+
+	out := struct {
+		Buckets   []string         `json:"buckets"`
+		Templates []statEntry      `json:"templates"`
+		Backend   []statEntry      `json:"backend"`
+		Bandwidth []bandwidthEntry `json:"bandwidth"`
+	}{
+		Buckets:   latencyBucketLabels,
+		Templates: s.stats.snapshot(s.stats.templates, sortBy, top),
+		Backend:   s.stats.snapshot(s.stats.backend, sortBy, top),
+		Bandwidth: s.bandwidth.snapshot(sortBy, top),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(out); err != nil {
+		log.Printf("ERROR: Failed to encode stats: %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"htmlnojs/health"
+	"htmlnojs/routebuilder"
+)
+
+// StatusPageConfig configures the uptime page built from health history
+type StatusPageConfig struct {
+	Path   string // Where the page is served, empty disables it
+	Title  string
+	Public bool // Serve other hosts too, not just localhost
+}
+
+//go:embed status.html
+var statusPageSource string
+
+var statusPageTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"uptime": func(percent float64) string {
+		if percent < 0 {
+			return "no data"
+		}
+		return fmt.Sprintf("%.2f%%", percent)
+	},
+	"uptimeClass": func(percent float64) string {
+		switch {
+		case percent < 0:
+			return "none"
+		case percent >= 99.9:
+			return "ok"
+		case percent >= 95:
+			return "degraded"
+		default:
+			return "down"
+		}
+	},
+	"since": func(t time.Time) string {
+		return t.UTC().Format("Jan 2 15:04 MST")
+	},
+	"duration": func(d time.Duration) string {
+		return d.Round(time.Second).String()
+	},
+}).Parse(statusPageSource))
+
+// statusPage is what the status template renders
+type statusPage struct {
+	Title       string          `json:"title"`
+	Overall     health.Status   `json:"status"`
+	Days        int             `json:"days"`
+	Subsystems  []health.Report `json:"subsystems"`
+	GeneratedAt time.Time       `json:"generated_at"`
+}
+
+// handleStatus renders uptime, incidents and current latency of every
+// registered subsystem; ?format=json returns the same data as JSON
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !s.config.StatusPage.Public && !isLoopback(r) {
+		http.NotFound(w, r)
+		return
+	}
+
+	page := statusPage{
+		Title:       s.config.StatusPage.Title,
+		Overall:     health.StatusOK,
+		GeneratedAt: time.Now(),
+	}
+	if page.Title == "" {
+		page.Title = "Service Status"
+	}
+
+	history := health.Default.History()
+	for _, subsystem := range health.Default.Snapshot() {
+		report := health.Report{Subsystem: subsystem, Uptime: -1}
+		if history != nil {
+			var err error
+			if report, err = history.Report(r.Context(), subsystem); err != nil {
+				log.Printf("WARNING: Failed to load %s health history: %v", subsystem.Name, err)
+			}
+			page.Days = history.Days()
+		}
+		switch {
+		case subsystem.Status == health.StatusDown:
+			page.Overall = health.StatusDown
+		case subsystem.Status == health.StatusDegraded && page.Overall == health.StatusOK:
+			page.Overall = health.StatusDegraded
+		}
+		page.Subsystems = append(page.Subsystems, report)
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(page); err != nil {
+			log.Printf("ERROR: Failed to encode status: %v", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusPageTemplate.Execute(w, page); err != nil {
+		log.Printf("ERROR: Failed to render status page: %v", err)
+	}
+}
+
+// routeTaken reports whether a page or handler is already served at path
+func routeTaken(routes *routebuilder.RouteCollection, path string) bool {
+	for _, route := range routes.HTMLRoutes {
+		if route.Route == path {
+			return true
+		}
+	}
+	for _, route := range routes.PythonRoutes {
+		if route.Route == path {
+			return true
+		}
+	}
+	return false
+}
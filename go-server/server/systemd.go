@@ -0,0 +1,112 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// systemdFirstFD is the first file descriptor systemd passes sockets on
+const systemdFirstFD = 3
+
+// systemdListeners returns the sockets systemd passed the server with
+// socket activation (LISTEN_FDS), with their names from LISTEN_FDNAMES, or
+// nil when it wasn't socket activated. The variables are cleared, so the
+// backends the server starts don't see them.
+func systemdListeners() ([]net.Listener, []string, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil, nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make([]net.Listener, 0, count)
+	labels := make([]string, 0, count)
+	for i := range count {
+		label := "systemd socket " + strconv.Itoa(i+1)
+		if i < len(names) && names[i] != "" {
+			label = "systemd socket " + names[i]
+		}
+		file := os.NewFile(uintptr(systemdFirstFD+i), label)
+		ln, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, nil, fmt.Errorf("failed to use %s: %w", label, err)
+		}
+		listeners = append(listeners, ln)
+		labels = append(labels, label)
+	}
+	return listeners, labels, nil
+}
+
+// sdNotify sends state, e.g. "READY=1", to systemd's NOTIFY_SOCKET; it
+// does nothing when the server wasn't started by a Type=notify unit
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:] // Abstract socket
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		log.Printf("WARNING: Failed to notify systemd: %v", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Printf("WARNING: Failed to notify systemd: %v", err)
+	}
+}
+
+// watchdogInterval returns how often systemd's watchdog wants to hear from
+// the server, half of WATCHDOG_USEC, or 0 when the unit has no watchdog
+func watchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// startWatchdog tells systemd's watchdog the server is alive until stop is
+// closed
+func startWatchdog(stop <-chan struct{}) {
+	interval := watchdogInterval()
+	if interval <= 0 || os.Getenv("NOTIFY_SOCKET") == "" {
+		return
+	}
+	log.Printf("Pinging the systemd watchdog every %v", interval)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				sdNotify("WATCHDOG=1")
+			}
+		}
+	}()
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// TLS is the certificate the server terminates HTTPS with, and the plain
+// HTTP port, if any, sending browsers over to it
+type TLS struct {
+	CertFile     string // PEM certificate chain
+	KeyFile      string // PEM private key
+	RedirectPort int    // Answers plain HTTP with a redirect to HTTPS, 0 for none
+}
+
+// tlsConfig loads the certificate up front, so a bad file fails the start
+// rather than the first handshake
+func (t *TLS) tlsConfig() (*tls.Config, error) {
+	if t.CertFile == "" || t.KeyFile == "" {
+		return nil, fmt.Errorf("HTTPS needs both a certificate and a key file")
+	}
+	cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate %s: %w", t.CertFile, err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// startRedirect serves the plain HTTP port, sending every request to the
+// same URL over HTTPS
+func (s *Server) startRedirect() {
+	addr := net.JoinHostPort(s.host, strconv.Itoa(s.tls.RedirectPort))
+	s.redirect = &http.Server{
+		Addr:              addr,
+		Handler:           http.HandlerFunc(s.redirectToHTTPS),
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       s.config.IdleTimeout,
+	}
+	log.Printf("Redirecting http://%s to HTTPS", addr)
+	go func() {
+		if err := s.redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("ERROR: HTTP redirect listener on %s failed: %v", addr, err)
+		}
+	}()
+}
+
+// redirectToHTTPS answers with the request's URL on the HTTPS port; methods
+// other than GET and HEAD get a 308 so browsers resend their body
+func (s *Server) redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "" {
+		host = s.host
+	}
+	if s.port != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(s.port))
+	}
+
+	status := http.StatusMovedPermanently
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		status = http.StatusPermanentRedirect
+	}
+	http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+}
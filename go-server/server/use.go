@@ -0,0 +1,75 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"slices"
+)
+
+// RouteGroup is a kind of route that middleware can be added to with UseFor
+type RouteGroup string
+
+// Route groups, one per kind of route in a RouteCollection
+const (
+	GroupHTML      RouteGroup = "html"
+	GroupCSS       RouteGroup = "css"
+	GroupFonts     RouteGroup = "fonts"
+	GroupAPI       RouteGroup = "api"
+	GroupWebSocket RouteGroup = "websocket"
+	GroupGateway   RouteGroup = "gateway"
+)
+
+// Use adds middleware around every route, in the order given: the first
+// one added sees the request first. Built-in routes such as /health and
+// /_routes aren't wrapped. Routes registered already are registered again
+// so they get it too.
+func (s *Server) Use(middleware ...MiddlewareFunc) {
+	s.registerMu.Lock()
+	defer s.registerMu.Unlock()
+	s.use = append(s.use, middleware...)
+	s.reregister()
+}
+
+// UseFor adds middleware around the routes of one group only. It runs
+// after the middleware added with Use.
+func (s *Server) UseFor(group RouteGroup, middleware ...MiddlewareFunc) {
+	s.registerMu.Lock()
+	defer s.registerMu.Unlock()
+	if s.groupUse == nil {
+		s.groupUse = map[RouteGroup][]MiddlewareFunc{}
+	}
+	s.groupUse[group] = append(s.groupUse[group], middleware...)
+	s.reregister()
+}
+
+// chain wraps a group's route handler in the middleware added with Use
+// and UseFor. Sessions are loaded first, so that middleware can use them.
+// It runs while routes are registered, under registerMu.
+func (s *Server) chain(group RouteGroup, handler http.Handler) http.Handler {
+	middleware := append(slices.Clone(s.use), s.groupUse[group]...)
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	if s.sessions != nil {
+		handler = s.sessions.middleware(handler)
+	}
+	switch group {
+	case GroupAPI, GroupWebSocket, GroupGateway:
+		if len(s.userAgents) > 0 {
+			handler = refuseStaticOnly(handler)
+		}
+	}
+	return handler
+}
+
+// reregister rebuilds the route table so new middleware applies to it;
+// the caller holds registerMu, so no other table replaces it meanwhile
+func (s *Server) reregister() {
+	routes := s.GetRoutes()
+	if routes == nil {
+		return
+	}
+	if err := s.registerRoutes(routes); err != nil {
+		log.Printf("ERROR: Failed to add middleware: %v", err)
+	}
+}
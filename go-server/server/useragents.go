@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"htmlnojs/routebuilder"
+)
+
+// What a user agent rule does to the requests it matches
+const (
+	UserAgentBlock      = "block"      // Refuse with a 403
+	UserAgentRateLimit  = "rate_limit" // Hold each address to RateLimit requests a minute
+	UserAgentStaticOnly = "static"     // Serve pages, stylesheets and fonts, but no handlers
+)
+
+// UserAgentRule acts on requests whose User-Agent contains one of Match,
+// compared without case, e.g. "AhrefsBot"; "" in Match stands for requests
+// without a User-Agent
+type UserAgentRule struct {
+	Name      string // Shown in logs and metrics
+	Match     []string
+	Action    string
+	RateLimit int // Requests a minute, for UserAgentRateLimit
+
+	matched atomic.Int64
+}
+
+func (rule *UserAgentRule) matches(agent string) bool {
+	agent = strings.ToLower(agent)
+	for _, match := range rule.Match {
+		if match == "" {
+			if agent == "" {
+				return true
+			}
+			continue
+		}
+		if strings.Contains(agent, strings.ToLower(match)) {
+			return true
+		}
+	}
+	return false
+}
+
+// UserAgentRules are checked in order before a request reaches any route;
+// the first rule matching its User-Agent applies
+type UserAgentRules []*UserAgentRule
+
+// match returns the rule for agent, or nil
+func (rules UserAgentRules) match(agent string) *UserAgentRule {
+	for _, rule := range rules {
+		if rule.matches(agent) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// staticOnlyKey marks requests from user agents only served static content
+type staticOnlyKey struct{}
+
+// checkUserAgent applies the first user agent rule matching the request,
+// reporting whether the request may go on; it may go on with a request
+// marked static-only
+func (s *Server) checkUserAgent(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if len(s.userAgents) == 0 {
+		return r, true
+	}
+	rule := s.userAgents.match(r.UserAgent())
+	if rule == nil {
+		return r, true
+	}
+	rule.matched.Add(1)
+
+	switch rule.Action {
+	case UserAgentBlock:
+		log.Printf("WARNING: Refused %s %s from %s, user agent %q matches rule %s", r.Method, r.URL.Path, r.RemoteAddr, r.UserAgent(), rule.Name)
+		routebuilder.WriteFragment(w, http.StatusForbidden, routebuilder.ErrorFragment(
+			"Access Denied",
+			"Automated clients like yours aren't served here",
+			"",
+		))
+		return r, false
+	case UserAgentRateLimit:
+		remaining, wait, by := s.limiter.allow(r.Context(), "user_agent:"+rule.Name, clientAddress(r), "", rule.RateLimit)
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(rule.RateLimit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		if by != "" {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			routebuilder.WriteFragment(w, http.StatusTooManyRequests, routebuilder.ErrorFragment(
+				"Too Many Requests",
+				fmt.Sprintf("Slow down, try again in %d seconds", retryAfter),
+				"",
+			))
+			return r, false
+		}
+	case UserAgentStaticOnly:
+		return r.WithContext(context.WithValue(r.Context(), staticOnlyKey{}, rule.Name)), true
+	}
+	return r, true
+}
+
+// refuseStaticOnly keeps requests from user agents only served static
+// content away from handlers, gateways and WebSockets
+func refuseStaticOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rule, ok := r.Context().Value(staticOnlyKey{}).(string); ok {
+			log.Printf("Refused %s %s from %s, user agent rule %s only allows static content", r.Method, r.URL.Path, r.RemoteAddr, rule)
+			routebuilder.WriteFragment(w, http.StatusForbidden, routebuilder.ErrorFragment(
+				"Access Denied",
+				"Automated clients like yours are only served pages",
+				"",
+			))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeMetrics counts the requests each rule matched
+func (rules UserAgentRules) writeMetrics(w io.Writer) {
+	for _, rule := range rules {
+		fmt.Fprintf(w, "user_agent_rule_matches_total{rule=%q,action=%q} %d\n", rule.Name, rule.Action, rule.matched.Load())
+	}
+}
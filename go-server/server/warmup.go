@@ -0,0 +1,77 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// warmupTimeout bounds each warmup request
+const warmupTimeout = 30 * time.Second
+
+// WarmupResult is the outcome of one warmup request
+type WarmupResult struct {
+	Path     string
+	Status   int // 0 when the request couldn't be made
+	Duration time.Duration
+	Err      error
+}
+
+// Warmup GETs each path through the route table, like a browser would but
+// without a connection, so backends import their code, open connections
+// and fill caches before the first user arrives
+func (s *Server) Warmup(ctx context.Context, paths []string) []WarmupResult {
+	results := make([]WarmupResult, 0, len(paths))
+	for _, path := range paths {
+		result := WarmupResult{Path: path}
+		reqCtx, cancel := context.WithTimeout(ctx, warmupTimeout)
+		req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, path, nil)
+		if err != nil {
+			cancel()
+			result.Err = err
+			results = append(results, result)
+			continue
+		}
+		req.Host = "localhost"
+		req.RemoteAddr = "127.0.0.1:0"
+		req.Header.Set("Accept", "text/html")
+		req.Header.Set("User-Agent", "htmlnojs-warmup")
+
+		w := &warmupWriter{header: http.Header{}}
+		start := time.Now()
+		s.ServeHTTP(w, req)
+		result.Duration = time.Since(start)
+		result.Status = w.status
+		if result.Status == 0 {
+			result.Status = http.StatusOK
+		}
+		cancel()
+		results = append(results, result)
+	}
+	return results
+}
+
+// warmupWriter drops the response of a warmup request, keeping its status
+type warmupWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *warmupWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *warmupWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *warmupWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return len(b), nil
+}
+
+func (w *warmupWriter) Flush() {}
@@ -0,0 +1,23 @@
+package server
+
+import "net/http"
+
+// wrappedWriter is embedded by the ResponseWriter wrappers in this package
+// so they all pass on what the underlying writer can do: Flush keeps
+// streamed responses streaming through them, and Unwrap lets
+// http.ResponseController reach the underlying writer, e.g. to hijack a
+// WebSocket connection. Wrappers that must act before anything is sent
+// override Flush and call this one after.
+type wrappedWriter struct {
+	http.ResponseWriter
+}
+
+func (w wrappedWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w wrappedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
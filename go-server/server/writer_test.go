@@ -0,0 +1,52 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWrappedWriters(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	tests := []struct {
+		name string
+		wrap func(w http.ResponseWriter) http.ResponseWriter
+	}{
+		{"responseWriter", func(w http.ResponseWriter) http.ResponseWriter {
+			return &responseWriter{wrappedWriter: wrappedWriter{w}}
+		}},
+		{"countingWriter", func(w http.ResponseWriter) http.ResponseWriter {
+			return &countingWriter{wrappedWriter: wrappedWriter{w}}
+		}},
+		{"recordingWriter", func(w http.ResponseWriter) http.ResponseWriter {
+			return &recordingWriter{wrappedWriter: wrappedWriter{w}}
+		}},
+		{"flashWriter", func(w http.ResponseWriter) http.ResponseWriter {
+			return &flashWriter{wrappedWriter: wrappedWriter{w}, r: r}
+		}},
+		{"sessionWriter", func(w http.ResponseWriter) http.ResponseWriter {
+			return &sessionWriter{wrappedWriter: wrappedWriter{w}, save: func() {}}
+		}},
+		{"cacheWriter", func(w http.ResponseWriter) http.ResponseWriter {
+			return &cacheWriter{recordingWriter: &recordingWriter{wrappedWriter: wrappedWriter{w}}, onHeader: func(int) {}}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			w := tt.wrap(recorder)
+			if _, err := w.Write([]byte("streamed")); err != nil {
+				t.Fatal(err)
+			}
+			if err := http.NewResponseController(w).Flush(); err != nil {
+				t.Fatalf("Flush() = %v", err)
+			}
+			if !recorder.Flushed {
+				t.Error("Flush didn't reach the underlying writer")
+			}
+			if recorder.Body.String() != "streamed" {
+				t.Errorf("body = %q, want %q", recorder.Body.String(), "streamed")
+			}
+		})
+	}
+}
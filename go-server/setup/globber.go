@@ -1,39 +1,140 @@
 package setup
 
 import (
+	"log"
+	"os"
 	"path/filepath"
+	"strings"
 )
 
 type FileSet struct {
 	PyHTMXFiles    []string
 	TemplateFiles  []string
 	CSSFiles       []string
+	FontFiles      []string
+	HandlerFiles   map[string][]string // Files below each of Config.HandlerDirs
 }
 
 // GlobFiles discovers all files in the project directories
 func (c *Config) GlobFiles() (*FileSet, error) {
 	fs := &FileSet{}
 
-	// Glob all files in py_htmx directory
-	pyFiles, err := filepath.Glob(filepath.Join(c.PyHTMXDir, "*"))
+	// Walk py_htmx recursively so subdirectories such as v1/ and v2/ become
+	// part of the API path
+	pyFiles, err := c.globRecursive(c.PyHTMXDir)
 	if err != nil {
 		return nil, err
 	}
 	fs.PyHTMXFiles = pyFiles
 
+	// Handler directories of other languages are walked the same way
+	fs.HandlerFiles = make(map[string][]string, len(c.HandlerDirs))
+	for language, dir := range c.HandlerDirs {
+		files, err := c.globRecursive(dir)
+		if err != nil {
+			return nil, err
+		}
+		fs.HandlerFiles[language] = files
+	}
+
 	// Glob all files in templates directory
-	templateFiles, err := filepath.Glob(filepath.Join(c.TemplatesDir, "*"))
+	templateFiles, err := c.glob(c.TemplatesDir)
 	if err != nil {
 		return nil, err
 	}
 	fs.TemplateFiles = templateFiles
 
 	// Glob all files in css directory
-	cssFiles, err := filepath.Glob(filepath.Join(c.CSSDir, "*"))
+	cssFiles, err := c.glob(c.CSSDir)
 	if err != nil {
 		return nil, err
 	}
 	fs.CSSFiles = cssFiles
 
+	// css/components/ holds component styles that can be scoped
+	componentFiles, err := c.glob(filepath.Join(c.CSSDir, "components"))
+	if err != nil {
+		return nil, err
+	}
+	fs.CSSFiles = append(fs.CSSFiles, componentFiles...)
+
+	// fonts/ is optional, a missing directory globs to nothing
+	if c.FontsDir != "" {
+		fontFiles, err := c.glob(c.FontsDir)
+		if err != nil {
+			return nil, err
+		}
+		fs.FontFiles = fontFiles
+	}
+
 	return fs, nil
-}
\ No newline at end of file
+}
+
+// glob lists the entries of dir that aren't matched by an exclusion pattern
+func (c *Config) glob(dir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]string, 0, len(matches))
+	for _, match := range matches {
+		if c.IsExcluded(match) {
+			log.Printf("Excluded from discovery: %s", match)
+			continue
+		}
+		files = append(files, match)
+	}
+	return files, nil
+}
+
+// globRecursive lists every file below dir that isn't excluded, skipping
+// excluded directories entirely
+func (c *Config) globRecursive(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+		if c.IsExcluded(path) {
+			log.Printf("Excluded from discovery: %s", path)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// IsExcluded reports whether any component of path below the project
+// directory matches one of the exclusion patterns
+func (c *Config) IsExcluded(path string) bool {
+	if len(c.Exclude) == 0 {
+		return false
+	}
+
+	rel, err := filepath.Rel(c.ProjectDir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = path
+	}
+
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		for _, pattern := range c.Exclude {
+			if ok, _ := filepath.Match(pattern, part); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -76,6 +76,13 @@ func (p *PyHTMXValidator) scanPythonFiles() error {
 			return err
 		}
 
+		if path != p.config.PyHTMXDir && p.config.IsExcluded(path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
 		if info.IsDir() || !strings.HasSuffix(strings.ToLower(path), ".py") {
 			return nil
 		}
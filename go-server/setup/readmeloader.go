@@ -3,6 +3,7 @@ package setup
 import (
 	"embed"
 	"fmt"
+	"io/fs"
 )
 
 //go:embed readmes/*
@@ -30,4 +31,8 @@ func GetCSSREADME() (string, error) {
 // GetPyHTMXREADME returns the Python HTMX directory README content
 func GetPyHTMXREADME() (string, error) {
 	return loadREADME("py_htmx.md")
-}
\ No newline at end of file
+}
+// EmbeddedFS returns the files embedded into the binary
+func EmbeddedFS() fs.FS {
+	return readmeFS
+}
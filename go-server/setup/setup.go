@@ -12,6 +12,9 @@ type Config struct {
 	PyHTMXDir    string
 	CSSDir       string
 	TemplatesDir string
+	FontsDir     string            // Optional, fonts/ files are served and preloaded
+	Exclude      []string          // Glob patterns skipped during discovery
+	HandlerDirs  map[string]string // Extra handler directories by language, e.g. node_htmx
 }
 
 // Setup creates the required directory structure for HTMLnoJS
@@ -27,6 +30,7 @@ func Setup(projectDir string) (*Config, error) {
 		PyHTMXDir:    filepath.Join(projectDir, "py_htmx"),
 		CSSDir:       filepath.Join(projectDir, "css"),
 		TemplatesDir: filepath.Join(projectDir, "templates"),
+		FontsDir:     filepath.Join(projectDir, "fonts"),
 	}
 
 	// Create py_htmx directory
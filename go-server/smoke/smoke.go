@@ -0,0 +1,202 @@
+// Package smoke walks a list of routes against a running server and checks
+// status codes and the presence of key elements, as a post-release gate
+package smoke
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+
+	"htmlnojs/config"
+	"htmlnojs/routebuilder"
+)
+
+// Result is the outcome of one check
+type Result struct {
+	Check    config.SmokeCheck
+	Status   int
+	Duration time.Duration
+	Failures []string
+}
+
+// OK reports whether every expectation of the check held
+func (r Result) OK() bool {
+	return len(r.Failures) == 0
+}
+
+func (r Result) String() string {
+	mark := "PASS"
+	if !r.OK() {
+		mark = "FAIL"
+	}
+	line := fmt.Sprintf("%s %s %s -> %d (%v)", mark, method(r.Check), r.Check.Path, r.Status, r.Duration.Round(time.Millisecond))
+	for _, failure := range r.Failures {
+		line += "\n    " + failure
+	}
+	return line
+}
+
+func method(check config.SmokeCheck) string {
+	if check.Method == "" {
+		return http.MethodGet
+	}
+	return strings.ToUpper(check.Method)
+}
+
+// DefaultChecks expects every page and the health endpoint to return 200
+func DefaultChecks(routes *routebuilder.RouteCollection) []config.SmokeCheck {
+	checks := []config.SmokeCheck{{Path: "/health"}}
+	for _, route := range routes.HTMLRoutes {
+		checks = append(checks, config.SmokeCheck{Path: route.Route})
+	}
+	return checks
+}
+
+// Run performs every check against baseURL in order
+func Run(ctx context.Context, client *http.Client, baseURL string, checks []config.SmokeCheck) []Result {
+	baseURL = strings.TrimRight(baseURL, "/")
+	results := make([]Result, 0, len(checks))
+	for _, check := range checks {
+		results = append(results, runCheck(ctx, client, baseURL, check))
+	}
+	return results
+}
+
+func runCheck(ctx context.Context, client *http.Client, baseURL string, check config.SmokeCheck) Result {
+	result := Result{Check: check}
+
+	req, err := newRequest(ctx, baseURL, check)
+	if err != nil {
+		result.Failures = append(result.Failures, err.Error())
+		return result
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	result.Duration = time.Since(start)
+	if err != nil {
+		result.Failures = append(result.Failures, fmt.Sprintf("request failed: %v", err))
+		return result
+	}
+	defer resp.Body.Close()
+	result.Status = resp.StatusCode
+
+	want := check.Status
+	if want == 0 {
+		want = http.StatusOK
+	}
+	if resp.StatusCode != want {
+		result.Failures = append(result.Failures, fmt.Sprintf("expected status %d, got %d", want, resp.StatusCode))
+	}
+
+	if len(check.Selectors) == 0 {
+		io.Copy(io.Discard, resp.Body)
+		return result
+	}
+
+	doc, err := goquery.NewDocumentFromReader(resp.Body)
+	if err != nil {
+		result.Failures = append(result.Failures, fmt.Sprintf("failed to parse response: %v", err))
+		return result
+	}
+	for _, selector := range check.Selectors {
+		if matched, err := matches(doc, selector); err != nil {
+			result.Failures = append(result.Failures, err.Error())
+		} else if !matched {
+			result.Failures = append(result.Failures, fmt.Sprintf("selector %q matched nothing", selector))
+		}
+	}
+	return result
+}
+
+// matches reports whether selector finds an element
+func matches(doc *goquery.Document, selector string) (bool, error) {
+	compiled, err := cascadia.Compile(selector)
+	if err != nil {
+		return false, fmt.Errorf("invalid selector %q: %v", selector, err)
+	}
+	return doc.FindMatcher(compiled).Length() > 0, nil
+}
+
+func newRequest(ctx context.Context, baseURL string, check config.SmokeCheck) (*http.Request, error) {
+	values := url.Values{}
+	for key, value := range check.Form {
+		values.Set(key, value)
+	}
+
+	target := baseURL + check.Path
+	var body io.Reader
+	m := method(check)
+	if m == http.MethodGet || m == http.MethodHead || m == http.MethodDelete {
+		if len(values) > 0 {
+			sep := "?"
+			if strings.Contains(target, "?") {
+				sep = "&"
+			}
+			target += sep + values.Encode()
+		}
+	} else {
+		body = strings.NewReader(values.Encode())
+	}
+
+	req, err := http.NewRequestWithContext(ctx, m, target, body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if check.HTMX {
+		req.Header.Set("HX-Request", "true")
+	}
+	req.Header.Set("User-Agent", "htmlnojs-smoke")
+	return req, nil
+}
+
+// MockBackend stands in for the handler backend, answering every request
+// with a small fragment so pages can be checked without Python
+type MockBackend struct {
+	listener net.Listener
+	server   *http.Server
+}
+
+// StartMockBackend listens on a free local port
+func StartMockBackend() (*MockBackend, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start mock backend: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"status":"ok","mock":true}`)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprintf(w, `<div class="smoke-mock" data-path="%s">mock response</div>`, html.EscapeString(r.URL.Path))
+	})
+
+	backend := &MockBackend{listener: listener, server: &http.Server{Handler: mux}}
+	go backend.server.Serve(listener)
+	return backend, nil
+}
+
+// Port returns the port the mock backend listens on
+func (m *MockBackend) Port() int {
+	return m.listener.Addr().(*net.TCPAddr).Port
+}
+
+// Close stops the mock backend
+func (m *MockBackend) Close() error {
+	return m.server.Close()
+}
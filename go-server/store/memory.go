@@ -0,0 +1,131 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryEntry is a value and its expiry, zero when it never expires
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+// Memory keeps everything in process; expired keys are dropped lazily
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+// NewMemory creates an empty in-memory store
+func NewMemory() *Memory {
+	return &Memory{entries: make(map[string]memoryEntry)}
+}
+
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// lookup returns the live entry for key, deleting it when expired
+func (m *Memory) lookup(key string) (memoryEntry, bool) {
+	entry, ok := m.entries[key]
+	if ok && entry.expired(time.Now()) {
+		delete(m.entries, key)
+		return memoryEntry{}, false
+	}
+	return entry, ok
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.lookup(key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), entry.value...), nil
+}
+
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.entries[key] = memoryEntry{value: append([]byte(nil), value...), expires: expiry(ttl)}
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *Memory) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.lookup(key)
+	if !ok {
+		entry = memoryEntry{value: []byte("0"), expires: expiry(ttl)}
+	}
+	n, err := strconv.ParseInt(string(entry.value), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("store: %s is not an integer", key)
+	}
+	n += delta
+	entry.value = []byte(strconv.FormatInt(n, 10))
+	m.entries[key] = entry
+	return n, nil
+}
+
+func (m *Memory) TTL(ctx context.Context, key string) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.lookup(key)
+	if !ok {
+		return 0, ErrNotFound
+	}
+	if entry.expires.IsZero() {
+		return 0, nil
+	}
+	return time.Until(entry.expires), nil
+}
+
+func (m *Memory) Scan(ctx context.Context, prefix string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var keys []string
+	for key, entry := range m.entries {
+		if entry.expired(now) {
+			delete(m.entries, key)
+			continue
+		}
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *Memory) Close() error {
+	return nil
+}
@@ -0,0 +1,167 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLite keeps keys in a local database file, surviving restarts of a
+// single server
+type SQLite struct {
+	db *sql.DB
+}
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS kv (
+	key        TEXT PRIMARY KEY,
+	value      BLOB NOT NULL,
+	expires_at INTEGER NOT NULL DEFAULT 0
+)`
+
+// OpenSQLite opens or creates the database at path
+func OpenSQLite(path string) (*SQLite, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create store directory %s: %w", dir, err)
+		}
+	}
+
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite store %s: %w", path, err)
+	}
+	// SQLite serializes writers; one connection avoids "database is locked"
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize SQLite store %s: %w", path, err)
+	}
+	return &SQLite{db: db}, nil
+}
+
+// expiresAt converts a ttl to the stored unix milliseconds, 0 for never
+func expiresAt(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return time.Now().Add(ttl).UnixMilli()
+}
+
+func (s *SQLite) Get(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	err := s.db.QueryRowContext(ctx,
+		`SELECT value FROM kv WHERE key = ? AND (expires_at = 0 OR expires_at > ?)`,
+		key, time.Now().UnixMilli(),
+	).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return value, err
+}
+
+func (s *SQLite) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO kv (key, value, expires_at) VALUES (?, ?, ?)
+		 ON CONFLICT(key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`,
+		key, value, expiresAt(ttl),
+	)
+	return err
+}
+
+func (s *SQLite) Delete(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM kv WHERE key = ?`, key)
+	return err
+}
+
+func (s *SQLite) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var value []byte
+	var expires int64
+	err = tx.QueryRowContext(ctx,
+		`SELECT value, expires_at FROM kv WHERE key = ? AND (expires_at = 0 OR expires_at > ?)`,
+		key, time.Now().UnixMilli(),
+	).Scan(&value, &expires)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		value, expires = []byte("0"), expiresAt(ttl)
+	case err != nil:
+		return 0, err
+	}
+
+	n, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("store: %s is not an integer", key)
+	}
+	n += delta
+
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO kv (key, value, expires_at) VALUES (?, ?, ?)
+		 ON CONFLICT(key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`,
+		key, []byte(strconv.FormatInt(n, 10)), expires,
+	); err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+func (s *SQLite) TTL(ctx context.Context, key string) (time.Duration, error) {
+	now := time.Now().UnixMilli()
+	var expires int64
+	err := s.db.QueryRowContext(ctx,
+		`SELECT expires_at FROM kv WHERE key = ? AND (expires_at = 0 OR expires_at > ?)`,
+		key, now,
+	).Scan(&expires)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil || expires == 0 {
+		return 0, err
+	}
+	return time.Duration(expires-now) * time.Millisecond, nil
+}
+
+func (s *SQLite) Scan(ctx context.Context, prefix string) ([]string, error) {
+	now := time.Now().UnixMilli()
+
+	// Expired rows are only hidden by reads; sweep them here
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM kv WHERE expires_at != 0 AND expires_at <= ?`, now); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT key FROM kv WHERE substr(key, 1, length(?1)) = ?1 ORDER BY key`,
+		prefix,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,140 @@
+// Package store is the single persistence layer shared by sessions, caches,
+// rate limits, jobs and flags. Operators pick one backend (memory, SQLite or
+// Redis) and every subsystem keeps its data there under its own prefix.
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned for keys that don't exist or have expired
+var ErrNotFound = errors.New("store: key not found")
+
+// Store is a small key-value interface with expiry
+type Store interface {
+	// Get returns the value of key, or ErrNotFound
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set stores value under key; a ttl of 0 keeps it until deleted
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes key, deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+	// Incr adds delta to the integer at key and returns the new value. A
+	// missing key starts at 0 and gets ttl; existing keys keep their expiry.
+	Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error)
+	// TTL returns the time left before key expires, 0 when it never does,
+	// or ErrNotFound
+	TTL(ctx context.Context, key string) (time.Duration, error)
+	// Scan returns every live key starting with prefix
+	Scan(ctx context.Context, prefix string) ([]string, error)
+	// Close releases the backend's connections
+	Close() error
+}
+
+// Open connects to the store described by rawURL:
+//
+//	memory://                        in-process, lost on restart (default)
+//	sqlite://path/to/state.db        a local SQLite file, relative to baseDir
+//	redis://[:password@]host:6379/0  shared between servers
+func Open(rawURL, baseDir string) (Store, error) {
+	if rawURL == "" {
+		rawURL = "memory://"
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid store URL %q: %w", rawURL, err)
+	}
+
+	switch u.Scheme {
+	case "memory":
+		return NewMemory(), nil
+	case "sqlite":
+		// sqlite://state.db is relative, sqlite:///var/lib/state.db absolute
+		path := u.Host + u.Path
+		if path == "" {
+			return nil, fmt.Errorf("invalid store URL %q: missing database file", rawURL)
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+		return OpenSQLite(path)
+	case "redis", "rediss":
+		return OpenRedis(rawURL)
+	default:
+		return nil, fmt.Errorf("unknown store %q (available: memory, sqlite, redis)", u.Scheme)
+	}
+}
+
+// Ping checks that the store answers
+func Ping(ctx context.Context, s Store) error {
+	_, err := s.Get(ctx, "htmlnojs:ping")
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
+
+// prefixed namespaces keys so subsystems can share one store
+type prefixed struct {
+	Store
+	prefix string
+}
+
+// Shared reports whether every server pointed at s sees the same keys, so
+// subsystems should keep their state there rather than in process memory
+func Shared(s Store) bool {
+	switch s := s.(type) {
+	case *Redis:
+		return true
+	case *prefixed:
+		return Shared(s.Store)
+	}
+	return false
+}
+
+// WithPrefix returns a view of s that keeps its keys under prefix, e.g.
+// "sessions:" or "ratelimit:"
+func WithPrefix(s Store, prefix string) Store {
+	return &prefixed{Store: s, prefix: prefix}
+}
+
+func (p *prefixed) Get(ctx context.Context, key string) ([]byte, error) {
+	return p.Store.Get(ctx, p.prefix+key)
+}
+
+func (p *prefixed) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return p.Store.Set(ctx, p.prefix+key, value, ttl)
+}
+
+func (p *prefixed) Delete(ctx context.Context, key string) error {
+	return p.Store.Delete(ctx, p.prefix+key)
+}
+
+func (p *prefixed) Incr(ctx context.Context, key string, delta int64, ttl time.Duration) (int64, error) {
+	return p.Store.Incr(ctx, p.prefix+key, delta, ttl)
+}
+
+func (p *prefixed) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return p.Store.TTL(ctx, p.prefix+key)
+}
+
+func (p *prefixed) Scan(ctx context.Context, prefix string) ([]string, error) {
+	keys, err := p.Store.Scan(ctx, p.prefix+prefix)
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range keys {
+		keys[i] = strings.TrimPrefix(key, p.prefix)
+	}
+	return keys, nil
+}
+
+// Close leaves the shared store open for the other subsystems
+func (p *prefixed) Close() error {
+	return nil
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"log"
+	"slices"
+	"time"
+
+	"htmlnojs/config"
+	"htmlnojs/routebuilder"
+	"htmlnojs/server"
+)
+
+// warmupWait bounds how long warmup waits for the Python backend to start
+const warmupWait = 30 * time.Second
+
+// warmupPaths lists what is requested at startup: the project's warmup
+// list, then the GET handlers marked @warmup
+func warmupPaths(project *config.ProjectConfig, routes *routebuilder.RouteCollection) []string {
+	paths := slices.Clone(project.Warmup)
+	for _, route := range routes.PythonRoutes {
+		if !route.Warmup || route.AliasOf != "" || slices.Contains(paths, route.Route) {
+			continue
+		}
+		if route.Method != "GET" {
+			log.Printf("WARNING: @warmup ignored for %s, only GET handlers are warmed up", route.Function)
+			continue
+		}
+		paths = append(paths, route.Route)
+	}
+	return paths
+}
+
+// warmup primes the backends with paths once the Python backend answers
+// check, and logs how each request went
+func warmup(srv *server.Server, paths []string, check func(context.Context) error) {
+	if len(paths) == 0 {
+		return
+	}
+
+	deadline := time.Now().Add(warmupWait)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := check(ctx)
+		cancel()
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			log.Printf("WARNING: Warming up anyway, the Python backend isn't healthy after %v: %v", warmupWait, err)
+			break
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+
+	start := time.Now()
+	failed := 0
+	for _, result := range srv.Warmup(context.Background(), paths) {
+		switch {
+		case result.Err != nil:
+			failed++
+			log.Printf("WARNING: Warmup GET %s failed: %v", result.Path, result.Err)
+		case result.Status >= 400:
+			failed++
+			log.Printf("WARNING: Warmup GET %s -> %d in %v", result.Path, result.Status, result.Duration.Round(time.Millisecond))
+		default:
+			log.Printf("Warmup GET %s -> %d in %v", result.Path, result.Status, result.Duration.Round(time.Millisecond))
+		}
+	}
+	log.Printf("Warmed up %d routes in %v, %d failed", len(paths), time.Since(start).Round(time.Millisecond), failed)
+}
@@ -8,6 +8,15 @@ from loguru import logger as log
 import importlib.util
 import requests
 import pathlib
+import inspect
+
+
+def call_handler(handler_func, data: Dict[str, Any]):
+    """Pass request data first, plus any later parameter named in the request."""
+    params = list(inspect.signature(handler_func).parameters.values())[1:]
+    kwargs = {p.name: data[p.name] for p in params
+              if p.name in data and p.kind in (p.POSITIONAL_OR_KEYWORD, p.KEYWORD_ONLY)}
+    return handler_func(data, **kwargs)
 
 
 def create_app_from_registry_map(reg_map: Dict[str, Any], project_dir: pathlib.Path) -> FastAPI:
@@ -26,16 +35,28 @@ def create_app_from_registry_map(reg_map: Dict[str, Any], project_dir: pathlib.P
 
     # mount dynamic Python handlers
     for e in python_routes:
+        # Default-version aliases reuse the versioned backend path
+        if e.get("alias_of"):
+            continue
+        # Handlers in other languages are served by their own backends
+        if e.get("language", "python") != "python":
+            continue
+
         go_route = e.get("route")  # This is "/api/demo/hello"
         fn_name = e.get("function")
         method = e.get("method")
 
-        # Strip /api/ prefix to match what Go server actually calls
-        fastapi_route = go_route.replace("/api/", "/", 1) if go_route.startswith("/api/") else go_route
+        # Prefer the backend path the Go proxy calls; it differs from the public
+        # route when a module declares __route_prefix__ or a _prefix file exists
+        fastapi_route = e.get("fastapi_path") or (
+            go_route.replace("/api/", "/", 1) if go_route.startswith("/api/") else go_route
+        )
 
-        # Extract module name from the stripped route
-        parts = fastapi_route.strip("/").split("/")
-        module = parts[0] if len(parts) > 0 else "demo"  # fallback to demo
+        # Module path relative to py_htmx, e.g. "demo" or "shop/cart"
+        module = e.get("module")
+        if not module:
+            parts = fastapi_route.strip("/").split("/")
+            module = parts[0] if len(parts) > 0 else "demo"  # fallback to demo
 
         file_path = project_dir.joinpath("py_htmx", f"{module}.py")
         log.debug(f"Mounting Python route {go_route} -> FastAPI {fastapi_route} -> {fn_name} from {file_path}")
@@ -116,7 +137,7 @@ def create_app_from_registry_map(reg_map: Dict[str, Any], project_dir: pathlib.P
                             log.debug(f"Query params: {data}")
 
                         log.debug(f"Final data passed to {func_name}: {data}")
-                        result = handler_func(data)
+                        result = call_handler(handler_func, data)
 
                         # Return HTML response
                         from fastapi.responses import HTMLResponse
@@ -154,7 +175,9 @@ def create_app_from_registry_map(reg_map: Dict[str, Any], project_dir: pathlib.P
         lines.append("")
         for p in python_routes:
             go_route = p.get('route')
-            fastapi_route = go_route.replace("/api/", "/", 1) if go_route.startswith("/api/") else go_route
+            fastapi_route = p.get('fastapi_path') or (
+                go_route.replace("/api/", "/", 1) if go_route.startswith("/api/") else go_route
+            )
             lines.append(f"PYTHON {p.get('method')} {go_route} -> FastAPI {fastapi_route} -> {p.get('function')}")
         lines.append(f"\nTOTAL ROUTES {reg_map.get('total_routes', len(python_routes))}")
         return "\n".join(lines)
@@ -211,6 +234,7 @@ class HTMXServer:
                 reg_map = {}
 
             app = create_app_from_registry_map(reg_map, self.project_dir)
+            threading.Thread(target=self._watch_registry, args=(app, reg_map), daemon=True).start()
             cfg = uvicorn.Config(app, host=self.host, port=self.port, log_level="info")
             self._server = uvicorn.Server(cfg)
             self._started = True
@@ -222,6 +246,26 @@ class HTMXServer:
         if self.verbose: log.debug("HTMXServer thread launched")
         return t
 
+    def _watch_registry(self, app: FastAPI, reg_map: Dict[str, Any], interval: float = 2.0) -> None:
+        """Remount handlers in place whenever the Go server swaps its route table."""
+        while True:
+            time.sleep(interval)
+            try:
+                resp = requests.get(f"{self.base_go_url}/_routes.json", timeout=2)
+                resp.raise_for_status()
+                latest = resp.json()
+            except Exception as err:
+                if self.verbose: log.debug(f"Registry poll failed: {err}")
+                continue
+
+            if latest == reg_map:
+                continue
+
+            log.info("Route registry changed, remounting Python handlers")
+            fresh = create_app_from_registry_map(latest, self.project_dir)
+            app.router.routes = fresh.router.routes
+            reg_map = latest
+
     async def is_running(self) -> bool:
         if not self.thread.is_alive():
             self.thread.start()